@@ -0,0 +1,50 @@
+package certificate
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/go-acme/lego/v5/challenge"
+	"github.com/go-acme/lego/v5/challenge/dns01"
+)
+
+// aliasedDnsProvider wraps a DNS-01 challenge provider so that challenge records are written within an alias
+// zone, instead of the zone of the domain being validated. The `_acme-challenge` record for each domain must
+// be a CNAME pointing at the corresponding record in the alias zone, so that the DNS provider only needs
+// access to the alias zone.
+type aliasedDnsProvider struct {
+	provider challenge.Provider
+	zone     string
+}
+
+// newAliasedDnsProvider wraps the given provider so that the challenge record for e.g. `example.com` is
+// written to `_acme-challenge.example-com.<zone>`.
+func newAliasedDnsProvider(provider challenge.Provider, zone string) *aliasedDnsProvider {
+	return &aliasedDnsProvider{
+		provider: provider,
+		zone:     strings.Trim(zone, "."),
+	}
+}
+
+func (a *aliasedDnsProvider) Present(ctx context.Context, domain, token, keyAuth string) error {
+	return a.provider.Present(ctx, a.alias(domain), token, keyAuth)
+}
+
+func (a *aliasedDnsProvider) CleanUp(ctx context.Context, domain, token, keyAuth string) error {
+	return a.provider.CleanUp(ctx, a.alias(domain), token, keyAuth)
+}
+
+// Timeout returns the propagation timeout and polling interval of the wrapped provider, so that lego waits for
+// as long as it would have done without the alias.
+func (a *aliasedDnsProvider) Timeout() (timeout, interval time.Duration) {
+	if p, ok := a.provider.(challenge.ProviderTimeout); ok {
+		return p.Timeout()
+	}
+	return dns01.DefaultPropagationTimeout, dns01.DefaultPollingInterval
+}
+
+// alias returns the name within the alias zone that stands in for the given domain.
+func (a *aliasedDnsProvider) alias(domain string) string {
+	return strings.ReplaceAll(strings.ToLower(strings.TrimSuffix(domain, ".")), ".", "-") + "." + a.zone
+}
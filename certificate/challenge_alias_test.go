@@ -0,0 +1,57 @@
+package certificate
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-acme/lego/v5/challenge/dns01"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeDnsProvider struct {
+	presented []string
+	cleaned   []string
+}
+
+func (f *fakeDnsProvider) Present(_ context.Context, domain, _, _ string) error {
+	f.presented = append(f.presented, domain)
+	return nil
+}
+
+func (f *fakeDnsProvider) CleanUp(_ context.Context, domain, _, _ string) error {
+	f.cleaned = append(f.cleaned, domain)
+	return nil
+}
+
+type fakeDnsProviderWithTimeout struct {
+	fakeDnsProvider
+	timeout time.Duration
+}
+
+func (f *fakeDnsProviderWithTimeout) Timeout() (time.Duration, time.Duration) {
+	return f.timeout, time.Second
+}
+
+func Test_aliasedDnsProvider_passesAliasToProvider(t *testing.T) {
+	provider := &fakeDnsProvider{}
+	aliased := newAliasedDnsProvider(provider, "acme.example.net.")
+
+	require.NoError(t, aliased.Present(t.Context(), "Sub.Example.com", "token", "keyauth"))
+	require.NoError(t, aliased.CleanUp(t.Context(), "sub.example.com", "token", "keyauth"))
+
+	assert.Equal(t, []string{"sub-example-com.acme.example.net"}, provider.presented)
+	assert.Equal(t, []string{"sub-example-com.acme.example.net"}, provider.cleaned)
+}
+
+func Test_aliasedDnsProvider_Timeout(t *testing.T) {
+	timeout, interval := newAliasedDnsProvider(&fakeDnsProvider{}, "acme.example.net").Timeout()
+	assert.Equal(t, dns01.DefaultPropagationTimeout, timeout)
+	assert.Equal(t, dns01.DefaultPollingInterval, interval)
+
+	provider := &fakeDnsProviderWithTimeout{timeout: time.Hour}
+	timeout, interval = newAliasedDnsProvider(provider, "acme.example.net").Timeout()
+	assert.Equal(t, time.Hour, timeout)
+	assert.Equal(t, time.Second, interval)
+}
@@ -0,0 +1,148 @@
+package certificate
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log/slog"
+	"sync"
+
+	"github.com/go-acme/lego/v5/challenge/tlsalpn01"
+)
+
+// ChallengeStore holds the responses to ACME challenges that are in progress. When running multiple Centauri
+// instances the store should be shared between them, as the ACME server may connect to any instance to
+// validate a challenge.
+type ChallengeStore interface {
+	SaveChallenge(key string, response string) error
+	GetChallenge(key string) (string, bool)
+	DeleteChallenge(key string) error
+}
+
+// MemoryChallengeStore is a ChallengeStore that keeps challenges in memory. It is only suitable for use by a
+// single Centauri instance.
+type MemoryChallengeStore struct {
+	mu         sync.Mutex
+	challenges map[string]string
+}
+
+// NewMemoryChallengeStore creates a new, empty, in-memory challenge store.
+func NewMemoryChallengeStore() *MemoryChallengeStore {
+	return &MemoryChallengeStore{challenges: make(map[string]string)}
+}
+
+// SaveChallenge stores the response for the challenge with the given key.
+func (m *MemoryChallengeStore) SaveChallenge(key string, response string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.challenges[key] = response
+	return nil
+}
+
+// GetChallenge returns the response for the challenge with the given key, if there is one.
+func (m *MemoryChallengeStore) GetChallenge(key string) (string, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	response, ok := m.challenges[key]
+	return response, ok
+}
+
+// DeleteChallenge removes the challenge with the given key.
+func (m *MemoryChallengeStore) DeleteChallenge(key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.challenges, key)
+	return nil
+}
+
+// HttpChallengeProvider solves ACME HTTP-01 challenges by placing the responses in a ChallengeStore, from which
+// they can be served by the plain HTTP listener.
+type HttpChallengeProvider struct {
+	store ChallengeStore
+}
+
+// NewHttpChallengeProvider creates a new HTTP-01 challenge provider backed by the given store.
+func NewHttpChallengeProvider(store ChallengeStore) *HttpChallengeProvider {
+	return &HttpChallengeProvider{store: store}
+}
+
+// Present makes the key authorisation available for the given domain and token.
+func (h *HttpChallengeProvider) Present(_ context.Context, domain, token, keyAuth string) error {
+	slog.Debug("Presenting HTTP-01 challenge", "domain", domain, "token", token)
+	if err := h.store.SaveChallenge(httpChallengeKey(domain, token), keyAuth); err != nil {
+		return fmt.Errorf("unable to save HTTP-01 challenge for %s: %w", domain, err)
+	}
+	return nil
+}
+
+// CleanUp removes the key authorisation for the given domain and token.
+func (h *HttpChallengeProvider) CleanUp(_ context.Context, domain, token, _ string) error {
+	if err := h.store.DeleteChallenge(httpChallengeKey(domain, token)); err != nil {
+		return fmt.Errorf("unable to remove HTTP-01 challenge for %s: %w", domain, err)
+	}
+	return nil
+}
+
+// HttpChallengeResponse returns the key authorisation that should be served for the given domain and token,
+// if a challenge is in progress.
+func (h *HttpChallengeProvider) HttpChallengeResponse(domain, token string) (string, bool) {
+	return h.store.GetChallenge(httpChallengeKey(domain, token))
+}
+
+// httpChallengeKey builds the key used to store a HTTP-01 challenge response.
+func httpChallengeKey(domain, token string) string {
+	return "http-01:" + normaliseIdentifier(domain) + ":" + token
+}
+
+// TlsAlpnChallengeProvider solves ACME TLS-ALPN-01 challenges by placing the key authorisations in a
+// ChallengeStore, from which the HTTPS listener can build challenge certificates.
+type TlsAlpnChallengeProvider struct {
+	store ChallengeStore
+}
+
+// NewTlsAlpnChallengeProvider creates a new TLS-ALPN-01 challenge provider backed by the given store.
+func NewTlsAlpnChallengeProvider(store ChallengeStore) *TlsAlpnChallengeProvider {
+	return &TlsAlpnChallengeProvider{store: store}
+}
+
+// Present makes the key authorisation available for the given domain.
+func (t *TlsAlpnChallengeProvider) Present(_ context.Context, domain, _, keyAuth string) error {
+	slog.Debug("Presenting TLS-ALPN-01 challenge", "domain", domain)
+	if err := t.store.SaveChallenge(tlsAlpnChallengeKey(domain), keyAuth); err != nil {
+		return fmt.Errorf("unable to save TLS-ALPN-01 challenge for %s: %w", domain, err)
+	}
+	return nil
+}
+
+// CleanUp removes the key authorisation for the given domain.
+func (t *TlsAlpnChallengeProvider) CleanUp(_ context.Context, domain, _, _ string) error {
+	if err := t.store.DeleteChallenge(tlsAlpnChallengeKey(domain)); err != nil {
+		return fmt.Errorf("unable to remove TLS-ALPN-01 challenge for %s: %w", domain, err)
+	}
+	return nil
+}
+
+// TlsAlpnChallengeCertificate returns the certificate that should be served to ACME servers validating the
+// given domain, if a challenge is in progress. Reverse DNS names are treated as the IP address they represent.
+func (t *TlsAlpnChallengeProvider) TlsAlpnChallengeCertificate(domain string) (*tls.Certificate, bool) {
+	if ip, ok := ipFromReverseName(domain); ok {
+		domain = ip
+	}
+
+	keyAuth, ok := t.store.GetChallenge(tlsAlpnChallengeKey(domain))
+	if !ok {
+		return nil, false
+	}
+
+	cert, err := tlsalpn01.ChallengeCert(domain, keyAuth)
+	if err != nil {
+		slog.Error("Unable to create TLS-ALPN-01 challenge certificate", "domain", domain, "error", err)
+		return nil, false
+	}
+	return cert, true
+}
+
+// tlsAlpnChallengeKey builds the key used to store a TLS-ALPN-01 challenge response.
+func tlsAlpnChallengeKey(domain string) string {
+	return "tls-alpn-01:" + normaliseIdentifier(domain)
+}
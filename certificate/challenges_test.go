@@ -0,0 +1,118 @@
+package certificate
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_MemoryChallengeStore_SaveGetDelete(t *testing.T) {
+	store := NewMemoryChallengeStore()
+
+	_, ok := store.GetChallenge("key")
+	assert.False(t, ok)
+
+	require.NoError(t, store.SaveChallenge("key", "response"))
+	response, ok := store.GetChallenge("key")
+	assert.True(t, ok)
+	assert.Equal(t, "response", response)
+
+	require.NoError(t, store.DeleteChallenge("key"))
+	_, ok = store.GetChallenge("key")
+	assert.False(t, ok)
+}
+
+func Test_HttpChallengeProvider_servesPresentedChallenges(t *testing.T) {
+	provider := NewHttpChallengeProvider(NewMemoryChallengeStore())
+
+	require.NoError(t, provider.Present(t.Context(), "Example.com", "token", "token.keyauth"))
+
+	response, ok := provider.HttpChallengeResponse("example.com", "token")
+	assert.True(t, ok)
+	assert.Equal(t, "token.keyauth", response)
+
+	_, ok = provider.HttpChallengeResponse("other.example.com", "token")
+	assert.False(t, ok, "challenges should not be served for other domains")
+
+	_, ok = provider.HttpChallengeResponse("example.com", "other-token")
+	assert.False(t, ok, "challenges should not be served for other tokens")
+}
+
+func Test_HttpChallengeProvider_CleanUp_removesChallenge(t *testing.T) {
+	provider := NewHttpChallengeProvider(NewMemoryChallengeStore())
+
+	require.NoError(t, provider.Present(t.Context(), "example.com", "token", "token.keyauth"))
+	require.NoError(t, provider.CleanUp(t.Context(), "example.com", "token", "token.keyauth"))
+
+	_, ok := provider.HttpChallengeResponse("example.com", "token")
+	assert.False(t, ok)
+}
+
+func Test_TlsAlpnChallengeProvider_createsCertificateForPresentedChallenges(t *testing.T) {
+	provider := NewTlsAlpnChallengeProvider(NewMemoryChallengeStore())
+
+	require.NoError(t, provider.Present(t.Context(), "example.com", "token", "token.keyauth"))
+
+	cert, ok := provider.TlsAlpnChallengeCertificate("Example.com")
+	require.True(t, ok)
+
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Example.com"}, leaf.DNSNames)
+
+	// The acmeIdentifier extension (RFC 8737) contains the SHA-256 digest of the key authorisation
+	expected := sha256.Sum256([]byte("token.keyauth"))
+	var found bool
+	for _, extension := range leaf.Extensions {
+		if extension.Id.Equal(asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 1, 31}) {
+			var digest []byte
+			_, err := asn1.Unmarshal(extension.Value, &digest)
+			require.NoError(t, err)
+			assert.Equal(t, expected[:], digest)
+			found = true
+		}
+	}
+	assert.True(t, found, "certificate should contain the acmeIdentifier extension")
+
+	_, ok = provider.TlsAlpnChallengeCertificate("other.example.com")
+	assert.False(t, ok, "certificates should not be created for other domains")
+}
+
+func Test_HttpChallengeProvider_servesChallengesForIPAddresses(t *testing.T) {
+	provider := NewHttpChallengeProvider(NewMemoryChallengeStore())
+
+	require.NoError(t, provider.Present(t.Context(), "2001:DB8::1", "token", "token.keyauth"))
+
+	response, ok := provider.HttpChallengeResponse("2001:db8:0:0::1", "token")
+	assert.True(t, ok)
+	assert.Equal(t, "token.keyauth", response)
+}
+
+func Test_TlsAlpnChallengeProvider_createsCertificateForReverseNames(t *testing.T) {
+	provider := NewTlsAlpnChallengeProvider(NewMemoryChallengeStore())
+
+	require.NoError(t, provider.Present(t.Context(), "203.0.113.10", "token", "token.keyauth"))
+
+	cert, ok := provider.TlsAlpnChallengeCertificate("10.113.0.203.in-addr.arpa")
+	require.True(t, ok)
+
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	require.NoError(t, err)
+	require.Len(t, leaf.IPAddresses, 1)
+	assert.Equal(t, "203.0.113.10", leaf.IPAddresses[0].String())
+	assert.Empty(t, leaf.DNSNames)
+}
+
+func Test_TlsAlpnChallengeProvider_CleanUp_removesChallenge(t *testing.T) {
+	provider := NewTlsAlpnChallengeProvider(NewMemoryChallengeStore())
+
+	require.NoError(t, provider.Present(t.Context(), "example.com", "token", "token.keyauth"))
+	require.NoError(t, provider.CleanUp(t.Context(), "example.com", "token", "token.keyauth"))
+
+	_, ok := provider.TlsAlpnChallengeCertificate("example.com")
+	assert.False(t, ok)
+}
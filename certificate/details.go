@@ -13,6 +13,7 @@ import (
 // Details contains the details of a certificate we've previously obtained and saved for future use.
 type Details struct {
 	Provider string `json:"provider"`
+	KeyType  string `json:"keyType"`
 	Issuer   string `json:"issuer"`
 
 	PrivateKey  string `json:"privateKey"`
@@ -46,6 +47,27 @@ func (s *Details) ShouldRenew(minimumValidity time.Duration) bool {
 	}
 }
 
+// NextAction returns the time at which the certificate will next need attention: either to be renewed, to have
+// its OCSP staple updated, or to have its renewal information refreshed.
+func (s *Details) NextAction(minimumValidity time.Duration, minimumStapleValidity time.Duration) time.Time {
+	next := s.NotAfter.Add(-minimumValidity)
+	if !s.AriRenewalTime.IsZero() {
+		next = s.AriRenewalTime
+	}
+
+	if !s.AriNextUpdate.IsZero() && s.AriNextUpdate.Before(next) {
+		next = s.AriNextUpdate
+	}
+
+	if s.RequiresStaple() {
+		if staple := s.NextOcspUpdate.Add(-minimumStapleValidity); staple.Before(next) {
+			next = staple
+		}
+	}
+
+	return next
+}
+
 // HasStapleFor indicates whether the OCSP staple covers the entirety of the given period.
 func (s *Details) HasStapleFor(period time.Duration) bool {
 	return s.NextOcspUpdate.After(time.Now().Add(period))
@@ -66,6 +88,19 @@ func (s *Details) IsFor(subject string, altNames []string) bool {
 	return slices.Compare(altNames1, altNames2) == 0
 }
 
+// HasKeyType determines whether this certificate has the given type of key. An empty key type is treated as
+// ECDSA, as certificates saved before key types were tracked are all ECDSA.
+func (s *Details) HasKeyType(keyType string) bool {
+	actual := s.KeyType
+	if actual == "" {
+		actual = KeyTypeECDSA
+	}
+	if keyType == "" {
+		keyType = KeyTypeECDSA
+	}
+	return actual == keyType
+}
+
 // keyPair returns this certificate's public and private key and OCSP staple as a tls.Certificate.
 func (s *Details) keyPair() (*tls.Certificate, error) {
 	cert, err := tls.X509KeyPair([]byte(s.Certificate), []byte(s.PrivateKey))
@@ -114,6 +114,46 @@ func Test_Details_ShouldRenew(t *testing.T) {
 	}
 }
 
+func Test_Details_NextAction(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name           string
+		notAfter       time.Time
+		ariRenewalTime time.Time
+		ariNextUpdate  time.Time
+		nextOcspUpdate time.Time
+		requiresStaple bool
+		want           time.Time
+	}{
+		{"No ARI, no staple", now.Add(time.Hour * 48), time.Time{}, time.Time{}, time.Time{}, false, now.Add(time.Hour * 24)},
+		{"ARI renewal time", now.Add(time.Hour * 48), now.Add(time.Hour * 30), now.Add(time.Hour * 40), time.Time{}, false, now.Add(time.Hour * 30)},
+		{"ARI update due first", now.Add(time.Hour * 48), now.Add(time.Hour * 30), now.Add(time.Hour * 6), time.Time{}, false, now.Add(time.Hour * 6)},
+		{"Staple due first", now.Add(time.Hour * 48), now.Add(time.Hour * 30), now.Add(time.Hour * 6), now.Add(time.Hour * 3), true, now.Add(time.Hour * 2)},
+		{"Staple ignored if not required", now.Add(time.Hour * 48), time.Time{}, time.Time{}, now.Add(time.Hour * 3), false, now.Add(time.Hour * 24)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Details{
+				NotAfter:       tt.notAfter,
+				AriRenewalTime: tt.ariRenewalTime,
+				AriNextUpdate:  tt.ariNextUpdate,
+				NextOcspUpdate: tt.nextOcspUpdate,
+				requiresStaple: &tt.requiresStaple,
+			}
+			assert.Equal(t, tt.want, s.NextAction(time.Hour*24, time.Hour))
+		})
+	}
+}
+
+func Test_Details_HasKeyType(t *testing.T) {
+	assert.True(t, (&Details{}).HasKeyType(KeyTypeECDSA))
+	assert.True(t, (&Details{}).HasKeyType(""))
+	assert.False(t, (&Details{}).HasKeyType(KeyTypeRSA))
+	assert.True(t, (&Details{KeyType: KeyTypeECDSA}).HasKeyType(""))
+	assert.True(t, (&Details{KeyType: KeyTypeRSA}).HasKeyType(KeyTypeRSA))
+	assert.False(t, (&Details{KeyType: KeyTypeRSA}).HasKeyType(KeyTypeECDSA))
+}
+
 func Test_Details_RequiresStaple(t *testing.T) {
 	cert := "-----BEGIN CERTIFICATE-----\nMIIDuDCCAz6gAwIBAgISA/GVWdX7eXUNyfsx+/kGdawlMAoGCCqGSM49BAMDMDIx\nCzAJBgNVBAYTAlVTMRYwFAYDVQQKEw1MZXQncyBFbmNyeXB0MQswCQYDVQQDEwJF\nNjAeFw0yNDEyMzEyMDExNTJaFw0yNTAzMzEyMDExNTFaMB4xHDAaBgNVBAMTE2Nv\nbnRhY3QuY2hhbWV0aC5jb20wdjAQBgcqhkjOPQIBBgUrgQQAIgNiAARascUGB0xf\n2aJMvSxpDw1afgymvDaByYAgHwC+m1rYmUoEFihqbKed7SvsKMjFT9F/1DQtNe3G\nbiijNtgC7vVrUfA7zajrSUnMo5Rh1v8YHhzV7NdIpszF19WRBHx3YNqjggIpMIIC\nJTAOBgNVHQ8BAf8EBAMCB4AwHQYDVR0lBBYwFAYIKwYBBQUHAwEGCCsGAQUFBwMC\nMAwGA1UdEwEB/wQCMAAwHQYDVR0OBBYEFFOC1EYV0Tb205nNhVotz+BnWnkPMB8G\nA1UdIwQYMBaAFJMnRpgDqVFojpjWxEJI2yO/WJTSMFUGCCsGAQUFBwEBBEkwRzAh\nBggrBgEFBQcwAYYVaHR0cDovL2U2Lm8ubGVuY3Iub3JnMCIGCCsGAQUFBzAChhZo\ndHRwOi8vZTYuaS5sZW5jci5vcmcvMB4GA1UdEQQXMBWCE2NvbnRhY3QuY2hhbWV0\naC5jb20wEwYDVR0gBAwwCjAIBgZngQwBAgEwggEFBgorBgEEAdZ5AgQCBIH2BIHz\nAPEAdgCi4wrkRe+9rZt+OO1HZ3dT14JbhJTXK14bLMS5UKRH5wAAAZQeji85AAAE\nAwBHMEUCIQCtpt1X49qC3Sr/hXLMW9OROwBzw5SbHCmWdNnkZa6s3AIgDbIZkxDd\nyaoQZk0aPI/oTNuNY9fMZzcswPw/Cx5S+scAdwDM+w9qhXEJZf6Vm1PO6bJ8IumF\nXA2XjbapflTA/kwNsAAAAZQeji9HAAAEAwBIMEYCIQDrSdM1yABkxJr+b87FQL7N\nTXJIuUmKl0mhWf4iUFUcKQIhAOkcRKmLta6goBR/eNm1xbDmwcldIAtY/UBckMwK\n4J6dMBEGCCsGAQUFBwEYBAUwAwIBBTAKBggqhkjOPQQDAwNoADBlAjEA1Dxzma2E\n8IpQUVT8X42xmcas9WISwLlO7DxN45QNbANnMMXK+/4dKi7cwQY5bX9jAjAhfvjk\nPe1I7vYVaRVBvI0STQ24CMfT8LPd7YJuHVrX2eVWZciUircG0Sg151aKB2g=\n-----END CERTIFICATE-----"
 	details := Details{Certificate: cert}
@@ -0,0 +1,164 @@
+package certificate
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/go-acme/lego/v5/challenge/dns01"
+	"github.com/miekg/dns"
+)
+
+// DnsChallengeServer is a minimal authoritative DNS server that solves ACME DNS-01 challenges. It answers
+// only TXT queries for challenges that are in progress, so the `_acme-challenge` records for a domain can be
+// delegated to Centauri (using a CNAME or NS record) without giving it access to the domain's DNS provider.
+type DnsChallengeServer struct {
+	store ChallengeStore
+
+	// mu serialises updates to the store, as multiple challenges may share the same record name.
+	mu      sync.Mutex
+	servers []*dns.Server
+}
+
+// NewDnsChallengeServer creates a new DNS challenge server backed by the given store. It won't answer queries
+// until Start is called.
+func NewDnsChallengeServer(store ChallengeStore) *DnsChallengeServer {
+	return &DnsChallengeServer{store: store}
+}
+
+// Start listens for DNS queries over both UDP and TCP on the given address.
+func (d *DnsChallengeServer) Start(address string) error {
+	packetConn, err := net.ListenPacket("udp", address)
+	if err != nil {
+		return fmt.Errorf("unable to listen for DNS queries over UDP: %w", err)
+	}
+
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		_ = packetConn.Close()
+		return fmt.Errorf("unable to listen for DNS queries over TCP: %w", err)
+	}
+
+	d.servers = []*dns.Server{
+		{PacketConn: packetConn, Handler: d},
+		{Listener: listener, Handler: d},
+	}
+
+	// Wait for the servers to start, so that they can be reliably stopped straight away.
+	errs := make(chan error, len(d.servers)*2)
+	for _, server := range d.servers {
+		server.NotifyStartedFunc = func() { errs <- nil }
+		go func() {
+			if err := server.ActivateAndServe(); err != nil {
+				slog.Error("DNS challenge server failed", "error", err)
+				errs <- err
+			}
+		}()
+	}
+
+	for range d.servers {
+		if err := <-errs; err != nil {
+			d.Stop(context.Background())
+			return fmt.Errorf("unable to start DNS challenge server: %w", err)
+		}
+	}
+
+	slog.Info("Started DNS challenge server", "address", address)
+	return nil
+}
+
+// Stop stops answering DNS queries.
+func (d *DnsChallengeServer) Stop(ctx context.Context) {
+	for i := range d.servers {
+		_ = d.servers[i].ShutdownContext(ctx)
+	}
+	d.servers = nil
+}
+
+// Present makes the TXT record for the given challenge available.
+func (d *DnsChallengeServer) Present(ctx context.Context, domain, _, keyAuth string) error {
+	info := dns01.GetChallengeInfo(ctx, domain, keyAuth)
+	slog.Debug("Presenting DNS-01 challenge", "domain", domain, "fqdn", info.EffectiveFQDN)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	values := d.records(info.EffectiveFQDN)
+	if slices.Contains(values, info.Value) {
+		return nil
+	}
+
+	if err := d.store.SaveChallenge(dnsChallengeKey(info.EffectiveFQDN), strings.Join(append(values, info.Value), "\n")); err != nil {
+		return fmt.Errorf("unable to save DNS-01 challenge for %s: %w", domain, err)
+	}
+	return nil
+}
+
+// CleanUp removes the TXT record for the given challenge.
+func (d *DnsChallengeServer) CleanUp(ctx context.Context, domain, _, keyAuth string) error {
+	info := dns01.GetChallengeInfo(ctx, domain, keyAuth)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	values := slices.DeleteFunc(d.records(info.EffectiveFQDN), func(value string) bool {
+		return value == info.Value
+	})
+
+	var err error
+	if len(values) == 0 {
+		err = d.store.DeleteChallenge(dnsChallengeKey(info.EffectiveFQDN))
+	} else {
+		err = d.store.SaveChallenge(dnsChallengeKey(info.EffectiveFQDN), strings.Join(values, "\n"))
+	}
+
+	if err != nil {
+		return fmt.Errorf("unable to remove DNS-01 challenge for %s: %w", domain, err)
+	}
+	return nil
+}
+
+// ServeDNS answers a single DNS query. TXT queries for names with challenges in progress are answered with
+// the challenge values; names without challenges don't exist as far as we're concerned.
+func (d *DnsChallengeServer) ServeDNS(writer dns.ResponseWriter, request *dns.Msg) {
+	response := new(dns.Msg)
+	response.SetReply(request)
+	response.Authoritative = true
+
+	if len(request.Question) != 1 {
+		response.Rcode = dns.RcodeFormatError
+	} else if question := request.Question[0]; question.Qclass != dns.ClassINET {
+		response.Rcode = dns.RcodeRefused
+	} else if values := d.records(question.Name); len(values) == 0 {
+		response.Rcode = dns.RcodeNameError
+	} else if question.Qtype == dns.TypeTXT || question.Qtype == dns.TypeANY {
+		for i := range values {
+			response.Answer = append(response.Answer, &dns.TXT{
+				Hdr: dns.RR_Header{Name: question.Name, Rrtype: dns.TypeTXT, Class: dns.ClassINET, Ttl: 0},
+				Txt: []string{values[i]},
+			})
+		}
+	}
+
+	if err := writer.WriteMsg(response); err != nil {
+		slog.Debug("Unable to send DNS response", "remote", writer.RemoteAddr(), "error", err)
+	}
+}
+
+// records returns the TXT values for in-progress challenges at the given name.
+func (d *DnsChallengeServer) records(name string) []string {
+	value, ok := d.store.GetChallenge(dnsChallengeKey(name))
+	if !ok || value == "" {
+		return nil
+	}
+	return strings.Split(value, "\n")
+}
+
+// dnsChallengeKey builds the key used to store the DNS-01 challenge values for a record name.
+func dnsChallengeKey(name string) string {
+	return "dns-01:" + strings.ToLower(dns.Fqdn(name))
+}
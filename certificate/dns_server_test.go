@@ -0,0 +1,118 @@
+package certificate
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// startTestDnsChallengeServer starts a DNS challenge server on a random local port, and returns the address
+// of its UDP and TCP listeners.
+func startTestDnsChallengeServer(t *testing.T) (*DnsChallengeServer, string, string) {
+	t.Helper()
+
+	// Don't try to resolve CNAMEs for the challenge records
+	t.Setenv("LEGO_DISABLE_CNAME_SUPPORT", "true")
+
+	server := NewDnsChallengeServer(NewMemoryChallengeStore())
+	require.NoError(t, server.Start("127.0.0.1:0"))
+	t.Cleanup(func() { server.Stop(t.Context()) })
+
+	return server, server.servers[0].PacketConn.LocalAddr().String(), server.servers[1].Listener.Addr().String()
+}
+
+func queryTXT(t *testing.T, network, address, name string) *dns.Msg {
+	t.Helper()
+
+	request := new(dns.Msg)
+	request.SetQuestion(name, dns.TypeTXT)
+
+	response, _, err := (&dns.Client{Net: network}).Exchange(request, address)
+	require.NoError(t, err)
+	return response
+}
+
+func txtValues(response *dns.Msg) []string {
+	var res []string
+	for _, answer := range response.Answer {
+		if txt, ok := answer.(*dns.TXT); ok {
+			res = append(res, txt.Txt...)
+		}
+	}
+	return res
+}
+
+func challengeValue(keyAuth string) string {
+	digest := sha256.Sum256([]byte(keyAuth))
+	return base64.RawURLEncoding.EncodeToString(digest[:])
+}
+
+func Test_DnsChallengeServer_answersPresentedChallenges(t *testing.T) {
+	server, udpAddress, tcpAddress := startTestDnsChallengeServer(t)
+
+	require.NoError(t, server.Present(t.Context(), "example.com", "token", "token.keyauth"))
+
+	for network, address := range map[string]string{"udp": udpAddress, "tcp": tcpAddress} {
+		t.Run(network, func(t *testing.T) {
+			response := queryTXT(t, network, address, "_acme-challenge.EXAMPLE.com.")
+			assert.Equal(t, dns.RcodeSuccess, response.Rcode)
+			assert.True(t, response.Authoritative)
+			assert.Equal(t, []string{challengeValue("token.keyauth")}, txtValues(response))
+		})
+	}
+}
+
+func Test_DnsChallengeServer_answersMultipleChallengesForTheSameName(t *testing.T) {
+	server, address, _ := startTestDnsChallengeServer(t)
+
+	require.NoError(t, server.Present(t.Context(), "example.com", "token1", "token1.keyauth"))
+	// e.g. when obtaining a certificate for both example.com and *.example.com
+	require.NoError(t, server.Present(t.Context(), "example.com", "token2", "token2.keyauth"))
+
+	response := queryTXT(t, "udp", address, "_acme-challenge.example.com.")
+	assert.ElementsMatch(t, []string{challengeValue("token1.keyauth"), challengeValue("token2.keyauth")}, txtValues(response))
+
+	require.NoError(t, server.CleanUp(t.Context(), "example.com", "token1", "token1.keyauth"))
+
+	response = queryTXT(t, "udp", address, "_acme-challenge.example.com.")
+	assert.Equal(t, []string{challengeValue("token2.keyauth")}, txtValues(response))
+}
+
+func Test_DnsChallengeServer_returnsNameErrorForUnknownNames(t *testing.T) {
+	server, address, _ := startTestDnsChallengeServer(t)
+
+	require.NoError(t, server.Present(t.Context(), "example.com", "token", "token.keyauth"))
+	require.NoError(t, server.CleanUp(t.Context(), "example.com", "token", "token.keyauth"))
+
+	response := queryTXT(t, "udp", address, "_acme-challenge.example.com.")
+	assert.Equal(t, dns.RcodeNameError, response.Rcode)
+	assert.Empty(t, response.Answer)
+
+	response = queryTXT(t, "udp", address, "_acme-challenge.other.example.com.")
+	assert.Equal(t, dns.RcodeNameError, response.Rcode)
+}
+
+func Test_DnsChallengeServer_returnsNoDataForOtherRecordTypes(t *testing.T) {
+	server, address, _ := startTestDnsChallengeServer(t)
+
+	require.NoError(t, server.Present(t.Context(), "example.com", "token", "token.keyauth"))
+
+	request := new(dns.Msg)
+	request.SetQuestion("_acme-challenge.example.com.", dns.TypeA)
+
+	response, _, err := new(dns.Client).Exchange(request, address)
+	require.NoError(t, err)
+	assert.Equal(t, dns.RcodeSuccess, response.Rcode)
+	assert.Empty(t, response.Answer)
+}
+
+func Test_DnsChallengeServer_Start_errorsIfAddressInUse(t *testing.T) {
+	_, address, _ := startTestDnsChallengeServer(t)
+
+	err := NewDnsChallengeServer(NewMemoryChallengeStore()).Start(address)
+	assert.Error(t, err)
+}
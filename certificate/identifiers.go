@@ -0,0 +1,89 @@
+package certificate
+
+import (
+	"net"
+	"net/netip"
+	"slices"
+	"strings"
+)
+
+// isIPIdentifier determines whether the name is an IP address, rather than a domain name.
+func isIPIdentifier(name string) bool {
+	_, err := netip.ParseAddr(name)
+	return err == nil
+}
+
+// hasIPIdentifier determines whether any of the names are IP addresses.
+func hasIPIdentifier(names []string) bool {
+	return slices.ContainsFunc(names, isIPIdentifier)
+}
+
+// splitIdentifiers separates the given names into domain names and IP addresses, as they need to be placed
+// in different fields of a certificate.
+func splitIdentifiers(names []string) ([]string, []net.IP) {
+	var dnsNames []string
+	var ipAddresses []net.IP
+	for _, name := range names {
+		if ip := net.ParseIP(name); ip != nil {
+			ipAddresses = append(ipAddresses, ip)
+		} else {
+			dnsNames = append(dnsNames, name)
+		}
+	}
+	return dnsNames, ipAddresses
+}
+
+// normaliseIdentifier returns the canonical form of the name: domain names are lower-cased, and IP addresses
+// are converted to their shortest form.
+func normaliseIdentifier(name string) string {
+	if addr, err := netip.ParseAddr(name); err == nil {
+		return addr.Unmap().String()
+	}
+	return strings.ToLower(name)
+}
+
+// ipFromReverseName converts a reverse DNS name (such as `10.113.0.203.in-addr.arpa`) back into the IP
+// address it represents. ACME servers use these names as the TLS server name when validating IP addresses
+// using the TLS-ALPN-01 challenge (RFC 8738).
+func ipFromReverseName(name string) (string, bool) {
+	name = strings.TrimSuffix(strings.ToLower(name), ".")
+
+	if labels, ok := strings.CutSuffix(name, ".in-addr.arpa"); ok {
+		parts := strings.Split(labels, ".")
+		if len(parts) != 4 {
+			return "", false
+		}
+		slices.Reverse(parts)
+		addr, err := netip.ParseAddr(strings.Join(parts, "."))
+		if err != nil || !addr.Is4() {
+			return "", false
+		}
+		return addr.String(), true
+	}
+
+	if labels, ok := strings.CutSuffix(name, ".ip6.arpa"); ok {
+		nibbles := strings.Split(labels, ".")
+		if len(nibbles) != 32 {
+			return "", false
+		}
+		slices.Reverse(nibbles)
+
+		var b strings.Builder
+		for i, nibble := range nibbles {
+			if len(nibble) != 1 {
+				return "", false
+			}
+			if i > 0 && i%4 == 0 {
+				b.WriteByte(':')
+			}
+			b.WriteString(nibble)
+		}
+		addr, err := netip.ParseAddr(b.String())
+		if err != nil {
+			return "", false
+		}
+		return addr.String(), true
+	}
+
+	return "", false
+}
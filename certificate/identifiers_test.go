@@ -0,0 +1,46 @@
+package certificate
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_splitIdentifiers(t *testing.T) {
+	dnsNames, ipAddresses := splitIdentifiers([]string{"example.com", "203.0.113.10", "www.example.com", "2001:db8::1"})
+	assert.Equal(t, []string{"example.com", "www.example.com"}, dnsNames)
+	if assert.Len(t, ipAddresses, 2) {
+		assert.Equal(t, "203.0.113.10", ipAddresses[0].String())
+		assert.Equal(t, "2001:db8::1", ipAddresses[1].String())
+	}
+}
+
+func Test_hasIPIdentifier(t *testing.T) {
+	assert.False(t, hasIPIdentifier([]string{"example.com", "www.example.com"}))
+	assert.True(t, hasIPIdentifier([]string{"example.com", "203.0.113.10"}))
+	assert.True(t, hasIPIdentifier([]string{"2001:db8::1"}))
+}
+
+func Test_ipFromReverseName(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+		ok   bool
+	}{
+		{"10.113.0.203.in-addr.arpa", "203.0.113.10", true},
+		{"10.113.0.203.IN-ADDR.ARPA.", "203.0.113.10", true},
+		{"1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6.arpa", "2001:db8::1", true},
+		{"113.0.203.in-addr.arpa", "", false},
+		{"300.113.0.203.in-addr.arpa", "", false},
+		{"1.0.0.ip6.arpa", "", false},
+		{"example.com", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := ipFromReverseName(tt.name)
+			assert.Equal(t, tt.ok, ok)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
@@ -0,0 +1,30 @@
+package certificate
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"fmt"
+)
+
+const (
+	// KeyTypeECDSA identifies certificates with an ECDSA key. These are smaller and faster than RSA, and are
+	// supported by all modern clients.
+	KeyTypeECDSA = "ecdsa"
+	// KeyTypeRSA identifies certificates with an RSA key, for legacy clients that don't support ECDSA.
+	KeyTypeRSA = "rsa"
+)
+
+// generateKey creates a new private key of the given type, for suppliers that sign certificates themselves.
+func generateKey(keyType string) (crypto.Signer, error) {
+	switch keyType {
+	case KeyTypeECDSA:
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case KeyTypeRSA:
+		return rsa.GenerateKey(rand.Reader, 2048)
+	default:
+		return nil, fmt.Errorf("unsupported key type: %s", keyType)
+	}
+}
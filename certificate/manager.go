@@ -3,6 +3,7 @@ package certificate
 import (
 	"context"
 	"crypto/tls"
+	"errors"
 	"fmt"
 	"log/slog"
 	"time"
@@ -10,15 +11,26 @@ import (
 
 // Store provides functions to get and store certificates.
 type Store interface {
-	GetCertificate(provider string, subject string, altNames []string) *Details
+	GetCertificate(provider string, keyType string, subject string, altNames []string) *Details
 	SaveCertificate(cert *Details) error
 	LockCertificate(subjectName string, altNames []string)
 	UnlockCertificate(subjectName string, altNames []string)
 }
 
+// ManagedStore is a Store whose certificates can also be listed and deleted, so they can be administered outside
+// of normal operation.
+type ManagedStore interface {
+	Store
+	// Certificates returns all certificates held by the store, including any that are no longer valid.
+	Certificates() ([]*Details, error)
+	// DeleteCertificate removes the certificate with the same provider, key type, subject and alt names as the
+	// given one. It is not an error if no such certificate exists.
+	DeleteCertificate(cert *Details) error
+}
+
 // Supplier provides new certificates and OCSP staples.
 type Supplier interface {
-	GetCertificate(ctx context.Context, subject string, altNames []string, shouldStaple bool) (*Details, error)
+	GetCertificate(ctx context.Context, keyType string, subject string, altNames []string, shouldStaple bool) (*Details, error)
 	UpdateStaple(ctx context.Context, cert *Details) error
 	UpdateRenewalInfo(ctx context.Context, cert *Details) error
 	MinCertificateValidity() time.Duration
@@ -32,6 +44,7 @@ type Manager struct {
 	store              Store
 	suppliers          map[string]Supplier
 	supplierPreference []string
+	fallbackSuppliers  []string
 }
 
 // NewManager returns a new certificate manager backed by the given store and supplier.
@@ -44,9 +57,15 @@ func NewManager(store Store, suppliers map[string]Supplier, supplierPreference [
 	}
 }
 
-// GetCertificate returns a certificate for the given subject and alternate names. This may take some time if a new
-// certificate needs to be obtained, or the OCSP staple needs to be updated.
-func (m *Manager) GetCertificate(ctx context.Context, preferredSupplier string, subject string, altNames []string) (*tls.Certificate, error) {
+// SetFallbackSuppliers configures suppliers that will be tried, in order, if a certificate can't be obtained from
+// the supplier that would normally be used.
+func (m *Manager) SetFallbackSuppliers(names []string) {
+	m.fallbackSuppliers = names
+}
+
+// GetCertificate returns a certificate with the given key type for the subject and alternate names. This may take
+// some time if a new certificate needs to be obtained, or the OCSP staple needs to be updated.
+func (m *Manager) GetCertificate(ctx context.Context, preferredSupplier string, keyType string, subject string, altNames []string) (*tls.Certificate, error) {
 	supplier, supplierName, err := m.supplier(preferredSupplier)
 	if err != nil {
 		return nil, err
@@ -55,10 +74,10 @@ func (m *Manager) GetCertificate(ctx context.Context, preferredSupplier string,
 	m.store.LockCertificate(subject, altNames)
 	defer m.store.UnlockCertificate(subject, altNames)
 
-	cert := m.store.GetCertificate(supplierName, subject, altNames)
+	cert := m.store.GetCertificate(supplierName, keyType, subject, altNames)
 	if cert == nil {
-		slog.Info("Obtaining new certificate", "domain", subject, "altNames", altNames)
-		return m.obtain(ctx, supplier, supplierName, subject, altNames)
+		slog.Info("Obtaining new certificate", "domain", subject, "altNames", altNames, "keyType", keyType)
+		return m.obtainWithFallback(ctx, supplier, supplierName, keyType, subject, altNames)
 	}
 
 	if cert.AriNextUpdate.Before(time.Now()) {
@@ -66,8 +85,8 @@ func (m *Manager) GetCertificate(ctx context.Context, preferredSupplier string,
 	}
 
 	if cert.ShouldRenew(supplier.MinCertificateValidity()) {
-		slog.Info("Renewing certificate", "domain", subject, "altNames", altNames)
-		return m.obtain(ctx, supplier, supplierName, subject, altNames)
+		slog.Info("Renewing certificate", "domain", subject, "altNames", altNames, "keyType", keyType)
+		return m.obtainWithFallback(ctx, supplier, supplierName, keyType, subject, altNames)
 	}
 
 	if cert.RequiresStaple() && !cert.HasStapleFor(supplier.MinStapleValidity()) {
@@ -78,16 +97,17 @@ func (m *Manager) GetCertificate(ctx context.Context, preferredSupplier string,
 	return cert.keyPair()
 }
 
-// GetExistingCertificate returns a previously saved certificate with the given subject and alternate names if it is
-// still valid. It also indicates whether the certificate is in need of renewal or not. Certificates should be renewed
+// GetExistingCertificate returns a previously saved certificate with the given key type, subject and alternate names
+// if it is still valid. It also indicates whether the certificate is in need of renewal or not. Certificates should be renewed
 // by calling GetCertificate, which will block and return the new certificate.
-func (m *Manager) GetExistingCertificate(preferredSupplier string, subject string, altNames []string) (*tls.Certificate, bool, error) {
+func (m *Manager) GetExistingCertificate(preferredSupplier string, keyType string, subject string, altNames []string) (*tls.Certificate, bool, error) {
 	supplier, supplierName, err := m.supplier(preferredSupplier)
 	if err != nil {
 		return nil, false, err
 	}
 
-	if cert := m.store.GetCertificate(supplierName, subject, altNames); cert == nil {
+	cert, supplier := m.existing(supplier, supplierName, keyType, subject, altNames)
+	if cert == nil {
 		return nil, true, fmt.Errorf("no stored certificate found")
 	} else if !cert.ValidFor(0) || (cert.RequiresStaple() && !cert.HasStapleFor(0)) {
 		return nil, true, fmt.Errorf("certificate has expired")
@@ -98,6 +118,40 @@ func (m *Manager) GetExistingCertificate(preferredSupplier string, subject strin
 	}
 }
 
+// NextCertificateAction returns the time at which the previously saved certificate with the given key type,
+// subject and alternate names will next need to be renewed, or have its OCSP staple or renewal information
+// updated, by calling GetCertificate.
+func (m *Manager) NextCertificateAction(preferredSupplier string, keyType string, subject string, altNames []string) (time.Time, error) {
+	supplier, supplierName, err := m.supplier(preferredSupplier)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	cert, supplier := m.existing(supplier, supplierName, keyType, subject, altNames)
+	if cert == nil {
+		return time.Time{}, fmt.Errorf("no stored certificate found")
+	}
+
+	return cert.NextAction(supplier.MinCertificateValidity(), supplier.MinStapleValidity()), nil
+}
+
+// existing returns the saved certificate from the given supplier. If it doesn't have a valid one, certificates
+// previously issued by the fallback suppliers are used instead. The supplier that issued the returned
+// certificate is also returned.
+func (m *Manager) existing(supplier Supplier, supplierName string, keyType string, subject string, altNames []string) (*Details, Supplier) {
+	cert := m.store.GetCertificate(supplierName, keyType, subject, altNames)
+	if cert == nil || !cert.ValidFor(0) {
+		for _, name := range m.fallbackSuppliers {
+			if fallback, ok := m.suppliers[name]; ok && name != supplierName {
+				if c := m.store.GetCertificate(name, keyType, subject, altNames); c != nil && c.ValidFor(0) {
+					return c, fallback
+				}
+			}
+		}
+	}
+	return cert, supplier
+}
+
 func (m *Manager) supplier(preferred string) (Supplier, string, error) {
 	if preferred != "" {
 		s, ok := m.suppliers[preferred]
@@ -117,13 +171,14 @@ func (m *Manager) supplier(preferred string) (Supplier, string, error) {
 }
 
 // obtain gets a new certificate and saves it to the store.
-func (m *Manager) obtain(ctx context.Context, supplier Supplier, supplierName string, subject string, altNames []string) (*tls.Certificate, error) {
-	cert, err := supplier.GetCertificate(ctx, subject, altNames, m.shouldStaple)
+func (m *Manager) obtain(ctx context.Context, supplier Supplier, supplierName string, keyType string, subject string, altNames []string) (*tls.Certificate, error) {
+	cert, err := supplier.GetCertificate(ctx, keyType, subject, altNames, m.shouldStaple)
 	if err != nil {
 		return nil, fmt.Errorf("failed to obtain certificate for %s: %w", subject, err)
 	}
 
 	cert.Provider = supplierName
+	cert.KeyType = keyType
 
 	m.updateRenewalInfo(ctx, supplier, cert, false)
 
@@ -134,6 +189,39 @@ func (m *Manager) obtain(ctx context.Context, supplier Supplier, supplierName st
 	return cert.keyPair()
 }
 
+// obtainWithFallback gets a new certificate from the given supplier. If that fails, each of the fallback suppliers
+// is tried in turn, reusing any valid certificate they previously issued rather than obtaining another one.
+func (m *Manager) obtainWithFallback(ctx context.Context, supplier Supplier, supplierName string, keyType string, subject string, altNames []string) (*tls.Certificate, error) {
+	cert, err := m.obtain(ctx, supplier, supplierName, keyType, subject, altNames)
+	if err == nil {
+		return cert, nil
+	}
+
+	errs := []error{err}
+	for _, name := range m.fallbackSuppliers {
+		fallback, ok := m.suppliers[name]
+		if !ok || name == supplierName {
+			continue
+		}
+
+		slog.Warn("Failed to obtain certificate, trying fallback supplier", "domain", subject, "altNames", altNames, "supplier", supplierName, "fallback", name, "error", errs[len(errs)-1])
+		if existing := m.store.GetCertificate(name, keyType, subject, altNames); existing != nil && !existing.ShouldRenew(fallback.MinCertificateValidity()) {
+			if existing.RequiresStaple() && !existing.HasStapleFor(fallback.MinStapleValidity()) {
+				return m.staple(ctx, fallback, existing)
+			}
+			return existing.keyPair()
+		}
+
+		cert, err := m.obtain(ctx, fallback, name, keyType, subject, altNames)
+		if err == nil {
+			return cert, nil
+		}
+		errs = append(errs, err)
+	}
+
+	return nil, errors.Join(errs...)
+}
+
 // staple updates the OCSP staple for the cert and saves it in the store.
 func (m *Manager) staple(ctx context.Context, supplier Supplier, cert *Details) (*tls.Certificate, error) {
 	if err := supplier.UpdateStaple(ctx, cert); err != nil {
@@ -14,9 +14,11 @@ import (
 
 type fakeStore struct {
 	provider     string
+	keyType      string
 	subject      string
 	altNames     []string
 	certificate  *Details
+	byProvider   map[string]*Details
 	savedCert    *Details
 	err          error
 	locked       bool
@@ -24,11 +26,15 @@ type fakeStore struct {
 	lockedOnGet  bool
 }
 
-func (f *fakeStore) GetCertificate(provider string, subject string, altNames []string) *Details {
+func (f *fakeStore) GetCertificate(provider string, keyType string, subject string, altNames []string) *Details {
 	f.lockedOnGet = f.locked && subject == f.subject && slices.Equal(altNames, f.altNames)
 	f.provider = provider
+	f.keyType = keyType
 	f.subject = subject
 	f.altNames = altNames
+	if f.byProvider != nil {
+		return f.byProvider[provider]
+	}
 	return f.certificate
 }
 
@@ -53,13 +59,15 @@ func (f *fakeStore) UnlockCertificate(subject string, altNames []string) {
 type fakeSupplier struct {
 	certificate     *Details
 	renewalInfoCert *Details
+	keyType         string
 	subject         string
 	altNames        []string
 	shouldStaple    bool
 	err             error
 }
 
-func (f *fakeSupplier) GetCertificate(_ context.Context, subject string, altNames []string, shouldStaple bool) (*Details, error) {
+func (f *fakeSupplier) GetCertificate(_ context.Context, keyType string, subject string, altNames []string, shouldStaple bool) (*Details, error) {
+	f.keyType = keyType
 	f.subject = subject
 	f.altNames = altNames
 	f.shouldStaple = shouldStaple
@@ -131,7 +139,7 @@ func Test_Manager_GetCertificate_retrievesFromStoreIfValid(t *testing.T) {
 		true,
 	)
 
-	c, err := manager.GetCertificate(t.Context(), "", "example.com", []string{"example.net"})
+	c, err := manager.GetCertificate(t.Context(), "", KeyTypeECDSA, "example.com", []string{"example.net"})
 	require.NoError(t, err)
 	assert.Equal(t, cert.Certificate, string(certcrypto.PEMEncode(certcrypto.DERCertificateBytes(c.Certificate[0]))))
 	assert.Equal(t, cert.PrivateKey, string(certcrypto.PEMEncode(c.PrivateKey)))
@@ -160,7 +168,7 @@ func Test_Manager_GetCertificate_updatesStapleIfTooOld(t *testing.T) {
 		true,
 	)
 
-	c, err := manager.GetCertificate(t.Context(), "", "example.com", []string{"example.net"})
+	c, err := manager.GetCertificate(t.Context(), "", KeyTypeECDSA, "example.com", []string{"example.net"})
 	require.NoError(t, err)
 	assert.Equal(t, cert.Certificate, string(certcrypto.PEMEncode(certcrypto.DERCertificateBytes(c.Certificate[0]))))
 	assert.Equal(t, cert.PrivateKey, string(certcrypto.PEMEncode(c.PrivateKey)))
@@ -192,7 +200,7 @@ func Test_Manager_GetCertificate_ignoresStapleIfCertDoesntRequire(t *testing.T)
 		true,
 	)
 
-	c, err := manager.GetCertificate(t.Context(), "", "example.com", []string{"example.net"})
+	c, err := manager.GetCertificate(t.Context(), "", KeyTypeECDSA, "example.com", []string{"example.net"})
 	require.NoError(t, err)
 	assert.Equal(t, cert.Certificate, string(certcrypto.PEMEncode(certcrypto.DERCertificateBytes(c.Certificate[0]))))
 	assert.Equal(t, cert.PrivateKey, string(certcrypto.PEMEncode(c.PrivateKey)))
@@ -222,7 +230,7 @@ func Test_Manager_GetCertificate_returnsErrorIfStaplingFails(t *testing.T) {
 		true,
 	)
 
-	_, err := manager.GetCertificate(t.Context(), "", "example.com", []string{"example.net"})
+	_, err := manager.GetCertificate(t.Context(), "", KeyTypeECDSA, "example.com", []string{"example.net"})
 	require.Error(t, err)
 }
 
@@ -246,7 +254,7 @@ func Test_Manager_GetCertificate_returnsErrorIfSavingAfterStaplingFails(t *testi
 		true,
 	)
 
-	_, err := manager.GetCertificate(t.Context(), "", "example.com", []string{"example.net"})
+	_, err := manager.GetCertificate(t.Context(), "", KeyTypeECDSA, "example.com", []string{"example.net"})
 	require.Error(t, err)
 }
 
@@ -270,7 +278,7 @@ func Test_Manager_GetCertificate_obtainsCertificateIfMissing(t *testing.T) {
 		true,
 	)
 
-	c, err := manager.GetCertificate(t.Context(), "", "example.com", []string{"example.net"})
+	c, err := manager.GetCertificate(t.Context(), "", KeyTypeECDSA, "example.com", []string{"example.net"})
 	require.NoError(t, err)
 	assert.Equal(t, cert.Certificate, string(certcrypto.PEMEncode(certcrypto.DERCertificateBytes(c.Certificate[0]))))
 	assert.Equal(t, cert.PrivateKey, string(certcrypto.PEMEncode(c.PrivateKey)))
@@ -282,6 +290,30 @@ func Test_Manager_GetCertificate_obtainsCertificateIfMissing(t *testing.T) {
 	assert.Equal(t, []string{"example.net"}, supplier.altNames)
 }
 
+func Test_Manager_GetCertificate_passesKeyTypeToStoreAndSupplier(t *testing.T) {
+	cert := &Details{
+		NotAfter:    time.Now().Add(time.Hour * 2),
+		Certificate: certPem,
+		PrivateKey:  keyPem,
+	}
+
+	store := &fakeStore{}
+	supplier := &fakeSupplier{certificate: cert}
+
+	manager := NewManager(
+		store,
+		map[string]Supplier{"test": supplier},
+		[]string{"test"},
+		false,
+	)
+
+	_, err := manager.GetCertificate(t.Context(), "", KeyTypeRSA, "example.com", nil)
+	require.NoError(t, err)
+	assert.Equal(t, KeyTypeRSA, store.keyType)
+	assert.Equal(t, KeyTypeRSA, supplier.keyType)
+	assert.Equal(t, KeyTypeRSA, store.savedCert.KeyType, "should set key type on saved cert")
+}
+
 func Test_Manager_GetCertificate_updatesARIWhenObtaining(t *testing.T) {
 	cert := &Details{
 		NotAfter:       time.Now().Add(time.Hour * 2),
@@ -302,7 +334,7 @@ func Test_Manager_GetCertificate_updatesARIWhenObtaining(t *testing.T) {
 		true,
 	)
 
-	_, err := manager.GetCertificate(t.Context(), "", "example.com", []string{"example.net"})
+	_, err := manager.GetCertificate(t.Context(), "", KeyTypeECDSA, "example.com", []string{"example.net"})
 	require.NoError(t, err)
 	assert.Equal(t, cert, supplier.renewalInfoCert, "should call UpdateRenewalInfo with new certificate")
 }
@@ -327,7 +359,7 @@ func Test_Manager_GetCertificate_obtainsCertificateIfValidityTooShort(t *testing
 		true,
 	)
 
-	c, err := manager.GetCertificate(t.Context(), "", "example.com", []string{"example.net"})
+	c, err := manager.GetCertificate(t.Context(), "", KeyTypeECDSA, "example.com", []string{"example.net"})
 	require.NoError(t, err)
 	assert.Equal(t, cert.Certificate, string(certcrypto.PEMEncode(certcrypto.DERCertificateBytes(c.Certificate[0]))))
 	assert.Equal(t, cert.PrivateKey, string(certcrypto.PEMEncode(c.PrivateKey)))
@@ -358,7 +390,7 @@ func Test_Manager_GetCertificate_updatesARIIfStale(t *testing.T) {
 		true,
 	)
 
-	_, err := manager.GetCertificate(t.Context(), "", "example.com", []string{"example.net"})
+	_, err := manager.GetCertificate(t.Context(), "", KeyTypeECDSA, "example.com", []string{"example.net"})
 	require.NoError(t, err)
 	assert.Equal(t, cert, supplier.renewalInfoCert, "should call UpdateRenewalInfo with certificate")
 	assert.Equal(t, cert, store.savedCert, "should save certificate after ARI update")
@@ -385,7 +417,7 @@ func Test_Manager_GetCertificate_obtainsCertificateIfARISaysRenew(t *testing.T)
 		true,
 	)
 
-	c, err := manager.GetCertificate(t.Context(), "", "example.com", []string{"example.net"})
+	c, err := manager.GetCertificate(t.Context(), "", KeyTypeECDSA, "example.com", []string{"example.net"})
 	require.NoError(t, err)
 	assert.Equal(t, cert.Certificate, string(certcrypto.PEMEncode(certcrypto.DERCertificateBytes(c.Certificate[0]))))
 	assert.Equal(t, cert.PrivateKey, string(certcrypto.PEMEncode(c.PrivateKey)))
@@ -406,7 +438,7 @@ func Test_Manager_GetCertificate_returnsErrorIfSupplierFails(t *testing.T) {
 		true,
 	)
 
-	_, err := manager.GetCertificate(t.Context(), "", "example.com", []string{"example.net"})
+	_, err := manager.GetCertificate(t.Context(), "", KeyTypeECDSA, "example.com", []string{"example.net"})
 	require.Error(t, err)
 }
 
@@ -430,7 +462,7 @@ func Test_Manager_GetCertificate_returnsErrorIfSavingNewCertFails(t *testing.T)
 		true,
 	)
 
-	_, err := manager.GetCertificate(t.Context(), "", "example.com", []string{"example.net"})
+	_, err := manager.GetCertificate(t.Context(), "", KeyTypeECDSA, "example.com", []string{"example.net"})
 	require.Error(t, err)
 }
 
@@ -454,7 +486,7 @@ func Test_Manager_GetCertificate_usesPreferredSupplierIfSpecified(t *testing.T)
 		true,
 	)
 
-	c, err := manager.GetCertificate(t.Context(), "test", "example.com", []string{"example.net"})
+	c, err := manager.GetCertificate(t.Context(), "test", KeyTypeECDSA, "example.com", []string{"example.net"})
 	require.NoError(t, err)
 	assert.Equal(t, cert.Certificate, string(certcrypto.PEMEncode(certcrypto.DERCertificateBytes(c.Certificate[0]))))
 	assert.Equal(t, cert.PrivateKey, string(certcrypto.PEMEncode(c.PrivateKey)))
@@ -473,7 +505,7 @@ func Test_Manager_GetCertificate_errorsIfPreferredSupplierNotFound(t *testing.T)
 		true,
 	)
 
-	_, err := manager.GetCertificate(t.Context(), "another", "example.com", []string{"example.net"})
+	_, err := manager.GetCertificate(t.Context(), "another", KeyTypeECDSA, "example.com", []string{"example.net"})
 	require.Error(t, err)
 }
 
@@ -485,7 +517,7 @@ func Test_Manager_GetCertificate_errorsIfNoSuppliersFound(t *testing.T) {
 		true,
 	)
 
-	_, err := manager.GetCertificate(t.Context(), "", "example.com", []string{"example.net"})
+	_, err := manager.GetCertificate(t.Context(), "", KeyTypeECDSA, "example.com", []string{"example.net"})
 	require.Error(t, err)
 }
 
@@ -508,7 +540,7 @@ func Test_Manager_GetCertificate_usesSupplierPreferenceIfPreferredSupplierNotSpe
 		supplierPreference: []string{"missing", "rubbish", "test", "other"},
 	}
 
-	c, err := manager.GetCertificate(t.Context(), "", "example.com", []string{"example.net"})
+	c, err := manager.GetCertificate(t.Context(), "", KeyTypeECDSA, "example.com", []string{"example.net"})
 	require.NoError(t, err)
 	assert.Equal(t, cert.Certificate, string(certcrypto.PEMEncode(certcrypto.DERCertificateBytes(c.Certificate[0]))))
 	assert.Equal(t, cert.PrivateKey, string(certcrypto.PEMEncode(c.PrivateKey)))
@@ -519,6 +551,81 @@ func Test_Manager_GetCertificate_usesSupplierPreferenceIfPreferredSupplierNotSpe
 	assert.Equal(t, []string{"example.net"}, supplier.altNames)
 }
 
+func Test_Manager_GetCertificate_triesFallbackSuppliersIfSupplierFails(t *testing.T) {
+	cert := &Details{
+		NotAfter:       time.Now().Add(time.Hour * 48),
+		NextOcspUpdate: time.Now().Add(time.Hour * 2),
+		Certificate:    certPem,
+		PrivateKey:     keyPem,
+		requiresStaple: &fs,
+	}
+
+	store := &fakeStore{}
+	failing := &fakeSupplier{err: fmt.Errorf("rate limited")}
+	fallback := &fakeSupplier{certificate: cert}
+
+	manager := NewManager(
+		store,
+		map[string]Supplier{"primary": failing, "broken": &fakeSupplier{err: fmt.Errorf("oops")}, "fallback": fallback},
+		[]string{"primary"},
+		true,
+	)
+	manager.SetFallbackSuppliers([]string{"missing", "broken", "fallback"})
+
+	c, err := manager.GetCertificate(t.Context(), "", KeyTypeECDSA, "example.com", []string{"example.net"})
+	require.NoError(t, err)
+	assert.Equal(t, cert.Certificate, string(certcrypto.PEMEncode(certcrypto.DERCertificateBytes(c.Certificate[0]))))
+	assert.Equal(t, "example.com", failing.subject)
+	assert.Equal(t, "example.com", fallback.subject)
+	assert.Equal(t, "fallback", store.savedCert.Provider, "should record the supplier that issued the cert")
+}
+
+func Test_Manager_GetCertificate_reusesCertificateFromFallbackSupplier(t *testing.T) {
+	cert := &Details{
+		Provider:       "fallback",
+		NotAfter:       time.Now().Add(time.Hour * 48),
+		NextOcspUpdate: time.Now().Add(time.Hour * 2),
+		Certificate:    certPem,
+		PrivateKey:     keyPem,
+		requiresStaple: &fs,
+		AriNextUpdate:  time.Now().Add(time.Hour),
+	}
+
+	store := &fakeStore{byProvider: map[string]*Details{"fallback": cert}}
+	failing := &fakeSupplier{err: fmt.Errorf("rate limited")}
+	fallback := &fakeSupplier{}
+
+	manager := NewManager(
+		store,
+		map[string]Supplier{"primary": failing, "fallback": fallback},
+		[]string{"primary"},
+		true,
+	)
+	manager.SetFallbackSuppliers([]string{"fallback"})
+
+	c, err := manager.GetCertificate(t.Context(), "", KeyTypeECDSA, "example.com", []string{"example.net"})
+	require.NoError(t, err)
+	assert.Equal(t, cert.Certificate, string(certcrypto.PEMEncode(certcrypto.DERCertificateBytes(c.Certificate[0]))))
+	assert.Equal(t, "example.com", failing.subject, "should try the primary supplier first")
+	assert.Equal(t, "", fallback.subject, "should not obtain a new cert from the fallback")
+	assert.Nil(t, store.savedCert)
+}
+
+func Test_Manager_GetCertificate_returnsAllErrorsIfFallbacksFail(t *testing.T) {
+	manager := NewManager(
+		&fakeStore{},
+		map[string]Supplier{"primary": &fakeSupplier{err: fmt.Errorf("rate limited")}, "fallback": &fakeSupplier{err: fmt.Errorf("outage")}},
+		[]string{"primary"},
+		true,
+	)
+	manager.SetFallbackSuppliers([]string{"primary", "fallback"})
+
+	_, err := manager.GetCertificate(t.Context(), "", KeyTypeECDSA, "example.com", []string{"example.net"})
+	require.Error(t, err)
+	assert.ErrorContains(t, err, "rate limited")
+	assert.ErrorContains(t, err, "outage")
+}
+
 func Test_Manager_GetCertificate_acquiresLockWhenGettingCert(t *testing.T) {
 	cert := &Details{
 		NotAfter:       time.Now().Add(time.Hour * 36),
@@ -539,7 +646,7 @@ func Test_Manager_GetCertificate_acquiresLockWhenGettingCert(t *testing.T) {
 		true,
 	)
 
-	_, err := manager.GetCertificate(t.Context(), "", "example.com", []string{"example.net"})
+	_, err := manager.GetCertificate(t.Context(), "", KeyTypeECDSA, "example.com", []string{"example.net"})
 	require.NoError(t, err)
 	assert.True(t, store.lockedOnGet)
 }
@@ -564,7 +671,7 @@ func Test_Manager_GetCertificate_releasesLockOnCompletion(t *testing.T) {
 		true,
 	)
 
-	_, err := manager.GetCertificate(t.Context(), "", "example.com", []string{"example.net"})
+	_, err := manager.GetCertificate(t.Context(), "", KeyTypeECDSA, "example.com", []string{"example.net"})
 	require.NoError(t, err)
 	assert.False(t, store.locked)
 }
@@ -591,7 +698,7 @@ func Test_Manager_GetCertificate_holdsLockWhenSaving(t *testing.T) {
 		true,
 	)
 
-	_, err := manager.GetCertificate(t.Context(), "", "example.com", []string{"example.net"})
+	_, err := manager.GetCertificate(t.Context(), "", KeyTypeECDSA, "example.com", []string{"example.net"})
 	require.NoError(t, err)
 	assert.True(t, store.lockedOnSave)
 }
@@ -616,7 +723,7 @@ func Test_Manager_GetExistingCertificate_retrievesFromStoreWhenValid(t *testing.
 		true,
 	)
 
-	c, r, err := manager.GetExistingCertificate("", "example.com", []string{"example.net"})
+	c, r, err := manager.GetExistingCertificate("", KeyTypeECDSA, "example.com", []string{"example.net"})
 	require.NoError(t, err)
 	assert.Equal(t, cert.Certificate, string(certcrypto.PEMEncode(certcrypto.DERCertificateBytes(c.Certificate[0]))))
 	assert.Equal(t, cert.PrivateKey, string(certcrypto.PEMEncode(c.PrivateKey)))
@@ -644,7 +751,7 @@ func Test_Manager_GetExistingCertificate_retrievesFromStoreWhenExpiringSoon(t *t
 		true,
 	)
 
-	c, r, err := manager.GetExistingCertificate("", "example.com", []string{"example.net"})
+	c, r, err := manager.GetExistingCertificate("", KeyTypeECDSA, "example.com", []string{"example.net"})
 	require.NoError(t, err)
 	assert.Equal(t, cert.Certificate, string(certcrypto.PEMEncode(certcrypto.DERCertificateBytes(c.Certificate[0]))))
 	assert.Equal(t, cert.PrivateKey, string(certcrypto.PEMEncode(c.PrivateKey)))
@@ -672,7 +779,7 @@ func Test_Manager_GetExistingCertificate_retrievesFromStoreWhenNeedsStapleSoon(t
 		true,
 	)
 
-	c, r, err := manager.GetExistingCertificate("", "example.com", []string{"example.net"})
+	c, r, err := manager.GetExistingCertificate("", KeyTypeECDSA, "example.com", []string{"example.net"})
 	require.NoError(t, err)
 	assert.Equal(t, cert.Certificate, string(certcrypto.PEMEncode(certcrypto.DERCertificateBytes(c.Certificate[0]))))
 	assert.Equal(t, cert.PrivateKey, string(certcrypto.PEMEncode(c.PrivateKey)))
@@ -700,7 +807,7 @@ func Test_Manager_GetExistingCertificate_ignoresExpiringStapleIfNotRequiredByCer
 		true,
 	)
 
-	c, r, err := manager.GetExistingCertificate("", "example.com", []string{"example.net"})
+	c, r, err := manager.GetExistingCertificate("", KeyTypeECDSA, "example.com", []string{"example.net"})
 	require.NoError(t, err)
 	assert.Equal(t, cert.Certificate, string(certcrypto.PEMEncode(certcrypto.DERCertificateBytes(c.Certificate[0]))))
 	assert.Equal(t, cert.PrivateKey, string(certcrypto.PEMEncode(c.PrivateKey)))
@@ -727,7 +834,7 @@ func Test_Manager_GetExistingCertificate_errorsWhenExpired(t *testing.T) {
 		true,
 	)
 
-	_, r, err := manager.GetExistingCertificate("", "example.com", []string{"example.net"})
+	_, r, err := manager.GetExistingCertificate("", KeyTypeECDSA, "example.com", []string{"example.net"})
 	require.Error(t, err)
 	assert.True(t, r)
 }
@@ -752,7 +859,7 @@ func Test_Manager_GetExistingCertificate_errorsWhenNotStapled(t *testing.T) {
 		true,
 	)
 
-	_, r, err := manager.GetExistingCertificate("", "example.com", []string{"example.net"})
+	_, r, err := manager.GetExistingCertificate("", KeyTypeECDSA, "example.com", []string{"example.net"})
 	require.Error(t, err)
 	assert.True(t, r)
 }
@@ -768,7 +875,7 @@ func Test_Manager_GetExistingCertificate_errorsWhenNoCertificateExists(t *testin
 		true,
 	)
 
-	_, r, err := manager.GetExistingCertificate("", "example.com", []string{"example.net"})
+	_, r, err := manager.GetExistingCertificate("", KeyTypeECDSA, "example.com", []string{"example.net"})
 	require.Error(t, err)
 	assert.True(t, r)
 }
@@ -784,7 +891,65 @@ func Test_Manager_GetExistingCertificate_errorsWhenSupplierInvalid(t *testing.T)
 		true,
 	)
 
-	_, r, err := manager.GetExistingCertificate("blah", "example.com", []string{"example.net"})
+	_, r, err := manager.GetExistingCertificate("blah", KeyTypeECDSA, "example.com", []string{"example.net"})
 	require.Error(t, err)
 	assert.False(t, r)
 }
+
+func Test_Manager_GetExistingCertificate_usesCertificateFromFallbackSupplier(t *testing.T) {
+	cert := &Details{
+		Provider:       "fallback",
+		NotAfter:       time.Now().Add(time.Hour * 48),
+		NextOcspUpdate: time.Now().Add(time.Hour * 2),
+		Certificate:    certPem,
+		PrivateKey:     keyPem,
+		requiresStaple: &fs,
+	}
+
+	manager := NewManager(
+		&fakeStore{byProvider: map[string]*Details{"fallback": cert}},
+		map[string]Supplier{"primary": &fakeSupplier{}, "fallback": &fakeSupplier{}},
+		[]string{"primary"},
+		true,
+	)
+	manager.SetFallbackSuppliers([]string{"fallback"})
+
+	c, r, err := manager.GetExistingCertificate("", KeyTypeECDSA, "example.com", []string{"example.net"})
+	require.NoError(t, err)
+	assert.False(t, r)
+	assert.Equal(t, cert.Certificate, string(certcrypto.PEMEncode(certcrypto.DERCertificateBytes(c.Certificate[0]))))
+}
+
+func Test_Manager_NextCertificateAction_returnsNextActionForStoredCertificate(t *testing.T) {
+	cert := &Details{
+		NotAfter:       time.Now().Add(time.Hour * 48),
+		AriRenewalTime: time.Now().Add(time.Hour * 30),
+		requiresStaple: &fs,
+	}
+
+	store := &fakeStore{certificate: cert}
+	manager := NewManager(
+		store,
+		map[string]Supplier{"test": &fakeSupplier{}},
+		[]string{"test"},
+		true,
+	)
+
+	next, err := manager.NextCertificateAction("", KeyTypeRSA, "example.com", []string{"example.net"})
+	require.NoError(t, err)
+	assert.Equal(t, cert.AriRenewalTime, next)
+	assert.Equal(t, "test", store.provider)
+	assert.Equal(t, KeyTypeRSA, store.keyType)
+}
+
+func Test_Manager_NextCertificateAction_errorsWhenNoCertificateExists(t *testing.T) {
+	manager := NewManager(
+		&fakeStore{},
+		map[string]Supplier{"test": &fakeSupplier{}},
+		[]string{"test"},
+		true,
+	)
+
+	_, err := manager.NextCertificateAction("", KeyTypeECDSA, "example.com", nil)
+	assert.Error(t, err)
+}
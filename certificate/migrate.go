@@ -0,0 +1,96 @@
+package certificate
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ConflictPolicy determines what happens when a certificate being migrated already exists in the destination
+// store, with the same provider, key type, subject and alt names.
+type ConflictPolicy string
+
+const (
+	ConflictSkip    ConflictPolicy = "skip"    // Keep the existing certificate
+	ConflictReplace ConflictPolicy = "replace" // Replace the existing certificate
+	ConflictNewer   ConflictPolicy = "newer"   // Keep whichever certificate expires later
+)
+
+// ParseConflictPolicy converts the name of a conflict policy into a ConflictPolicy, returning an error if the name
+// isn't recognised.
+func ParseConflictPolicy(name string) (ConflictPolicy, error) {
+	switch policy := ConflictPolicy(strings.ToLower(name)); policy {
+	case ConflictSkip, ConflictReplace, ConflictNewer:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("invalid conflict policy: %s (must be skip, replace or newer)", name)
+	}
+}
+
+// MigrationAction describes what was done with a certificate during a migration.
+type MigrationAction string
+
+const (
+	MigrationCopied    MigrationAction = "copied"    // The certificate didn't exist in the destination store
+	MigrationReplaced  MigrationAction = "replaced"  // The certificate replaced a different one in the destination
+	MigrationUnchanged MigrationAction = "unchanged" // The destination store already has the same certificate
+	MigrationConflict  MigrationAction = "conflict"  // The destination store has a different certificate, which was kept
+	MigrationExpired   MigrationAction = "expired"   // The certificate has expired, so wasn't copied
+)
+
+// MigrationResult records what happened to a single certificate during a migration.
+type MigrationResult struct {
+	Certificate *Details
+	Action      MigrationAction
+}
+
+// MigrateCertificates copies every certificate from one store to another, returning what happened to each one.
+// Certificates are matched by provider, key type, subject and alt names; if the destination store already has a
+// different certificate, the conflict policy decides which one is kept. Expired certificates are never copied.
+//
+// If dryRun is true, the results describe what would happen but the destination store isn't modified.
+func MigrateCertificates(from ManagedStore, to Store, policy ConflictPolicy, dryRun bool) ([]MigrationResult, error) {
+	certs, err := from.Certificates()
+	if err != nil {
+		return nil, fmt.Errorf("unable to list certificates: %w", err)
+	}
+
+	var res []MigrationResult
+	for _, cert := range certs {
+		action, err := migrateCertificate(cert, to, policy, dryRun)
+		if err != nil {
+			return res, fmt.Errorf("unable to migrate certificate for %s: %w", cert.Subject, err)
+		}
+		res = append(res, MigrationResult{Certificate: cert, Action: action})
+	}
+
+	return res, nil
+}
+
+// migrateCertificate copies a single certificate to the destination store, if the conflict policy allows it.
+func migrateCertificate(cert *Details, to Store, policy ConflictPolicy, dryRun bool) (MigrationAction, error) {
+	if !cert.ValidFor(0) {
+		return MigrationExpired, nil
+	}
+
+	to.LockCertificate(cert.Subject, cert.AltNames)
+	defer to.UnlockCertificate(cert.Subject, cert.AltNames)
+
+	action := MigrationCopied
+	// Stores return legacy certificates (without a provider) for any provider, but they're not a conflict: the
+	// migrated certificate will be saved alongside them.
+	if existing := to.GetCertificate(cert.Provider, cert.KeyType, cert.Subject, cert.AltNames); existing != nil && existing.Provider == cert.Provider {
+		switch {
+		case existing.Certificate == cert.Certificate:
+			return MigrationUnchanged, nil
+		case policy == ConflictSkip, policy == ConflictNewer && !cert.NotAfter.After(existing.NotAfter):
+			return MigrationConflict, nil
+		default:
+			action = MigrationReplaced
+		}
+	}
+
+	if dryRun {
+		return action, nil
+	}
+	return action, to.SaveCertificate(cert)
+}
@@ -0,0 +1,114 @@
+package certificate
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestMigrationStores(t *testing.T) (*JsonStore, *JsonStore) {
+	from, err := NewStore(filepath.Join(t.TempDir(), "from.json"))
+	require.NoError(t, err)
+	to, err := NewStore(filepath.Join(t.TempDir(), "to.json"))
+	require.NoError(t, err)
+	return from, to
+}
+
+func testMigrationDetails(provider, certificate string, validity time.Duration) *Details {
+	return &Details{
+		Provider:    provider,
+		KeyType:     KeyTypeECDSA,
+		Certificate: certificate,
+		Subject:     "example.com",
+		AltNames:    []string{"www.example.com"},
+		NotAfter:    time.Now().Add(validity),
+	}
+}
+
+func Test_ParseConflictPolicy(t *testing.T) {
+	policy, err := ParseConflictPolicy("Newer")
+	require.NoError(t, err)
+	assert.Equal(t, ConflictNewer, policy)
+
+	_, err = ParseConflictPolicy("overwrite")
+	assert.ErrorContains(t, err, "invalid conflict policy")
+}
+
+func Test_MigrateCertificates_copiesCertificates(t *testing.T) {
+	from, to := newTestMigrationStores(t)
+	lego := testMigrationDetails("lego", "lego cert", time.Hour)
+	selfSigned := testMigrationDetails("selfsigned", "self-signed cert", time.Hour)
+	require.NoError(t, from.SaveCertificate(lego))
+	require.NoError(t, from.SaveCertificate(selfSigned))
+
+	res, err := MigrateCertificates(from, to, ConflictSkip, false)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []MigrationResult{{lego, MigrationCopied}, {selfSigned, MigrationCopied}}, res)
+	assert.Equal(t, lego, to.GetCertificate("lego", KeyTypeECDSA, "example.com", []string{"www.example.com"}))
+	assert.Equal(t, selfSigned, to.GetCertificate("selfsigned", KeyTypeECDSA, "example.com", []string{"www.example.com"}))
+}
+
+func Test_MigrateCertificates_dryRunDoesNotModifyDestination(t *testing.T) {
+	from, to := newTestMigrationStores(t)
+	cert := testMigrationDetails("lego", "lego cert", time.Hour)
+	require.NoError(t, from.SaveCertificate(cert))
+
+	res, err := MigrateCertificates(from, to, ConflictSkip, true)
+	require.NoError(t, err)
+	assert.Equal(t, []MigrationResult{{cert, MigrationCopied}}, res)
+
+	certs, err := to.Certificates()
+	require.NoError(t, err)
+	assert.Empty(t, certs)
+}
+
+func Test_MigrateCertificates_skipsExpiredCertificates(t *testing.T) {
+	from, to := newTestMigrationStores(t)
+	cert := testMigrationDetails("lego", "lego cert", -time.Hour)
+	from.certificates = append(from.certificates, cert)
+
+	res, err := MigrateCertificates(from, to, ConflictReplace, false)
+	require.NoError(t, err)
+	assert.Equal(t, []MigrationResult{{cert, MigrationExpired}}, res)
+	assert.Nil(t, to.GetCertificate("lego", KeyTypeECDSA, "example.com", []string{"www.example.com"}))
+}
+
+func Test_MigrateCertificates_handlesConflicts(t *testing.T) {
+	tests := []struct {
+		name     string
+		policy   ConflictPolicy
+		existing *Details
+		action   MigrationAction
+		replaced bool
+	}{
+		{"skip keeps existing", ConflictSkip, testMigrationDetails("lego", "existing", 2*time.Hour), MigrationConflict, false},
+		{"replace overwrites existing", ConflictReplace, testMigrationDetails("lego", "existing", 2*time.Hour), MigrationReplaced, true},
+		{"newer keeps later expiry", ConflictNewer, testMigrationDetails("lego", "existing", 2*time.Hour), MigrationConflict, false},
+		{"newer replaces earlier expiry", ConflictNewer, testMigrationDetails("lego", "existing", time.Minute), MigrationReplaced, true},
+		{"identical certificate", ConflictReplace, testMigrationDetails("lego", "migrated", 2*time.Hour), MigrationUnchanged, false},
+		{"legacy certificate isn't a conflict", ConflictSkip, testMigrationDetails("", "existing", 2*time.Hour), MigrationCopied, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			from, to := newTestMigrationStores(t)
+			cert := testMigrationDetails("lego", "migrated", time.Hour)
+			require.NoError(t, from.SaveCertificate(cert))
+			require.NoError(t, to.SaveCertificate(tt.existing))
+
+			res, err := MigrateCertificates(from, to, tt.policy, false)
+			require.NoError(t, err)
+			assert.Equal(t, []MigrationResult{{cert, tt.action}}, res)
+
+			got := to.GetCertificate("lego", KeyTypeECDSA, "example.com", []string{"www.example.com"})
+			if tt.replaced {
+				assert.Equal(t, cert, got)
+			} else {
+				assert.Equal(t, tt.existing, got)
+			}
+		})
+	}
+}
@@ -3,6 +3,9 @@ package certificate
 import (
 	"context"
 	"log/slog"
+
+	"github.com/go-acme/lego/v5/challenge"
+	"golang.org/x/time/rate"
 )
 
 // ProviderConfig contains everything needed to assemble a certificate provider.
@@ -12,30 +15,103 @@ type ProviderConfig struct {
 	// Lego, if set, is used to create an ACME-backed supplier. If the supplier cannot be created a warning
 	// is logged and the provider continues with the selfsigned supplier only.
 	Lego *LegoSupplierConfig
+	// DnsProviders contains named DNS-01 challenge providers. If Lego is set, an additional lego supplier is
+	// created for each of them, registered under the provider's name, so routes can select the DNS provider
+	// that hosts their domains.
+	DnsProviders map[string]challenge.Provider
+	// AcmeIssuers contains additional named ACME directories. If Lego is set, an additional lego supplier is
+	// created for each of them, registered under the issuer's name, using its own ACME account.
+	AcmeIssuers map[string]AcmeIssuer
+	// InternalCADir, if set, is the directory containing the private CA used by the internalca supplier. If the
+	// supplier cannot be created a warning is logged and the provider continues without it.
+	InternalCADir string
 	// PreferredSuppliers lists the names of the suppliers to use, in order of preference.
 	PreferredSuppliers []string
+	// FallbackSuppliers lists the names of suppliers to try, in order, if a certificate can't be obtained from
+	// the supplier that would normally be used.
+	FallbackSuppliers []string
 	// WildcardDomains lists domains for which a single wildcard certificate should be requested.
 	WildcardDomains []string
 	// UseStaples enables requesting OCSP staples from suppliers.
 	UseStaples bool
 }
 
+// AcmeIssuer describes an additional ACME directory to obtain certificates from. Any settings not given here are
+// taken from the main lego configuration.
+type AcmeIssuer struct {
+	// DirUrl is the URL of the ACME directory.
+	DirUrl string
+	// Path is where the ACME account for this issuer is stored. It must differ from that of other issuers.
+	Path string
+	// Profile is the ACME profile to request certificates with, if any.
+	Profile string
+	// ExternalAccountKid and ExternalAccountHmac are used for external account binding, if the issuer requires it.
+	ExternalAccountKid  string
+	ExternalAccountHmac string
+}
+
 // NewProvider assembles a certificate provider from the given configuration: a certificate Manager backed
 // by the configured store and suppliers, wrapped in a WildcardResolver.
 func NewProvider(ctx context.Context, config ProviderConfig) *WildcardResolver {
 	suppliers := make(map[string]Supplier)
 	suppliers["selfsigned"] = NewSelfSignedSupplier()
 
+	if config.InternalCADir != "" {
+		if internalCASupplier, err := NewInternalCASupplier(config.InternalCADir); err != nil {
+			slog.Warn("Unable to create internal CA certificate supplier", "error", err)
+		} else {
+			suppliers["internalca"] = internalCASupplier
+		}
+	}
+
 	if config.Lego != nil {
+		// All lego suppliers use the same ACME account, so they need to share its issuance limit.
+		limiter := rate.NewLimiter(rate.Every(config.Lego.ObtainInterval), 1)
+
 		if legoSupplier, err := NewLegoSupplier(ctx, config.Lego); err != nil {
 			slog.Warn("Unable to create lego certificate supplier", "error", err)
 		} else {
+			legoSupplier.obtainLimiter = limiter
 			suppliers["lego"] = legoSupplier
 		}
+
+		for name, dnsProvider := range config.DnsProviders {
+			named := *config.Lego
+			named.DnsProvider = dnsProvider
+
+			if legoSupplier, err := NewLegoSupplier(ctx, &named); err != nil {
+				slog.Warn("Unable to create lego certificate supplier", "name", name, "error", err)
+			} else {
+				legoSupplier.obtainLimiter = limiter
+				suppliers[name] = legoSupplier
+			}
+		}
+
+		for name, issuer := range config.AcmeIssuers {
+			named := *config.Lego
+			named.DirUrl = issuer.DirUrl
+			named.Path = issuer.Path
+			named.Profile = issuer.Profile
+			named.ExternalAccountKid = issuer.ExternalAccountKid
+			named.ExternalAccountHmac = issuer.ExternalAccountHmac
+
+			// Each issuer has its own account, and so gets its own issuance limit.
+			if legoSupplier, err := NewLegoSupplier(ctx, &named); err != nil {
+				slog.Warn("Unable to create lego certificate supplier", "name", name, "directory", issuer.DirUrl, "error", err)
+			} else {
+				suppliers[name] = legoSupplier
+			}
+		}
 	}
 
-	return NewWildcardResolver(
-		NewManager(config.Store, suppliers, config.PreferredSuppliers, config.UseStaples),
-		config.WildcardDomains,
-	)
+	for _, name := range config.FallbackSuppliers {
+		if _, ok := suppliers[name]; !ok {
+			slog.Warn("Fallback certificate supplier is not available", "name", name)
+		}
+	}
+
+	manager := NewManager(config.Store, suppliers, config.PreferredSuppliers, config.UseStaples)
+	manager.SetFallbackSuppliers(config.FallbackSuppliers)
+
+	return NewWildcardResolver(manager, config.WildcardDomains)
 }
@@ -21,7 +21,7 @@ func Test_NewProvider_obtainsSelfSignedCertificateIfLegoFails(t *testing.T) {
 		PreferredSuppliers: []string{"lego", "selfsigned"},
 	})
 
-	cert, err := provider.GetCertificate(t.Context(), "", "example.com", nil)
+	cert, err := provider.GetCertificate(t.Context(), "", KeyTypeECDSA, "example.com", nil)
 	require.NoError(t, err)
 	require.NotNil(t, cert)
 
@@ -39,7 +39,7 @@ func Test_NewProvider_servesStoredCertificatesFromTheStore(t *testing.T) {
 		Store:              store,
 		PreferredSuppliers: []string{"selfsigned"},
 	})
-	_, err = provider.GetCertificate(t.Context(), "", "example.com", nil)
+	_, err = provider.GetCertificate(t.Context(), "", KeyTypeECDSA, "example.com", nil)
 	require.NoError(t, err)
 
 	// A second provider over the same store should find the existing certificate
@@ -50,7 +50,7 @@ func Test_NewProvider_servesStoredCertificatesFromTheStore(t *testing.T) {
 		PreferredSuppliers: []string{"selfsigned"},
 	})
 
-	cert, needsRenewal, err := provider2.GetExistingCertificate("", "example.com", nil)
+	cert, needsRenewal, err := provider2.GetExistingCertificate("", KeyTypeECDSA, "example.com", nil)
 	require.NoError(t, err)
 	require.NotNil(t, cert)
 	assert.False(t, needsRenewal)
@@ -66,7 +66,7 @@ func Test_NewProvider_appliesWildcardDomains(t *testing.T) {
 		WildcardDomains:    []string{"example.com"},
 	})
 
-	cert, err := provider.GetCertificate(t.Context(), "", "foo.example.com", nil)
+	cert, err := provider.GetCertificate(t.Context(), "", KeyTypeECDSA, "foo.example.com", nil)
 	require.NoError(t, err)
 	require.NotNil(t, cert)
 
@@ -74,3 +74,44 @@ func Test_NewProvider_appliesWildcardDomains(t *testing.T) {
 	require.NoError(t, err)
 	assert.Equal(t, "*.example.com", leaf.Subject.CommonName)
 }
+
+func Test_NewProvider_addsInternalCASupplierIfConfigured(t *testing.T) {
+	store, err := NewStore(filepath.Join(t.TempDir(), "certs.json"))
+	require.NoError(t, err)
+
+	provider := NewProvider(t.Context(), ProviderConfig{
+		Store:              store,
+		InternalCADir:      t.TempDir(),
+		PreferredSuppliers: []string{"selfsigned"},
+	})
+
+	cert, err := provider.GetCertificate(t.Context(), "internalca", KeyTypeECDSA, "example.com", nil)
+	require.NoError(t, err)
+	require.Len(t, cert.Certificate, 2)
+
+	intermediate, err := x509.ParseCertificate(cert.Certificate[1])
+	require.NoError(t, err)
+	assert.Equal(t, "Centauri Internal Intermediate CA", intermediate.Subject.CommonName)
+}
+
+func Test_NewProvider_skipsAcmeIssuersThatCantBeCreated(t *testing.T) {
+	store, err := NewStore(filepath.Join(t.TempDir(), "certs.json"))
+	require.NoError(t, err)
+
+	provider := NewProvider(t.Context(), ProviderConfig{
+		Store: store,
+		Lego:  &LegoSupplierConfig{Path: filepath.Join(t.TempDir(), "missing", "user.pem")},
+		AcmeIssuers: map[string]AcmeIssuer{
+			"zerossl": {DirUrl: "https://acme.zerossl.com/v2/DV90", Path: filepath.Join(t.TempDir(), "missing", "user-zerossl.pem")},
+		},
+		PreferredSuppliers: []string{"zerossl", "selfsigned"},
+		FallbackSuppliers:  []string{"zerossl"},
+	})
+
+	_, err = provider.GetCertificate(t.Context(), "zerossl", KeyTypeECDSA, "example.com", nil)
+	assert.Error(t, err, "issuer should not be registered")
+
+	cert, err := provider.GetCertificate(t.Context(), "", KeyTypeECDSA, "example.com", nil)
+	require.NoError(t, err)
+	require.NotNil(t, cert)
+}
@@ -4,14 +4,17 @@ import (
 	"encoding/json"
 	"errors"
 	"os"
+	"sort"
 	"strings"
 	"sync"
 )
 
 // JsonStore is responsible for storing and managing certificates. It can save and load data to/from a JSON file.
+// It is safe for concurrent use.
 type JsonStore struct {
 	path string
 
+	mu           sync.Mutex
 	certificates []*Details
 	locks        map[string]*sync.Mutex
 }
@@ -42,7 +45,8 @@ func (j *JsonStore) load() error {
 	return json.Unmarshal(b, &j.certificates)
 }
 
-// save serialises the current store to disk.
+// save serialises the current store to disk. The file is replaced atomically, so it's never left partially
+// written. The caller must hold the mutex.
 func (j *JsonStore) save() error {
 	j.pruneCertificates()
 
@@ -51,26 +55,31 @@ func (j *JsonStore) save() error {
 		return err
 	}
 
-	return os.WriteFile(j.path, b, 0600)
+	return writeFileAtomically(j.path, b, 0600)
 }
 
-// GetCertificate returns a previously stored certificate for the given provider, subject and alt names, or `nil` if
-// none exists.
+// GetCertificate returns a previously stored certificate for the given provider, key type, subject and alt names, or
+// `nil` if none exists.
 //
 // A certificate with an empty provider (i.e. one loaded from a store created before providers were tracked) is
 // treated as a legacy fallback: it will be returned for any provider. This allows existing certificates to continue
 // to be served until they naturally expire and are replaced with provider-specific certificates on the next renewal.
 //
-// Returned certificates are not guaranteed to be valid.
-func (j *JsonStore) GetCertificate(provider string, subjectName string, altNames []string) *Details {
+// Returned certificates are not guaranteed to be valid. Each call returns a copy, so callers may modify it without
+// affecting the store until it is saved.
+func (j *JsonStore) GetCertificate(provider string, keyType string, subjectName string, altNames []string) *Details {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
 	var legacy *Details
 	for i := range j.certificates {
-		if !j.certificates[i].IsFor(subjectName, altNames) {
+		if !j.certificates[i].IsFor(subjectName, altNames) || !j.certificates[i].HasKeyType(keyType) {
 			continue
 		}
 
 		if j.certificates[i].Provider == provider {
-			return j.certificates[i]
+			c := *j.certificates[i]
+			return &c
 		}
 
 		if j.certificates[i].Provider == "" {
@@ -78,7 +87,11 @@ func (j *JsonStore) GetCertificate(provider string, subjectName string, altNames
 		}
 	}
 
-	return legacy
+	if legacy == nil {
+		return nil
+	}
+	c := *legacy
+	return &c
 }
 
 // LockCertificate acquires a lock over the writing of the given certificate. All calls to LockCertificate should
@@ -96,6 +109,9 @@ func (j *JsonStore) UnlockCertificate(subjectName string, altNames []string) {
 func (j *JsonStore) lockFor(subjectName string, altNames []string) *sync.Mutex {
 	key := strings.Join(append([]string{subjectName}, altNames...), ";")
 
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
 	if mu, ok := j.locks[key]; ok {
 		return mu
 	} else {
@@ -105,18 +121,19 @@ func (j *JsonStore) lockFor(subjectName string, altNames []string) *sync.Mutex {
 	}
 }
 
-// removeCertificate removes any previously stored certificate for the given provider, subject and alt names.
-// Certificates with a different provider (including legacy certificates with no provider) are left untouched.
-func (j *JsonStore) removeCertificate(provider string, subjectName string, altNames []string) {
+// removeCertificate removes any previously stored certificate for the given provider, key type, subject and alt
+// names. Certificates with a different provider (including legacy certificates with no provider) or key type are
+// left untouched. The caller must hold the mutex.
+func (j *JsonStore) removeCertificate(provider string, keyType string, subjectName string, altNames []string) {
 	for i := range j.certificates {
-		if j.certificates[i].IsFor(subjectName, altNames) && j.certificates[i].Provider == provider {
+		if j.certificates[i].IsFor(subjectName, altNames) && j.certificates[i].HasKeyType(keyType) && j.certificates[i].Provider == provider {
 			j.certificates = append(j.certificates[:i], j.certificates[i+1:]...)
 			return
 		}
 	}
 }
 
-// pruneCertificates removes any certificates that are no longer valid.
+// pruneCertificates removes any certificates that are no longer valid. The caller must hold the mutex.
 func (j *JsonStore) pruneCertificates() {
 	savedCerts := j.certificates[:0]
 	for i := range j.certificates {
@@ -127,12 +144,55 @@ func (j *JsonStore) pruneCertificates() {
 	j.certificates = savedCerts
 }
 
-// SaveCertificate adds the given certificate to the store. Any previously saved certificate for the same provider,
-// subject and alt names will be removed. The store will be saved to disk after the certificate is added.
+// SaveCertificate adds a copy of the given certificate to the store. Any previously saved certificate for the same
+// provider, key type, subject and alt names will be removed. The store will be saved to disk after the certificate is
+// added.
 //
 // Callers should acquire a lock on the certificate by calling LockCertificate before saving it.
 func (j *JsonStore) SaveCertificate(certificate *Details) error {
-	j.removeCertificate(certificate.Provider, certificate.Subject, certificate.AltNames)
-	j.certificates = append(j.certificates, certificate)
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.removeCertificate(certificate.Provider, certificate.KeyType, certificate.Subject, certificate.AltNames)
+	c := *certificate
+	j.certificates = append(j.certificates, &c)
 	return j.save()
 }
+
+// Certificates returns copies of all certificates held by the store.
+func (j *JsonStore) Certificates() ([]*Details, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	res := make([]*Details, len(j.certificates))
+	for i := range j.certificates {
+		c := *j.certificates[i]
+		res[i] = &c
+	}
+	return res, nil
+}
+
+// DeleteCertificate removes the certificate with the same provider, key type, subject and alt names as the given one,
+// and saves the store to disk.
+//
+// Callers should acquire a lock on the certificate by calling LockCertificate before deleting it.
+func (j *JsonStore) DeleteCertificate(certificate *Details) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.removeCertificate(certificate.Provider, certificate.KeyType, certificate.Subject, certificate.AltNames)
+	return j.save()
+}
+
+// namesKey builds a stable identifier for a subject and its alt names, used for lock keys. Alt names are sorted so
+// that routes specifying the same names in a different order still share a lock.
+func namesKey(subjectName string, altNames []string) string {
+	return strings.Join(append([]string{subjectName}, sortedCopy(altNames)...), ";")
+}
+
+// sortedCopy returns a sorted copy of the given names, so they can be joined into a key without mutating caller data.
+func sortedCopy(names []string) []string {
+	res := append([]string(nil), names...)
+	sort.Strings(res)
+	return res
+}
@@ -0,0 +1,444 @@
+package certificate
+
+import (
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+)
+
+const (
+	dirStoreCertificateFile = "cert.pem"
+	dirStoreChainFile       = "chain.pem"
+	dirStoreFullChainFile   = "fullchain.pem"
+	dirStoreKeyFile         = "key.pem"
+	dirStoreMetadataFile    = "metadata.json"
+)
+
+// DirStore is responsible for storing and managing certificates as PEM files, with a directory for each
+// certificate. This allows other services on the same host to use certificates obtained by Centauri.
+//
+// Each directory contains the certificate (cert.pem), any intermediate certificates (chain.pem), both of those
+// combined (fullchain.pem), the private key (key.pem), and a metadata.json file with everything else Centauri
+// knows about the certificate. Directories are named after the certificate's subject, with the key type appended
+// for non-ECDSA certificates, and a numeric suffix if that name is already used by a different certificate. Each
+// directory is actually a symlink to a hidden, versioned directory; a new version is written in full before the
+// symlink is swapped to point at it, so other services never see a mix of old and new files.
+type DirStore struct {
+	path string
+
+	mu           sync.Mutex
+	certificates map[string]*Details
+	locks        map[string]*sync.Mutex
+}
+
+// NewDirStore creates a new certificate store that keeps certificates in subdirectories of the given path, and
+// tries to load any saved certificates. The directory is created if it doesn't exist.
+func NewDirStore(path string) (*DirStore, error) {
+	if err := os.MkdirAll(path, 0750); err != nil {
+		return nil, err
+	}
+
+	d := &DirStore{
+		path:         path,
+		certificates: make(map[string]*Details),
+		locks:        make(map[string]*sync.Mutex),
+	}
+
+	if err := d.load(); err != nil {
+		return nil, err
+	}
+
+	return d, nil
+}
+
+// load reads all certificates from the store's directory. Directories that don't contain a certificate are
+// ignored, and any that can't be read are skipped with a warning.
+func (d *DirStore) load() error {
+	entries, err := os.ReadDir(d.path)
+	if err != nil {
+		return err
+	}
+
+	for i := range entries {
+		name := entries[i].Name()
+		if strings.HasPrefix(name, ".") {
+			continue
+		}
+
+		if info, err := os.Stat(filepath.Join(d.path, name)); err != nil || !info.IsDir() {
+			continue
+		}
+
+		if _, err := os.Stat(filepath.Join(d.path, name, dirStoreMetadataFile)); errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+
+		certificate, err := d.read(name)
+		if err != nil {
+			slog.Warn("Skipping unreadable certificate in directory store", "directory", name, "error", err)
+			continue
+		}
+		d.certificates[name] = certificate
+	}
+
+	return nil
+}
+
+// read loads the certificate stored in the named directory.
+func (d *DirStore) read(name string) (*Details, error) {
+	dir := filepath.Join(d.path, name)
+
+	b, err := os.ReadFile(filepath.Join(dir, dirStoreMetadataFile))
+	if err != nil {
+		return nil, err
+	}
+
+	certificate := &Details{}
+	if err := json.Unmarshal(b, certificate); err != nil {
+		return nil, err
+	}
+
+	chain, err := os.ReadFile(filepath.Join(dir, dirStoreFullChainFile))
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := os.ReadFile(filepath.Join(dir, dirStoreKeyFile))
+	if err != nil {
+		return nil, err
+	}
+
+	certificate.Certificate = string(chain)
+	certificate.PrivateKey = string(key)
+	return certificate, nil
+}
+
+// write saves the certificate to a new version of the named directory, and then atomically swaps the directory's
+// symlink to point at it, so other services see either the old files or the new ones but never a mix. The previous
+// version is removed afterwards.
+func (d *DirStore) write(name string, certificate *Details) error {
+	metadata := *certificate
+	metadata.Certificate = ""
+	metadata.PrivateKey = ""
+	b, err := json.MarshalIndent(metadata, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	version, err := os.MkdirTemp(d.path, "."+name+".")
+	if err != nil {
+		return err
+	}
+
+	if err := os.Chmod(version, 0750); err != nil {
+		_ = os.RemoveAll(version)
+		return err
+	}
+
+	leaf, chain := splitChain(certificate.Certificate)
+	files := []struct {
+		name    string
+		content []byte
+		mode    os.FileMode
+	}{
+		{dirStoreKeyFile, []byte(certificate.PrivateKey), 0640},
+		{dirStoreCertificateFile, leaf, 0644},
+		{dirStoreChainFile, chain, 0644},
+		{dirStoreFullChainFile, []byte(certificate.Certificate), 0644},
+		{dirStoreMetadataFile, b, 0644},
+	}
+
+	for i := range files {
+		if err := writeFileAtomically(filepath.Join(version, files[i].name), files[i].content, files[i].mode); err != nil {
+			_ = os.RemoveAll(version)
+			return err
+		}
+	}
+
+	previous, err := d.swap(name, filepath.Base(version))
+	if err != nil {
+		_ = os.RemoveAll(version)
+		return err
+	}
+
+	if previous != "" {
+		if err := os.RemoveAll(filepath.Join(d.path, previous)); err != nil {
+			slog.Warn("Unable to remove previous certificate version from directory store", "directory", previous, "error", err)
+		}
+	}
+	return nil
+}
+
+// swap points the named symlink at the given version directory, returning the version it previously pointed at.
+// Directories written before versions were used are moved aside first; that's the only time the name briefly
+// doesn't exist.
+func (d *DirStore) swap(name string, version string) (string, error) {
+	link := filepath.Join(d.path, name)
+
+	previous := ""
+	if info, err := os.Lstat(link); err == nil && info.IsDir() {
+		legacy, err := os.MkdirTemp(d.path, "."+name+".")
+		if err != nil {
+			return "", err
+		}
+		if err := os.Remove(legacy); err != nil {
+			return "", err
+		}
+		if err := os.Rename(link, legacy); err != nil {
+			return "", err
+		}
+		previous = filepath.Base(legacy)
+	} else if err == nil {
+		previous = d.version(name)
+	}
+
+	temp := filepath.Join(d.path, "."+name+".link")
+	_ = os.Remove(temp)
+	if err := os.Symlink(version, temp); err != nil {
+		return "", err
+	}
+
+	if err := os.Rename(temp, link); err != nil {
+		_ = os.Remove(temp)
+		return "", err
+	}
+
+	return previous, nil
+}
+
+// version returns the versioned directory that the named symlink points at, or an empty string if it isn't a
+// symlink to one of the store's versions.
+func (d *DirStore) version(name string) string {
+	target, err := os.Readlink(filepath.Join(d.path, name))
+	if err != nil || target != filepath.Base(target) || !strings.HasPrefix(target, "."+name+".") {
+		return ""
+	}
+	return target
+}
+
+// remove deletes the named directory from disk, along with the version it points at.
+func (d *DirStore) remove(name string) error {
+	version := d.version(name)
+	if err := os.RemoveAll(filepath.Join(d.path, name)); err != nil {
+		return err
+	}
+
+	if version != "" {
+		return os.RemoveAll(filepath.Join(d.path, version))
+	}
+	return nil
+}
+
+// GetCertificate returns a previously stored certificate for the given provider, key type, subject and alt names, or
+// `nil` if none exists.
+//
+// A certificate with an empty provider (i.e. one saved before providers were tracked) is treated as a legacy
+// fallback: it will be returned for any provider.
+//
+// Returned certificates are not guaranteed to be valid.
+func (d *DirStore) GetCertificate(provider string, keyType string, subjectName string, altNames []string) *Details {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if name, ok := d.find(provider, keyType, subjectName, altNames); ok {
+		return d.certificates[name]
+	}
+
+	if name, ok := d.find("", keyType, subjectName, altNames); ok {
+		return d.certificates[name]
+	}
+
+	return nil
+}
+
+// SaveCertificate adds the given certificate to the store, replacing any previously saved certificate for the same
+// provider, key type, subject and alt names. Any certificates that are no longer valid are removed.
+//
+// Callers should acquire a lock on the certificate by calling LockCertificate before saving it.
+func (d *DirStore) SaveCertificate(certificate *Details) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	name := d.directoryFor(certificate)
+	if err := d.write(name, certificate); err != nil {
+		return err
+	}
+
+	d.certificates[name] = certificate
+	d.pruneCertificates()
+	return nil
+}
+
+// Certificates returns all certificates held by the store.
+func (d *DirStore) Certificates() ([]*Details, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	res := make([]*Details, 0, len(d.certificates))
+	for _, certificate := range d.certificates {
+		res = append(res, certificate)
+	}
+	return res, nil
+}
+
+// DeleteCertificate removes the directory of the certificate with the same provider, key type, subject and alt
+// names as the given one.
+//
+// Callers should acquire a lock on the certificate by calling LockCertificate before deleting it.
+func (d *DirStore) DeleteCertificate(certificate *Details) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	name, ok := d.existingDirectory(certificate)
+	if !ok {
+		return nil
+	}
+
+	if err := d.remove(name); err != nil {
+		return err
+	}
+	delete(d.certificates, name)
+	return nil
+}
+
+// existingDirectory returns the name of the directory holding a certificate with the same provider, key type,
+// subject and alt names as the given one, if there is one.
+func (d *DirStore) existingDirectory(certificate *Details) (string, bool) {
+	return d.find(certificate.Provider, certificate.KeyType, certificate.Subject, certificate.AltNames)
+}
+
+// find returns the name of the directory holding a certificate from exactly the given provider, with the given key
+// type, subject and alt names. Certificates without a key type are treated as ECDSA. If more than one directory
+// matches, one with exactly the same key type is preferred, and then the first by name, so that the same directory
+// is always read and replaced.
+func (d *DirStore) find(provider string, keyType string, subjectName string, altNames []string) (string, bool) {
+	var match string
+	for _, name := range slices.Sorted(maps.Keys(d.certificates)) {
+		certificate := d.certificates[name]
+		if certificate.Provider != provider || !certificate.HasKeyType(keyType) || !certificate.IsFor(subjectName, altNames) {
+			continue
+		}
+
+		if certificate.KeyType == keyType {
+			return name, true
+		}
+
+		if match == "" {
+			match = name
+		}
+	}
+	return match, match != ""
+}
+
+// directoryFor returns the name of the directory the certificate should be saved in: either the directory of the
+// certificate it replaces, or an unused one based on its subject.
+func (d *DirStore) directoryFor(certificate *Details) string {
+	if name, ok := d.existingDirectory(certificate); ok {
+		return name
+	}
+
+	base := strings.ReplaceAll(strings.ToLower(certificate.Subject), "*", "_")
+	if certificate.KeyType != "" && certificate.KeyType != KeyTypeECDSA {
+		base = base + "-" + certificate.KeyType
+	}
+
+	name := base
+	for i := 1; d.inUse(name); i++ {
+		name = fmt.Sprintf("%s-%04d", base, i)
+	}
+	return name
+}
+
+// inUse indicates whether a directory with the given name already exists in the store.
+func (d *DirStore) inUse(name string) bool {
+	if _, ok := d.certificates[name]; ok {
+		return true
+	}
+	_, err := os.Lstat(filepath.Join(d.path, name))
+	return !errors.Is(err, os.ErrNotExist)
+}
+
+// pruneCertificates removes any certificates that are no longer valid.
+func (d *DirStore) pruneCertificates() {
+	for name, certificate := range d.certificates {
+		if certificate.ValidFor(0) {
+			continue
+		}
+
+		if err := d.remove(name); err != nil {
+			slog.Warn("Unable to remove expired certificate from directory store", "directory", name, "error", err)
+			continue
+		}
+		delete(d.certificates, name)
+	}
+}
+
+// LockCertificate acquires a lock over the writing of the given certificate. All calls to LockCertificate should
+// be followed by calls to UnlockCertificate.
+func (d *DirStore) LockCertificate(subjectName string, altNames []string) {
+	d.lockFor(subjectName, altNames).Lock()
+}
+
+// UnlockCertificate releases a previously acquired lock over the writing of the given certificate.
+func (d *DirStore) UnlockCertificate(subjectName string, altNames []string) {
+	d.lockFor(subjectName, altNames).Unlock()
+}
+
+// lockFor provides the mutex to use for locking access to the given certificate.
+func (d *DirStore) lockFor(subjectName string, altNames []string) *sync.Mutex {
+	key := namesKey(subjectName, altNames)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if mu, ok := d.locks[key]; ok {
+		return mu
+	}
+
+	mu := &sync.Mutex{}
+	d.locks[key] = mu
+	return mu
+}
+
+// splitChain splits a PEM-encoded certificate chain into the first certificate and any that follow it.
+func splitChain(certificate string) ([]byte, []byte) {
+	block, rest := pem.Decode([]byte(certificate))
+	if block == nil {
+		return []byte(certificate), nil
+	}
+	return pem.EncodeToMemory(block), []byte(strings.TrimLeft(string(rest), "\r\n"))
+}
+
+// writeFileAtomically writes the content to a temporary file alongside the given path, and then renames it into
+// place.
+func writeFileAtomically(path string, content []byte, mode os.FileMode) error {
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.Write(content); err != nil {
+		_ = f.Close()
+		return err
+	}
+
+	if err := f.Chmod(mode); err != nil {
+		_ = f.Close()
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), path)
+}
@@ -0,0 +1,307 @@
+package certificate
+
+import (
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testChain(names ...string) string {
+	var res []byte
+	for i := range names {
+		res = append(res, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: []byte(names[i])})...)
+	}
+	return string(res)
+}
+
+func Test_NewDirStore_returnsErrorIfPathIsAFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "certs")
+	require.NoError(t, os.WriteFile(path, []byte("not a directory"), 0600))
+
+	_, err := NewDirStore(path)
+	assert.Error(t, err)
+}
+
+func Test_NewDirStore_skipsUnreadableCertificates(t *testing.T) {
+	path := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(path, "example.com"), 0700))
+	require.NoError(t, os.WriteFile(filepath.Join(path, "example.com", "metadata.json"), []byte("{invalid json"), 0600))
+	require.NoError(t, os.MkdirAll(filepath.Join(path, "unrelated"), 0700))
+
+	store, err := NewDirStore(path)
+	require.NoError(t, err)
+	assert.Empty(t, store.certificates)
+}
+
+func Test_DirStore_LoadSaveGet(t *testing.T) {
+	path := t.TempDir()
+	store, err := NewDirStore(path)
+	require.NoError(t, err, "store should load")
+
+	timestamp := time.Now().Add(time.Hour).UTC()
+
+	cert := &Details{
+		Provider:       "lego",
+		KeyType:        KeyTypeECDSA,
+		Issuer:         "this is the issuer",
+		PrivateKey:     "this is the private key",
+		Certificate:    testChain("leaf", "intermediate", "root"),
+		Subject:        "subject.example.com",
+		AltNames:       []string{"alt1.example.com", "alt2.example.com"},
+		NotAfter:       timestamp,
+		OcspResponse:   []byte("this is the ocsp response"),
+		NextOcspUpdate: timestamp.Add(time.Minute),
+	}
+
+	require.NoError(t, store.SaveCertificate(cert), "store should save certificate")
+
+	newStore, err := NewDirStore(path)
+	require.NoError(t, err, "second store should load")
+
+	newCert := newStore.GetCertificate("lego", KeyTypeECDSA, cert.Subject, cert.AltNames)
+	assert.Equal(t, cert, newCert, "certificates should match")
+}
+
+func Test_DirStore_SaveCertificate_writesPemFiles(t *testing.T) {
+	path := t.TempDir()
+	store, err := NewDirStore(path)
+	require.NoError(t, err)
+
+	require.NoError(t, store.SaveCertificate(&Details{
+		Provider:    "lego",
+		KeyType:     KeyTypeECDSA,
+		PrivateKey:  "this is the private key",
+		Certificate: testChain("leaf", "intermediate"),
+		Subject:     "Example.com",
+		NotAfter:    time.Now().Add(time.Hour),
+	}))
+
+	dir := filepath.Join(path, "example.com")
+	files := map[string]string{
+		"cert.pem":      testChain("leaf"),
+		"chain.pem":     testChain("intermediate"),
+		"fullchain.pem": testChain("leaf", "intermediate"),
+		"key.pem":       "this is the private key",
+	}
+	for name, expected := range files {
+		b, err := os.ReadFile(filepath.Join(dir, name))
+		require.NoError(t, err, name)
+		assert.Equal(t, expected, string(b), name)
+	}
+
+	b, err := os.ReadFile(filepath.Join(dir, "metadata.json"))
+	require.NoError(t, err)
+	assert.NotContains(t, string(b), "this is the private key")
+	assert.Contains(t, string(b), `"subject": "Example.com"`)
+
+	info, err := os.Stat(filepath.Join(dir, "key.pem"))
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0640), info.Mode().Perm())
+}
+
+func Test_DirStore_SaveCertificate_swapsVersionsAtomically(t *testing.T) {
+	path := t.TempDir()
+	store, err := NewDirStore(path)
+	require.NoError(t, err)
+
+	cert := &Details{Provider: "lego", KeyType: KeyTypeECDSA, Certificate: testChain("old"), Subject: "example.com", NotAfter: time.Now().Add(time.Hour)}
+	require.NoError(t, store.SaveCertificate(cert))
+
+	info, err := os.Lstat(filepath.Join(path, "example.com"))
+	require.NoError(t, err)
+	assert.Equal(t, os.ModeSymlink, info.Mode().Type())
+	old, err := os.Readlink(filepath.Join(path, "example.com"))
+	require.NoError(t, err)
+
+	renewed := &Details{Provider: "lego", KeyType: KeyTypeECDSA, Certificate: testChain("new"), Subject: "example.com", NotAfter: time.Now().Add(2 * time.Hour)}
+	require.NoError(t, store.SaveCertificate(renewed))
+
+	current, err := os.Readlink(filepath.Join(path, "example.com"))
+	require.NoError(t, err)
+	assert.NotEqual(t, old, current)
+	assert.NoDirExists(t, filepath.Join(path, old))
+
+	b, err := os.ReadFile(filepath.Join(path, "example.com", "cert.pem"))
+	require.NoError(t, err)
+	assert.Equal(t, testChain("new"), string(b))
+
+	entries, err := os.ReadDir(path)
+	require.NoError(t, err)
+	assert.Len(t, entries, 2, "only the symlink and current version should exist")
+}
+
+func Test_DirStore_SaveCertificate_replacesUnversionedDirectories(t *testing.T) {
+	path := t.TempDir()
+	store, err := NewDirStore(path)
+	require.NoError(t, err)
+
+	cert := &Details{Provider: "lego", KeyType: KeyTypeECDSA, Certificate: testChain("old"), Subject: "example.com", NotAfter: time.Now().Add(time.Hour)}
+	require.NoError(t, store.SaveCertificate(cert))
+
+	// Replace the symlink with a plain directory, as written by older versions
+	version, err := os.Readlink(filepath.Join(path, "example.com"))
+	require.NoError(t, err)
+	require.NoError(t, os.Remove(filepath.Join(path, "example.com")))
+	require.NoError(t, os.Rename(filepath.Join(path, version), filepath.Join(path, "example.com")))
+
+	store, err = NewDirStore(path)
+	require.NoError(t, err)
+	require.NotNil(t, store.GetCertificate("lego", KeyTypeECDSA, "example.com", nil))
+
+	renewed := &Details{Provider: "lego", KeyType: KeyTypeECDSA, Certificate: testChain("new"), Subject: "example.com", NotAfter: time.Now().Add(2 * time.Hour)}
+	require.NoError(t, store.SaveCertificate(renewed))
+
+	b, err := os.ReadFile(filepath.Join(path, "example.com", "cert.pem"))
+	require.NoError(t, err)
+	assert.Equal(t, testChain("new"), string(b))
+
+	entries, err := os.ReadDir(path)
+	require.NoError(t, err)
+	assert.Len(t, entries, 2, "the old directory should be removed")
+}
+
+func Test_DirStore_SaveCertificate_namesDirectories(t *testing.T) {
+	path := t.TempDir()
+	store, err := NewDirStore(path)
+	require.NoError(t, err)
+
+	certs := []*Details{
+		{Provider: "lego", KeyType: KeyTypeECDSA, Subject: "example.com"},
+		{Provider: "lego", KeyType: KeyTypeRSA, Subject: "example.com"},
+		{Provider: "selfsigned", KeyType: KeyTypeECDSA, Subject: "example.com"},
+		{Provider: "lego", KeyType: KeyTypeECDSA, Subject: "*.example.com"},
+		{Provider: "lego", KeyType: KeyTypeECDSA, Subject: "example.com"},
+	}
+
+	for i := range certs {
+		certs[i].NotAfter = time.Now().Add(time.Hour)
+		require.NoError(t, store.SaveCertificate(certs[i]))
+	}
+
+	entries, err := os.ReadDir(path)
+	require.NoError(t, err)
+
+	var names []string
+	for i := range entries {
+		if !strings.HasPrefix(entries[i].Name(), ".") {
+			names = append(names, entries[i].Name())
+		}
+	}
+	assert.ElementsMatch(t, []string{"example.com", "example.com-rsa", "example.com-0001", "_.example.com"}, names)
+	assert.Same(t, certs[4], store.GetCertificate("lego", KeyTypeECDSA, "example.com", nil))
+}
+
+func Test_DirStore_SaveCertificate_replacesCertificatesWithoutKeyType(t *testing.T) {
+	path := t.TempDir()
+	store, err := NewDirStore(path)
+	require.NoError(t, err)
+
+	old := &Details{Provider: "lego", Subject: "example.com", Certificate: testChain("old"), NotAfter: time.Now().Add(time.Hour)}
+	require.NoError(t, store.SaveCertificate(old))
+
+	renewed := &Details{Provider: "lego", KeyType: KeyTypeECDSA, Subject: "example.com", Certificate: testChain("new"), NotAfter: time.Now().Add(2 * time.Hour)}
+	require.NoError(t, store.SaveCertificate(renewed))
+
+	assert.NoFileExists(t, filepath.Join(path, "example.com-0001"))
+	assert.Same(t, renewed, store.GetCertificate("lego", KeyTypeECDSA, "example.com", nil))
+	assert.Same(t, renewed, store.GetCertificate("lego", "", "example.com", nil))
+
+	reloaded, err := NewDirStore(path)
+	require.NoError(t, err)
+	assert.Equal(t, renewed.Certificate, reloaded.GetCertificate("lego", KeyTypeECDSA, "example.com", nil).Certificate)
+}
+
+func Test_DirStore_GetCertificate_prefersExactKeyTypeMatch(t *testing.T) {
+	store, err := NewDirStore(t.TempDir())
+	require.NoError(t, err)
+
+	withoutKeyType := &Details{Provider: "lego", Subject: "example.com", NotAfter: time.Now().Add(time.Hour)}
+	withKeyType := &Details{Provider: "lego", KeyType: KeyTypeECDSA, Subject: "example.com", NotAfter: time.Now().Add(time.Hour)}
+	store.certificates["a.example.com"] = withoutKeyType
+	store.certificates["b.example.com"] = withKeyType
+
+	for range 10 {
+		assert.Same(t, withKeyType, store.GetCertificate("lego", KeyTypeECDSA, "example.com", nil))
+		assert.Same(t, withoutKeyType, store.GetCertificate("lego", "", "example.com", nil))
+	}
+}
+
+func Test_DirStore_saveCertificate_prunesExpiredCerts(t *testing.T) {
+	path := t.TempDir()
+	store, err := NewDirStore(path)
+	require.NoError(t, err, "store should load")
+
+	certs := []*Details{
+		{
+			Subject:  "just-expired.example.com",
+			NotAfter: time.Now().Add(-time.Hour),
+		},
+		{
+			Subject:  "zero-time.example.com",
+			NotAfter: time.Time{},
+		},
+		{
+			Subject:  "just-valid.example.com",
+			NotAfter: time.Now().Add(time.Hour),
+		},
+	}
+
+	for i := range certs {
+		require.NoError(t, store.SaveCertificate(certs[i]), "store should save certificate")
+	}
+
+	for i := range certs {
+		t.Run(certs[i].Subject, func(t *testing.T) {
+			hasCert := store.GetCertificate("", KeyTypeECDSA, certs[i].Subject, certs[i].AltNames) != nil
+			expectedCert := strings.Contains(certs[i].Subject, "-valid")
+			assert.Equal(t, expectedCert, hasCert)
+
+			_, err := os.Stat(filepath.Join(path, certs[i].Subject))
+			assert.Equal(t, expectedCert, err == nil)
+		})
+	}
+}
+
+func Test_DirStore_GetCertificate_returnsLegacyCertAsFallback(t *testing.T) {
+	store, err := NewDirStore(t.TempDir())
+	require.NoError(t, err)
+
+	legacy := &Details{Subject: "example.com", NotAfter: time.Now().Add(time.Hour)}
+	require.NoError(t, store.SaveCertificate(legacy))
+
+	assert.Same(t, legacy, store.GetCertificate("lego", KeyTypeECDSA, "example.com", nil))
+	assert.Nil(t, store.GetCertificate("lego", KeyTypeECDSA, "example.net", nil))
+}
+
+func Test_DirStore_CertificatesAndDelete(t *testing.T) {
+	path := t.TempDir()
+	store, err := NewDirStore(path)
+	require.NoError(t, err, "store should load")
+
+	cert1 := &Details{Provider: "lego", KeyType: KeyTypeECDSA, Certificate: testChain("one"), Subject: "one.example.com", NotAfter: time.Now().Add(time.Hour)}
+	cert2 := &Details{Provider: "lego", KeyType: KeyTypeECDSA, Certificate: testChain("two"), Subject: "two.example.com", NotAfter: time.Now().Add(time.Hour)}
+	require.NoError(t, store.SaveCertificate(cert1))
+	require.NoError(t, store.SaveCertificate(cert2))
+
+	certs, err := store.Certificates()
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []*Details{cert1, cert2}, certs)
+
+	require.NoError(t, store.DeleteCertificate(cert1))
+	assert.NoFileExists(t, filepath.Join(path, "one.example.com", "cert.pem"))
+	assert.FileExists(t, filepath.Join(path, "two.example.com", "cert.pem"))
+
+	entries, err := os.ReadDir(path)
+	require.NoError(t, err)
+	assert.Len(t, entries, 2, "only the remaining certificate and its version should be left")
+
+	certs, err = store.Certificates()
+	require.NoError(t, err)
+	assert.Equal(t, []*Details{cert2}, certs)
+}
@@ -0,0 +1,300 @@
+package certificate
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+)
+
+const (
+	// encryptedKeyPrefix marks private keys that have been encrypted by an EncryptedStore. It's followed by the ID
+	// of the encryption key, a colon, and the base64-encoded nonce and ciphertext.
+	encryptedKeyPrefix = "centauri-encrypted:v1:"
+	// encryptionKeySize is the size of the keys used to encrypt private keys, in bytes. Keys are used for AES-256.
+	encryptionKeySize = 32
+)
+
+// EncryptedStore wraps another Store, encrypting the private key of each certificate with AES-GCM before it is
+// saved, and decrypting it again when it's loaded.
+//
+// Any number of encryption keys may be used: the first is used to encrypt keys, and the others are only used to
+// decrypt keys that were saved before the encryption key was rotated. Private keys that aren't encrypted with the
+// first key (including those saved before encryption was enabled) are re-encrypted in the background the first
+// time they're loaded. Close should be called before exiting so that these saves aren't interrupted.
+type EncryptedStore struct {
+	store Store
+	keys  []encryptionKey
+
+	mu        sync.Mutex
+	migrating map[string]bool
+	closed    bool
+	wg        sync.WaitGroup
+}
+
+// encryptionKey is a key that can be used to encrypt or decrypt private keys, along with its ID.
+type encryptionKey struct {
+	id   string
+	aead cipher.AEAD
+}
+
+// ParseEncryptionKeys parses a whitespace-separated list of base64-encoded encryption keys. Each key must be 32
+// bytes long, and at least one must be given.
+func ParseEncryptionKeys(input string) ([][]byte, error) {
+	var res [][]byte
+	for _, encoded := range strings.Fields(input) {
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("invalid encryption key: %w", err)
+		}
+		if len(key) != encryptionKeySize {
+			return nil, fmt.Errorf("invalid encryption key: must be %d bytes, not %d", encryptionKeySize, len(key))
+		}
+		res = append(res, key)
+	}
+
+	if len(res) == 0 {
+		return nil, fmt.Errorf("no encryption keys specified")
+	}
+	return res, nil
+}
+
+// NewEncryptedStore creates a new EncryptedStore that saves certificates to the given store. The first key is
+// used to encrypt private keys, and all of them can be used to decrypt them.
+func NewEncryptedStore(store Store, keys [][]byte) (*EncryptedStore, error) {
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no encryption keys specified")
+	}
+
+	e := &EncryptedStore{
+		store:     store,
+		migrating: make(map[string]bool),
+	}
+
+	for i := range keys {
+		block, err := aes.NewCipher(keys[i])
+		if err != nil {
+			return nil, fmt.Errorf("invalid encryption key: %w", err)
+		}
+
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+
+		hash := sha256.Sum256(keys[i])
+		e.keys = append(e.keys, encryptionKey{id: hex.EncodeToString(hash[:4]), aead: aead})
+	}
+
+	return e, nil
+}
+
+// GetCertificate returns a previously stored certificate, with its private key decrypted. If the private key can't
+// be decrypted (for example because the key it was encrypted with is no longer configured), an error is logged and
+// `nil` is returned.
+func (e *EncryptedStore) GetCertificate(provider string, keyType string, subjectName string, altNames []string) *Details {
+	cert := e.store.GetCertificate(provider, keyType, subjectName, altNames)
+	if cert == nil {
+		return nil
+	}
+
+	decrypted, current, err := e.decrypt(cert)
+	if err != nil {
+		slog.Error("Unable to decrypt stored private key", "error", err, "domain", subjectName, "altNames", altNames, "provider", cert.Provider)
+		return nil
+	}
+
+	if !current {
+		e.reencrypt(provider, keyType, subjectName, altNames)
+	}
+
+	return decrypted
+}
+
+// SaveCertificate encrypts the certificate's private key, and saves it to the underlying store. The given
+// certificate is not modified.
+func (e *EncryptedStore) SaveCertificate(cert *Details) error {
+	encrypted, err := e.encrypt(cert)
+	if err != nil {
+		return err
+	}
+	return e.store.SaveCertificate(encrypted)
+}
+
+// Certificates returns all certificates held by the underlying store, with their private keys decrypted. Any whose
+// private key can't be decrypted are skipped. An error is returned if the underlying store can't list its
+// certificates.
+func (e *EncryptedStore) Certificates() ([]*Details, error) {
+	managed, ok := e.store.(ManagedStore)
+	if !ok {
+		return nil, fmt.Errorf("certificate store does not support listing certificates")
+	}
+
+	certs, err := managed.Certificates()
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]*Details, 0, len(certs))
+	for i := range certs {
+		decrypted, _, err := e.decrypt(certs[i])
+		if err != nil {
+			slog.Warn("Skipping certificate with undecryptable private key", "error", err, "domain", certs[i].Subject, "altNames", certs[i].AltNames)
+			continue
+		}
+		res = append(res, decrypted)
+	}
+	return res, nil
+}
+
+// DeleteCertificate removes the certificate from the underlying store. An error is returned if the underlying
+// store doesn't support deleting certificates.
+func (e *EncryptedStore) DeleteCertificate(cert *Details) error {
+	managed, ok := e.store.(ManagedStore)
+	if !ok {
+		return fmt.Errorf("certificate store does not support deleting certificates")
+	}
+	return managed.DeleteCertificate(cert)
+}
+
+// Close waits for any private keys being re-encrypted in the background to be saved, and then closes the
+// underlying store if it can be closed. Keys loaded after Close is called aren't re-encrypted.
+func (e *EncryptedStore) Close() error {
+	e.mu.Lock()
+	e.closed = true
+	e.mu.Unlock()
+
+	e.wg.Wait()
+	if closer, ok := e.store.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// Unwrap returns the underlying store, for callers that need to use features of it that aren't related to
+// certificates (such as storing ACME challenges).
+func (e *EncryptedStore) Unwrap() Store {
+	return e.store
+}
+
+// LockCertificate acquires a lock over the writing of the given certificate in the underlying store.
+func (e *EncryptedStore) LockCertificate(subjectName string, altNames []string) {
+	e.store.LockCertificate(subjectName, altNames)
+}
+
+// UnlockCertificate releases a previously acquired lock over the writing of the given certificate.
+func (e *EncryptedStore) UnlockCertificate(subjectName string, altNames []string) {
+	e.store.UnlockCertificate(subjectName, altNames)
+}
+
+// encrypt returns a copy of the certificate with its private key encrypted using the first key.
+func (e *EncryptedStore) encrypt(cert *Details) (*Details, error) {
+	key := e.keys[0]
+
+	nonce := make([]byte, key.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	sealed := key.aead.Seal(nonce, nonce, []byte(cert.PrivateKey), encryptionAdditionalData(cert))
+
+	res := *cert
+	res.PrivateKey = encryptedKeyPrefix + key.id + ":" + base64.StdEncoding.EncodeToString(sealed)
+	return &res, nil
+}
+
+// decrypt returns a copy of the certificate with its private key decrypted. It also indicates whether the key
+// was encrypted with the current encryption key; if not, it should be re-encrypted. Private keys that aren't
+// encrypted at all are returned as-is.
+func (e *EncryptedStore) decrypt(cert *Details) (*Details, bool, error) {
+	res := *cert
+
+	payload, ok := strings.CutPrefix(cert.PrivateKey, encryptedKeyPrefix)
+	if !ok {
+		return &res, false, nil
+	}
+
+	id, encoded, ok := strings.Cut(payload, ":")
+	if !ok {
+		return nil, false, fmt.Errorf("malformed encrypted private key")
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, false, fmt.Errorf("malformed encrypted private key: %w", err)
+	}
+
+	for i := range e.keys {
+		if e.keys[i].id != id {
+			continue
+		}
+
+		aead := e.keys[i].aead
+		if len(sealed) < aead.NonceSize() {
+			return nil, false, fmt.Errorf("malformed encrypted private key")
+		}
+
+		plain, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], encryptionAdditionalData(cert))
+		if err != nil {
+			return nil, false, fmt.Errorf("unable to decrypt private key: %w", err)
+		}
+
+		res.PrivateKey = string(plain)
+		return &res, i == 0, nil
+	}
+
+	return nil, false, fmt.Errorf("private key was encrypted with an unknown key (%s)", id)
+}
+
+// reencrypt saves the given certificate again in the background, so that its private key is encrypted with the
+// current key. The certificate is locked while doing so, in case it's being renewed at the same time. Nothing is
+// done once the store has been closed.
+func (e *EncryptedStore) reencrypt(provider string, keyType string, subjectName string, altNames []string) {
+	id := strings.Join([]string{provider, keyType, namesKey(subjectName, altNames)}, " ")
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.closed || e.migrating[id] {
+		return
+	}
+	e.migrating[id] = true
+
+	e.wg.Go(func() {
+		defer func() {
+			e.mu.Lock()
+			delete(e.migrating, id)
+			e.mu.Unlock()
+		}()
+
+		e.store.LockCertificate(subjectName, altNames)
+		defer e.store.UnlockCertificate(subjectName, altNames)
+
+		cert := e.store.GetCertificate(provider, keyType, subjectName, altNames)
+		if cert == nil {
+			return
+		}
+
+		decrypted, current, err := e.decrypt(cert)
+		if err != nil || current {
+			return
+		}
+
+		slog.Info("Re-encrypting stored private key", "domain", subjectName, "altNames", altNames, "provider", cert.Provider)
+		if err := e.SaveCertificate(decrypted); err != nil {
+			slog.Error("Unable to re-encrypt stored private key", "error", err, "domain", subjectName, "altNames", altNames)
+		}
+	})
+}
+
+// encryptionAdditionalData returns the data that's authenticated along with the certificate's private key, so
+// that an encrypted key can't be moved to a different certificate.
+func encryptionAdditionalData(cert *Details) []byte {
+	return []byte(namesKey(cert.Subject, cert.AltNames))
+}
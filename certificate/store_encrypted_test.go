@@ -0,0 +1,217 @@
+package certificate
+
+import (
+	"bytes"
+	"encoding/base64"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/synctest"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	testEncryptionKey1 = bytes.Repeat([]byte{1}, encryptionKeySize)
+	testEncryptionKey2 = bytes.Repeat([]byte{2}, encryptionKeySize)
+)
+
+func newTestEncryptedStore(t *testing.T, store Store, keys ...[]byte) *EncryptedStore {
+	encrypted, err := NewEncryptedStore(store, keys)
+	require.NoError(t, err)
+	return encrypted
+}
+
+func testEncryptedDetails() *Details {
+	return &Details{
+		Provider:    "lego",
+		KeyType:     KeyTypeECDSA,
+		PrivateKey:  "this is the private key",
+		Certificate: "this is the cert",
+		Subject:     "example.com",
+		AltNames:    []string{"www.example.com"},
+		NotAfter:    time.Now().Add(time.Hour).UTC(),
+	}
+}
+
+func Test_ParseEncryptionKeys(t *testing.T) {
+	encoded1 := base64.StdEncoding.EncodeToString(testEncryptionKey1)
+	encoded2 := base64.StdEncoding.EncodeToString(testEncryptionKey2)
+
+	keys, err := ParseEncryptionKeys(" " + encoded1 + "\n" + encoded2 + "\n")
+	require.NoError(t, err)
+	assert.Equal(t, [][]byte{testEncryptionKey1, testEncryptionKey2}, keys)
+
+	_, err = ParseEncryptionKeys("")
+	assert.ErrorContains(t, err, "no encryption keys")
+
+	_, err = ParseEncryptionKeys("not*base64")
+	assert.ErrorContains(t, err, "invalid encryption key")
+
+	_, err = ParseEncryptionKeys(base64.StdEncoding.EncodeToString([]byte("too short")))
+	assert.ErrorContains(t, err, "must be 32 bytes")
+}
+
+func Test_EncryptedStore_encryptsPrivateKeysAtRest(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "certs.json")
+	json, err := NewStore(path)
+	require.NoError(t, err)
+	store := newTestEncryptedStore(t, json, testEncryptionKey1)
+
+	cert := testEncryptedDetails()
+	require.NoError(t, store.SaveCertificate(cert))
+	assert.Equal(t, "this is the private key", cert.PrivateKey, "caller's certificate should not be modified")
+
+	raw := json.GetCertificate("lego", KeyTypeECDSA, "example.com", []string{"www.example.com"})
+	require.NotNil(t, raw)
+	assert.True(t, strings.HasPrefix(raw.PrivateKey, encryptedKeyPrefix))
+	assert.NotContains(t, raw.PrivateKey, "this is the private key")
+
+	reloaded, err := NewStore(path)
+	require.NoError(t, err)
+	got := newTestEncryptedStore(t, reloaded, testEncryptionKey1).GetCertificate("lego", KeyTypeECDSA, "example.com", []string{"www.example.com"})
+	assert.Equal(t, cert, got)
+}
+
+func Test_EncryptedStore_Certificates_decryptsPrivateKeys(t *testing.T) {
+	json, err := NewStore(filepath.Join(t.TempDir(), "certs.json"))
+	require.NoError(t, err)
+	store := newTestEncryptedStore(t, json, testEncryptionKey1)
+
+	cert := testEncryptedDetails()
+	require.NoError(t, store.SaveCertificate(cert))
+
+	undecryptable := testEncryptedDetails()
+	undecryptable.Subject = "example.org"
+	require.NoError(t, newTestEncryptedStore(t, json, testEncryptionKey2).SaveCertificate(undecryptable))
+
+	certs, err := store.Certificates()
+	require.NoError(t, err)
+	assert.Equal(t, []*Details{cert}, certs)
+
+	require.NoError(t, store.DeleteCertificate(cert))
+	assert.Nil(t, json.GetCertificate("lego", KeyTypeECDSA, "example.com", []string{"www.example.com"}))
+}
+
+func Test_EncryptedStore_GetCertificate_returnsNilIfKeyCantBeDecrypted(t *testing.T) {
+	json, err := NewStore(filepath.Join(t.TempDir(), "certs.json"))
+	require.NoError(t, err)
+	require.NoError(t, newTestEncryptedStore(t, json, testEncryptionKey1).SaveCertificate(testEncryptedDetails()))
+
+	store := newTestEncryptedStore(t, json, testEncryptionKey2)
+	assert.Nil(t, store.GetCertificate("lego", KeyTypeECDSA, "example.com", []string{"www.example.com"}))
+}
+
+func Test_EncryptedStore_GetCertificate_rejectsKeysMovedToOtherCertificates(t *testing.T) {
+	json, err := NewStore(filepath.Join(t.TempDir(), "certs.json"))
+	require.NoError(t, err)
+	store := newTestEncryptedStore(t, json, testEncryptionKey1)
+	require.NoError(t, store.SaveCertificate(testEncryptedDetails()))
+
+	moved := *json.GetCertificate("lego", KeyTypeECDSA, "example.com", []string{"www.example.com"})
+	moved.Subject = "example.net"
+	moved.AltNames = nil
+	require.NoError(t, json.SaveCertificate(&moved))
+
+	assert.Nil(t, store.GetCertificate("lego", KeyTypeECDSA, "example.net", nil))
+}
+
+func Test_EncryptedStore_GetCertificate_encryptsExistingPlaintextKeys(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		json, err := NewStore(filepath.Join(t.TempDir(), "certs.json"))
+		require.NoError(t, err)
+		require.NoError(t, json.SaveCertificate(testEncryptedDetails()))
+
+		store := newTestEncryptedStore(t, json, testEncryptionKey1)
+		got := store.GetCertificate("lego", KeyTypeECDSA, "example.com", []string{"www.example.com"})
+		require.NotNil(t, got)
+		assert.Equal(t, "this is the private key", got.PrivateKey)
+
+		synctest.Wait()
+		raw := json.GetCertificate("lego", KeyTypeECDSA, "example.com", []string{"www.example.com"})
+		assert.True(t, strings.HasPrefix(raw.PrivateKey, encryptedKeyPrefix))
+	})
+}
+
+func Test_EncryptedStore_GetCertificate_reencryptsKeysAfterRotation(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		json, err := NewStore(filepath.Join(t.TempDir(), "certs.json"))
+		require.NoError(t, err)
+		require.NoError(t, newTestEncryptedStore(t, json, testEncryptionKey1).SaveCertificate(testEncryptedDetails()))
+
+		rotated := newTestEncryptedStore(t, json, testEncryptionKey2, testEncryptionKey1)
+		got := rotated.GetCertificate("lego", KeyTypeECDSA, "example.com", []string{"www.example.com"})
+		require.NotNil(t, got)
+		assert.Equal(t, "this is the private key", got.PrivateKey)
+		synctest.Wait()
+
+		// The old key should no longer be needed
+		got = newTestEncryptedStore(t, json, testEncryptionKey2).GetCertificate("lego", KeyTypeECDSA, "example.com", []string{"www.example.com"})
+		require.NotNil(t, got)
+		assert.Equal(t, "this is the private key", got.PrivateKey)
+	})
+}
+
+// closingStore is a Store that records whether it has been closed.
+type closingStore struct {
+	Store
+	closed bool
+}
+
+func (c *closingStore) Close() error {
+	c.closed = true
+	return nil
+}
+
+func Test_EncryptedStore_Close_waitsForReencryptionAndClosesStore(t *testing.T) {
+	json, err := NewStore(filepath.Join(t.TempDir(), "certs.json"))
+	require.NoError(t, err)
+	require.NoError(t, json.SaveCertificate(testEncryptedDetails()))
+
+	underlying := &closingStore{Store: json}
+	store := newTestEncryptedStore(t, underlying, testEncryptionKey1)
+	require.NotNil(t, store.GetCertificate("lego", KeyTypeECDSA, "example.com", []string{"www.example.com"}))
+	require.NoError(t, store.Close())
+
+	assert.True(t, underlying.closed)
+	raw := json.GetCertificate("lego", KeyTypeECDSA, "example.com", []string{"www.example.com"})
+	assert.True(t, strings.HasPrefix(raw.PrivateKey, encryptedKeyPrefix))
+}
+
+func Test_EncryptedStore_Close_stopsReencryptingKeys(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		json, err := NewStore(filepath.Join(t.TempDir(), "certs.json"))
+		require.NoError(t, err)
+		require.NoError(t, json.SaveCertificate(testEncryptedDetails()))
+
+		store := newTestEncryptedStore(t, json, testEncryptionKey1)
+		require.NoError(t, store.Close())
+
+		got := store.GetCertificate("lego", KeyTypeECDSA, "example.com", []string{"www.example.com"})
+		require.NotNil(t, got)
+		assert.Equal(t, "this is the private key", got.PrivateKey)
+
+		synctest.Wait()
+		raw := json.GetCertificate("lego", KeyTypeECDSA, "example.com", []string{"www.example.com"})
+		assert.Equal(t, "this is the private key", raw.PrivateKey)
+	})
+}
+
+func Test_EncryptedStore_keyPairWorksWithDecryptedKeys(t *testing.T) {
+	supplier := NewSelfSignedSupplier()
+	cert, err := supplier.GetCertificate(t.Context(), KeyTypeECDSA, "example.com", nil, false)
+	require.NoError(t, err)
+	cert.Provider = "selfsigned"
+
+	json, err := NewStore(filepath.Join(t.TempDir(), "certs.json"))
+	require.NoError(t, err)
+	store := newTestEncryptedStore(t, json, testEncryptionKey1)
+	require.NoError(t, store.SaveCertificate(cert))
+
+	got := store.GetCertificate("selfsigned", KeyTypeECDSA, "example.com", nil)
+	require.NotNil(t, got)
+	_, err = got.keyPair()
+	assert.NoError(t, err)
+}
@@ -7,10 +7,10 @@ import (
 	"crypto/rand"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
 	"os"
-	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -27,6 +27,9 @@ const (
 	// redisLockRenewInterval is how often a held lock is refreshed. Locks are refreshed so that lengthy
 	// certificate obtaining operations don't outlive the lock.
 	redisLockRenewInterval = redisLockTTL / 3
+	// redisChallengeTTL is how long challenge responses are kept for. Challenges are normally removed once
+	// they've been validated, this just stops them lingering forever if an instance dies part way through.
+	redisChallengeTTL = time.Hour
 )
 
 // RedisStore is responsible for storing and managing certificates in Redis. Unlike the JsonStore it allows multiple
@@ -60,15 +63,15 @@ func NewRedisStore(client *redis.Client, keyPrefix string) (*RedisStore, error)
 	}, nil
 }
 
-// GetCertificate returns a previously stored certificate for the given provider, subject and alt names, or `nil` if
-// none exists.
+// GetCertificate returns a previously stored certificate for the given provider, key type, subject and alt names, or
+// `nil` if none exists.
 //
 // A certificate with an empty provider (i.e. one loaded from a store created before providers were tracked) is
 // treated as a legacy fallback: it will be returned for any provider. This allows existing certificates to continue
 // to be served until they naturally expire and are replaced with provider-specific certificates on the next renewal.
 //
 // Returned certificates are not guaranteed to be valid.
-func (r *RedisStore) GetCertificate(provider string, subjectName string, altNames []string) *Details {
+func (r *RedisStore) GetCertificate(provider string, keyType string, subjectName string, altNames []string) *Details {
 	certificates, err := r.allCertificates()
 	if err != nil {
 		slog.Error("Unable to load certificates from redis", "error", err)
@@ -77,7 +80,7 @@ func (r *RedisStore) GetCertificate(provider string, subjectName string, altName
 
 	var legacy *Details
 	for i := range certificates {
-		if !certificates[i].IsFor(subjectName, altNames) {
+		if !certificates[i].IsFor(subjectName, altNames) || !certificates[i].HasKeyType(keyType) {
 			continue
 		}
 
@@ -94,7 +97,7 @@ func (r *RedisStore) GetCertificate(provider string, subjectName string, altName
 }
 
 // SaveCertificate adds the given certificate to the store, replacing any previously saved certificate for the same
-// provider, subject and alt names. Certificates belonging to other providers are left untouched, and any certificates
+// provider, key type, subject and alt names. Certificates belonging to other providers are left untouched, and any certificates
 // that are no longer valid are removed.
 //
 // Expired certificates are only removed if they're unchanged since we read them: we hold the lock for this
@@ -129,11 +132,26 @@ func (r *RedisStore) SaveCertificate(certificate *Details) error {
 	if len(pruneArgs) > 0 {
 		pipe.Eval(ctx, pruneCertificatesScript, []string{r.certificatesKey()}, pruneArgs...)
 	}
-	pipe.HSet(ctx, r.certificatesKey(), certificateKey(certificate.Provider, certificate.Subject, certificate.AltNames), b)
+	pipe.HSet(ctx, r.certificatesKey(), certificateKey(certificate.Provider, certificate.KeyType, certificate.Subject, certificate.AltNames), b)
 	_, err = pipe.Exec(ctx)
 	return err
 }
 
+// Certificates returns all certificates held in Redis. Entries that cannot be decoded are skipped.
+func (r *RedisStore) Certificates() ([]*Details, error) {
+	return r.allCertificates()
+}
+
+// DeleteCertificate removes the certificate with the same provider, key type, subject and alt names as the given
+// one.
+//
+// Callers should acquire a lock on the certificate by calling LockCertificate before deleting it.
+func (r *RedisStore) DeleteCertificate(certificate *Details) error {
+	ctx, cancel := operationContext()
+	defer cancel()
+	return r.client.HDel(ctx, r.certificatesKey(), certificateKey(certificate.Provider, certificate.KeyType, certificate.Subject, certificate.AltNames)).Err()
+}
+
 // LockCertificate acquires a lock over the writing of the given certificate. The lock is distributed: other Centauri
 // instances using the same Redis server will block until it is released. All calls to LockCertificate should be
 // followed by calls to UnlockCertificate.
@@ -146,6 +164,41 @@ func (r *RedisStore) UnlockCertificate(subjectName string, altNames []string) {
 	r.lockFor(subjectName, altNames).Unlock()
 }
 
+// SaveChallenge stores the response to an in-progress ACME challenge, so that it can be served by any Centauri
+// instance using the same Redis server.
+func (r *RedisStore) SaveChallenge(key string, response string) error {
+	ctx, cancel := operationContext()
+	defer cancel()
+	return r.client.Set(ctx, r.challengeKey(key), response, redisChallengeTTL).Err()
+}
+
+// GetChallenge returns the response to the ACME challenge with the given key, if there is one.
+func (r *RedisStore) GetChallenge(key string) (string, bool) {
+	ctx, cancel := operationContext()
+	defer cancel()
+
+	response, err := r.client.Get(ctx, r.challengeKey(key)).Result()
+	if err != nil {
+		if !errors.Is(err, redis.Nil) {
+			slog.Error("Unable to load challenge from redis", "error", err, "key", key)
+		}
+		return "", false
+	}
+	return response, true
+}
+
+// DeleteChallenge removes the response to the ACME challenge with the given key.
+func (r *RedisStore) DeleteChallenge(key string) error {
+	ctx, cancel := operationContext()
+	defer cancel()
+	return r.client.Del(ctx, r.challengeKey(key)).Err()
+}
+
+// challengeKey provides the Redis key used to store the challenge with the given key.
+func (r *RedisStore) challengeKey(key string) string {
+	return r.keyPrefix + ":challenge:" + key
+}
+
 // lockFor provides the lock to use for locking access to the given certificate.
 func (r *RedisStore) lockFor(subjectName string, altNames []string) *redisLock {
 	key := r.keyPrefix + ":lock:" + namesKey(subjectName, altNames)
@@ -203,24 +256,16 @@ func operationContext() (context.Context, context.CancelFunc) {
 	return context.WithTimeout(context.Background(), redisOperationTimeout)
 }
 
-// certificateKey builds the hash field that identifies a certificate from its provider, subject and alt names.
-func certificateKey(provider string, subjectName string, altNames []string) string {
+// certificateKey builds the hash field that identifies a certificate from its provider, key type, subject and alt
+// names. ECDSA certificates don't include the key type, so they keep using the fields written before key types
+// were tracked.
+func certificateKey(provider string, keyType string, subjectName string, altNames []string) string {
+	if keyType != "" && keyType != KeyTypeECDSA {
+		provider = provider + "+" + keyType
+	}
 	return strings.Join(append([]string{provider, subjectName}, sortedCopy(altNames)...), ";")
 }
 
-// namesKey builds a stable identifier for a subject and its alt names, used for lock keys. Alt names are sorted so
-// that routes specifying the same names in a different order still share a lock.
-func namesKey(subjectName string, altNames []string) string {
-	return strings.Join(append([]string{subjectName}, sortedCopy(altNames)...), ";")
-}
-
-// sortedCopy returns a sorted copy of the given names, so they can be joined into a key without mutating caller data.
-func sortedCopy(names []string) []string {
-	res := append([]string(nil), names...)
-	sort.Strings(res)
-	return res
-}
-
 // releaseLockScript releases the lock held by the given token, without affecting any lock acquired by someone else
 // in the meantime (e.g. if our lock expired).
 const releaseLockScript = `if redis.call("get", KEYS[1]) == ARGV[1] then return redis.call("del", KEYS[1]) else return 0 end`
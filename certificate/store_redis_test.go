@@ -62,7 +62,7 @@ func Test_RedisStore_LoadSaveGet(t *testing.T) {
 	newStore, err := NewRedisStore(redis.NewClient(&redis.Options{Addr: server.Addr()}), "test")
 	require.NoError(t, err, "second store should load")
 
-	newCert := newStore.GetCertificate("", cert.Subject, cert.AltNames)
+	newCert := newStore.GetCertificate("", KeyTypeECDSA, cert.Subject, cert.AltNames)
 	assert.Equal(t, cert, newCert, "certificates should match")
 }
 
@@ -98,7 +98,7 @@ func Test_RedisStore_saveCertificate_prunesExpiredCerts(t *testing.T) {
 
 	for i := range certs {
 		t.Run(certs[i].Subject, func(t *testing.T) {
-			hasCert := store.GetCertificate("", certs[i].Subject, certs[i].AltNames) != nil
+			hasCert := store.GetCertificate("", KeyTypeECDSA, certs[i].Subject, certs[i].AltNames) != nil
 			expectedCert := strings.Contains(certs[i].Subject, "-valid")
 			assert.Equal(t, expectedCert, hasCert)
 		})
@@ -160,8 +160,8 @@ func Test_RedisStore_GetCertificate_returnsCertWithMatchingProvider(t *testing.T
 	require.NoError(t, store.SaveCertificate(acmeCert))
 	require.NoError(t, store.SaveCertificate(selfSignedCert))
 
-	assert.Equal(t, acmeCert, store.GetCertificate("acme", "*.example.com", nil))
-	assert.Equal(t, selfSignedCert, store.GetCertificate("selfsigned", "*.example.com", nil))
+	assert.Equal(t, acmeCert, store.GetCertificate("acme", KeyTypeECDSA, "*.example.com", nil))
+	assert.Equal(t, selfSignedCert, store.GetCertificate("selfsigned", KeyTypeECDSA, "*.example.com", nil))
 }
 
 func Test_RedisStore_GetCertificate_returnsLegacyCertAsFallback(t *testing.T) {
@@ -175,8 +175,8 @@ func Test_RedisStore_GetCertificate_returnsLegacyCertAsFallback(t *testing.T) {
 
 	require.NoError(t, store.SaveCertificate(legacyCert))
 
-	assert.Equal(t, legacyCert, store.GetCertificate("acme", "*.example.com", nil))
-	assert.Equal(t, legacyCert, store.GetCertificate("selfsigned", "*.example.com", nil))
+	assert.Equal(t, legacyCert, store.GetCertificate("acme", KeyTypeECDSA, "*.example.com", nil))
+	assert.Equal(t, legacyCert, store.GetCertificate("selfsigned", KeyTypeECDSA, "*.example.com", nil))
 }
 
 func Test_RedisStore_GetCertificate_prefersExactProviderMatchOverLegacy(t *testing.T) {
@@ -196,8 +196,8 @@ func Test_RedisStore_GetCertificate_prefersExactProviderMatchOverLegacy(t *testi
 	require.NoError(t, store.SaveCertificate(legacyCert))
 	require.NoError(t, store.SaveCertificate(acmeCert))
 
-	assert.Equal(t, acmeCert, store.GetCertificate("acme", "*.example.com", nil))
-	assert.Equal(t, legacyCert, store.GetCertificate("selfsigned", "*.example.com", nil))
+	assert.Equal(t, acmeCert, store.GetCertificate("acme", KeyTypeECDSA, "*.example.com", nil))
+	assert.Equal(t, legacyCert, store.GetCertificate("selfsigned", KeyTypeECDSA, "*.example.com", nil))
 }
 
 func Test_RedisStore_GetCertificate_returnsNilIfNoMatchingProvider(t *testing.T) {
@@ -211,7 +211,7 @@ func Test_RedisStore_GetCertificate_returnsNilIfNoMatchingProvider(t *testing.T)
 
 	require.NoError(t, store.SaveCertificate(acmeCert))
 
-	assert.Nil(t, store.GetCertificate("selfsigned", "*.example.com", nil))
+	assert.Nil(t, store.GetCertificate("selfsigned", KeyTypeECDSA, "*.example.com", nil))
 }
 
 func Test_RedisStore_SaveCertificate_doesNotEvictCertsFromOtherProviders(t *testing.T) {
@@ -234,8 +234,59 @@ func Test_RedisStore_SaveCertificate_doesNotEvictCertsFromOtherProviders(t *test
 	stored, err := store.allCertificates()
 	require.NoError(t, err)
 	assert.Equal(t, 2, len(stored))
-	assert.NotNil(t, store.GetCertificate("selfsigned", "*.example.com", nil))
-	assert.NotNil(t, store.GetCertificate("acme", "*.example.com", nil))
+	assert.NotNil(t, store.GetCertificate("selfsigned", KeyTypeECDSA, "*.example.com", nil))
+	assert.NotNil(t, store.GetCertificate("acme", KeyTypeECDSA, "*.example.com", nil))
+}
+
+func Test_RedisStore_SaveCertificate_keepsCertsWithDifferentKeyTypes(t *testing.T) {
+	store := newTestRedisStore(t, "test")
+
+	legacyCert := &Details{
+		Provider: "acme",
+		Subject:  "example.com",
+		NotAfter: time.Now().Add(time.Hour).UTC(),
+	}
+	ecdsaCert := &Details{
+		Provider: "acme",
+		KeyType:  KeyTypeECDSA,
+		Subject:  "example.com",
+		NotAfter: time.Now().Add(time.Hour).UTC(),
+	}
+	rsaCert := &Details{
+		Provider: "acme",
+		KeyType:  KeyTypeRSA,
+		Subject:  "example.com",
+		NotAfter: time.Now().Add(time.Hour).UTC(),
+	}
+
+	require.NoError(t, store.SaveCertificate(legacyCert))
+	require.NoError(t, store.SaveCertificate(ecdsaCert))
+	require.NoError(t, store.SaveCertificate(rsaCert))
+
+	stored, err := store.allCertificates()
+	require.NoError(t, err)
+	assert.Equal(t, 2, len(stored), "ECDSA cert should replace the legacy cert")
+	assert.Equal(t, ecdsaCert, store.GetCertificate("acme", KeyTypeECDSA, "example.com", nil))
+	assert.Equal(t, rsaCert, store.GetCertificate("acme", KeyTypeRSA, "example.com", nil))
+}
+
+func Test_RedisStore_CertificatesAndDelete(t *testing.T) {
+	store := newTestRedisStore(t, "test")
+
+	ecdsaCert := &Details{Provider: "acme", KeyType: KeyTypeECDSA, Subject: "example.com", NotAfter: time.Now().Add(time.Hour).UTC()}
+	rsaCert := &Details{Provider: "acme", KeyType: KeyTypeRSA, Subject: "example.com", NotAfter: time.Now().Add(time.Hour).UTC()}
+	require.NoError(t, store.SaveCertificate(ecdsaCert))
+	require.NoError(t, store.SaveCertificate(rsaCert))
+
+	certs, err := store.Certificates()
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []*Details{ecdsaCert, rsaCert}, certs)
+
+	require.NoError(t, store.DeleteCertificate(rsaCert))
+
+	certs, err = store.Certificates()
+	require.NoError(t, err)
+	assert.Equal(t, []*Details{ecdsaCert}, certs)
 }
 
 func Test_RedisStore_storesAreIsolatedByKeyPrefix(t *testing.T) {
@@ -254,8 +305,8 @@ func Test_RedisStore_storesAreIsolatedByKeyPrefix(t *testing.T) {
 	}
 	require.NoError(t, storeOne.SaveCertificate(cert))
 
-	assert.Equal(t, cert, storeOne.GetCertificate("", "example.com", nil))
-	assert.Nil(t, storeTwo.GetCertificate("", "example.com", nil))
+	assert.Equal(t, cert, storeOne.GetCertificate("", KeyTypeECDSA, "example.com", nil))
+	assert.Nil(t, storeTwo.GetCertificate("", KeyTypeECDSA, "example.com", nil))
 }
 
 func Test_RedisStore_GetCertificate_skipsCorruptEntries(t *testing.T) {
@@ -269,7 +320,7 @@ func Test_RedisStore_GetCertificate_skipsCorruptEntries(t *testing.T) {
 	}
 	require.NoError(t, store.SaveCertificate(cert))
 
-	assert.Equal(t, cert, store.GetCertificate("", "example.com", nil))
+	assert.Equal(t, cert, store.GetCertificate("", KeyTypeECDSA, "example.com", nil))
 }
 
 func Test_RedisStore_pruneScript_onlyDeletesUnchangedEntries(t *testing.T) {
@@ -328,7 +379,7 @@ func Test_RedisStore_operationsAreBounded(t *testing.T) {
 	store := &RedisStore{client: client, keyPrefix: "test", locks: make(map[string]*redisLock)}
 
 	start := time.Now()
-	assert.Nil(t, store.GetCertificate("", "example.com", nil), "no certificate should be returned when redis is unresponsive")
+	assert.Nil(t, store.GetCertificate("", KeyTypeECDSA, "example.com", nil), "no certificate should be returned when redis is unresponsive")
 	assert.Less(t, time.Since(start), 2*time.Second, "GetCertificate should be bounded by the operation timeout")
 
 	start = time.Now()
@@ -431,3 +482,24 @@ func Test_RedisStore_Lock_ignoresUnlockWhenNotHeld(t *testing.T) {
 	store.LockCertificate("example.com", nil)
 	store.UnlockCertificate("example.com", nil)
 }
+
+func Test_RedisStore_Challenges_areSharedBetweenStores(t *testing.T) {
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { _ = client.Close() })
+
+	storeOne, err := NewRedisStore(client, "test")
+	require.NoError(t, err)
+	storeTwo, err := NewRedisStore(client, "test")
+	require.NoError(t, err)
+
+	require.NoError(t, storeOne.SaveChallenge("key", "response"))
+	response, ok := storeTwo.GetChallenge("key")
+	assert.True(t, ok)
+	assert.Equal(t, "response", response)
+	assert.Equal(t, redisChallengeTTL, server.TTL("test:challenge:key"))
+
+	require.NoError(t, storeTwo.DeleteChallenge("key"))
+	_, ok = storeOne.GetChallenge("key")
+	assert.False(t, ok)
+}
@@ -0,0 +1,335 @@
+//go:build !nosqlite
+
+package certificate
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/sys/unix"
+	_ "modernc.org/sqlite"
+)
+
+// sqliteBusyTimeout is how long an operation waits for another process to finish writing to the database before
+// giving up.
+const sqliteBusyTimeout = 10 * time.Second
+
+// sqliteSchema creates the tables used by the SqliteStore, if they don't already exist.
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS certificates (
+	provider  TEXT    NOT NULL,
+	key_type  TEXT    NOT NULL,
+	names     TEXT    NOT NULL,
+	not_after INTEGER NOT NULL,
+	details   TEXT    NOT NULL,
+	updated   INTEGER NOT NULL,
+	PRIMARY KEY (provider, key_type, names)
+);
+
+CREATE TABLE IF NOT EXISTS certificate_history (
+	id          INTEGER PRIMARY KEY AUTOINCREMENT,
+	provider    TEXT    NOT NULL,
+	key_type    TEXT    NOT NULL,
+	names       TEXT    NOT NULL,
+	not_after   INTEGER NOT NULL,
+	certificate TEXT    NOT NULL,
+	saved       INTEGER NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS certificate_history_names ON certificate_history (names, saved);
+`
+
+// SqliteStore is responsible for storing and managing certificates in an SQLite database. Unlike the JsonStore,
+// saving a certificate only updates its own row, and multiple Centauri processes on the same host can share a
+// single database: locks are held using advisory file locks alongside the database.
+//
+// Each new certificate (but not its private key) is also recorded in a history table, which is never pruned.
+type SqliteStore struct {
+	db       *sql.DB
+	locksDir string
+
+	locksMu sync.Mutex
+	locks   map[string]*os.File
+}
+
+// HistoricalCertificate describes a certificate that was previously saved in a SqliteStore.
+type HistoricalCertificate struct {
+	Provider    string
+	KeyType     string
+	Subject     string
+	AltNames    []string
+	NotAfter    time.Time
+	Certificate string
+	Saved       time.Time
+}
+
+// NewSqliteStore opens (or creates) the SQLite database at the given path, and creates the tables required to
+// store certificates. Lock files are kept in a directory next to the database.
+func NewSqliteStore(path string) (*SqliteStore, error) {
+	locksDir := path + "-locks"
+	if err := os.MkdirAll(locksDir, 0700); err != nil {
+		return nil, fmt.Errorf("unable to create lock directory: %w", err)
+	}
+
+	dsn := (&url.URL{
+		Scheme: "file",
+		Opaque: path,
+		RawQuery: url.Values{
+			"_pragma": []string{
+				fmt.Sprintf("busy_timeout(%d)", sqliteBusyTimeout.Milliseconds()),
+				"journal_mode(WAL)",
+			},
+			"_txlock": []string{"immediate"},
+		}.Encode(),
+	}).String()
+
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("unable to create sqlite schema: %w", err)
+	}
+
+	return &SqliteStore{
+		db:       db,
+		locksDir: locksDir,
+		locks:    make(map[string]*os.File),
+	}, nil
+}
+
+// Close closes the underlying database.
+func (s *SqliteStore) Close() error {
+	return s.db.Close()
+}
+
+// GetCertificate returns a previously stored certificate for the given provider, key type, subject and alt names, or
+// `nil` if none exists.
+//
+// A certificate with an empty provider (i.e. one imported from a store created before providers were tracked) is
+// treated as a legacy fallback: it will be returned for any provider.
+//
+// Returned certificates are not guaranteed to be valid.
+func (s *SqliteStore) GetCertificate(provider string, keyType string, subjectName string, altNames []string) *Details {
+	var raw string
+	err := s.db.QueryRow(
+		`SELECT details FROM certificates
+		WHERE key_type = ? AND names = ? AND provider IN (?, '')
+		ORDER BY provider = ? DESC
+		LIMIT 1`,
+		sqliteKeyType(keyType), namesKey(subjectName, altNames), provider, provider,
+	).Scan(&raw)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	} else if err != nil {
+		slog.Error("Unable to load certificate from sqlite", "error", err, "subject", subjectName)
+		return nil
+	}
+
+	certificate := &Details{}
+	if err := json.Unmarshal([]byte(raw), certificate); err != nil {
+		slog.Warn("Skipping corrupt certificate in sqlite store", "error", err, "subject", subjectName)
+		return nil
+	}
+	return certificate
+}
+
+// SaveCertificate adds the given certificate to the store, replacing any previously saved certificate for the same
+// provider, key type, subject and alt names. If the certificate itself has changed (rather than e.g. just its OCSP
+// staple), it is added to the history. Any certificates that are no longer valid are removed. All changes are made
+// in a single transaction.
+//
+// Callers should acquire a lock on the certificate by calling LockCertificate before saving it.
+func (s *SqliteStore) SaveCertificate(certificate *Details) error {
+	b, err := json.Marshal(certificate)
+	if err != nil {
+		return err
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	keyType := sqliteKeyType(certificate.KeyType)
+	names := namesKey(certificate.Subject, certificate.AltNames)
+	now := time.Now()
+
+	var previous string
+	err = tx.QueryRow(
+		`SELECT details FROM certificates WHERE provider = ? AND key_type = ? AND names = ?`,
+		certificate.Provider, keyType, names,
+	).Scan(&previous)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+
+	var existing Details
+	if previous == "" || json.Unmarshal([]byte(previous), &existing) != nil || existing.Certificate != certificate.Certificate {
+		if _, err := tx.Exec(
+			`INSERT INTO certificate_history (provider, key_type, names, not_after, certificate, saved) VALUES (?, ?, ?, ?, ?, ?)`,
+			certificate.Provider, keyType, names, certificate.NotAfter.Unix(), certificate.Certificate, now.Unix(),
+		); err != nil {
+			return err
+		}
+	}
+
+	if _, err := tx.Exec(
+		`INSERT INTO certificates (provider, key_type, names, not_after, details, updated) VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT (provider, key_type, names) DO UPDATE SET not_after = excluded.not_after, details = excluded.details, updated = excluded.updated`,
+		certificate.Provider, keyType, names, certificate.NotAfter.Unix(), string(b), now.Unix(),
+	); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(`DELETE FROM certificates WHERE not_after <= ?`, now.Unix()); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Certificates returns all certificates held by the store. Entries that can't be decoded are skipped.
+func (s *SqliteStore) Certificates() ([]*Details, error) {
+	rows, err := s.db.Query(`SELECT details FROM certificates ORDER BY names, provider, key_type`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var res []*Details
+	for rows.Next() {
+		var raw string
+		if err := rows.Scan(&raw); err != nil {
+			return nil, err
+		}
+
+		certificate := &Details{}
+		if err := json.Unmarshal([]byte(raw), certificate); err != nil {
+			slog.Warn("Skipping corrupt certificate in sqlite store", "error", err)
+			continue
+		}
+		res = append(res, certificate)
+	}
+
+	return res, rows.Err()
+}
+
+// DeleteCertificate removes the certificate with the same provider, key type, subject and alt names as the given
+// one. The certificate remains in the history.
+//
+// Callers should acquire a lock on the certificate by calling LockCertificate before deleting it.
+func (s *SqliteStore) DeleteCertificate(certificate *Details) error {
+	_, err := s.db.Exec(
+		`DELETE FROM certificates WHERE provider = ? AND key_type = ? AND names = ?`,
+		certificate.Provider, sqliteKeyType(certificate.KeyType), namesKey(certificate.Subject, certificate.AltNames),
+	)
+	return err
+}
+
+// History returns all certificates that have been saved for the given subject and alt names, from any provider,
+// newest first.
+func (s *SqliteStore) History(subjectName string, altNames []string) ([]HistoricalCertificate, error) {
+	rows, err := s.db.Query(
+		`SELECT provider, key_type, not_after, certificate, saved FROM certificate_history
+		WHERE names = ?
+		ORDER BY saved DESC, id DESC`,
+		namesKey(subjectName, altNames),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var res []HistoricalCertificate
+	for rows.Next() {
+		var notAfter, saved int64
+		entry := HistoricalCertificate{
+			Subject:  subjectName,
+			AltNames: sortedCopy(altNames),
+		}
+		if err := rows.Scan(&entry.Provider, &entry.KeyType, &notAfter, &entry.Certificate, &saved); err != nil {
+			return nil, err
+		}
+		entry.NotAfter = time.Unix(notAfter, 0)
+		entry.Saved = time.Unix(saved, 0)
+		res = append(res, entry)
+	}
+
+	return res, rows.Err()
+}
+
+// LockCertificate acquires a lock over the writing of the given certificate. The lock is an advisory lock on a
+// file next to the database, so other processes using the same database will block until it is released, and it
+// is released automatically if the process holding it dies. All calls to LockCertificate should be followed by
+// calls to UnlockCertificate.
+func (s *SqliteStore) LockCertificate(subjectName string, altNames []string) {
+	key := namesKey(subjectName, altNames)
+	path := s.lockPath(key)
+
+	for {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0600)
+		if err == nil {
+			if err = unix.Flock(int(f.Fd()), unix.LOCK_EX); err == nil {
+				s.locksMu.Lock()
+				s.locks[key] = f
+				s.locksMu.Unlock()
+				return
+			}
+			_ = f.Close()
+		}
+
+		slog.Error("Unable to acquire certificate lock, will retry", "error", err, "lock", path)
+		time.Sleep(time.Second)
+	}
+}
+
+// UnlockCertificate releases a previously acquired lock over the writing of the given certificate. It is a no-op if
+// the lock is not currently held.
+func (s *SqliteStore) UnlockCertificate(subjectName string, altNames []string) {
+	key := namesKey(subjectName, altNames)
+
+	s.locksMu.Lock()
+	f, ok := s.locks[key]
+	delete(s.locks, key)
+	s.locksMu.Unlock()
+
+	if !ok {
+		return
+	}
+
+	if err := unix.Flock(int(f.Fd()), unix.LOCK_UN); err != nil {
+		slog.Warn("Unable to release certificate lock", "error", err, "lock", f.Name())
+	}
+	_ = f.Close()
+}
+
+// lockPath returns the path of the file used to lock the certificate with the given names key. Names are hashed
+// so that they're always safe to use as a file name.
+func (s *SqliteStore) lockPath(key string) string {
+	hash := sha256.Sum256([]byte(key))
+	return filepath.Join(s.locksDir, hex.EncodeToString(hash[:])+".lock")
+}
+
+// sqliteKeyType normalises the key type for use in the database. Certificates saved before key types were tracked
+// are all ECDSA.
+func sqliteKeyType(keyType string) string {
+	if keyType == "" {
+		return KeyTypeECDSA
+	}
+	return strings.ToLower(keyType)
+}
@@ -0,0 +1,13 @@
+//go:build !nosqlite
+
+package certificate
+
+// NewSqliteStoreFromPath creates a certificate store backed by the SQLite database at the given path, creating it
+// if necessary.
+func NewSqliteStoreFromPath(path string) (Store, error) {
+	store, err := NewSqliteStore(path)
+	if err != nil {
+		return nil, err
+	}
+	return store, nil
+}
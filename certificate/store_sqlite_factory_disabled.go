@@ -0,0 +1,10 @@
+//go:build nosqlite
+
+package certificate
+
+import "fmt"
+
+// NewSqliteStoreFromPath always errors: the binary has been built without sqlite support.
+func NewSqliteStoreFromPath(path string) (Store, error) {
+	return nil, fmt.Errorf("sqlite certificate store is not compiled in (built with the nosqlite tag)")
+}
@@ -0,0 +1,231 @@
+//go:build !nosqlite
+
+package certificate
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestSqliteStore(t *testing.T, path string) *SqliteStore {
+	store, err := NewSqliteStore(path)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = store.Close() })
+	return store
+}
+
+func Test_NewSqliteStore_returnsErrorIfDatabaseIsInvalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "certs.db")
+	require.NoError(t, os.WriteFile(path, []byte("this is not a database, it's just a really long string of text"), 0600))
+
+	_, err := NewSqliteStore(path)
+	assert.Error(t, err)
+}
+
+func Test_NewSqliteStoreFromPath_createsDatabase(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "certs.db")
+
+	store, err := NewSqliteStoreFromPath(path)
+	require.NoError(t, err)
+	require.NotNil(t, store)
+	assert.FileExists(t, path)
+}
+
+func Test_SqliteStore_LoadSaveGet(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "certs.db")
+	store := newTestSqliteStore(t, path)
+
+	timestamp := time.Now().Add(time.Hour).UTC()
+
+	cert := &Details{
+		Provider:       "lego",
+		KeyType:        KeyTypeECDSA,
+		Issuer:         "this is the issuer",
+		PrivateKey:     "this is the private key",
+		Certificate:    "this is the cert",
+		Subject:        "subject.example.com",
+		AltNames:       []string{"alt1.example.com", "alt2.example.com"},
+		NotAfter:       timestamp,
+		OcspResponse:   []byte("this is the ocsp response"),
+		NextOcspUpdate: timestamp.Add(time.Minute),
+	}
+
+	require.NoError(t, store.SaveCertificate(cert), "store should save certificate")
+
+	newStore := newTestSqliteStore(t, path)
+	newCert := newStore.GetCertificate("lego", KeyTypeECDSA, cert.Subject, []string{"alt2.example.com", "alt1.example.com"})
+	assert.Equal(t, cert, newCert, "certificates should match")
+}
+
+func Test_SqliteStore_saveCertificate_prunesExpiredCerts(t *testing.T) {
+	store := newTestSqliteStore(t, filepath.Join(t.TempDir(), "certs.db"))
+
+	require.NoError(t, store.SaveCertificate(&Details{Subject: "expired.example.com", NotAfter: time.Now().Add(-time.Hour)}))
+	require.NoError(t, store.SaveCertificate(&Details{Subject: "zero-time.example.com"}))
+	require.NoError(t, store.SaveCertificate(&Details{Subject: "valid.example.com", NotAfter: time.Now().Add(time.Hour)}))
+
+	assert.Nil(t, store.GetCertificate("", KeyTypeECDSA, "expired.example.com", nil))
+	assert.Nil(t, store.GetCertificate("", KeyTypeECDSA, "zero-time.example.com", nil))
+	assert.NotNil(t, store.GetCertificate("", KeyTypeECDSA, "valid.example.com", nil))
+}
+
+func Test_SqliteStore_GetCertificate_matchesProviderAndKeyType(t *testing.T) {
+	store := newTestSqliteStore(t, filepath.Join(t.TempDir(), "certs.db"))
+	notAfter := time.Now().Add(time.Hour)
+
+	certs := []*Details{
+		{Provider: "", KeyType: "", Certificate: "legacy", Subject: "example.com", NotAfter: notAfter},
+		{Provider: "lego", KeyType: KeyTypeECDSA, Certificate: "lego", Subject: "example.com", NotAfter: notAfter},
+		{Provider: "lego", KeyType: KeyTypeRSA, Certificate: "lego-rsa", Subject: "example.com", NotAfter: notAfter},
+	}
+	for i := range certs {
+		require.NoError(t, store.SaveCertificate(certs[i]))
+	}
+
+	tests := []struct {
+		provider string
+		keyType  string
+		expected string
+	}{
+		{"lego", KeyTypeECDSA, "lego"},
+		{"lego", KeyTypeRSA, "lego-rsa"},
+		{"selfsigned", KeyTypeECDSA, "legacy"},
+		{"selfsigned", "", "legacy"},
+		{"selfsigned", KeyTypeRSA, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.provider+"/"+tt.keyType, func(t *testing.T) {
+			cert := store.GetCertificate(tt.provider, tt.keyType, "example.com", nil)
+			if tt.expected == "" {
+				assert.Nil(t, cert)
+			} else {
+				require.NotNil(t, cert)
+				assert.Equal(t, tt.expected, cert.Certificate)
+			}
+		})
+	}
+}
+
+func Test_SqliteStore_SaveCertificate_replacesExistingCertificate(t *testing.T) {
+	store := newTestSqliteStore(t, filepath.Join(t.TempDir(), "certs.db"))
+
+	require.NoError(t, store.SaveCertificate(&Details{Provider: "lego", Certificate: "first", Subject: "example.com", NotAfter: time.Now().Add(time.Hour)}))
+	require.NoError(t, store.SaveCertificate(&Details{Provider: "lego", Certificate: "second", Subject: "example.com", NotAfter: time.Now().Add(time.Hour)}))
+
+	var count int
+	require.NoError(t, store.db.QueryRow(`SELECT COUNT(*) FROM certificates`).Scan(&count))
+	assert.Equal(t, 1, count)
+	assert.Equal(t, "second", store.GetCertificate("lego", KeyTypeECDSA, "example.com", nil).Certificate)
+}
+
+func Test_SqliteStore_CertificatesAndDelete(t *testing.T) {
+	store := newTestSqliteStore(t, filepath.Join(t.TempDir(), "certs.db"))
+
+	cert1 := &Details{Provider: "lego", KeyType: KeyTypeECDSA, Certificate: "one", Subject: "one.example.com", NotAfter: time.Now().Add(time.Hour).UTC()}
+	cert2 := &Details{Provider: "lego", KeyType: KeyTypeECDSA, Certificate: "two", Subject: "two.example.com", NotAfter: time.Now().Add(time.Hour).UTC()}
+	require.NoError(t, store.SaveCertificate(cert1))
+	require.NoError(t, store.SaveCertificate(cert2))
+
+	certs, err := store.Certificates()
+	require.NoError(t, err)
+	assert.Equal(t, []*Details{cert1, cert2}, certs)
+
+	require.NoError(t, store.DeleteCertificate(cert1))
+	assert.Nil(t, store.GetCertificate("lego", KeyTypeECDSA, "one.example.com", nil))
+
+	certs, err = store.Certificates()
+	require.NoError(t, err)
+	assert.Equal(t, []*Details{cert2}, certs)
+
+	history, err := store.History("one.example.com", nil)
+	require.NoError(t, err)
+	assert.Len(t, history, 1, "deleted certificates should remain in the history")
+}
+
+func Test_SqliteStore_History_recordsNewCertificates(t *testing.T) {
+	store := newTestSqliteStore(t, filepath.Join(t.TempDir(), "certs.db"))
+	notAfter := time.Now().Add(time.Hour)
+
+	first := &Details{Provider: "lego", Certificate: "first", PrivateKey: "secret", Subject: "example.com", AltNames: []string{"www.example.com"}, NotAfter: notAfter}
+	require.NoError(t, store.SaveCertificate(first))
+
+	// Updating the staple shouldn't add a new entry
+	first.OcspResponse = []byte("staple")
+	require.NoError(t, store.SaveCertificate(first))
+
+	require.NoError(t, store.SaveCertificate(&Details{Provider: "lego", Certificate: "second", Subject: "example.com", AltNames: []string{"www.example.com"}, NotAfter: notAfter}))
+	require.NoError(t, store.SaveCertificate(&Details{Provider: "lego", Certificate: "other", Subject: "example.net", NotAfter: notAfter}))
+
+	history, err := store.History("example.com", []string{"www.example.com"})
+	require.NoError(t, err)
+	require.Len(t, history, 2)
+	assert.Equal(t, "second", history[0].Certificate)
+	assert.Equal(t, "first", history[1].Certificate)
+	assert.Equal(t, "lego", history[1].Provider)
+	assert.Equal(t, KeyTypeECDSA, history[1].KeyType)
+	assert.Equal(t, notAfter.Unix(), history[1].NotAfter.Unix())
+
+	var keys int
+	require.NoError(t, store.db.QueryRow(`SELECT COUNT(*) FROM certificate_history WHERE certificate LIKE '%secret%'`).Scan(&keys))
+	assert.Zero(t, keys, "private keys should not be kept in the history")
+}
+
+func Test_SqliteStore_Lock_isExclusiveAcrossStores(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "certs.db")
+	storeA := newTestSqliteStore(t, path)
+	storeB := newTestSqliteStore(t, path)
+
+	storeA.LockCertificate("example.com", []string{"a.example.com", "b.example.com"})
+
+	acquired := make(chan struct{})
+	go func() {
+		storeB.LockCertificate("example.com", []string{"b.example.com", "a.example.com"})
+		close(acquired)
+	}()
+
+	select {
+	case <-acquired:
+		t.Fatal("store B should not acquire the lock while store A holds it")
+	case <-time.After(500 * time.Millisecond):
+	}
+
+	storeA.UnlockCertificate("example.com", []string{"a.example.com", "b.example.com"})
+
+	select {
+	case <-acquired:
+	case <-time.After(5 * time.Second):
+		t.Fatal("store B should acquire the lock after store A releases it")
+	}
+
+	storeB.UnlockCertificate("example.com", []string{"a.example.com", "b.example.com"})
+}
+
+func Test_SqliteStore_Lock_doesNotBlockOtherCertificates(t *testing.T) {
+	store := newTestSqliteStore(t, filepath.Join(t.TempDir(), "certs.db"))
+
+	store.LockCertificate("example.com", nil)
+	defer store.UnlockCertificate("example.com", nil)
+
+	done := make(chan struct{})
+	go func() {
+		store.LockCertificate("example.net", nil)
+		store.UnlockCertificate("example.net", nil)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("locking a different certificate should not block")
+	}
+}
+
+func Test_SqliteStore_Lock_ignoresUnlockWhenNotHeld(t *testing.T) {
+	store := newTestSqliteStore(t, filepath.Join(t.TempDir(), "certs.db"))
+	store.UnlockCertificate("example.com", nil)
+}
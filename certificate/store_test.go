@@ -1,9 +1,11 @@
 package certificate
 
 import (
+	"fmt"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -47,7 +49,7 @@ func Test_Store_LoadSaveGet(t *testing.T) {
 	newStore, err := NewStore(path)
 	require.NoError(t, err, "second store should load")
 
-	newCert := newStore.GetCertificate("", cert.Subject, cert.AltNames)
+	newCert := newStore.GetCertificate("", KeyTypeECDSA, cert.Subject, cert.AltNames)
 	assert.Equal(t, cert, newCert, "certificates should match")
 }
 
@@ -85,7 +87,7 @@ func Test_Store_saveCertificate_prunesExpiredCerts(t *testing.T) {
 
 	for i := range certs {
 		t.Run(certs[i].Subject, func(t *testing.T) {
-			hasCert := store.GetCertificate("", certs[i].Subject, certs[i].AltNames) != nil
+			hasCert := store.GetCertificate("", KeyTypeECDSA, certs[i].Subject, certs[i].AltNames) != nil
 			expectedCert := strings.Contains(certs[i].Subject, "-valid")
 			assert.Equal(t, expectedCert, hasCert)
 		})
@@ -149,8 +151,8 @@ func Test_Store_GetCertificate_returnsCertWithMatchingProvider(t *testing.T) {
 	require.NoError(t, store.SaveCertificate(acmeCert))
 	require.NoError(t, store.SaveCertificate(selfSignedCert))
 
-	assert.Equal(t, acmeCert, store.GetCertificate("acme", "*.example.com", nil))
-	assert.Equal(t, selfSignedCert, store.GetCertificate("selfsigned", "*.example.com", nil))
+	assert.Equal(t, acmeCert, store.GetCertificate("acme", KeyTypeECDSA, "*.example.com", nil))
+	assert.Equal(t, selfSignedCert, store.GetCertificate("selfsigned", KeyTypeECDSA, "*.example.com", nil))
 }
 
 func Test_Store_GetCertificate_returnsLegacyCertAsFallback(t *testing.T) {
@@ -166,8 +168,8 @@ func Test_Store_GetCertificate_returnsLegacyCertAsFallback(t *testing.T) {
 
 	require.NoError(t, store.SaveCertificate(legacyCert))
 
-	assert.Equal(t, legacyCert, store.GetCertificate("acme", "*.example.com", nil))
-	assert.Equal(t, legacyCert, store.GetCertificate("selfsigned", "*.example.com", nil))
+	assert.Equal(t, legacyCert, store.GetCertificate("acme", KeyTypeECDSA, "*.example.com", nil))
+	assert.Equal(t, legacyCert, store.GetCertificate("selfsigned", KeyTypeECDSA, "*.example.com", nil))
 }
 
 func Test_Store_GetCertificate_prefersExactProviderMatchOverLegacy(t *testing.T) {
@@ -189,8 +191,8 @@ func Test_Store_GetCertificate_prefersExactProviderMatchOverLegacy(t *testing.T)
 	require.NoError(t, store.SaveCertificate(legacyCert))
 	require.NoError(t, store.SaveCertificate(acmeCert))
 
-	assert.Equal(t, acmeCert, store.GetCertificate("acme", "*.example.com", nil))
-	assert.Equal(t, legacyCert, store.GetCertificate("selfsigned", "*.example.com", nil))
+	assert.Equal(t, acmeCert, store.GetCertificate("acme", KeyTypeECDSA, "*.example.com", nil))
+	assert.Equal(t, legacyCert, store.GetCertificate("selfsigned", KeyTypeECDSA, "*.example.com", nil))
 }
 
 func Test_Store_GetCertificate_returnsNilIfNoMatchingProvider(t *testing.T) {
@@ -206,7 +208,7 @@ func Test_Store_GetCertificate_returnsNilIfNoMatchingProvider(t *testing.T) {
 
 	require.NoError(t, store.SaveCertificate(acmeCert))
 
-	assert.Nil(t, store.GetCertificate("selfsigned", "*.example.com", nil))
+	assert.Nil(t, store.GetCertificate("selfsigned", KeyTypeECDSA, "*.example.com", nil))
 }
 
 func Test_Store_SaveCertificate_doesNotEvictCertsFromOtherProviders(t *testing.T) {
@@ -229,6 +231,130 @@ func Test_Store_SaveCertificate_doesNotEvictCertsFromOtherProviders(t *testing.T
 	require.NoError(t, store.SaveCertificate(acmeCert))
 
 	assert.Equal(t, 2, len(store.certificates))
-	assert.NotNil(t, store.GetCertificate("selfsigned", "*.example.com", nil))
-	assert.NotNil(t, store.GetCertificate("acme", "*.example.com", nil))
+	assert.NotNil(t, store.GetCertificate("selfsigned", KeyTypeECDSA, "*.example.com", nil))
+	assert.NotNil(t, store.GetCertificate("acme", KeyTypeECDSA, "*.example.com", nil))
+}
+
+func Test_Store_GetCertificate_returnsCertWithMatchingKeyType(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store.json")
+	store, err := NewStore(path)
+	require.NoError(t, err, "store should load")
+
+	legacyCert := &Details{
+		Provider: "acme",
+		Subject:  "example.com",
+		NotAfter: time.Now().Add(time.Hour),
+	}
+	rsaCert := &Details{
+		Provider: "acme",
+		KeyType:  KeyTypeRSA,
+		Subject:  "example.com",
+		NotAfter: time.Now().Add(time.Hour),
+	}
+
+	require.NoError(t, store.SaveCertificate(legacyCert))
+	require.NoError(t, store.SaveCertificate(rsaCert))
+
+	assert.Equal(t, 2, len(store.certificates))
+	assert.Equal(t, legacyCert, store.GetCertificate("acme", KeyTypeECDSA, "example.com", nil))
+	assert.Equal(t, rsaCert, store.GetCertificate("acme", KeyTypeRSA, "example.com", nil))
+}
+
+func Test_Store_SaveCertificate_replacesLegacyCertWithECDSACert(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store.json")
+	store, err := NewStore(path)
+	require.NoError(t, err, "store should load")
+
+	legacyCert := &Details{
+		Provider: "acme",
+		Subject:  "example.com",
+		NotAfter: time.Now().Add(time.Hour),
+	}
+	ecdsaCert := &Details{
+		Provider: "acme",
+		KeyType:  KeyTypeECDSA,
+		Subject:  "example.com",
+		NotAfter: time.Now().Add(time.Hour),
+	}
+
+	require.NoError(t, store.SaveCertificate(legacyCert))
+	require.NoError(t, store.SaveCertificate(ecdsaCert))
+
+	assert.Equal(t, 1, len(store.certificates))
+	assert.Equal(t, ecdsaCert, store.GetCertificate("acme", KeyTypeECDSA, "example.com", nil))
+}
+
+func Test_Store_CertificatesAndDelete(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store.json")
+	store, err := NewStore(path)
+	require.NoError(t, err, "store should load")
+
+	ecdsaCert := &Details{Provider: "acme", KeyType: KeyTypeECDSA, Subject: "example.com", NotAfter: time.Now().Add(time.Hour)}
+	rsaCert := &Details{Provider: "acme", KeyType: KeyTypeRSA, Subject: "example.com", NotAfter: time.Now().Add(time.Hour)}
+	require.NoError(t, store.SaveCertificate(ecdsaCert))
+	require.NoError(t, store.SaveCertificate(rsaCert))
+
+	certs, err := store.Certificates()
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []*Details{ecdsaCert, rsaCert}, certs)
+
+	require.NoError(t, store.DeleteCertificate(rsaCert))
+
+	reloaded, err := NewStore(path)
+	require.NoError(t, err, "store should reload")
+	certs, err = reloaded.Certificates()
+	require.NoError(t, err)
+	require.Len(t, certs, 1)
+	assert.Equal(t, KeyTypeECDSA, certs[0].KeyType)
+}
+
+func Test_Store_GetCertificate_returnsCopyThatCanBeModifiedConcurrently(t *testing.T) {
+	store, err := NewStore(filepath.Join(t.TempDir(), "store.json"))
+	require.NoError(t, err, "store should load")
+	require.NoError(t, store.SaveCertificate(&Details{Provider: "lego", Subject: "example.com", NotAfter: time.Now().Add(time.Hour)}))
+
+	cert := store.GetCertificate("lego", KeyTypeECDSA, "example.com", nil)
+	require.NotNil(t, cert)
+
+	wg := &sync.WaitGroup{}
+	wg.Go(func() {
+		for i := range 100 {
+			cert.AriNextUpdate = time.Now().Add(time.Duration(i) * time.Minute)
+		}
+	})
+	for i := range 20 {
+		wg.Go(func() {
+			subject := fmt.Sprintf("%d.example.net", i)
+			assert.NoError(t, store.SaveCertificate(&Details{Provider: "lego", Subject: subject, NotAfter: time.Now().Add(time.Hour)}))
+		})
+	}
+	wg.Wait()
+
+	assert.True(t, store.GetCertificate("lego", KeyTypeECDSA, "example.com", nil).AriNextUpdate.IsZero())
+}
+
+func Test_Store_isSafeForConcurrentUse(t *testing.T) {
+	store, err := NewStore(filepath.Join(t.TempDir(), "store.json"))
+	require.NoError(t, err, "store should load")
+
+	wg := &sync.WaitGroup{}
+	for i := range 40 {
+		wg.Go(func() {
+			subject := fmt.Sprintf("%d.example.com", i)
+			store.LockCertificate(subject, nil)
+			defer store.UnlockCertificate(subject, nil)
+
+			assert.Nil(t, store.GetCertificate("selfsigned", KeyTypeECDSA, subject, nil))
+			assert.NoError(t, store.SaveCertificate(&Details{Provider: "selfsigned", Subject: subject, NotAfter: time.Now().Add(time.Hour)}))
+			_, err := store.Certificates()
+			assert.NoError(t, err)
+		})
+	}
+	wg.Wait()
+
+	reloaded, err := NewStore(store.path)
+	require.NoError(t, err, "store should reload")
+	certs, err := reloaded.Certificates()
+	require.NoError(t, err)
+	assert.Len(t, certs, 40)
 }
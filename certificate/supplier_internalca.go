@@ -0,0 +1,324 @@
+package certificate
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/go-acme/lego/v5/certcrypto"
+)
+
+const (
+	// internalCARootValidity is how long the root certificate of an internal CA is valid for. Clients have to be
+	// configured to trust the root, so it should rarely change.
+	internalCARootValidity = time.Hour * 24 * 365 * 10
+	// internalCAIntermediateValidity is how long intermediate certificates are valid for.
+	internalCAIntermediateValidity = time.Hour * 24 * 365
+	// internalCAIntermediateMinValidity is the minimum remaining validity of the intermediate certificate. If
+	// it will expire sooner, a new intermediate is created.
+	internalCAIntermediateMinValidity = time.Hour * 24 * 60
+	// internalCALeafValidity is how long certificates issued by the internal CA are valid for.
+	internalCALeafValidity = time.Hour * 24 * 30
+
+	internalCARootCertFile         = "root.crt"
+	internalCARootKeyFile          = "root.key"
+	internalCAIntermediateCertFile = "intermediate.crt"
+	internalCAIntermediateKeyFile  = "intermediate.key"
+)
+
+// InternalCASupplier issues certificates signed by a private certificate authority. The CA's root and
+// intermediate certificates and keys are persisted to disk, so that clients can be configured to trust the root.
+type InternalCASupplier struct {
+	dir string
+
+	mu               sync.Mutex
+	root             *x509.Certificate
+	rootKey          crypto.Signer
+	intermediate     *x509.Certificate
+	intermediateKey  crypto.Signer
+	intermediatePEM  []byte
+	intermediateName string
+}
+
+// NewInternalCASupplier creates a new supplier using the CA stored in the given directory. If the directory
+// doesn't contain a CA, a new one is created.
+func NewInternalCASupplier(dir string) (*InternalCASupplier, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("unable to create internal CA directory: %w", err)
+	}
+
+	s := &InternalCASupplier{dir: dir}
+	if err := s.loadRoot(); err != nil {
+		return nil, err
+	}
+
+	if err := s.loadIntermediate(); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// RootCertificatePath returns the path to the file containing the root certificate of the CA.
+func (s *InternalCASupplier) RootCertificatePath() string {
+	return filepath.Join(s.dir, internalCARootCertFile)
+}
+
+func (s *InternalCASupplier) GetCertificate(_ context.Context, keyType string, subject string, altNames []string, _ bool) (*Details, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.intermediate.NotAfter.Before(time.Now().Add(internalCAIntermediateMinValidity)) {
+		if err := s.createIntermediate(); err != nil {
+			return nil, err
+		}
+	}
+
+	key, err := generateKey(keyType)
+	if err != nil {
+		return nil, err
+	}
+
+	serial, err := randomSerial()
+	if err != nil {
+		return nil, err
+	}
+
+	keyUsage := x509.KeyUsageDigitalSignature
+	if keyType == KeyTypeRSA {
+		// Legacy clients may use RSA key exchange, which encrypts the pre-master secret with the certificate's key
+		keyUsage |= x509.KeyUsageKeyEncipherment
+	}
+
+	notAfter := time.Now().Add(internalCALeafValidity)
+	if notAfter.After(s.intermediate.NotAfter) {
+		notAfter = s.intermediate.NotAfter
+	}
+
+	dnsNames, ipAddresses := splitIdentifiers(append([]string{subject}, altNames...))
+
+	template := x509.Certificate{
+		SerialNumber: serial,
+		Subject: pkix.Name{
+			Organization: []string{"Centauri"},
+			CommonName:   subject,
+		},
+		NotBefore: time.Now().Add(-time.Hour),
+		NotAfter:  notAfter,
+
+		KeyUsage:              keyUsage,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		DNSNames:              dnsNames,
+		IPAddresses:           ipAddresses,
+		BasicConstraintsValid: true,
+	}
+
+	derBytes, err := x509.CreateCertificate(rand.Reader, &template, s.intermediate, key.Public(), s.intermediateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	chain := append(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: derBytes}), s.intermediatePEM...)
+	return &Details{
+		Issuer:         s.intermediateName,
+		PrivateKey:     string(certcrypto.PEMEncode(key)),
+		Certificate:    string(chain),
+		Subject:        subject,
+		AltNames:       altNames,
+		NotAfter:       template.NotAfter,
+		NextOcspUpdate: template.NotAfter, // We don't run an OCSP responder, so certs never need a staple
+	}, nil
+}
+
+func (s *InternalCASupplier) UpdateStaple(_ context.Context, _ *Details) error {
+	// Shouldn't be called - internal CA certs aren't stapled
+	return nil
+}
+
+func (s *InternalCASupplier) UpdateRenewalInfo(_ context.Context, _ *Details) error { return nil }
+
+func (s *InternalCASupplier) MinCertificateValidity() time.Duration {
+	return time.Hour * 24 * 10
+}
+
+func (s *InternalCASupplier) MinStapleValidity() time.Duration {
+	return time.Second
+}
+
+// loadRoot loads the root certificate and key from disk, creating them if they don't exist.
+func (s *InternalCASupplier) loadRoot() error {
+	cert, key, err := s.readPair(internalCARootCertFile, internalCARootKeyFile)
+	if err == nil {
+		s.root, s.rootKey = cert, key
+		return nil
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("unable to load internal CA root: %w", err)
+	}
+
+	key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+
+	serial, err := randomSerial()
+	if err != nil {
+		return err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject: pkix.Name{
+			Organization: []string{"Centauri"},
+			CommonName:   "Centauri Internal Root CA",
+		},
+		NotBefore: time.Now().Add(-time.Hour),
+		NotAfter:  time.Now().Add(internalCARootValidity),
+
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLen:            1,
+	}
+
+	cert, err = s.writePair(template, template, key, key, internalCARootCertFile, internalCARootKeyFile)
+	if err != nil {
+		return fmt.Errorf("unable to save internal CA root: %w", err)
+	}
+
+	slog.Info("Created new internal CA root certificate", "path", s.RootCertificatePath())
+	s.root, s.rootKey = cert, key
+	return nil
+}
+
+// loadIntermediate loads the intermediate certificate and key from disk. If they don't exist, weren't signed
+// by the current root, or will expire soon, a new intermediate is created.
+func (s *InternalCASupplier) loadIntermediate() error {
+	cert, key, err := s.readPair(internalCAIntermediateCertFile, internalCAIntermediateKeyFile)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("unable to load internal CA intermediate: %w", err)
+	}
+
+	if err != nil || cert.CheckSignatureFrom(s.root) != nil || cert.NotAfter.Before(time.Now().Add(internalCAIntermediateMinValidity)) {
+		return s.createIntermediate()
+	}
+
+	s.setIntermediate(cert, key)
+	return nil
+}
+
+// createIntermediate creates and saves a new intermediate certificate signed by the root.
+func (s *InternalCASupplier) createIntermediate() error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+
+	serial, err := randomSerial()
+	if err != nil {
+		return err
+	}
+
+	notAfter := time.Now().Add(internalCAIntermediateValidity)
+	if notAfter.After(s.root.NotAfter) {
+		notAfter = s.root.NotAfter
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject: pkix.Name{
+			Organization: []string{"Centauri"},
+			CommonName:   "Centauri Internal Intermediate CA",
+		},
+		NotBefore: time.Now().Add(-time.Hour),
+		NotAfter:  notAfter,
+
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLen:            0,
+		MaxPathLenZero:        true,
+	}
+
+	cert, err := s.writePair(template, s.root, key, s.rootKey, internalCAIntermediateCertFile, internalCAIntermediateKeyFile)
+	if err != nil {
+		return fmt.Errorf("unable to save internal CA intermediate: %w", err)
+	}
+
+	slog.Info("Created new internal CA intermediate certificate", "expires", cert.NotAfter)
+	s.setIntermediate(cert, key)
+	return nil
+}
+
+func (s *InternalCASupplier) setIntermediate(cert *x509.Certificate, key crypto.Signer) {
+	s.intermediate = cert
+	s.intermediateKey = key
+	s.intermediatePEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+	s.intermediateName = cert.Subject.CommonName
+}
+
+// readPair reads a PEM-encoded certificate and private key from the CA directory.
+func (s *InternalCASupplier) readPair(certFile, keyFile string) (*x509.Certificate, crypto.Signer, error) {
+	certBytes, err := os.ReadFile(filepath.Join(s.dir, certFile))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	keyBytes, err := os.ReadFile(filepath.Join(s.dir, keyFile))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	cert, err := certcrypto.ParsePEMCertificate(certBytes)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	key, err := certcrypto.ParsePEMPrivateKey(keyBytes)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return cert, key, nil
+}
+
+// writePair creates a certificate from the template, signed by the parent, and writes it and its private key
+// to the CA directory.
+func (s *InternalCASupplier) writePair(template, parent *x509.Certificate, key, parentKey crypto.Signer, certFile, keyFile string) (*x509.Certificate, error) {
+	derBytes, err := x509.CreateCertificate(rand.Reader, template, parent, key.Public(), parentKey)
+	if err != nil {
+		return nil, err
+	}
+
+	cert, err := x509.ParseCertificate(derBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.WriteFile(filepath.Join(s.dir, keyFile), certcrypto.PEMEncode(key), 0600); err != nil {
+		return nil, err
+	}
+
+	if err := os.WriteFile(filepath.Join(s.dir, certFile), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: derBytes}), 0644); err != nil {
+		return nil, err
+	}
+
+	return cert, nil
+}
+
+// randomSerial generates a random 128-bit certificate serial number.
+func randomSerial() (*big.Int, error) {
+	return rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+}
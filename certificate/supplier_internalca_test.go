@@ -0,0 +1,142 @@
+package certificate
+
+import (
+	"crypto/x509"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-acme/lego/v5/certcrypto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func verifyInternalCACertificate(t *testing.T, supplier *InternalCASupplier, details *Details, name string) {
+	certs, err := certcrypto.ParsePEMBundle([]byte(details.Certificate))
+	require.NoError(t, err)
+	require.Len(t, certs, 2)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(supplier.root)
+	intermediates := x509.NewCertPool()
+	intermediates.AddCert(certs[1])
+
+	_, err = certs[0].Verify(x509.VerifyOptions{
+		DNSName:       name,
+		Roots:         roots,
+		Intermediates: intermediates,
+	})
+	assert.NoError(t, err)
+}
+
+func Test_InternalCASupplier_GetCertificate_returnsCertSignedByRoot(t *testing.T) {
+	supplier, err := NewInternalCASupplier(t.TempDir())
+	require.NoError(t, err)
+
+	details, err := supplier.GetCertificate(t.Context(), KeyTypeECDSA, "subject.example.com", []string{"alt1.example.com", "alt2.example.com"}, false)
+	require.NoError(t, err)
+
+	cert, err := certcrypto.ParsePEMCertificate([]byte(details.Certificate))
+	require.NoError(t, err)
+	assert.Equal(t, "subject.example.com", cert.Subject.CommonName)
+	assert.Equal(t, []string{"subject.example.com", "alt1.example.com", "alt2.example.com"}, cert.DNSNames)
+	assert.Equal(t, "Centauri Internal Intermediate CA", details.Issuer)
+	assert.True(t, details.ValidFor(supplier.MinCertificateValidity()))
+	assert.False(t, details.RequiresStaple())
+
+	verifyInternalCACertificate(t, supplier, details, "alt2.example.com")
+}
+
+func Test_InternalCASupplier_GetCertificate_issuesRSACertificates(t *testing.T) {
+	supplier, err := NewInternalCASupplier(t.TempDir())
+	require.NoError(t, err)
+
+	details, err := supplier.GetCertificate(t.Context(), KeyTypeRSA, "example.com", nil, false)
+	require.NoError(t, err)
+
+	cert, err := certcrypto.ParsePEMCertificate([]byte(details.Certificate))
+	require.NoError(t, err)
+	assert.Equal(t, x509.RSA, cert.PublicKeyAlgorithm)
+	assert.NotZero(t, cert.KeyUsage&x509.KeyUsageKeyEncipherment)
+
+	verifyInternalCACertificate(t, supplier, details, "example.com")
+}
+
+func Test_InternalCASupplier_GetCertificate_usesUniqueSerials(t *testing.T) {
+	supplier, err := NewInternalCASupplier(t.TempDir())
+	require.NoError(t, err)
+
+	details1, err := supplier.GetCertificate(t.Context(), KeyTypeECDSA, "example.com", nil, false)
+	require.NoError(t, err)
+	details2, err := supplier.GetCertificate(t.Context(), KeyTypeECDSA, "example.com", nil, false)
+	require.NoError(t, err)
+
+	cert1, err := certcrypto.ParsePEMCertificate([]byte(details1.Certificate))
+	require.NoError(t, err)
+	cert2, err := certcrypto.ParsePEMCertificate([]byte(details2.Certificate))
+	require.NoError(t, err)
+	assert.NotEqual(t, cert1.SerialNumber, cert2.SerialNumber)
+}
+
+func Test_NewInternalCASupplier_persistsCA(t *testing.T) {
+	dir := t.TempDir()
+
+	supplier1, err := NewInternalCASupplier(dir)
+	require.NoError(t, err)
+	assert.FileExists(t, filepath.Join(dir, "root.crt"))
+	assert.Equal(t, filepath.Join(dir, "root.crt"), supplier1.RootCertificatePath())
+
+	info, err := os.Stat(filepath.Join(dir, "root.key"))
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	supplier2, err := NewInternalCASupplier(dir)
+	require.NoError(t, err)
+	assert.Equal(t, supplier1.root.Raw, supplier2.root.Raw)
+	assert.Equal(t, supplier1.intermediatePEM, supplier2.intermediatePEM)
+
+	details, err := supplier2.GetCertificate(t.Context(), KeyTypeECDSA, "example.com", nil, false)
+	require.NoError(t, err)
+	verifyInternalCACertificate(t, supplier1, details, "example.com")
+}
+
+func Test_NewInternalCASupplier_replacesIntermediateNotSignedByRoot(t *testing.T) {
+	dir := t.TempDir()
+	supplier1, err := NewInternalCASupplier(dir)
+	require.NoError(t, err)
+
+	require.NoError(t, os.Remove(filepath.Join(dir, "root.crt")))
+	require.NoError(t, os.Remove(filepath.Join(dir, "root.key")))
+
+	supplier2, err := NewInternalCASupplier(dir)
+	require.NoError(t, err)
+	assert.NotEqual(t, supplier1.root.Raw, supplier2.root.Raw)
+	assert.NotEqual(t, supplier1.intermediatePEM, supplier2.intermediatePEM)
+
+	details, err := supplier2.GetCertificate(t.Context(), KeyTypeECDSA, "example.com", nil, false)
+	require.NoError(t, err)
+	verifyInternalCACertificate(t, supplier2, details, "example.com")
+}
+
+func Test_InternalCASupplier_GetCertificate_replacesExpiringIntermediate(t *testing.T) {
+	supplier, err := NewInternalCASupplier(t.TempDir())
+	require.NoError(t, err)
+
+	old := supplier.intermediatePEM
+	supplier.intermediate.NotAfter = time.Now().Add(time.Hour)
+
+	details, err := supplier.GetCertificate(t.Context(), KeyTypeECDSA, "example.com", nil, false)
+	require.NoError(t, err)
+	assert.NotEqual(t, old, supplier.intermediatePEM)
+	verifyInternalCACertificate(t, supplier, details, "example.com")
+}
+
+func Test_NewInternalCASupplier_errorsIfCAIsCorrupt(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "root.crt"), []byte("nope"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "root.key"), []byte("nope"), 0600))
+
+	_, err := NewInternalCASupplier(dir)
+	assert.Error(t, err)
+}
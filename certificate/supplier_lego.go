@@ -49,7 +49,9 @@ type LegoSupplier struct {
 	user          *acmeUser
 	certifier     certifier
 	profile       string
-	keyType       certcrypto.KeyType
+	ipProfile     string
+	ipChallenges  bool
+	keyTypes      map[string]certcrypto.KeyType
 	timeout       time.Duration
 	obtainLimiter *rate.Limiter
 }
@@ -64,10 +66,26 @@ type LegoSupplierConfig struct {
 	DirUrl string
 	// Profile is the name of the profile to use when requesting a certificate.
 	Profile string
-	// KeyType is the type of key to use when generating a certificate.
+	// IPProfile is the name of the profile to use when requesting a certificate for an IP address. Defaults to
+	// Profile.
+	IPProfile string
+	// KeyType is the type of key to use when generating an ECDSA certificate.
 	KeyType certcrypto.KeyType
-	// DnsProvider is the DNS-01 challenge provider that will verify domain ownership.
+	// RSAKeyType is the type of key to use when generating an RSA certificate. Defaults to 2048-bit RSA.
+	RSAKeyType certcrypto.KeyType
+	// DnsProvider is the DNS-01 challenge provider that will verify domain ownership. Optional if another
+	// provider is set, but required for wildcard certificates.
 	DnsProvider challenge.Provider
+	// ChallengeAlias, if set, is a zone that DNS-01 challenge records are written to instead of the zone of the
+	// domain being validated. The `_acme-challenge` record for each domain must be a CNAME to the record named
+	// `_acme-challenge.<domain with dots replaced by dashes>.<ChallengeAlias>`.
+	ChallengeAlias string
+	// HttpProvider is the HTTP-01 challenge provider that will verify domain ownership. Optional if another
+	// provider is set.
+	HttpProvider challenge.Provider
+	// TlsAlpnProvider is the TLS-ALPN-01 challenge provider that will verify domain ownership. Optional if
+	// another provider is set.
+	TlsAlpnProvider challenge.Provider
 	// DisablePropagationCheck instructs the lego client to not bother checking for DNS propagation.
 	DisablePropagationCheck bool
 	// PropagationDelay is the duration to sleep for if the propagation check is disabled.
@@ -94,6 +112,10 @@ func NewLegoSupplier(ctx context.Context, config *LegoSupplierConfig) (*LegoSupp
 		return nil, fmt.Errorf("unable to write to path %s: %w", config.Path, err)
 	}
 
+	if config.DnsProvider == nil && config.HttpProvider == nil && config.TlsAlpnProvider == nil {
+		return nil, errors.New("no ACME challenge providers configured")
+	}
+
 	user := &acmeUser{
 		email:   config.Email,
 		eabKid:  config.ExternalAccountKid,
@@ -118,24 +140,43 @@ func NewLegoSupplier(ctx context.Context, config *LegoSupplierConfig) (*LegoSupp
 		}))
 	}
 
-	if err = client.Challenge.SetDNS01Provider(
-		config.DnsProvider,
-		dns01.CondOptions(
-			config.DisablePropagationCheck,
-			dns01.WrapPreCheck(func(ctx context.Context, domain, fqdn, value string, check dns01.PreCheckFunc) (bool, error) {
-				slog.Info("Propagation check disabled, not checking DNS at all", "domain", domain, "wait", config.PropagationDelay)
+	if config.DnsProvider != nil {
+		dnsProvider := config.DnsProvider
+		if config.ChallengeAlias != "" {
+			dnsProvider = newAliasedDnsProvider(dnsProvider, config.ChallengeAlias)
+		}
 
-				select {
-				case <-time.After(config.PropagationDelay):
-				case <-ctx.Done():
-					return false, ctx.Err()
-				}
+		if err = client.Challenge.SetDNS01Provider(
+			dnsProvider,
+			dns01.CondOptions(
+				config.DisablePropagationCheck,
+				dns01.WrapPreCheck(func(ctx context.Context, domain, fqdn, value string, check dns01.PreCheckFunc) (bool, error) {
+					slog.Info("Propagation check disabled, not checking DNS at all", "domain", domain, "wait", config.PropagationDelay)
+
+					select {
+					case <-time.After(config.PropagationDelay):
+					case <-ctx.Done():
+						return false, ctx.Err()
+					}
+
+					return true, nil
+				}),
+			),
+		); err != nil {
+			return nil, err
+		}
+	}
 
-				return true, nil
-			}),
-		),
-	); err != nil {
-		return nil, err
+	if config.HttpProvider != nil {
+		if err = client.Challenge.SetHTTP01Provider(config.HttpProvider); err != nil {
+			return nil, err
+		}
+	}
+
+	if config.TlsAlpnProvider != nil {
+		if err = client.Challenge.SetTLSALPN01Provider(config.TlsAlpnProvider); err != nil {
+			return nil, err
+		}
 	}
 
 	if user.account == nil {
@@ -144,11 +185,18 @@ func NewLegoSupplier(ctx context.Context, config *LegoSupplierConfig) (*LegoSupp
 		}
 	}
 
+	rsaKeyType := config.RSAKeyType
+	if rsaKeyType == "" {
+		rsaKeyType = certcrypto.RSA2048
+	}
+
 	s := &LegoSupplier{
 		user:          user,
 		certifier:     client.Certificate,
 		profile:       config.Profile,
-		keyType:       config.KeyType,
+		ipProfile:     config.IPProfile,
+		ipChallenges:  config.HttpProvider != nil || config.TlsAlpnProvider != nil,
+		keyTypes:      map[string]certcrypto.KeyType{KeyTypeECDSA: config.KeyType, KeyTypeRSA: rsaKeyType},
 		timeout:       config.Timeout,
 		obtainLimiter: rate.NewLimiter(rate.Every(config.ObtainInterval), 1),
 	}
@@ -157,8 +205,25 @@ func NewLegoSupplier(ctx context.Context, config *LegoSupplierConfig) (*LegoSupp
 }
 
 // GetCertificate obtains a new certificate for the given names, and immediately requests a new OCSP staple.
-func (s *LegoSupplier) GetCertificate(ctx context.Context, subject string, altNames []string, shouldStaple bool) (*Details, error) {
-	slog.Info("Starting ACME process to obtain certificate", "domain", subject, "altNames", altNames, "timeout", s.timeout, "limited", s.obtainLimiter.Tokens() < 1)
+func (s *LegoSupplier) GetCertificate(ctx context.Context, keyType string, subject string, altNames []string, shouldStaple bool) (*Details, error) {
+	legoKeyType, ok := s.keyTypes[keyType]
+	if !ok {
+		return nil, fmt.Errorf("unsupported key type: %s", keyType)
+	}
+
+	names := append([]string{subject}, altNames...)
+	profile := s.profile
+	if hasIPIdentifier(names) {
+		// IP addresses can't be validated using DNS-01, so fail early rather than waiting for the CA to refuse.
+		if !s.ipChallenges {
+			return nil, errors.New("certificates for IP addresses require the HTTP-01 or TLS-ALPN-01 challenge")
+		}
+		if s.ipProfile != "" {
+			profile = s.ipProfile
+		}
+	}
+
+	slog.Info("Starting ACME process to obtain certificate", "domain", subject, "altNames", altNames, "keyType", keyType, "timeout", s.timeout, "limited", s.obtainLimiter.Tokens() < 1)
 
 	if err := s.obtainLimiter.Wait(ctx); err != nil {
 		return nil, err
@@ -171,11 +236,11 @@ func (s *LegoSupplier) GetCertificate(ctx context.Context, subject string, altNa
 	}
 
 	res, err := s.certifier.Obtain(ctx, legocert.ObtainRequest{
-		Domains:    append([]string{subject}, altNames...),
+		Domains:    names,
 		Bundle:     true,
 		MustStaple: shouldStaple,
-		Profile:    s.profile,
-		KeyType:    s.keyType,
+		Profile:    profile,
+		KeyType:    legoKeyType,
 	})
 	if err != nil {
 		return nil, err
@@ -198,17 +198,44 @@ func Test_Supplier_GetCertificate_passesDetailsToCertifier(t *testing.T) {
 	}
 	s := &LegoSupplier{
 		certifier:     c,
-		keyType:       certcrypto.EC384,
+		keyTypes:      map[string]certcrypto.KeyType{KeyTypeECDSA: certcrypto.EC384},
 		obtainLimiter: rate.NewLimiter(rate.Inf, 1),
 	}
 
-	_, _ = s.GetCertificate(t.Context(), "example.com", []string{"alt.example.com", "example.net"}, true)
+	_, _ = s.GetCertificate(t.Context(), KeyTypeECDSA, "example.com", []string{"alt.example.com", "example.net"}, true)
 	assert.Equal(t, c.request.Domains, []string{"example.com", "alt.example.com", "example.net"})
 	assert.True(t, c.request.Bundle)
 	assert.True(t, c.request.MustStaple)
 	assert.Equal(t, certcrypto.EC384, c.request.KeyType)
 }
 
+func Test_Supplier_GetCertificate_usesKeyTypeForRequestedType(t *testing.T) {
+	c := &fakeCertifier{
+		obtainErr: fmt.Errorf("denied"),
+	}
+	s := &LegoSupplier{
+		certifier:     c,
+		keyTypes:      map[string]certcrypto.KeyType{KeyTypeECDSA: certcrypto.EC384, KeyTypeRSA: certcrypto.RSA2048},
+		obtainLimiter: rate.NewLimiter(rate.Inf, 1),
+	}
+
+	_, _ = s.GetCertificate(t.Context(), KeyTypeRSA, "example.com", nil, false)
+	assert.Equal(t, certcrypto.RSA2048, c.request.KeyType)
+}
+
+func Test_Supplier_GetCertificate_errorsForUnknownKeyType(t *testing.T) {
+	c := &fakeCertifier{}
+	s := &LegoSupplier{
+		certifier:     c,
+		keyTypes:      map[string]certcrypto.KeyType{KeyTypeECDSA: certcrypto.EC384},
+		obtainLimiter: rate.NewLimiter(rate.Inf, 1),
+	}
+
+	_, err := s.GetCertificate(t.Context(), "dsa", "example.com", nil, false)
+	assert.ErrorContains(t, err, "unsupported key type")
+	assert.Nil(t, c.request.Domains)
+}
+
 func Test_Supplier_GetCertificate_passesProfileToCertifier(t *testing.T) {
 	c := &fakeCertifier{
 		obtainErr: fmt.Errorf("denied"),
@@ -216,12 +243,46 @@ func Test_Supplier_GetCertificate_passesProfileToCertifier(t *testing.T) {
 	s := &LegoSupplier{
 		certifier:     c,
 		profile:       "shortlived",
-		keyType:       certcrypto.EC384,
+		keyTypes:      map[string]certcrypto.KeyType{KeyTypeECDSA: certcrypto.EC384},
+		obtainLimiter: rate.NewLimiter(rate.Inf, 1),
+	}
+
+	_, _ = s.GetCertificate(t.Context(), KeyTypeECDSA, "example.com", nil, false)
+	assert.Equal(t, "shortlived", c.request.Profile)
+}
+
+func Test_Supplier_GetCertificate_usesIPProfileForIPAddresses(t *testing.T) {
+	c := &fakeCertifier{
+		obtainErr: fmt.Errorf("denied"),
+	}
+	s := &LegoSupplier{
+		certifier:     c,
+		profile:       "classic",
+		ipProfile:     "shortlived",
+		ipChallenges:  true,
+		keyTypes:      map[string]certcrypto.KeyType{KeyTypeECDSA: certcrypto.EC384},
 		obtainLimiter: rate.NewLimiter(rate.Inf, 1),
 	}
 
-	_, _ = s.GetCertificate(t.Context(), "example.com", nil, false)
+	_, _ = s.GetCertificate(t.Context(), KeyTypeECDSA, "example.com", nil, false)
+	assert.Equal(t, "classic", c.request.Profile)
+
+	_, _ = s.GetCertificate(t.Context(), KeyTypeECDSA, "example.com", []string{"2001:db8::1"}, false)
 	assert.Equal(t, "shortlived", c.request.Profile)
+	assert.Equal(t, []string{"example.com", "2001:db8::1"}, c.request.Domains)
+}
+
+func Test_Supplier_GetCertificate_errorsForIPAddressesWithoutSuitableChallenge(t *testing.T) {
+	c := &fakeCertifier{}
+	s := &LegoSupplier{
+		certifier:     c,
+		keyTypes:      map[string]certcrypto.KeyType{KeyTypeECDSA: certcrypto.EC384},
+		obtainLimiter: rate.NewLimiter(rate.Inf, 1),
+	}
+
+	_, err := s.GetCertificate(t.Context(), KeyTypeECDSA, "203.0.113.10", nil, false)
+	assert.ErrorContains(t, err, "IP addresses")
+	assert.Nil(t, c.request.Domains)
 }
 
 func Test_Supplier_GetCertificate_returnsErrorIfObtainFails(t *testing.T) {
@@ -230,10 +291,11 @@ func Test_Supplier_GetCertificate_returnsErrorIfObtainFails(t *testing.T) {
 	}
 	s := &LegoSupplier{
 		certifier:     c,
+		keyTypes:      map[string]certcrypto.KeyType{KeyTypeECDSA: certcrypto.EC384},
 		obtainLimiter: rate.NewLimiter(rate.Inf, 1),
 	}
 
-	_, err := s.GetCertificate(t.Context(), "example.com", []string{"alt.example.com", "example.net"}, true)
+	_, err := s.GetCertificate(t.Context(), KeyTypeECDSA, "example.com", []string{"alt.example.com", "example.net"}, true)
 	assert.Error(t, err)
 }
 
@@ -243,10 +305,11 @@ func Test_Supplier_GetCertificate_returnsErrorIfCertificateCantBeParsed(t *testi
 	}
 	s := &LegoSupplier{
 		certifier:     c,
+		keyTypes:      map[string]certcrypto.KeyType{KeyTypeECDSA: certcrypto.EC384},
 		obtainLimiter: rate.NewLimiter(rate.Inf, 1),
 	}
 
-	_, err := s.GetCertificate(t.Context(), "example.com", []string{"alt.example.com", "example.net"}, true)
+	_, err := s.GetCertificate(t.Context(), KeyTypeECDSA, "example.com", []string{"alt.example.com", "example.net"}, true)
 	assert.Error(t, err)
 }
 
@@ -260,10 +323,11 @@ func Test_Supplier_GetCertificate_returnsErrorIfStaplingFailsWhenEnabled(t *test
 	}
 	s := &LegoSupplier{
 		certifier:     c,
+		keyTypes:      map[string]certcrypto.KeyType{KeyTypeECDSA: certcrypto.EC384},
 		obtainLimiter: rate.NewLimiter(rate.Inf, 1),
 	}
 
-	_, err := s.GetCertificate(t.Context(), "example.com", []string{"alt.example.com", "example.net"}, true)
+	_, err := s.GetCertificate(t.Context(), KeyTypeECDSA, "example.com", []string{"alt.example.com", "example.net"}, true)
 	assert.Error(t, err)
 }
 
@@ -277,10 +341,11 @@ func Test_Supplier_GetCertificate_doesNotTryToStapleIfDisabled(t *testing.T) {
 	}
 	s := &LegoSupplier{
 		certifier:     c,
+		keyTypes:      map[string]certcrypto.KeyType{KeyTypeECDSA: certcrypto.EC384},
 		obtainLimiter: rate.NewLimiter(rate.Inf, 1),
 	}
 
-	cert, err := s.GetCertificate(t.Context(), "example.com", []string{"alt.example.com", "example.net"}, false)
+	cert, err := s.GetCertificate(t.Context(), KeyTypeECDSA, "example.com", []string{"alt.example.com", "example.net"}, false)
 	assert.NoError(t, err)
 	assert.EqualValues(t, cert.Certificate, pemCert)
 }
@@ -304,10 +369,11 @@ func Test_Supplier_GetCertificate_returnsCertificateDetails(t *testing.T) {
 	}
 	s := &LegoSupplier{
 		certifier:     c,
+		keyTypes:      map[string]certcrypto.KeyType{KeyTypeECDSA: certcrypto.EC384},
 		obtainLimiter: rate.NewLimiter(rate.Inf, 1),
 	}
 
-	cert, err := s.GetCertificate(t.Context(), "example.com", []string{"alt.example.com", "example.net"}, true)
+	cert, err := s.GetCertificate(t.Context(), KeyTypeECDSA, "example.com", []string{"alt.example.com", "example.net"}, true)
 	assert.NoError(t, err)
 	assert.EqualValues(t, cert.Certificate, pemCert)
 	assert.Equal(t, cert.Issuer, "issuer")
@@ -350,6 +416,7 @@ func Test_Supplier_UpdateStaple_passesCertToStapler(t *testing.T) {
 	c := &fakeCertifier{}
 	s := &LegoSupplier{
 		certifier:     c,
+		keyTypes:      map[string]certcrypto.KeyType{KeyTypeECDSA: certcrypto.EC384},
 		obtainLimiter: rate.NewLimiter(rate.Inf, 1),
 	}
 	_ = s.UpdateStaple(t.Context(), &Details{Certificate: "cert"})
@@ -366,6 +433,7 @@ func Test_Supplier_UpdateStaple_updatesOcspDetails(t *testing.T) {
 	}
 	s := &LegoSupplier{
 		certifier:     c,
+		keyTypes:      map[string]certcrypto.KeyType{KeyTypeECDSA: certcrypto.EC384},
 		obtainLimiter: rate.NewLimiter(rate.Inf, 1),
 	}
 
@@ -392,6 +460,7 @@ func Test_Supplier_UpdateRenewalInfo_returnsNilIfServerDoesNotSupportARI(t *test
 	}
 	s := &LegoSupplier{
 		certifier:     c,
+		keyTypes:      map[string]certcrypto.KeyType{KeyTypeECDSA: certcrypto.EC384},
 		obtainLimiter: rate.NewLimiter(rate.Inf, 1),
 	}
 
@@ -410,6 +479,7 @@ func Test_Supplier_UpdateRenewalInfo_returnsErrorIfGetRenewalInfoFails(t *testin
 	}
 	s := &LegoSupplier{
 		certifier:     c,
+		keyTypes:      map[string]certcrypto.KeyType{KeyTypeECDSA: certcrypto.EC384},
 		obtainLimiter: rate.NewLimiter(rate.Inf, 1),
 	}
 
@@ -436,6 +506,7 @@ func Test_Supplier_UpdateRenewalInfo_passesCertToCertifier(t *testing.T) {
 	}
 	s := &LegoSupplier{
 		certifier:     c,
+		keyTypes:      map[string]certcrypto.KeyType{KeyTypeECDSA: certcrypto.EC384},
 		obtainLimiter: rate.NewLimiter(rate.Inf, 1),
 	}
 
@@ -466,6 +537,7 @@ func Test_Supplier_UpdateRenewalInfo_updatesARIDetails(t *testing.T) {
 	}
 	s := &LegoSupplier{
 		certifier:     c,
+		keyTypes:      map[string]certcrypto.KeyType{KeyTypeECDSA: certcrypto.EC384},
 		obtainLimiter: rate.NewLimiter(rate.Inf, 1),
 	}
 
@@ -499,6 +571,13 @@ func Test_NewLegoSupplier_errorsIfUserDataPathIsReadOnly(t *testing.T) {
 	assert.ErrorContains(t, err, "unable to write to path")
 }
 
+func Test_NewLegoSupplier_errorsIfNoChallengeProvidersConfigured(t *testing.T) {
+	_, err := NewLegoSupplier(t.Context(), &LegoSupplierConfig{
+		Path: filepath.Join(t.TempDir(), "user.pem"),
+	})
+	assert.ErrorContains(t, err, "no ACME challenge providers configured")
+}
+
 func Test_ParseResolvers(t *testing.T) {
 	tests := []struct {
 		name  string
@@ -2,8 +2,6 @@ package certificate
 
 import (
 	"context"
-	"crypto/ecdsa"
-	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/x509"
 	"crypto/x509/pkix"
@@ -21,12 +19,14 @@ func NewSelfSignedSupplier() *SelfSignedSupplier {
 	return &SelfSignedSupplier{}
 }
 
-func (s *SelfSignedSupplier) GetCertificate(_ context.Context, subject string, altNames []string, shouldStaple bool) (*Details, error) {
-	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+func (s *SelfSignedSupplier) GetCertificate(_ context.Context, keyType string, subject string, altNames []string, shouldStaple bool) (*Details, error) {
+	key, err := generateKey(keyType)
 	if err != nil {
 		return nil, err
 	}
 
+	dnsNames, ipAddresses := splitIdentifiers(append([]string{subject}, altNames...))
+
 	template := x509.Certificate{
 		SerialNumber: big.NewInt(1),
 		Subject: pkix.Name{
@@ -38,7 +38,8 @@ func (s *SelfSignedSupplier) GetCertificate(_ context.Context, subject string, a
 
 		KeyUsage:              x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature,
 		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
-		DNSNames:              append([]string{subject}, altNames...),
+		DNSNames:              dnsNames,
+		IPAddresses:           ipAddresses,
 		BasicConstraintsValid: true,
 	}
 
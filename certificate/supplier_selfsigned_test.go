@@ -1,15 +1,17 @@
 package certificate
 
 import (
+	"crypto/x509"
 	"testing"
 
 	"github.com/go-acme/lego/v5/certcrypto"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func Test_SelfSignedSupplier_GetCertificate_returnsCertWithCorrectNames(t *testing.T) {
 	supplier := &SelfSignedSupplier{}
-	details, err := supplier.GetCertificate(t.Context(), "subject.example.com", []string{"alt1.example.com", "alt2.example.com"}, false)
+	details, err := supplier.GetCertificate(t.Context(), KeyTypeECDSA, "subject.example.com", []string{"alt1.example.com", "alt2.example.com"}, false)
 
 	assert.Nil(t, err)
 
@@ -20,3 +22,36 @@ func Test_SelfSignedSupplier_GetCertificate_returnsCertWithCorrectNames(t *testi
 	assert.Equal(t, "subject.example.com", cert.Subject.CommonName)
 	assert.Equal(t, []string{"subject.example.com", "alt1.example.com", "alt2.example.com"}, cert.DNSNames)
 }
+
+func Test_SelfSignedSupplier_GetCertificate_includesIPAddresses(t *testing.T) {
+	supplier := &SelfSignedSupplier{}
+	details, err := supplier.GetCertificate(t.Context(), KeyTypeECDSA, "203.0.113.10", []string{"example.com", "2001:db8::1"}, false)
+	require.NoError(t, err)
+
+	cert, err := certcrypto.ParsePEMCertificate([]byte(details.Certificate))
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"example.com"}, cert.DNSNames)
+	require.Len(t, cert.IPAddresses, 2)
+	assert.Equal(t, "203.0.113.10", cert.IPAddresses[0].String())
+	assert.Equal(t, "2001:db8::1", cert.IPAddresses[1].String())
+}
+
+func Test_SelfSignedSupplier_GetCertificate_usesRequestedKeyType(t *testing.T) {
+	supplier := &SelfSignedSupplier{}
+
+	details, err := supplier.GetCertificate(t.Context(), KeyTypeRSA, "example.com", nil, false)
+	assert.NoError(t, err)
+	cert, err := certcrypto.ParsePEMCertificate([]byte(details.Certificate))
+	assert.NoError(t, err)
+	assert.Equal(t, x509.RSA, cert.PublicKeyAlgorithm)
+
+	details, err = supplier.GetCertificate(t.Context(), KeyTypeECDSA, "example.com", nil, false)
+	assert.NoError(t, err)
+	cert, err = certcrypto.ParsePEMCertificate([]byte(details.Certificate))
+	assert.NoError(t, err)
+	assert.Equal(t, x509.ECDSA, cert.PublicKeyAlgorithm)
+
+	_, err = supplier.GetCertificate(t.Context(), "dsa", "example.com", nil, false)
+	assert.ErrorContains(t, err, "unsupported key type")
+}
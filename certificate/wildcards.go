@@ -5,12 +5,14 @@ import (
 	"crypto/tls"
 	"fmt"
 	"strings"
+	"time"
 )
 
 // Provider defines the interface for providing certificates to a WildcardResolver.
 type Provider interface {
-	GetCertificate(ctx context.Context, preferredSupplier string, subject string, altNames []string) (*tls.Certificate, error)
-	GetExistingCertificate(preferredSupplier string, subject string, altNames []string) (*tls.Certificate, bool, error)
+	GetCertificate(ctx context.Context, preferredSupplier string, keyType string, subject string, altNames []string) (*tls.Certificate, error)
+	GetExistingCertificate(preferredSupplier string, keyType string, subject string, altNames []string) (*tls.Certificate, bool, error)
+	NextCertificateAction(preferredSupplier string, keyType string, subject string, altNames []string) (time.Time, error)
 }
 
 // WildcardResolver wraps around a certificate provider and modifies the domain and altNames
@@ -44,14 +46,20 @@ func NewWildcardResolver(upstream Provider, domains []string) *WildcardResolver
 
 // GetCertificate returns a certificate from the upstream provider that will cover the
 // given subject and altNames, taking into account the configured wildcard domains.
-func (w *WildcardResolver) GetCertificate(ctx context.Context, preferredSupplier string, subject string, altNames []string) (*tls.Certificate, error) {
-	return w.upstream.GetCertificate(ctx, preferredSupplier, w.applyWildcard(subject), w.applyWildcards(altNames))
+func (w *WildcardResolver) GetCertificate(ctx context.Context, preferredSupplier string, keyType string, subject string, altNames []string) (*tls.Certificate, error) {
+	return w.upstream.GetCertificate(ctx, preferredSupplier, keyType, w.applyWildcard(subject), w.applyWildcards(altNames))
 }
 
 // GetExistingCertificate returns an existing, saved certificate from the upstream provider that will cover the
 // given subject and altNames, taking into account the configured wildcard domains.
-func (w *WildcardResolver) GetExistingCertificate(preferredSupplier string, subject string, altNames []string) (*tls.Certificate, bool, error) {
-	return w.upstream.GetExistingCertificate(preferredSupplier, w.applyWildcard(subject), w.applyWildcards(altNames))
+func (w *WildcardResolver) GetExistingCertificate(preferredSupplier string, keyType string, subject string, altNames []string) (*tls.Certificate, bool, error) {
+	return w.upstream.GetExistingCertificate(preferredSupplier, keyType, w.applyWildcard(subject), w.applyWildcards(altNames))
+}
+
+// NextCertificateAction returns the time at which the upstream provider's certificate covering the given subject
+// and altNames will next need attention, taking into account the configured wildcard domains.
+func (w *WildcardResolver) NextCertificateAction(preferredSupplier string, keyType string, subject string, altNames []string) (time.Time, error) {
+	return w.upstream.NextCertificateAction(preferredSupplier, keyType, w.applyWildcard(subject), w.applyWildcards(altNames))
 }
 
 // applyWildcards checks each entry in the given slice of domains, replacing it with a wildcard domain if necessary.
@@ -64,8 +72,13 @@ func (w *WildcardResolver) applyWildcards(domains []string) []string {
 }
 
 // applyWildcard tests if any of the configured wildcard domains covers the given domain. If so, it returns the
-// matching wildcard domain; otherwise it returns the passed domain unaltered.
+// matching wildcard domain; otherwise it returns the passed domain unaltered. IP addresses are never covered
+// by wildcards.
 func (w *WildcardResolver) applyWildcard(domain string) string {
+	if isIPIdentifier(domain) {
+		return domain
+	}
+
 	for i := range w.domains {
 		prefix := strings.TrimSuffix(domain, w.domains[i])
 		if prefix != domain && strings.Count(prefix, ".") == 0 {
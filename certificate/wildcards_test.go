@@ -5,6 +5,7 @@ import (
 	"crypto/tls"
 	"fmt"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -13,26 +14,38 @@ type fakeCertManager struct {
 	certificate  *tls.Certificate
 	existingCert *tls.Certificate
 	needsRenewal bool
+	nextAction   time.Time
 	err          error
 	supplier     string
+	keyType      string
 	subject      string
 	altNames     []string
 }
 
-func (f *fakeCertManager) GetCertificate(_ context.Context, preferredSupplier string, subject string, altNames []string) (*tls.Certificate, error) {
+func (f *fakeCertManager) GetCertificate(_ context.Context, preferredSupplier string, keyType string, subject string, altNames []string) (*tls.Certificate, error) {
 	f.supplier = preferredSupplier
+	f.keyType = keyType
 	f.subject = subject
 	f.altNames = altNames
 	return f.certificate, f.err
 }
 
-func (f *fakeCertManager) GetExistingCertificate(preferredSupplier string, subject string, altNames []string) (*tls.Certificate, bool, error) {
+func (f *fakeCertManager) GetExistingCertificate(preferredSupplier string, keyType string, subject string, altNames []string) (*tls.Certificate, bool, error) {
 	f.supplier = preferredSupplier
+	f.keyType = keyType
 	f.subject = subject
 	f.altNames = altNames
 	return f.existingCert, f.needsRenewal, f.err
 }
 
+func (f *fakeCertManager) NextCertificateAction(preferredSupplier string, keyType string, subject string, altNames []string) (time.Time, error) {
+	f.supplier = preferredSupplier
+	f.keyType = keyType
+	f.subject = subject
+	f.altNames = altNames
+	return f.nextAction, f.err
+}
+
 var dummyCert = &tls.Certificate{}
 
 func Test_WildcardResolver_GetCertificate_passesRequestThroughIfNoDomainsConfigured(t *testing.T) {
@@ -41,7 +54,7 @@ func Test_WildcardResolver_GetCertificate_passesRequestThroughIfNoDomainsConfigu
 		err:         fmt.Errorf("an upstream error, oh my"),
 	}
 	resolver := NewWildcardResolver(upstream, nil)
-	cert, err := resolver.GetCertificate(t.Context(), "supplier", "example.com", []string{"foo.example.com", "bar.example.com"})
+	cert, err := resolver.GetCertificate(t.Context(), "supplier", KeyTypeECDSA, "example.com", []string{"foo.example.com", "bar.example.com"})
 
 	assert.Equal(t, upstream.certificate, cert)
 	assert.Equal(t, upstream.err, err)
@@ -56,7 +69,7 @@ func Test_WildcardResolver_GetCertificate_modifiesWildcardDomains(t *testing.T)
 		err:         fmt.Errorf("an upstream error, oh my"),
 	}
 	resolver := NewWildcardResolver(upstream, []string{"example.com", ".example.org"})
-	cert, err := resolver.GetCertificate(t.Context(), "supplier", "foo.example.com", []string{"bar.example.org"})
+	cert, err := resolver.GetCertificate(t.Context(), "supplier", KeyTypeECDSA, "foo.example.com", []string{"bar.example.org"})
 
 	assert.Equal(t, upstream.certificate, cert)
 	assert.Equal(t, upstream.err, err)
@@ -71,7 +84,7 @@ func Test_WildcardResolver_GetCertificate_doesNotModifySubdomains(t *testing.T)
 		err:         fmt.Errorf("an upstream error, oh my"),
 	}
 	resolver := NewWildcardResolver(upstream, []string{"example.com", ".example.org"})
-	cert, err := resolver.GetCertificate(t.Context(), "supplier", "foo.bar.example.com", []string{"foo.bar.example.org"})
+	cert, err := resolver.GetCertificate(t.Context(), "supplier", KeyTypeECDSA, "foo.bar.example.com", []string{"foo.bar.example.org"})
 
 	assert.Equal(t, upstream.certificate, cert)
 	assert.Equal(t, upstream.err, err)
@@ -80,13 +93,23 @@ func Test_WildcardResolver_GetCertificate_doesNotModifySubdomains(t *testing.T)
 	assert.Equal(t, []string{"foo.bar.example.org"}, upstream.altNames)
 }
 
+func Test_WildcardResolver_GetCertificate_doesNotModifyIPAddresses(t *testing.T) {
+	upstream := &fakeCertManager{
+		certificate: dummyCert,
+	}
+	resolver := NewWildcardResolver(upstream, []string{"0.113.10"})
+	_, _ = resolver.GetCertificate(t.Context(), "supplier", KeyTypeECDSA, "203.0.113.10", nil)
+
+	assert.Equal(t, "203.0.113.10", upstream.subject)
+}
+
 func Test_WildcardResolver_GetCertificate_doesNotModifyRootDomains(t *testing.T) {
 	upstream := &fakeCertManager{
 		certificate: dummyCert,
 		err:         fmt.Errorf("an upstream error, oh my"),
 	}
 	resolver := NewWildcardResolver(upstream, []string{"example.com", ".example.org"})
-	cert, err := resolver.GetCertificate(t.Context(), "supplier", "example.com", []string{"example.org"})
+	cert, err := resolver.GetCertificate(t.Context(), "supplier", KeyTypeECDSA, "example.com", []string{"example.org"})
 
 	assert.Equal(t, upstream.certificate, cert)
 	assert.Equal(t, upstream.err, err)
@@ -101,7 +124,7 @@ func Test_WildcardResolver_GetCertificate_doesNotModifyOtherDomains(t *testing.T
 		err:         fmt.Errorf("an upstream error, oh my"),
 	}
 	resolver := NewWildcardResolver(upstream, []string{"example.com", ".example.org"})
-	cert, err := resolver.GetCertificate(t.Context(), "supplier", "example.net", []string{"example.org.example.net"})
+	cert, err := resolver.GetCertificate(t.Context(), "supplier", KeyTypeECDSA, "example.net", []string{"example.org.example.net"})
 
 	assert.Equal(t, upstream.certificate, cert)
 	assert.Equal(t, upstream.err, err)
@@ -117,7 +140,7 @@ func Test_WildcardResolver_GetExistingCertificate_passesRequestThroughIfNoDomain
 		needsRenewal: true,
 	}
 	resolver := NewWildcardResolver(upstream, nil)
-	cert, r, err := resolver.GetExistingCertificate("supplier", "example.com", []string{"foo.example.com", "bar.example.com"})
+	cert, r, err := resolver.GetExistingCertificate("supplier", KeyTypeECDSA, "example.com", []string{"foo.example.com", "bar.example.com"})
 
 	assert.Equal(t, upstream.existingCert, cert)
 	assert.Equal(t, upstream.err, err)
@@ -133,7 +156,7 @@ func Test_WildcardResolver_GetExistingCertificate_modifiesWildcardDomains(t *tes
 		err:          fmt.Errorf("an upstream error, oh my"),
 	}
 	resolver := NewWildcardResolver(upstream, []string{"example.com", ".example.org"})
-	cert, _, err := resolver.GetExistingCertificate("supplier", "foo.example.com", []string{"bar.example.org"})
+	cert, _, err := resolver.GetExistingCertificate("supplier", KeyTypeECDSA, "foo.example.com", []string{"bar.example.org"})
 
 	assert.Equal(t, upstream.existingCert, cert)
 	assert.Equal(t, upstream.err, err)
@@ -148,7 +171,7 @@ func Test_WildcardResolver_GetExistingCertificate_doesNotModifySubdomains(t *tes
 		err:          fmt.Errorf("an upstream error, oh my"),
 	}
 	resolver := NewWildcardResolver(upstream, []string{"example.com", ".example.org"})
-	cert, _, err := resolver.GetExistingCertificate("supplier", "foo.bar.example.com", []string{"foo.bar.example.org"})
+	cert, _, err := resolver.GetExistingCertificate("supplier", KeyTypeECDSA, "foo.bar.example.com", []string{"foo.bar.example.org"})
 
 	assert.Equal(t, upstream.existingCert, cert)
 	assert.Equal(t, upstream.err, err)
@@ -163,7 +186,7 @@ func Test_WildcardResolver_GetExistingCertificate_doesNotModifyRootDomains(t *te
 		err:          fmt.Errorf("an upstream error, oh my"),
 	}
 	resolver := NewWildcardResolver(upstream, []string{"example.com", ".example.org"})
-	cert, _, err := resolver.GetExistingCertificate("supplier", "example.com", []string{"example.org"})
+	cert, _, err := resolver.GetExistingCertificate("supplier", KeyTypeECDSA, "example.com", []string{"example.org"})
 
 	assert.Equal(t, upstream.existingCert, cert)
 	assert.Equal(t, upstream.err, err)
@@ -178,7 +201,7 @@ func Test_WildcardResolver_GetExistingCertificate_doesNotModifyOtherDomains(t *t
 		err:          fmt.Errorf("an upstream error, oh my"),
 	}
 	resolver := NewWildcardResolver(upstream, []string{"example.com", ".example.org"})
-	cert, _, err := resolver.GetExistingCertificate("supplier", "example.net", []string{"example.org.example.net"})
+	cert, _, err := resolver.GetExistingCertificate("supplier", KeyTypeECDSA, "example.net", []string{"example.org.example.net"})
 
 	assert.Equal(t, upstream.existingCert, cert)
 	assert.Equal(t, upstream.err, err)
@@ -186,3 +209,16 @@ func Test_WildcardResolver_GetExistingCertificate_doesNotModifyOtherDomains(t *t
 	assert.Equal(t, "example.net", upstream.subject)
 	assert.Equal(t, []string{"example.org.example.net"}, upstream.altNames)
 }
+
+func Test_WildcardResolver_NextCertificateAction_modifiesWildcardDomains(t *testing.T) {
+	next := time.Now().Add(time.Hour)
+	upstream := &fakeCertManager{nextAction: next}
+	resolver := NewWildcardResolver(upstream, []string{"example.com"})
+	res, err := resolver.NextCertificateAction("supplier", KeyTypeECDSA, "foo.example.com", []string{"example.com"})
+
+	assert.NoError(t, err)
+	assert.Equal(t, next, res)
+	assert.Equal(t, "supplier", upstream.supplier)
+	assert.Equal(t, "*.example.com", upstream.subject)
+	assert.Equal(t, []string{"example.com"}, upstream.altNames)
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/csmith/centauri/certificate"
+)
+
+// parseAcmeIssuers parses a space-separated list of `name=url` pairs, as accepted by the acme-issuers option,
+// into a map of names to ACME directory URLs.
+func parseAcmeIssuers(input string) (map[string]string, error) {
+	return parseNamedOptions(input, "ACME issuer", "url")
+}
+
+// createAcmeIssuers creates each of the ACME issuers configured in the acme-issuers option. Each issuer gets its
+// own account, stored alongside the main user data, and reads its external account binding and profile from
+// environment variables prefixed with its name.
+func createAcmeIssuers(input string, userDataPath string) (map[string]certificate.AcmeIssuer, error) {
+	urls, err := parseAcmeIssuers(input)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make(map[string]certificate.AcmeIssuer)
+	for name, url := range urls {
+		prefix := namedEnvPrefix(name)
+		res[name] = certificate.AcmeIssuer{
+			DirUrl:              url,
+			Path:                acmeIssuerUserDataPath(userDataPath, name),
+			Profile:             os.Getenv(prefix + "ACME_PROFILE"),
+			ExternalAccountKid:  os.Getenv(prefix + "ACME_EXTERNAL_KID"),
+			ExternalAccountHmac: os.Getenv(prefix + "ACME_EXTERNAL_HMAC"),
+		}
+	}
+	return res, nil
+}
+
+// acmeIssuerUserDataPath returns the path to store the named issuer's account in, derived from the main user
+// data path. For example, `user.pem` becomes `user-zerossl.pem` for an issuer named `zerossl`.
+func acmeIssuerUserDataPath(userDataPath string, name string) string {
+	ext := filepath.Ext(userDataPath)
+	return strings.TrimSuffix(userDataPath, ext) + "-" + name + ext
+}
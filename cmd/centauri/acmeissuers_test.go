@@ -0,0 +1,65 @@
+//go:build integration
+
+package main
+
+import (
+	"testing"
+
+	"github.com/csmith/centauri/certificate"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_ParseAcmeIssuers_ParsesNamesAndUrls(t *testing.T) {
+	issuers, err := parseAcmeIssuers(" zerossl=https://acme.zerossl.com/v2/DV90  private=https://ca.example.com/acme/directory ")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"zerossl": "https://acme.zerossl.com/v2/DV90",
+		"private": "https://ca.example.com/acme/directory",
+	}, issuers)
+}
+
+func Test_ParseAcmeIssuers_ErrorsForInvalidInput(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+	}{
+		{"missing url", "zerossl"},
+		{"empty url", "zerossl="},
+		{"empty name", "=https://acme.zerossl.com/v2/DV90"},
+		{"uppercase name", "ZeroSSL=https://acme.zerossl.com/v2/DV90"},
+		{"lego name", "lego=https://acme.zerossl.com/v2/DV90"},
+		{"selfsigned name", "selfsigned=https://acme.zerossl.com/v2/DV90"},
+		{"duplicate name", "zerossl=https://a.example.com zerossl=https://b.example.com"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseAcmeIssuers(tt.input)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func Test_CreateAcmeIssuers_ReadsPrefixedEnvironmentVariables(t *testing.T) {
+	t.Setenv("PRIVATE_CA_ACME_EXTERNAL_KID", "kid")
+	t.Setenv("PRIVATE_CA_ACME_EXTERNAL_HMAC", "hmac")
+	t.Setenv("PRIVATE_CA_ACME_PROFILE", "shortlived")
+
+	issuers, err := createAcmeIssuers("private-ca=https://ca.example.com/acme/directory", "/data/user.pem")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]certificate.AcmeIssuer{
+		"private-ca": {
+			DirUrl:              "https://ca.example.com/acme/directory",
+			Path:                "/data/user-private-ca.pem",
+			Profile:             "shortlived",
+			ExternalAccountKid:  "kid",
+			ExternalAccountHmac: "hmac",
+		},
+	}, issuers)
+}
+
+func Test_AcmeIssuerUserDataPath(t *testing.T) {
+	assert.Equal(t, "user-zerossl.pem", acmeIssuerUserDataPath("user.pem", "zerossl"))
+	assert.Equal(t, "/data/account-zerossl", acmeIssuerUserDataPath("/data/account", "zerossl"))
+}
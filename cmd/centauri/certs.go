@@ -0,0 +1,451 @@
+package main
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/csmith/centauri/certificate"
+)
+
+const certsUsage = "usage: centauri certs <list|show NAME|renew NAME|delete NAME|export [FILE]|import FILE|migrate [-dry-run] [-on-conflict skip|replace|newer] FROM TO>"
+
+// runCerts runs one of the `certs` subcommands, which manage the certificates held in the configured certificate
+// store, and then exits. Output is written to out.
+func runCerts(args []string, out io.Writer) error {
+	if len(args) == 0 {
+		return errors.New(certsUsage)
+	}
+
+	if args[0] == "migrate" {
+		return migrateCerts(args[1:], out)
+	}
+
+	store, err := managedCertificateStore()
+	if err != nil {
+		return err
+	}
+	if closer, ok := store.(io.Closer); ok {
+		defer closer.Close()
+	}
+
+	switch args[0] {
+	case "list":
+		return listCerts(store, out)
+	case "show":
+		if len(args) != 2 {
+			return errors.New(certsUsage)
+		}
+		return showCerts(store, args[1], out)
+	case "renew":
+		if len(args) != 2 {
+			return errors.New(certsUsage)
+		}
+		return renewCerts(store, args[1], out)
+	case "delete":
+		if len(args) != 2 {
+			return errors.New(certsUsage)
+		}
+		return deleteCerts(store, args[1], out)
+	case "export":
+		if len(args) > 2 {
+			return errors.New(certsUsage)
+		}
+		return exportCerts(store, args[1:], out)
+	case "import":
+		if len(args) != 2 {
+			return errors.New(certsUsage)
+		}
+		return importCerts(store, args[1], out)
+	default:
+		return fmt.Errorf("unknown certs command: %s\n%s", args[0], certsUsage)
+	}
+}
+
+// managedCertificateStore opens the configured certificate store, including encryption if it's enabled.
+func managedCertificateStore() (certificate.ManagedStore, error) {
+	return openManagedStore(*certificateStoreType)
+}
+
+// openManagedStore opens the certificate store of the given type, including encryption if it's enabled.
+func openManagedStore(name string) (certificate.ManagedStore, error) {
+	store, err := createCertificateStore(name)
+	if err != nil {
+		return nil, fmt.Errorf("certificate store error: %v", err)
+	}
+
+	certStore, err := encryptCertificateStore(store)
+	if err != nil {
+		return nil, fmt.Errorf("certificate encryption error: %v", err)
+	}
+
+	managed, ok := certStore.(certificate.ManagedStore)
+	if !ok {
+		return nil, fmt.Errorf("certificate store %s can't be managed", name)
+	}
+	return managed, nil
+}
+
+// listCerts prints a summary of every certificate in the store.
+func listCerts(store certificate.ManagedStore, out io.Writer) error {
+	certs, err := sortedCertificates(store)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "SUBJECT\tALT NAMES\tPROVIDER\tKEY TYPE\tEXPIRES\tRENEWAL (ARI)\tSTAPLE")
+	for _, cert := range certs {
+		_, _ = fmt.Fprintf(
+			w,
+			"%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			cert.Subject,
+			orNone(strings.Join(cert.AltNames, ",")),
+			orNone(cert.Provider),
+			keyTypeName(cert),
+			formatTime(cert.NotAfter),
+			formatTime(cert.AriRenewalTime),
+			stapleStatus(cert),
+		)
+	}
+	return w.Flush()
+}
+
+// showCerts prints everything known about each certificate in the store that covers the given name.
+func showCerts(store certificate.ManagedStore, name string, out io.Writer) error {
+	certs, err := matchingCertificates(store, name)
+	if err != nil {
+		return err
+	}
+
+	for i, cert := range certs {
+		if i > 0 {
+			_, _ = fmt.Fprintln(out)
+		}
+
+		w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+		_, _ = fmt.Fprintf(w, "Subject:\t%s\n", cert.Subject)
+		_, _ = fmt.Fprintf(w, "Alt names:\t%s\n", orNone(strings.Join(cert.AltNames, ", ")))
+		_, _ = fmt.Fprintf(w, "Provider:\t%s\n", orNone(cert.Provider))
+		_, _ = fmt.Fprintf(w, "Key type:\t%s\n", keyTypeName(cert))
+		if leaf := parseLeaf(cert); leaf != nil {
+			_, _ = fmt.Fprintf(w, "Issuer:\t%s\n", leaf.Issuer.String())
+			_, _ = fmt.Fprintf(w, "Serial:\t%x\n", leaf.SerialNumber)
+			_, _ = fmt.Fprintf(w, "Not before:\t%s\n", formatTime(leaf.NotBefore))
+		}
+		_, _ = fmt.Fprintf(w, "Expires:\t%s\n", formatTime(cert.NotAfter))
+		_, _ = fmt.Fprintf(w, "ARI renewal:\t%s\n", formatTime(cert.AriRenewalTime))
+		_, _ = fmt.Fprintf(w, "ARI next update:\t%s\n", formatTime(cert.AriNextUpdate))
+		if cert.AriExplanation != "" {
+			_, _ = fmt.Fprintf(w, "ARI explanation:\t%s\n", cert.AriExplanation)
+		}
+		_, _ = fmt.Fprintf(w, "Staple:\t%s\n", stapleStatus(cert))
+		if err := w.Flush(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// renewCerts forces each certificate in the store that covers the given name to be renewed immediately, using the
+// configured certificate providers. The new certificates are saved to the same store.
+func renewCerts(store certificate.ManagedStore, name string, out io.Writer) error {
+	certs, err := matchingCertificates(store, name)
+	if err != nil {
+		return err
+	}
+
+	// Mark the certificates as due for renewal, so the provider will replace them rather than returning them.
+	// The ARI information is pushed into the future so it isn't refreshed (and the renewal time reset) first.
+	for _, cert := range certs {
+		store.LockCertificate(cert.Subject, cert.AltNames)
+		cert.AriRenewalTime = time.Now().Add(-time.Minute)
+		cert.AriNextUpdate = time.Now().Add(time.Hour)
+		err := store.SaveCertificate(cert)
+		store.UnlockCertificate(cert.Subject, cert.AltNames)
+		if err != nil {
+			return fmt.Errorf("unable to mark certificate for renewal: %w", err)
+		}
+	}
+
+	provider, challenges, err := certProviderForStore(store)
+	if err != nil {
+		return fmt.Errorf("error creating certificate providers: %v", err)
+	}
+	defer challenges.stop()
+
+	for _, cert := range certs {
+		if _, err := provider.GetCertificate(context.Background(), cert.Provider, keyTypeName(cert), cert.Subject, cert.AltNames); err != nil {
+			return fmt.Errorf("unable to renew certificate for %s: %w", cert.Subject, err)
+		}
+		_, _ = fmt.Fprintf(out, "Renewed certificate for %s (%s)\n", cert.Subject, keyTypeName(cert))
+	}
+	return nil
+}
+
+// deleteCerts removes each certificate in the store that covers the given name.
+func deleteCerts(store certificate.ManagedStore, name string, out io.Writer) error {
+	certs, err := matchingCertificates(store, name)
+	if err != nil {
+		return err
+	}
+
+	for _, cert := range certs {
+		store.LockCertificate(cert.Subject, cert.AltNames)
+		err := store.DeleteCertificate(cert)
+		store.UnlockCertificate(cert.Subject, cert.AltNames)
+		if err != nil {
+			return fmt.Errorf("unable to delete certificate for %s: %w", cert.Subject, err)
+		}
+		_, _ = fmt.Fprintf(out, "Deleted certificate for %s (%s, %s)\n", cert.Subject, orNone(cert.Provider), keyTypeName(cert))
+	}
+	return nil
+}
+
+// exportCerts writes every certificate in the store, including private keys, to the named file (or out, if no
+// file is given) in the same format as the json certificate store.
+func exportCerts(store certificate.ManagedStore, args []string, out io.Writer) error {
+	certs, err := sortedCertificates(store)
+	if err != nil {
+		return err
+	}
+
+	b, err := json.MarshalIndent(certs, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if len(args) == 0 {
+		_, err = fmt.Fprintln(out, string(b))
+		return err
+	}
+
+	if err := os.WriteFile(args[0], b, 0600); err != nil {
+		return err
+	}
+	_, _ = fmt.Fprintf(out, "Exported %d certificates to %s\n", len(certs), args[0])
+	return nil
+}
+
+// importCerts saves every certificate in the named file, which should be in the same format as the json
+// certificate store, replacing any existing certificates with the same names. Expired certificates are skipped.
+func importCerts(store certificate.ManagedStore, path string, out io.Writer) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var certs []*certificate.Details
+	if err := json.Unmarshal(b, &certs); err != nil {
+		return fmt.Errorf("unable to parse certificates: %w", err)
+	}
+
+	imported := 0
+	for _, cert := range certs {
+		if !cert.ValidFor(0) {
+			_, _ = fmt.Fprintf(out, "Skipping expired certificate for %s\n", cert.Subject)
+			continue
+		}
+
+		store.LockCertificate(cert.Subject, cert.AltNames)
+		err := store.SaveCertificate(cert)
+		store.UnlockCertificate(cert.Subject, cert.AltNames)
+		if err != nil {
+			return fmt.Errorf("unable to import certificate for %s: %w", cert.Subject, err)
+		}
+		imported++
+	}
+
+	_, _ = fmt.Fprintf(out, "Imported %d certificates from %s\n", imported, path)
+	return nil
+}
+
+// migrateCerts copies every certificate from one type of certificate store to another. Each store is configured
+// using its usual options (e.g. CERTIFICATE_STORE for the json store, and REDIS_ADDRESS for the redis store).
+func migrateCerts(args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	flags.SetOutput(out)
+	dryRun := flags.Bool("dry-run", false, "Show what would be migrated without changing the destination store")
+	onConflict := flags.String("on-conflict", "skip", "What to do if the destination already has a different certificate: skip, replace or newer")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if flags.NArg() != 2 {
+		return errors.New(certsUsage)
+	}
+
+	policy, err := certificate.ParseConflictPolicy(*onConflict)
+	if err != nil {
+		return err
+	}
+
+	fromType, toType := strings.ToLower(flags.Arg(0)), strings.ToLower(flags.Arg(1))
+	if fromType == toType {
+		return errors.New("certificates can't be migrated to the same type of store")
+	}
+
+	from, err := openManagedStore(fromType)
+	if err != nil {
+		return err
+	}
+	if closer, ok := from.(io.Closer); ok {
+		defer closer.Close()
+	}
+
+	to, err := openManagedStore(toType)
+	if err != nil {
+		return err
+	}
+	if closer, ok := to.(io.Closer); ok {
+		defer closer.Close()
+	}
+
+	results, err := certificate.MigrateCertificates(from, to, policy, *dryRun)
+
+	counts := make(map[certificate.MigrationAction]int)
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "SUBJECT\tALT NAMES\tPROVIDER\tKEY TYPE\tEXPIRES\tRESULT")
+	for _, result := range results {
+		counts[result.Action]++
+		_, _ = fmt.Fprintf(
+			w,
+			"%s\t%s\t%s\t%s\t%s\t%s\n",
+			result.Certificate.Subject,
+			orNone(strings.Join(result.Certificate.AltNames, ",")),
+			orNone(result.Certificate.Provider),
+			keyTypeName(result.Certificate),
+			formatTime(result.Certificate.NotAfter),
+			result.Action,
+		)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	prefix := "Migrated"
+	if *dryRun {
+		prefix = "Dry run: would migrate"
+	}
+	_, _ = fmt.Fprintf(
+		out,
+		"%s %d certificates from %s to %s (%d copied, %d replaced, %d unchanged, %d conflicts skipped, %d expired)\n",
+		prefix,
+		counts[certificate.MigrationCopied]+counts[certificate.MigrationReplaced],
+		fromType,
+		toType,
+		counts[certificate.MigrationCopied],
+		counts[certificate.MigrationReplaced],
+		counts[certificate.MigrationUnchanged],
+		counts[certificate.MigrationConflict],
+		counts[certificate.MigrationExpired],
+	)
+	return nil
+}
+
+// sortedCertificates returns all certificates in the store, ordered by subject, provider and key type.
+func sortedCertificates(store certificate.ManagedStore) ([]*certificate.Details, error) {
+	certs, err := store.Certificates()
+	if err != nil {
+		return nil, err
+	}
+
+	slices.SortFunc(certs, func(a, b *certificate.Details) int {
+		if c := strings.Compare(a.Subject, b.Subject); c != 0 {
+			return c
+		}
+		if c := strings.Compare(a.Provider, b.Provider); c != 0 {
+			return c
+		}
+		return strings.Compare(keyTypeName(a), keyTypeName(b))
+	})
+	return certs, nil
+}
+
+// matchingCertificates returns all certificates in the store whose subject or alt names include the given name.
+// An error is returned if there are none.
+func matchingCertificates(store certificate.ManagedStore, name string) ([]*certificate.Details, error) {
+	certs, err := sortedCertificates(store)
+	if err != nil {
+		return nil, err
+	}
+
+	var res []*certificate.Details
+	for _, cert := range certs {
+		if strings.EqualFold(cert.Subject, name) || slices.ContainsFunc(cert.AltNames, func(alt string) bool {
+			return strings.EqualFold(alt, name)
+		}) {
+			res = append(res, cert)
+		}
+	}
+
+	if len(res) == 0 {
+		return nil, fmt.Errorf("no certificates found for %s", name)
+	}
+	return res, nil
+}
+
+// parseLeaf parses the first certificate in the chain, returning nil if it's not valid.
+func parseLeaf(cert *certificate.Details) *x509.Certificate {
+	block, _ := pem.Decode([]byte(cert.Certificate))
+	if block == nil {
+		return nil
+	}
+
+	leaf, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil
+	}
+	return leaf
+}
+
+// stapleStatus describes whether the certificate requires an OCSP staple, and if so when it expires.
+func stapleStatus(cert *certificate.Details) string {
+	if parseLeaf(cert) == nil || !cert.RequiresStaple() {
+		return "not required"
+	}
+	if len(cert.OcspResponse) == 0 {
+		return "missing"
+	}
+	if !cert.HasStapleFor(0) {
+		return "expired " + formatTime(cert.NextOcspUpdate)
+	}
+	return "valid until " + formatTime(cert.NextOcspUpdate)
+}
+
+// keyTypeName returns the key type of the certificate. Certificates saved before key types were tracked are ECDSA.
+func keyTypeName(cert *certificate.Details) string {
+	if cert.KeyType == "" {
+		return certificate.KeyTypeECDSA
+	}
+	return cert.KeyType
+}
+
+// formatTime formats the time for display, or returns "-" if it's not set.
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+// orNone returns the string, or "-" if it's empty.
+func orNone(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
@@ -3,14 +3,17 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"crypto/tls"
 	"crypto/x509"
 	"encoding/json"
 	"encoding/pem"
+	"flag"
 	"log/slog"
 	"net/http"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 	"time"
@@ -165,3 +168,30 @@ func Test_Run_ObtainsCertificatesUsingAcmeWithRedisStore(t *testing.T) {
 
 	assert.Fail(t, "timeout exceeded")
 }
+
+func Test_Certs_Migrate_BetweenJsonAndRedis(t *testing.T) {
+	server := miniredis.RunT(t)
+	path := createTestCertificateStore(t, []string{"example.com", "www.example.com"})
+	restored := filepath.Join(t.TempDir(), "restored.json")
+
+	resetFlags()
+	require.NoError(t, flag.Set("certificate-store", path))
+	require.NoError(t, flag.Set("redis-address", server.Addr()))
+	out := &bytes.Buffer{}
+	require.NoError(t, runCerts([]string{"migrate", "json", "redis"}, out))
+	assert.Contains(t, out.String(), "Migrated 1 certificates from json to redis")
+
+	require.NoError(t, flag.Set("certificate-store", restored))
+	out.Reset()
+	require.NoError(t, runCerts([]string{"migrate", "redis", "json"}, out))
+	assert.Contains(t, out.String(), "Migrated 1 certificates from redis to json")
+
+	original, err := certificate.NewStore(path)
+	require.NoError(t, err)
+	store, err := certificate.NewStore(restored)
+	require.NoError(t, err)
+	assert.Equal(t,
+		original.GetCertificate("selfsigned", certificate.KeyTypeECDSA, "example.com", []string{"www.example.com"}),
+		store.GetCertificate("selfsigned", certificate.KeyTypeECDSA, "example.com", []string{"www.example.com"}),
+	)
+}
@@ -0,0 +1,223 @@
+//go:build integration
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/csmith/centauri/certificate"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func createTestCertificateStore(t *testing.T, names ...[]string) string {
+	path := filepath.Join(t.TempDir(), "certs.json")
+	store, err := certificate.NewStore(path)
+	require.NoError(t, err)
+
+	for i := range names {
+		cert, err := certificate.NewSelfSignedSupplier().GetCertificate(context.Background(), certificate.KeyTypeECDSA, names[i][0], names[i][1:], false)
+		require.NoError(t, err)
+		cert.Provider = "selfsigned"
+		require.NoError(t, store.SaveCertificate(cert))
+	}
+
+	return path
+}
+
+func runCertsTest(t *testing.T, path string, args ...string) (string, error) {
+	resetFlags()
+	require.NoError(t, flag.Set("certificate-store", path))
+	require.NoError(t, flag.Set("certificate-providers", "selfsigned"))
+
+	out := &bytes.Buffer{}
+	err := runCerts(args, out)
+	return out.String(), err
+}
+
+func Test_Run_Certs_ErrorsForUnknownCommand(t *testing.T) {
+	err := runTestWithArgs(nil, []string{"certs", "frobnicate"}, "CERTIFICATE_STORE", filepath.Join(t.TempDir(), "certs.json"))
+	assert.ErrorContains(t, err, "unknown certs command: frobnicate")
+}
+
+func Test_Run_Certs_ErrorsWithoutCommand(t *testing.T) {
+	err := runTestWithArgs(nil, []string{"certs"}, "CERTIFICATE_STORE", filepath.Join(t.TempDir(), "certs.json"))
+	assert.ErrorContains(t, err, "usage: centauri certs")
+}
+
+func Test_Certs_List(t *testing.T) {
+	path := createTestCertificateStore(t, []string{"example.com", "www.example.com"}, []string{"example.org"})
+
+	out, err := runCertsTest(t, path, "list")
+	require.NoError(t, err)
+	assert.Regexp(t, `SUBJECT\s+ALT NAMES\s+PROVIDER\s+KEY TYPE\s+EXPIRES\s+RENEWAL \(ARI\)\s+STAPLE`, out)
+	assert.Regexp(t, `example.com\s+www.example.com\s+selfsigned\s+ecdsa\s+\S+\s+-\s+not required`, out)
+	assert.Regexp(t, `example.org\s+-\s+selfsigned\s+ecdsa`, out)
+}
+
+func Test_Certs_Show(t *testing.T) {
+	path := createTestCertificateStore(t, []string{"example.com", "www.example.com"}, []string{"example.org"})
+
+	out, err := runCertsTest(t, path, "show", "WWW.example.com")
+	require.NoError(t, err)
+	assert.Regexp(t, `Subject:\s+example.com\n`, out)
+	assert.Regexp(t, `Alt names:\s+www.example.com\n`, out)
+	assert.Regexp(t, `Provider:\s+selfsigned\n`, out)
+	assert.Regexp(t, `Serial:\s+[0-9a-f]+\n`, out)
+	assert.Regexp(t, `Staple:\s+not required\n`, out)
+	assert.NotContains(t, out, "example.org")
+}
+
+func Test_Certs_Show_ErrorsIfNoMatch(t *testing.T) {
+	path := createTestCertificateStore(t, []string{"example.com"})
+
+	_, err := runCertsTest(t, path, "show", "example.net")
+	assert.ErrorContains(t, err, "no certificates found for example.net")
+}
+
+func Test_Certs_Delete(t *testing.T) {
+	path := createTestCertificateStore(t, []string{"example.com"}, []string{"example.org"})
+
+	out, err := runCertsTest(t, path, "delete", "example.com")
+	require.NoError(t, err)
+	assert.Contains(t, out, "Deleted certificate for example.com")
+
+	store, err := certificate.NewStore(path)
+	require.NoError(t, err)
+	assert.Nil(t, store.GetCertificate("selfsigned", certificate.KeyTypeECDSA, "example.com", nil))
+	assert.NotNil(t, store.GetCertificate("selfsigned", certificate.KeyTypeECDSA, "example.org", nil))
+}
+
+func Test_Certs_Renew(t *testing.T) {
+	path := createTestCertificateStore(t, []string{"example.com"})
+
+	store, err := certificate.NewStore(path)
+	require.NoError(t, err)
+	original := store.GetCertificate("selfsigned", certificate.KeyTypeECDSA, "example.com", nil)
+
+	out, err := runCertsTest(t, path, "renew", "example.com")
+	require.NoError(t, err)
+	assert.Contains(t, out, "Renewed certificate for example.com")
+
+	store, err = certificate.NewStore(path)
+	require.NoError(t, err)
+	renewed := store.GetCertificate("selfsigned", certificate.KeyTypeECDSA, "example.com", nil)
+	require.NotNil(t, renewed)
+	assert.NotEqual(t, original.Certificate, renewed.Certificate)
+}
+
+func Test_Certs_Renew_WithEncryptedStore(t *testing.T) {
+	path := createTestCertificateStore(t, []string{"example.com"})
+
+	store, err := certificate.NewStore(path)
+	require.NoError(t, err)
+	original := store.GetCertificate("selfsigned", certificate.KeyTypeECDSA, "example.com", nil)
+
+	resetFlags()
+	require.NoError(t, flag.Set("certificate-store", path))
+	require.NoError(t, flag.Set("certificate-providers", "selfsigned"))
+	require.NoError(t, flag.Set("certificate-encryption-keys", "AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA="))
+
+	out := &bytes.Buffer{}
+	require.NoError(t, runCerts([]string{"renew", "example.com"}, out))
+	assert.Contains(t, out.String(), "Renewed certificate for example.com")
+
+	b, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Contains(t, string(b), "centauri-encrypted:v1:")
+	assert.NotContains(t, string(b), "PRIVATE KEY")
+
+	store, err = certificate.NewStore(path)
+	require.NoError(t, err)
+	renewed := store.GetCertificate("selfsigned", certificate.KeyTypeECDSA, "example.com", nil)
+	require.NotNil(t, renewed)
+	assert.NotEqual(t, original.Certificate, renewed.Certificate)
+}
+
+func Test_Certs_ExportAndImport(t *testing.T) {
+	path := createTestCertificateStore(t, []string{"example.com", "www.example.com"})
+	exported := filepath.Join(t.TempDir(), "export.json")
+
+	_, err := runCertsTest(t, path, "export", exported)
+	require.NoError(t, err)
+
+	b, err := os.ReadFile(exported)
+	require.NoError(t, err)
+	var certs []*certificate.Details
+	require.NoError(t, json.Unmarshal(b, &certs))
+	require.Len(t, certs, 1)
+	assert.Contains(t, certs[0].PrivateKey, "PRIVATE KEY")
+
+	target := filepath.Join(t.TempDir(), "certs.json")
+	out, err := runCertsTest(t, target, "import", exported)
+	require.NoError(t, err)
+	assert.Contains(t, out, "Imported 1 certificates")
+
+	store, err := certificate.NewStore(target)
+	require.NoError(t, err)
+	imported := store.GetCertificate("selfsigned", certificate.KeyTypeECDSA, "example.com", []string{"www.example.com"})
+	require.NotNil(t, imported)
+	assert.Equal(t, certs[0].Certificate, imported.Certificate)
+	assert.True(t, imported.NotAfter.After(time.Now()))
+}
+
+func Test_Certs_Migrate_ErrorsForSameStoreType(t *testing.T) {
+	path := createTestCertificateStore(t, []string{"example.com"})
+
+	_, err := runCertsTest(t, path, "migrate", "json", "JSON")
+	assert.ErrorContains(t, err, "same type of store")
+}
+
+func Test_Certs_Migrate_ErrorsForInvalidConflictPolicy(t *testing.T) {
+	path := createTestCertificateStore(t, []string{"example.com"})
+
+	_, err := runCertsTest(t, path, "migrate", "-on-conflict", "overwrite", "json", "dir")
+	assert.ErrorContains(t, err, "invalid conflict policy")
+}
+
+func Test_Certs_Migrate_DryRun(t *testing.T) {
+	path := createTestCertificateStore(t, []string{"example.com", "www.example.com"})
+	dir := filepath.Join(t.TempDir(), "certs")
+
+	resetFlags()
+	require.NoError(t, flag.Set("certificate-store", path))
+	require.NoError(t, flag.Set("certificate-store-dir", dir))
+	out := &bytes.Buffer{}
+	require.NoError(t, runCerts([]string{"migrate", "-dry-run", "json", "dir"}, out))
+
+	assert.Regexp(t, `example.com\s+www.example.com\s+selfsigned\s+ecdsa\s+\S+\s+copied`, out.String())
+	assert.Contains(t, out.String(), "Dry run: would migrate 1 certificates from json to dir (1 copied, 0 replaced")
+	assert.NoFileExists(t, filepath.Join(dir, "example.com"))
+}
+
+func Test_Certs_Migrate_JsonToDir(t *testing.T) {
+	path := createTestCertificateStore(t, []string{"example.com", "www.example.com"}, []string{"example.org"})
+	dir := filepath.Join(t.TempDir(), "certs")
+
+	resetFlags()
+	require.NoError(t, flag.Set("certificate-store", path))
+	require.NoError(t, flag.Set("certificate-store-dir", dir))
+	out := &bytes.Buffer{}
+	require.NoError(t, runCerts([]string{"migrate", "json", "dir"}, out))
+	assert.Contains(t, out.String(), "Migrated 2 certificates from json to dir (2 copied")
+
+	out.Reset()
+	require.NoError(t, runCerts([]string{"migrate", "json", "dir"}, out))
+	assert.Contains(t, out.String(), "Migrated 0 certificates from json to dir (0 copied, 0 replaced, 2 unchanged")
+
+	source, err := certificate.NewStore(path)
+	require.NoError(t, err)
+	target, err := certificate.NewDirStore(dir)
+	require.NoError(t, err)
+	assert.Equal(t,
+		source.GetCertificate("selfsigned", certificate.KeyTypeECDSA, "example.com", []string{"www.example.com"}).Certificate,
+		target.GetCertificate("selfsigned", certificate.KeyTypeECDSA, "example.com", []string{"www.example.com"}).Certificate,
+	)
+}
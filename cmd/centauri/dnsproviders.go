@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/csmith/legotapas/v2"
+	"github.com/go-acme/lego/v5/challenge"
+)
+
+// parseDnsProviders parses a space-separated list of `name=type` pairs, as accepted by the dns-providers
+// option, into a map of names to provider types.
+func parseDnsProviders(input string) (map[string]string, error) {
+	return parseNamedOptions(input, "DNS provider", "type")
+}
+
+// createNamedDnsProviders creates each of the DNS providers configured in the dns-providers option. Providers
+// read their credentials from environment variables prefixed with their name, so that multiple providers of
+// the same type can be used with different credentials.
+func createNamedDnsProviders(input string) (map[string]challenge.Provider, error) {
+	types, err := parseDnsProviders(input)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make(map[string]challenge.Provider)
+	for name, providerType := range types {
+		var provider challenge.Provider
+		err := withEnvironmentPrefix(namedEnvPrefix(name), func() error {
+			var err error
+			provider, err = legotapas.CreateProvider(providerType)
+			return err
+		})
+		if err != nil {
+			return nil, fmt.Errorf("unable to create DNS provider %s: %w", name, err)
+		}
+		res[name] = provider
+	}
+	return res, nil
+}
+
+// withEnvironmentPrefix calls fn with every environment variable that starts with the given prefix also set
+// without the prefix, overriding any existing value. The environment is restored afterwards.
+func withEnvironmentPrefix(prefix string, fn func() error) error {
+	type previousValue struct {
+		value string
+		set   bool
+	}
+	previous := make(map[string]previousValue)
+
+	defer func() {
+		for key, old := range previous {
+			if old.set {
+				_ = os.Setenv(key, old.value)
+			} else {
+				_ = os.Unsetenv(key)
+			}
+		}
+	}()
+
+	for _, entry := range os.Environ() {
+		key, value, _ := strings.Cut(entry, "=")
+		if !strings.HasPrefix(key, prefix) || len(key) == len(prefix) {
+			continue
+		}
+
+		unprefixed := strings.TrimPrefix(key, prefix)
+		if _, ok := previous[unprefixed]; !ok {
+			old, set := os.LookupEnv(unprefixed)
+			previous[unprefixed] = previousValue{value: old, set: set}
+		}
+
+		if err := os.Setenv(unprefixed, value); err != nil {
+			return err
+		}
+	}
+
+	return fn()
+}
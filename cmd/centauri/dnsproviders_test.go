@@ -0,0 +1,89 @@
+//go:build integration
+
+package main
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_ParseDnsProviders_ReturnsEmptyMapForEmptyInput(t *testing.T) {
+	providers, err := parseDnsProviders("")
+	require.NoError(t, err)
+	assert.Empty(t, providers)
+}
+
+func Test_ParseDnsProviders_ParsesNamesAndTypes(t *testing.T) {
+	providers, err := parseDnsProviders(" cloudflare-main=cloudflare  route53-legacy=route53 ")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"cloudflare-main": "cloudflare",
+		"route53-legacy":  "route53",
+	}, providers)
+}
+
+func Test_ParseDnsProviders_ErrorsForInvalidInput(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+	}{
+		{"missing type", "cloudflare-main"},
+		{"empty type", "cloudflare-main="},
+		{"empty name", "=cloudflare"},
+		{"uppercase name", "Cloudflare=cloudflare"},
+		{"underscore in name", "cloudflare_main=cloudflare"},
+		{"trailing dash", "cloudflare-=cloudflare"},
+		{"lego name", "lego=cloudflare"},
+		{"selfsigned name", "selfsigned=cloudflare"},
+		{"internalca name", "internalca=cloudflare"},
+		{"duplicate name", "main=cloudflare main=route53"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseDnsProviders(tt.input)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func Test_WithEnvironmentPrefix_SetsUnprefixedVariablesDuringCall(t *testing.T) {
+	t.Setenv("CLOUDFLARE_MAIN_CF_DNS_API_TOKEN", "main-token")
+	t.Setenv("CF_DNS_API_TOKEN", "default-token")
+	t.Setenv("CF_ZONE_API_TOKEN", "zone-token")
+	t.Setenv("CF_API_EMAIL", "")
+	require.NoError(t, os.Unsetenv("CF_API_EMAIL"))
+	t.Setenv("CLOUDFLARE_MAIN_CF_API_EMAIL", "dade@example.com")
+
+	err := withEnvironmentPrefix("CLOUDFLARE_MAIN_", func() error {
+		assert.Equal(t, "main-token", os.Getenv("CF_DNS_API_TOKEN"))
+		assert.Equal(t, "zone-token", os.Getenv("CF_ZONE_API_TOKEN"))
+		assert.Equal(t, "dade@example.com", os.Getenv("CF_API_EMAIL"))
+		return nil
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, "default-token", os.Getenv("CF_DNS_API_TOKEN"))
+	_, set := os.LookupEnv("CF_API_EMAIL")
+	assert.False(t, set)
+}
+
+func Test_WithEnvironmentPrefix_RestoresEnvironmentOnError(t *testing.T) {
+	t.Setenv("ROUTE53_LEGACY_AWS_REGION", "eu-west-2")
+	t.Setenv("AWS_REGION", "us-east-1")
+
+	err := withEnvironmentPrefix("ROUTE53_LEGACY_", func() error {
+		return errors.New("oops")
+	})
+	assert.Error(t, err)
+	assert.Equal(t, "us-east-1", os.Getenv("AWS_REGION"))
+}
+
+func Test_CreateNamedDnsProviders_ErrorsForInvalidInput(t *testing.T) {
+	_, err := createNamedDnsProviders("lego=cloudflare")
+	assert.Error(t, err)
+}
@@ -2,14 +2,17 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"log/slog"
 	"net"
 	"net/http"
 	"os"
 	"os/signal"
 	"runtime/pprof"
+	"slices"
 	"strings"
 	"syscall"
 	"time"
@@ -27,9 +30,13 @@ import (
 	"github.com/go-acme/lego/v5/log"
 )
 
-// certCheckInterval is how often the proxy manager re-checks the certificates for its routes.
+// certCheckInterval is the longest the proxy manager will go without re-checking all the certificates for its
+// routes. Certificates are normally renewed at the time their provider says they need it.
 const certCheckInterval = 12 * time.Hour
 
+// certFileCheckInterval is how often certificates loaded from files are reloaded to pick up any changes.
+const certFileCheckInterval = time.Minute
+
 var (
 	selectedFrontend     = flag.String("frontend", "tcp", "Frontend to listen on")
 	selectedConfigSource = flag.String("config-source", "file", "Config source to use")
@@ -37,15 +44,35 @@ var (
 	metricsPort          = flag.Int("metrics-port", 0, "Port to expose metrics endpoint on. Disabled by default.")
 	debugCpuProfile      = flag.String("debug-cpu-profile", "", "File to write cpu profiling information to. Disabled by default.")
 	validate             = flag.Bool("validate", false, "Validate config file and exit")
+	drainDelay           = flag.Duration("drain-delay", 0, "Time to report as not ready before stopping the frontend on shutdown")
+	drainTimeout         = flag.Duration("drain-timeout", 5*time.Second, "Maximum time to wait for open connections to finish on shutdown")
+	tlsProfile           = flag.String("tls-profile", "intermediate", "Default TLS profile for routes: modern, intermediate or old")
 
 	configPath           = flag.String("config", "centauri.conf", "Path to config")
 	configNetworkAddr    = flag.String("config-network-address", "", "Address to connect to for network config source")
 	userDataPath         = flag.String("user-data", "user.pem", "Path to user data")
 	certificateStoreType = flag.String("certificate-store-type", "json", "Type of certificate store to use")
 	certificateStorePath = flag.String("certificate-store", "certs.json", "Path to certificate store, when using the json certificate store")
+	certificateStoreDir  = flag.String("certificate-store-dir", "certs", "Directory to keep certificates in, when using the dir certificate store")
+	certificateStoreDB   = flag.String("certificate-store-sqlite", "certs.db", "Path to the database, when using the sqlite certificate store")
+	encryptionKeys       = flag.String("certificate-encryption-keys", "", "Space separated list of base64-encoded keys to encrypt stored private keys with. The first is used for new keys. Disabled by default.")
+	encryptionKeysFile   = flag.String("certificate-encryption-keys-file", "", "Path to a file containing keys to encrypt stored private keys with, instead of certificate-encryption-keys")
 	certificateProv      = flag.String("certificate-providers", "lego selfsigned", "Space separated list of certificate providers to use by default in order of preference")
+	certificateFallback  = flag.String("certificate-fallback-providers", "", "Space separated list of certificate providers to try, in order, if a certificate can't be obtained from the usual provider")
+	internalCADir        = flag.String("internal-ca-dir", "", "Directory to store the internal CA in, when using the internalca certificate provider. Disabled by default.")
 	wildcardDomains      = flag.String("wildcard-domains", "", "Space separated list of wildcard domains")
+	keyTypes             = flag.String("key-types", "ecdsa", "Space separated list of certificate key types to obtain for routes by default, in order of preference: ecdsa, rsa")
+	certificateWorkers   = flag.Int("certificate-workers", 4, "Maximum number of certificates to obtain or renew at the same time")
 	useStaples           = flag.Bool("ocsp-stapling", false, "Enable OCSP response stapling")
+	temporaryCertProv    = flag.String("temporary-certificate-provider", "", "Certificate provider to obtain temporary certificates from while waiting for a route's certificate. Disabled by default.")
+	temporaryCertPage    = flag.String("temporary-certificate-page", "", "Path to a HTML page to serve for routes using a temporary certificate")
+
+	onDemandDomains       = flag.String("on-demand-tls-domains", "", "Space separated list of domains that certificates may be obtained for on demand, along with their subdomains")
+	onDemandAsk           = flag.String("on-demand-tls-ask", "", "URL to ask whether a certificate may be obtained on demand for a domain")
+	onDemandTimeout       = flag.Duration("on-demand-tls-timeout", 3*time.Second, "Maximum time a TLS handshake will wait for an on-demand certificate")
+	onDemandInterval      = flag.Duration("on-demand-tls-interval", time.Minute, "Minimum duration between new on-demand certificates, once the burst is used up")
+	onDemandBurst         = flag.Int("on-demand-tls-burst", 5, "Number of on-demand certificates that may be obtained in quick succession")
+	onDemandNegativeCache = flag.Duration("on-demand-tls-negative-cache", 10*time.Minute, "Time to wait before retrying a domain that was refused or failed")
 
 	httpPort  = flag.Int("http-port", 8080, "Port to listen on for plain HTTP requests for the TCP frontend")
 	httpsPort = flag.Int("https-port", 8443, "Port to listen on for HTTPS requests for the TCP frontend")
@@ -63,11 +90,18 @@ var (
 	redisUseTLS    = flag.Bool("redis-tls", false, "Use TLS when connecting to the Redis server")
 
 	dnsProviderName         = flag.String("dns-provider", "", "DNS provider to use for ACME DNS-01 challenges")
+	dnsProviders            = flag.String("dns-providers", "", "Space separated list of additional named DNS providers, in the form name=type")
+	acmeHttpChallenge       = flag.Bool("acme-http-challenge", false, "Solve ACME HTTP-01 challenges by serving them on the plain HTTP port")
+	acmeTlsAlpnChallenge    = flag.Bool("acme-tls-alpn-challenge", false, "Solve ACME TLS-ALPN-01 challenges by serving them on the HTTPS port")
+	acmeChallengeAlias      = flag.String("acme-challenge-alias", "", "Zone to write DNS-01 challenge records to, instead of the zone of the domain being validated")
+	acmeDnsServer           = flag.String("acme-dns-server", "", "Address to run a DNS server on to answer ACME DNS-01 challenges, instead of using a DNS provider")
 	acmeEmail               = flag.String("acme-email", "", "Email address for ACME account")
 	acmeExternalAccountKid  = flag.String("acme-external-kid", "", "Key ID for ACME external account binding")
 	acmeExternalAccountHmac = flag.String("acme-external-hmac", "", "Base64-url-encoded HMAC for ACME external account binding")
+	acmeIssuers             = flag.String("acme-issuers", "", "Space separated list of additional named ACME directories, in the form name=url")
 	acmeDirectory           = flag.String("acme-directory", lego.DirectoryURLLetsEncrypt, "ACME directory to use")
 	acmeProfile             = flag.String("acme-profile", "", "Profile to use when requesting a certificate")
+	acmeIPProfile           = flag.String("acme-ip-profile", "", "Profile to use when requesting a certificate for an IP address. Defaults to the value of acme-profile.")
 	acmeDisablePropagation  = flag.Bool("acme-disable-propagation-check", false, "Prevents the ACME client from checking that DNS propagation was successful")
 	acmePropagationDelay    = flag.Duration("acme-propagation-delay", 10*time.Second, "Length of time to wait for propagation if ACME_DISABLE_PROPAGATION_CHECK is enabled")
 	acmeResolvers           = flag.String("acme-resolvers", "", "Comma separated list of nameservers to use for DNS checks. Each should be specified as a host:port pair")
@@ -90,6 +124,10 @@ func run(args []string, signalChan <-chan os.Signal) error {
 	envflag.Parse(envflag.WithArguments(args))
 	initLogging()
 
+	if flag.Arg(0) == "certs" {
+		return runCerts(flag.Args()[1:], os.Stdout)
+	}
+
 	if *debugCpuProfile != "" {
 		slog.Warn("Running with CPU profiling. This will heavily impact performance.", "target", *debugCpuProfile)
 		cpuFile, err := os.Create(*debugCpuProfile)
@@ -121,12 +159,18 @@ func run(args []string, signalChan <-chan os.Signal) error {
 	}
 
 	var provider proxy.CertificateProvider
+	var challenges acmeChallenges
 	if f.UsesCertificates() {
+		var store certificate.Store
 		var err error
-		provider, err = certProvider()
+		provider, store, challenges, err = certProvider()
 		if err != nil {
 			return fmt.Errorf("error creating certificate providers: %v", err)
 		}
+		if closer, ok := store.(io.Closer); ok {
+			defer closer.Close()
+		}
+		defer challenges.stop()
 	}
 
 	downstreams, err := proxy.ParseCIDRList(*trustedDownstreams)
@@ -134,7 +178,42 @@ func run(args []string, signalChan <-chan os.Signal) error {
 		return fmt.Errorf("could not parse trusted downstreams: %w", err)
 	}
 
+	defaultTLSProfile, err := proxy.ParseTLSProfile(*tlsProfile)
+	if err != nil {
+		return err
+	}
+
+	defaultKeyTypes, err := parseKeyTypes(*keyTypes)
+	if err != nil {
+		return err
+	}
+
 	proxyManager := proxy.NewManager(provider)
+	if f.UsesCertificates() {
+		proxyManager.SetDefaultKeyTypes(defaultKeyTypes)
+		proxyManager.SetCertificateWorkers(*certificateWorkers)
+
+		onDemand, err := onDemandConfig()
+		if err != nil {
+			return fmt.Errorf("invalid on-demand TLS configuration: %w", err)
+		}
+
+		if onDemand != nil {
+			proxyManager.EnableOnDemand(*onDemand)
+		}
+
+		if *temporaryCertProv != "" {
+			proxyManager.EnableTemporaryCertificates(*temporaryCertProv)
+		}
+	}
+
+	var pendingPage []byte
+	if *temporaryCertPage != "" {
+		pendingPage, err = os.ReadFile(*temporaryCertPage)
+		if err != nil {
+			return fmt.Errorf("could not read temporary certificate page: %w", err)
+		}
+	}
 	rewriter := proxy.NewRewriter(proxyManager, downstreams)
 
 	if err := configSource.Start(context.Background(), proxyManager.SetRoutes, errChan); err != nil {
@@ -142,16 +221,23 @@ func run(args []string, signalChan <-chan os.Signal) error {
 	}
 
 	if f.UsesCertificates() {
-		go proxyManager.MonitorCertificates(context.Background(), certCheckInterval)
+		go proxyManager.ScheduleCertificates(context.Background(), certCheckInterval)
+		go proxyManager.MonitorCertificateFiles(context.Background(), certFileCheckInterval)
 	}
 
 	recorder := metrics.NewRecorder(proxyManager.RouteForDomain)
+	recorder.TrackCertificates(proxyManager.Routes)
 
 	if err := f.Serve(&frontend.Context{
-		Manager:  proxyManager,
-		Rewriter: rewriter,
-		Recorder: recorder,
-		ErrChan:  errChan,
+		Manager:                proxyManager,
+		Rewriter:               rewriter,
+		Recorder:               recorder,
+		ErrChan:                errChan,
+		DrainTimeout:           *drainTimeout,
+		TLSProfile:             defaultTLSProfile,
+		HttpChallenges:         challenges.http,
+		TlsAlpnChallenges:      challenges.tlsAlpn,
+		PendingCertificatePage: pendingPage,
 	}); err != nil {
 		return fmt.Errorf("failed to start frontend: %v", err)
 	}
@@ -169,10 +255,20 @@ func run(args []string, signalChan <-chan os.Signal) error {
 				slog.Info("Received signal, reloading config...", "signal", sig)
 				configSource.Reload()
 			case syscall.SIGINT, syscall.SIGTERM:
-				slog.Info("Received signal, stopping frontend...", "signal", sig)
-				metricsChan <- struct{}{}
+				recorder.SetDraining()
+				if *drainDelay > 0 {
+					slog.Info("Received signal, reporting as not ready before stopping...", "signal", sig, "delay", *drainDelay)
+					select {
+					case <-time.After(*drainDelay):
+					case sig := <-signalChan:
+						slog.Info("Received another signal, not waiting any longer", "signal", sig)
+					}
+				}
+
+				slog.Info("Stopping frontend...", "signal", sig, "timeout", *drainTimeout)
 				configSource.Stop(context.Background())
 				f.Stop(context.Background())
+				metricsChan <- struct{}{}
 				slog.Info("Frontend stopped. Goodbye!")
 				return nil
 			}
@@ -227,6 +323,10 @@ func createCertificateStore(name string) (certificate.Store, error) {
 	switch strings.ToLower(name) {
 	case "json":
 		return certificate.NewStore(*certificateStorePath)
+	case "dir":
+		return certificate.NewDirStore(*certificateStoreDir)
+	case "sqlite":
+		return certificate.NewSqliteStoreFromPath(*certificateStoreDB)
 	case "redis":
 		return certificate.NewRedisStoreFromOptions(certificate.RedisOptions{
 			Addr:      *redisAddress,
@@ -241,51 +341,234 @@ func createCertificateStore(name string) (certificate.Store, error) {
 	}
 }
 
+// encryptCertificateStore wraps the store so that private keys are encrypted before they're saved, if any
+// encryption keys are configured. Otherwise the store is returned as-is.
+func encryptCertificateStore(store certificate.Store) (certificate.Store, error) {
+	keys := *encryptionKeys
+	if *encryptionKeysFile != "" {
+		if keys != "" {
+			return nil, errors.New("encryption keys can't be specified both directly and in a file")
+		}
+
+		b, err := os.ReadFile(*encryptionKeysFile)
+		if err != nil {
+			return nil, err
+		}
+		keys = string(b)
+	}
+
+	if keys == "" {
+		return store, nil
+	}
+
+	if _, ok := store.(*certificate.DirStore); ok {
+		return nil, errors.New("the dir certificate store writes private keys for other services to use, so can't encrypt them")
+	}
+
+	parsed, err := certificate.ParseEncryptionKeys(keys)
+	if err != nil {
+		return nil, err
+	}
+	return certificate.NewEncryptedStore(store, parsed)
+}
+
+// acmeChallenges contains the providers the frontend should use to answer ACME challenges. Fields are nil if
+// the corresponding challenge type is disabled.
+type acmeChallenges struct {
+	http    proxy.HttpChallengeProvider
+	tlsAlpn frontend.TlsAlpnChallengeProvider
+	dns     *certificate.DnsChallengeServer
+}
+
+// stop stops any servers that were started to answer challenges.
+func (a acmeChallenges) stop() {
+	if a.dns != nil {
+		a.dns.Stop(context.Background())
+	}
+}
+
+// onDemandConfig builds the configuration for obtaining certificates on demand. If no policies are configured,
+// on-demand certificates are disabled and nil is returned.
+func onDemandConfig() (*proxy.OnDemandConfig, error) {
+	var policies []proxy.OnDemandPolicy
+
+	if domains := strings.Fields(*onDemandDomains); len(domains) > 0 {
+		policies = append(policies, proxy.NewSuffixPolicy(domains))
+	}
+
+	if *onDemandAsk != "" {
+		policy, err := proxy.NewAskPolicy(*onDemandAsk)
+		if err != nil {
+			return nil, err
+		}
+		policies = append(policies, policy)
+	}
+
+	if len(policies) == 0 {
+		return nil, nil
+	}
+
+	return &proxy.OnDemandConfig{
+		Policies:              policies,
+		Timeout:               *onDemandTimeout,
+		Interval:              *onDemandInterval,
+		Burst:                 *onDemandBurst,
+		NegativeCacheDuration: *onDemandNegativeCache,
+	}, nil
+}
+
+// parseKeyTypes parses the space-separated list of key types given in the key-types option.
+func parseKeyTypes(input string) ([]proxy.KeyType, error) {
+	var res []proxy.KeyType
+	for _, name := range strings.Fields(input) {
+		keyType, err := proxy.ParseKeyType(name)
+		if err != nil {
+			return nil, err
+		}
+		if slices.Contains(res, keyType) {
+			return nil, fmt.Errorf("duplicate key type: %s", keyType)
+		}
+		res = append(res, keyType)
+	}
+
+	if len(res) == 0 {
+		return nil, fmt.Errorf("at least one key type must be specified")
+	}
+	return res, nil
+}
+
 // certProvider assembles the certificate provider from the configured store and suppliers. If the lego
-// supplier cannot be created - for example because no DNS provider is configured - a warning is logged
-// and only the selfsigned supplier is used.
-func certProvider() (proxy.CertificateProvider, error) {
+// supplier cannot be created - for example because no challenge types are configured - a warning is logged
+// and only the selfsigned supplier is used. Any HTTP-01 or TLS-ALPN-01 challenges are answered using the
+// returned challenge providers. The certificate store is also returned, so that it can be closed when the
+// provider is no longer needed.
+func certProvider() (proxy.CertificateProvider, certificate.Store, acmeChallenges, error) {
 	store, err := createCertificateStore(*certificateStoreType)
 	if err != nil {
-		return nil, fmt.Errorf("certificate store error: %v", err)
-	}
-
-	var legoConfig *certificate.LegoSupplierConfig
-	if *dnsProviderName == "" {
-		slog.Warn("Unable to create lego certificate supplier: no DNS provider specified")
-	} else if dnsProvider, err := legotapas.CreateProvider(*dnsProviderName); err != nil {
-		slog.Warn("Unable to create lego certificate supplier", "error", err)
-	} else {
-		legoConfig = &certificate.LegoSupplierConfig{
-			Path:                    *userDataPath,
-			Email:                   *acmeEmail,
-			DirUrl:                  *acmeDirectory,
-			KeyType:                 certcrypto.EC384,
-			DnsProvider:             dnsProvider,
-			DisablePropagationCheck: *acmeDisablePropagation,
-			PropagationDelay:        *acmePropagationDelay,
-			Profile:                 *acmeProfile,
-			ExternalAccountKid:      *acmeExternalAccountKid,
-			ExternalAccountHmac:     *acmeExternalAccountHmac,
-			OverallRequestLimit:     *acmeOverallLimit,
-			ObtainInterval:          *acmeObtainInterval,
-			Resolvers:               certificate.ParseResolvers(*acmeResolvers),
-			Timeout:                 *acmeOverallTimeout,
+		return nil, nil, acmeChallenges{}, fmt.Errorf("certificate store error: %v", err)
+	}
+
+	certStore, err := encryptCertificateStore(store)
+	if err != nil {
+		return nil, nil, acmeChallenges{}, fmt.Errorf("certificate encryption error: %v", err)
+	}
+
+	provider, challenges, err := certProviderForStore(certStore)
+	if err != nil {
+		return nil, nil, challenges, err
+	}
+	return provider, certStore, challenges, nil
+}
+
+// certProviderForStore assembles the certificate provider from the configured suppliers, keeping certificates in
+// the given store. The caller remains responsible for closing the store.
+func certProviderForStore(certStore certificate.Store) (proxy.CertificateProvider, acmeChallenges, error) {
+	var challenges acmeChallenges
+
+	legoConfig := &certificate.LegoSupplierConfig{
+		Path:                    *userDataPath,
+		Email:                   *acmeEmail,
+		DirUrl:                  *acmeDirectory,
+		KeyType:                 certcrypto.EC384,
+		RSAKeyType:              certcrypto.RSA2048,
+		ChallengeAlias:          *acmeChallengeAlias,
+		DisablePropagationCheck: *acmeDisablePropagation,
+		PropagationDelay:        *acmePropagationDelay,
+		Profile:                 *acmeProfile,
+		IPProfile:               *acmeIPProfile,
+		ExternalAccountKid:      *acmeExternalAccountKid,
+		ExternalAccountHmac:     *acmeExternalAccountHmac,
+		OverallRequestLimit:     *acmeOverallLimit,
+		ObtainInterval:          *acmeObtainInterval,
+		Resolvers:               certificate.ParseResolvers(*acmeResolvers),
+		Timeout:                 *acmeOverallTimeout,
+	}
+
+	if (*dnsProviderName != "" || *dnsProviders != "") && *acmeDnsServer != "" {
+		return nil, challenges, errors.New("a DNS provider and a DNS challenge server can't both be used")
+	}
+
+	if *acmeDnsServer != "" {
+		dnsServer := certificate.NewDnsChallengeServer(challengeStore(certStore))
+		if err := dnsServer.Start(*acmeDnsServer); err != nil {
+			return nil, challenges, err
+		}
+		challenges.dns = dnsServer
+		legoConfig.DnsProvider = dnsServer
+		// Records are served by us as soon as they're presented, so there's nothing to wait for.
+		legoConfig.DisablePropagationCheck = true
+		legoConfig.PropagationDelay = 0
+	} else if *dnsProviderName != "" {
+		if dnsProvider, err := legotapas.CreateProvider(*dnsProviderName); err != nil {
+			slog.Warn("Unable to create DNS provider", "error", err)
+		} else {
+			legoConfig.DnsProvider = dnsProvider
+		}
+	}
+
+	if *acmeHttpChallenge {
+		httpProvider := certificate.NewHttpChallengeProvider(challengeStore(certStore))
+		legoConfig.HttpProvider = httpProvider
+		challenges.http = httpProvider
+	}
+
+	if *acmeTlsAlpnChallenge {
+		tlsAlpnProvider := certificate.NewTlsAlpnChallengeProvider(challengeStore(certStore))
+		legoConfig.TlsAlpnProvider = tlsAlpnProvider
+		challenges.tlsAlpn = tlsAlpnProvider
+	}
+
+	namedDnsProviders, err := createNamedDnsProviders(*dnsProviders)
+	if err != nil {
+		return nil, challenges, err
+	}
+
+	namedAcmeIssuers, err := createAcmeIssuers(*acmeIssuers, *userDataPath)
+	if err != nil {
+		return nil, challenges, err
+	}
+
+	for name := range namedAcmeIssuers {
+		if _, ok := namedDnsProviders[name]; ok {
+			return nil, challenges, fmt.Errorf("ACME issuer %q has the same name as a DNS provider", name)
 		}
 	}
 
+	if legoConfig.DnsProvider == nil && legoConfig.HttpProvider == nil && legoConfig.TlsAlpnProvider == nil && len(namedDnsProviders) == 0 {
+		slog.Warn("Unable to create lego certificate supplier: no DNS provider specified and HTTP and TLS-ALPN challenges disabled")
+		legoConfig = nil
+	}
+
 	return certificate.NewProvider(context.Background(), certificate.ProviderConfig{
-		Store:              store,
+		Store:              certStore,
 		Lego:               legoConfig,
+		DnsProviders:       namedDnsProviders,
+		AcmeIssuers:        namedAcmeIssuers,
+		InternalCADir:      *internalCADir,
 		PreferredSuppliers: strings.Split(*certificateProv, " "),
+		FallbackSuppliers:  strings.Fields(*certificateFallback),
 		WildcardDomains:    strings.Split(*wildcardDomains, " "),
 		UseStaples:         *useStaples,
-	}), nil
+	}), challenges, nil
+}
+
+// challengeStore returns the store to keep ACME challenges in. Certificate stores that can be shared between
+// instances are used so that any instance can answer a challenge; otherwise challenges are kept in memory.
+// Stores wrapped to encrypt private keys are unwrapped, as challenges aren't encrypted.
+func challengeStore(store certificate.Store) certificate.ChallengeStore {
+	if wrapped, ok := store.(interface{ Unwrap() certificate.Store }); ok {
+		store = wrapped.Unwrap()
+	}
+	if challenges, ok := store.(certificate.ChallengeStore); ok {
+		return challenges
+	}
+	return certificate.NewMemoryChallengeStore()
 }
 
 func serveMetrics(recorder *metrics.Recorder, shutdownChan <-chan struct{}, errChan chan<- error) {
 	mux := http.NewServeMux()
 	mux.Handle("/metrics", recorder.Handler())
+	mux.Handle("/ready", recorder.ReadinessHandler())
 	s := frontend.NewServer(mux, errChan)
 
 	go func() {
@@ -0,0 +1,68 @@
+//go:build integration && !nosqlite
+
+package main
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/csmith/centauri/certificate"
+	"github.com/csmith/centauri/cmd/centauri/testdata"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Run_UsesSqliteCertificateStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "certs.db")
+
+	upstream := startStaticServer(8701)
+	defer upstream.Stop(context.Background())
+
+	signalChan := make(chan os.Signal, 1)
+	doneChan := make(chan struct{}, 1)
+
+	go func() {
+		err := runTest(
+			signalChan,
+			"CONFIG", testdata.Path("simple-proxy.conf"),
+			"PROVIDER", "selfsigned",
+			"CERTIFICATE_STORE_TYPE", "sqlite",
+			"CERTIFICATE_STORE_SQLITE", path,
+			"FRONTEND", "tcp",
+			"HTTP_PORT", "8702",
+			"HTTPS_PORT", "8703",
+		)
+		assert.NoError(t, err)
+		doneChan <- struct{}{}
+	}()
+
+	start := time.Now()
+	for time.Since(start) < 30*time.Second {
+		time.Sleep(500 * time.Millisecond)
+
+		res, err := proxyGet(8703, "https://example.com/test")
+		if err != nil {
+			continue
+		}
+
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+
+		signalChan <- os.Interrupt
+		<-doneChan
+
+		store, err := certificate.NewSqliteStore(path)
+		require.NoError(t, err)
+		defer store.Close()
+
+		history, err := store.History("example.com", nil)
+		require.NoError(t, err)
+		assert.Len(t, history, 1, "certificate should be stored in the database")
+		return
+	}
+
+	assert.Fail(t, "timeout exceeded")
+}
@@ -5,6 +5,7 @@ package main
 import (
 	"context"
 	"crypto/tls"
+	"crypto/x509"
 	"encoding/binary"
 	"flag"
 	"fmt"
@@ -14,6 +15,8 @@ import (
 	"net/http"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"slices"
 	"strings"
 	"syscall"
 	"testing"
@@ -54,6 +57,112 @@ func Test_Run_ErrorsIfCertificateStoreTypeUndefined(t *testing.T) {
 	assert.ErrorContains(t, err, "unknown certificate store: invalid")
 }
 
+func Test_Run_UsesDirCertificateStore(t *testing.T) {
+	dir := t.TempDir()
+
+	upstream := startStaticServer(8701)
+	defer upstream.Stop(context.Background())
+
+	signalChan := make(chan os.Signal, 1)
+	doneChan := make(chan struct{}, 1)
+
+	go func() {
+		err := runTest(
+			signalChan,
+			"CONFIG", testdata.Path("simple-proxy.conf"),
+			"PROVIDER", "selfsigned",
+			"CERTIFICATE_STORE_TYPE", "dir",
+			"CERTIFICATE_STORE_DIR", dir,
+			"FRONTEND", "tcp",
+			"HTTP_PORT", "8702",
+			"HTTPS_PORT", "8703",
+		)
+		assert.NoError(t, err)
+		doneChan <- struct{}{}
+	}()
+
+	start := time.Now()
+	for time.Since(start) < 30*time.Second {
+		time.Sleep(500 * time.Millisecond)
+
+		res, err := proxyGet(8703, "https://example.com/test")
+		if err != nil {
+			continue
+		}
+
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+		_, err = tls.LoadX509KeyPair(dir+"/example.com/fullchain.pem", dir+"/example.com/key.pem")
+		assert.NoError(t, err, "certificate should be written to the directory")
+
+		signalChan <- os.Interrupt
+		<-doneChan
+		return
+	}
+
+	assert.Fail(t, "timeout exceeded")
+}
+
+func Test_Run_ErrorsIfCertificateEncryptionKeysInvalid(t *testing.T) {
+	err := runTest(
+		make(chan os.Signal, 1),
+		"CERTIFICATE_STORE", filepath.Join(t.TempDir(), "certs.json"),
+		"CERTIFICATE_ENCRYPTION_KEYS", "c2hvcnQ=",
+	)
+
+	assert.ErrorContains(t, err, "certificate encryption error")
+	assert.ErrorContains(t, err, "must be 32 bytes")
+}
+
+func Test_Run_EncryptsStoredPrivateKeys(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "certs.json")
+	keysFile := filepath.Join(t.TempDir(), "keys")
+	require.NoError(t, os.WriteFile(keysFile, []byte("AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA=\n"), 0600))
+
+	upstream := startStaticServer(8701)
+	defer upstream.Stop(context.Background())
+
+	signalChan := make(chan os.Signal, 1)
+	doneChan := make(chan struct{}, 1)
+
+	go func() {
+		err := runTest(
+			signalChan,
+			"CONFIG", testdata.Path("simple-proxy.conf"),
+			"PROVIDER", "selfsigned",
+			"CERTIFICATE_STORE", path,
+			"CERTIFICATE_ENCRYPTION_KEYS_FILE", keysFile,
+			"FRONTEND", "tcp",
+			"HTTP_PORT", "8702",
+			"HTTPS_PORT", "8703",
+		)
+		assert.NoError(t, err)
+		doneChan <- struct{}{}
+	}()
+
+	start := time.Now()
+	for time.Since(start) < 30*time.Second {
+		time.Sleep(500 * time.Millisecond)
+
+		res, err := proxyGet(8703, "https://example.com/test")
+		if err != nil {
+			continue
+		}
+
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+
+		b, err := os.ReadFile(path)
+		require.NoError(t, err)
+		assert.Contains(t, string(b), "centauri-encrypted:v1:")
+		assert.NotContains(t, string(b), "PRIVATE KEY")
+
+		signalChan <- os.Interrupt
+		<-doneChan
+		return
+	}
+
+	assert.Fail(t, "timeout exceeded")
+}
+
 func Test_Run_ErrorsIfNetworkConfigSourceWithoutAddress(t *testing.T) {
 	err := runTest(
 		make(chan os.Signal, 1),
@@ -651,6 +760,273 @@ func Test_Run_ObtainsCertificatesUsingAcme(t *testing.T) {
 	assert.Fail(t, "timeout exceeded")
 }
 
+func Test_Run_ObtainsCertificatesUsingAcmeHttpChallenge(t *testing.T) {
+	upstream := startStaticServer(8701)
+	defer upstream.Stop(context.Background())
+
+	stopPebble := startPebble("pebble-config.json")
+	defer stopPebble()
+
+	signalChan := make(chan os.Signal, 1)
+	doneChan := make(chan struct{}, 1)
+
+	userPem, err := os.CreateTemp("", "centauri-integration-test-user-*.pem")
+	assert.NoError(t, err)
+	userPem.Close()
+	os.Remove(userPem.Name())
+	defer os.Remove(userPem.Name())
+
+	certsJson, err := os.CreateTemp("", "centauri-integration-test-certs-*.json")
+	assert.NoError(t, err)
+	certsJson.Close()
+	os.Remove(certsJson.Name())
+	defer os.Remove(certsJson.Name())
+
+	go func() {
+		err := runTest(
+			signalChan,
+			"CONFIG", testdata.Path("simple-proxy.conf"),
+			"PROVIDER", "lego",
+			"ACME_HTTP_CHALLENGE", "true",
+			"ACME_EMAIL", "test@example.com",
+			"ACME_DIRECTORY", "https://localhost:14000/dir",
+			"USER_DATA", userPem.Name(),
+			"CERTIFICATE_STORE", certsJson.Name(),
+			"LEGO_CA_CERTIFICATES", testdata.Path("pebble.minica.pem"),
+			"FRONTEND", "tcp",
+			// Pebble validates HTTP-01 challenges on port 5002
+			"HTTP_PORT", "5002",
+			"HTTPS_PORT", "8703",
+		)
+		assert.NoError(t, err)
+		doneChan <- struct{}{}
+	}()
+
+	start := time.Now()
+	for time.Since(start) < time.Minute {
+		time.Sleep(2 * time.Second)
+
+		res, err := proxyGet(8703, "https://example.com/test")
+		if err != nil && strings.Contains(err.Error(), "tls: unrecognized name") {
+			slog.Warn("Centauri isn't serving a cert yet, waiting...")
+			continue
+		}
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+		assert.True(t, strings.Contains(res.TLS.PeerCertificates[0].Issuer.CommonName, "Pebble Intermediate CA"))
+
+		signalChan <- os.Interrupt
+		<-doneChan
+		return
+	}
+
+	assert.Fail(t, "timeout exceeded")
+}
+
+func Test_Run_ObtainsCertificatesUsingAcmeTlsAlpnChallenge(t *testing.T) {
+	upstream := startStaticServer(8701)
+	defer upstream.Stop(context.Background())
+
+	stopPebble := startPebble("pebble-config.json")
+	defer stopPebble()
+
+	signalChan := make(chan os.Signal, 1)
+	doneChan := make(chan struct{}, 1)
+
+	userPem, err := os.CreateTemp("", "centauri-integration-test-user-*.pem")
+	assert.NoError(t, err)
+	userPem.Close()
+	os.Remove(userPem.Name())
+	defer os.Remove(userPem.Name())
+
+	certsJson, err := os.CreateTemp("", "centauri-integration-test-certs-*.json")
+	assert.NoError(t, err)
+	certsJson.Close()
+	os.Remove(certsJson.Name())
+	defer os.Remove(certsJson.Name())
+
+	go func() {
+		err := runTest(
+			signalChan,
+			"CONFIG", testdata.Path("simple-proxy.conf"),
+			"PROVIDER", "lego",
+			"ACME_TLS_ALPN_CHALLENGE", "true",
+			"ACME_EMAIL", "test@example.com",
+			"ACME_DIRECTORY", "https://localhost:14000/dir",
+			"USER_DATA", userPem.Name(),
+			"CERTIFICATE_STORE", certsJson.Name(),
+			"LEGO_CA_CERTIFICATES", testdata.Path("pebble.minica.pem"),
+			"FRONTEND", "tcp",
+			"HTTP_PORT", "8702",
+			// Pebble validates TLS-ALPN-01 challenges on port 5001
+			"HTTPS_PORT", "5001",
+		)
+		assert.NoError(t, err)
+		doneChan <- struct{}{}
+	}()
+
+	start := time.Now()
+	for time.Since(start) < time.Minute {
+		time.Sleep(2 * time.Second)
+
+		res, err := proxyGet(5001, "https://example.com/test")
+		if err != nil && strings.Contains(err.Error(), "tls: unrecognized name") {
+			slog.Warn("Centauri isn't serving a cert yet, waiting...")
+			continue
+		}
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+		assert.True(t, strings.Contains(res.TLS.PeerCertificates[0].Issuer.CommonName, "Pebble Intermediate CA"))
+
+		signalChan <- os.Interrupt
+		<-doneChan
+		return
+	}
+
+	assert.Fail(t, "timeout exceeded")
+}
+
+func Test_Run_ObtainsCertificatesOnDemand(t *testing.T) {
+	upstream := startStaticServer(8701)
+	defer upstream.Stop(context.Background())
+
+	stopPebble := startPebble("pebble-config.json")
+	defer stopPebble()
+
+	signalChan := make(chan os.Signal, 1)
+	doneChan := make(chan struct{}, 1)
+
+	userPem, err := os.CreateTemp("", "centauri-integration-test-user-*.pem")
+	assert.NoError(t, err)
+	userPem.Close()
+	os.Remove(userPem.Name())
+	defer os.Remove(userPem.Name())
+
+	certsJson, err := os.CreateTemp("", "centauri-integration-test-certs-*.json")
+	assert.NoError(t, err)
+	certsJson.Close()
+	os.Remove(certsJson.Name())
+	defer os.Remove(certsJson.Name())
+
+	go func() {
+		err := runTest(
+			signalChan,
+			"CONFIG", testdata.Path("simple-proxy.conf"),
+			"PROVIDER", "lego",
+			"ACME_TLS_ALPN_CHALLENGE", "true",
+			"ACME_EMAIL", "test@example.com",
+			"ACME_DIRECTORY", "https://localhost:14000/dir",
+			"USER_DATA", userPem.Name(),
+			"CERTIFICATE_STORE", certsJson.Name(),
+			"LEGO_CA_CERTIFICATES", testdata.Path("pebble.minica.pem"),
+			"ON_DEMAND_TLS_DOMAINS", "example.net",
+			"ON_DEMAND_TLS_TIMEOUT", "2s",
+			"FRONTEND", "tcp",
+			"HTTP_PORT", "8702",
+			// Pebble validates TLS-ALPN-01 challenges on port 5001
+			"HTTPS_PORT", "5001",
+		)
+		assert.NoError(t, err)
+		doneChan <- struct{}{}
+	}()
+
+	start := time.Now()
+	for time.Since(start) < time.Minute {
+		time.Sleep(2 * time.Second)
+
+		if _, err := proxyGet(5001, "https://example.com/test"); err != nil {
+			slog.Warn("Centauri isn't serving the fallback route yet, waiting...", "error", err)
+			continue
+		}
+
+		res, err := proxyGet(5001, "https://shop.example.net/test")
+		assert.NoError(t, err)
+		if !slices.Contains(res.TLS.PeerCertificates[0].DNSNames, "shop.example.net") {
+			slog.Warn("Centauri is serving the fallback cert, waiting...")
+			continue
+		}
+
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+		assert.True(t, strings.Contains(res.TLS.PeerCertificates[0].Issuer.CommonName, "Pebble Intermediate CA"))
+
+		res, err = proxyGet(5001, "https://shop.example.org/test")
+		assert.NoError(t, err)
+		assert.NotContains(t, res.TLS.PeerCertificates[0].DNSNames, "shop.example.org")
+
+		signalChan <- os.Interrupt
+		<-doneChan
+		return
+	}
+
+	assert.Fail(t, "timeout exceeded")
+}
+
+func Test_Run_ObtainsCertificatesUsingAcmeDnsServer(t *testing.T) {
+	upstream := startStaticServer(8701)
+	defer upstream.Stop(context.Background())
+
+	stopPebble := startPebbleWithDnsServer("pebble-config.json", "127.0.0.1:8054")
+	defer stopPebble()
+
+	signalChan := make(chan os.Signal, 1)
+	doneChan := make(chan struct{}, 1)
+
+	userPem, err := os.CreateTemp("", "centauri-integration-test-user-*.pem")
+	assert.NoError(t, err)
+	userPem.Close()
+	os.Remove(userPem.Name())
+	defer os.Remove(userPem.Name())
+
+	certsJson, err := os.CreateTemp("", "centauri-integration-test-certs-*.json")
+	assert.NoError(t, err)
+	certsJson.Close()
+	os.Remove(certsJson.Name())
+	defer os.Remove(certsJson.Name())
+
+	go func() {
+		err := runTest(
+			signalChan,
+			"CONFIG", testdata.Path("simple-proxy.conf"),
+			"PROVIDER", "lego",
+			"ACME_DNS_SERVER", "127.0.0.1:8054",
+			"ACME_RESOLVERS", "127.0.0.1:8054",
+			"ACME_EMAIL", "test@example.com",
+			"ACME_DIRECTORY", "https://localhost:14000/dir",
+			"USER_DATA", userPem.Name(),
+			"CERTIFICATE_STORE", certsJson.Name(),
+			"LEGO_CA_CERTIFICATES", testdata.Path("pebble.minica.pem"),
+			"FRONTEND", "tcp",
+			"HTTP_PORT", "8702",
+			"HTTPS_PORT", "8703",
+		)
+		assert.NoError(t, err)
+		doneChan <- struct{}{}
+	}()
+
+	start := time.Now()
+	for time.Since(start) < time.Minute {
+		time.Sleep(2 * time.Second)
+
+		res, err := proxyGet(8703, "https://example.com/test")
+		if err != nil && strings.Contains(err.Error(), "tls: unrecognized name") {
+			slog.Warn("Centauri isn't serving a cert yet, waiting...")
+			continue
+		}
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+		assert.True(t, strings.Contains(res.TLS.PeerCertificates[0].Issuer.CommonName, "Pebble Intermediate CA"))
+
+		signalChan <- os.Interrupt
+		<-doneChan
+		return
+	}
+
+	assert.Fail(t, "timeout exceeded")
+}
+
 func Test_Run_ObtainsCertificatesUsingAcmeWithExternalAccountBinding(t *testing.T) {
 	upstream := startStaticServer(8701)
 	defer upstream.Stop(context.Background())
@@ -877,6 +1253,161 @@ func Test_Run_UsesSubjectForCertificateNames(t *testing.T) {
 	<-doneChan
 }
 
+func Test_Run_ServesTemporaryCertificateWhilePending(t *testing.T) {
+	upstream := startStaticServer(8701)
+	defer upstream.Stop(context.Background())
+
+	certsJson, err := os.CreateTemp("", "centauri-integration-test-certs-*.json")
+	assert.NoError(t, err)
+	certsJson.Close()
+	os.Remove(certsJson.Name())
+	defer os.Remove(certsJson.Name())
+
+	pendingPage, err := os.CreateTemp("", "centauri-integration-test-pending-*.html")
+	assert.NoError(t, err)
+	pendingPage.WriteString("Please wait")
+	pendingPage.Close()
+	defer os.Remove(pendingPage.Name())
+
+	signalChan := make(chan os.Signal, 1)
+	doneChan := make(chan struct{}, 1)
+
+	go func() {
+		err := runTest(
+			signalChan,
+			"CONFIG", testdata.Path("simple-proxy.conf"),
+			// Lego isn't configured, so a real certificate will never be obtained
+			"CERTIFICATE_PROVIDERS", "lego",
+			"CERTIFICATE_STORE", certsJson.Name(),
+			"TEMPORARY_CERTIFICATE_PROVIDER", "selfsigned",
+			"TEMPORARY_CERTIFICATE_PAGE", pendingPage.Name(),
+			"FRONTEND", "tcp",
+			"HTTP_PORT", "8702",
+			"HTTPS_PORT", "8703",
+		)
+		assert.NoError(t, err)
+		doneChan <- struct{}{}
+	}()
+
+	time.Sleep(2 * time.Second)
+
+	res, err := proxyGet(8703, "https://example.com/test")
+	require.NoError(t, err)
+	defer res.Body.Close()
+	assert.Equal(t, http.StatusServiceUnavailable, res.StatusCode)
+	assert.Equal(t, "example.com", res.TLS.PeerCertificates[0].Subject.CommonName)
+
+	body, err := io.ReadAll(res.Body)
+	assert.NoError(t, err)
+	assert.Equal(t, "Please wait", string(body))
+
+	signalChan <- os.Interrupt
+	<-doneChan
+}
+
+func Test_Run_ServesRSACertificatesToLegacyClients(t *testing.T) {
+	upstream := startStaticServer(8701)
+	defer upstream.Stop(context.Background())
+
+	certsJson, err := os.CreateTemp("", "centauri-integration-test-certs-*.json")
+	assert.NoError(t, err)
+	certsJson.Close()
+	os.Remove(certsJson.Name())
+	defer os.Remove(certsJson.Name())
+
+	signalChan := make(chan os.Signal, 1)
+	doneChan := make(chan struct{}, 1)
+
+	go func() {
+		err := runTest(
+			signalChan,
+			"CONFIG", testdata.Path("simple-proxy.conf"),
+			"CERTIFICATE_PROVIDERS", "selfsigned",
+			"CERTIFICATE_STORE", certsJson.Name(),
+			"KEY_TYPES", "ecdsa rsa",
+			"FRONTEND", "tcp",
+			"HTTP_PORT", "8702",
+			"HTTPS_PORT", "8703",
+		)
+		assert.NoError(t, err)
+		doneChan <- struct{}{}
+	}()
+
+	time.Sleep(2 * time.Second)
+
+	dial := func(config *tls.Config) x509.PublicKeyAlgorithm {
+		config.ServerName = "example.com"
+		config.InsecureSkipVerify = true
+		conn, err := tls.Dial("tcp", "127.0.0.1:8703", config)
+		require.NoError(t, err)
+		defer conn.Close()
+		return conn.ConnectionState().PeerCertificates[0].PublicKeyAlgorithm
+	}
+
+	assert.Equal(t, x509.ECDSA, dial(&tls.Config{}))
+	assert.Equal(t, x509.RSA, dial(&tls.Config{
+		MaxVersion:   tls.VersionTLS12,
+		CipherSuites: []uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256},
+	}))
+
+	signalChan <- os.Interrupt
+	<-doneChan
+}
+
+func Test_Run_ServesRoutesForIPAddresses(t *testing.T) {
+	upstream := startStaticServer(8701)
+	defer upstream.Stop(context.Background())
+
+	certsJson, err := os.CreateTemp("", "centauri-integration-test-certs-*.json")
+	assert.NoError(t, err)
+	certsJson.Close()
+	os.Remove(certsJson.Name())
+	defer os.Remove(certsJson.Name())
+
+	signalChan := make(chan os.Signal, 1)
+	doneChan := make(chan struct{}, 1)
+
+	go func() {
+		err := runTest(
+			signalChan,
+			"CONFIG", testdata.Path("ip-literal.conf"),
+			"CERTIFICATE_PROVIDERS", "selfsigned",
+			"CERTIFICATE_STORE", certsJson.Name(),
+			"FRONTEND", "tcp",
+			"HTTP_PORT", "8702",
+			"HTTPS_PORT", "8703",
+		)
+		assert.NoError(t, err)
+		doneChan <- struct{}{}
+	}()
+
+	time.Sleep(2 * time.Second)
+
+	// Clients connecting to an IP address don't send SNI, so the certificate is chosen by the local address
+	conn, err := tls.Dial("tcp", "127.0.0.1:8703", &tls.Config{InsecureSkipVerify: true})
+	require.NoError(t, err)
+	leaf := conn.ConnectionState().PeerCertificates[0]
+	conn.Close()
+	require.Len(t, leaf.IPAddresses, 1)
+	assert.Equal(t, "127.0.0.1", leaf.IPAddresses[0].String())
+
+	res, err := proxyGet(8703, "https://127.0.0.1/")
+	require.NoError(t, err)
+	body, _ := io.ReadAll(res.Body)
+	res.Body.Close()
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Contains(t, string(body), "This is the upstream on port 8701")
+
+	res, err = proxyGet(8702, "http://127.0.0.1/foo")
+	require.NoError(t, err)
+	res.Body.Close()
+	assert.Equal(t, http.StatusPermanentRedirect, res.StatusCode)
+	assert.Equal(t, "https://127.0.0.1/foo", res.Header.Get("Location"))
+
+	signalChan <- os.Interrupt
+	<-doneChan
+}
+
 func Test_Run_RedirectsHttpToHttps(t *testing.T) {
 	upstream := startStaticServer(8701)
 	defer upstream.Stop(context.Background())
@@ -925,6 +1456,21 @@ func Test_Run_RedirectsHttpToHttps(t *testing.T) {
 }
 
 func runTest(signalChan <-chan os.Signal, cfg ...string) error {
+	return runTestWithArgs(signalChan, []string{}, cfg...)
+}
+
+func runTestWithArgs(signalChan <-chan os.Signal, args []string, cfg ...string) error {
+	resetFlags()
+
+	for i := 0; i < len(cfg); i += 2 {
+		os.Setenv(cfg[i], cfg[i+1])
+		defer os.Unsetenv(cfg[i])
+	}
+
+	return run(args, signalChan)
+}
+
+func resetFlags() {
 	flag.CommandLine.VisitAll(func(f *flag.Flag) {
 		if !strings.HasPrefix(f.Name, "test") {
 			if err := f.Value.Set(f.DefValue); err != nil {
@@ -932,13 +1478,6 @@ func runTest(signalChan <-chan os.Signal, cfg ...string) error {
 			}
 		}
 	})
-
-	for i := 0; i < len(cfg); i += 2 {
-		os.Setenv(cfg[i], cfg[i+1])
-		defer os.Unsetenv(cfg[i])
-	}
-
-	return run([]string{}, signalChan)
 }
 
 func startStaticServer(port int) *frontend.Server {
@@ -1030,8 +1569,17 @@ func getClientProxy(realPort int) *http.Client {
 
 func startPebble(configName string) func() {
 	stopChallTestSrv := startChallTestSrv()
+	stopPebble := startPebbleWithDnsServer(configName, "localhost:8053")
 
-	cmd := exec.Command("go", "tool", "github.com/letsencrypt/pebble/v2/cmd/pebble", "-strict", "-config", configName, "-dnsserver", "localhost:8053")
+	return func() {
+		stopPebble()
+		stopChallTestSrv()
+	}
+}
+
+// startPebbleWithDnsServer starts pebble, using the given DNS server to resolve challenge records.
+func startPebbleWithDnsServer(configName string, dnsServer string) func() {
+	cmd := exec.Command("go", "tool", "github.com/letsencrypt/pebble/v2/cmd/pebble", "-strict", "-config", configName, "-dnsserver", dnsServer)
 	cmd.Dir = testdata.Path(".")
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
@@ -1064,7 +1612,6 @@ func startPebble(configName string) func() {
 		if cmd.Process != nil {
 			cmd.Process.Signal(syscall.SIGTERM)
 		}
-		stopChallTestSrv()
 	}
 }
 
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// namedOptionPattern restricts the names given to DNS providers and ACME issuers, so they can be used as
+// environment variable prefixes.
+var namedOptionPattern = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+
+// reservedOptionNames are the names of the built-in certificate providers. DNS providers and ACME issuers can be
+// selected as certificate providers by name, so they can't use these.
+var reservedOptionNames = []string{"lego", "selfsigned", "internalca"}
+
+// parseNamedOptions parses a space-separated list of `name=value` pairs into a map of names to values. The kind
+// and valueName describe the option in error messages, e.g. "DNS provider" and "type".
+func parseNamedOptions(input string, kind string, valueName string) (map[string]string, error) {
+	res := make(map[string]string)
+	for _, entry := range strings.Fields(input) {
+		name, value, ok := strings.Cut(entry, "=")
+		if !ok || value == "" {
+			return nil, fmt.Errorf("invalid %s %q: must be in the form name=%s", kind, entry, valueName)
+		}
+
+		if !namedOptionPattern.MatchString(name) {
+			return nil, fmt.Errorf("invalid %s name %q: must contain only lowercase letters, digits and dashes", kind, name)
+		}
+
+		if slices.Contains(reservedOptionNames, name) {
+			return nil, fmt.Errorf("invalid %s name %q: conflicts with a certificate provider", kind, name)
+		}
+
+		if _, ok := res[name]; ok {
+			return nil, fmt.Errorf("duplicate %s name %q", kind, name)
+		}
+
+		res[name] = value
+	}
+	return res, nil
+}
+
+// namedEnvPrefix returns the prefix for environment variables that configure the named DNS provider or ACME
+// issuer.
+func namedEnvPrefix(name string) string {
+	return strings.ToUpper(strings.ReplaceAll(name, "-", "_")) + "_"
+}
@@ -0,0 +1,25 @@
+//go:build integration
+
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_ParseNamedOptions_IncludesKindInErrors(t *testing.T) {
+	_, err := parseNamedOptions("lego=value", "widget", "value")
+	require.Error(t, err)
+	assert.Equal(t, `invalid widget name "lego": conflicts with a certificate provider`, err.Error())
+
+	_, err = parseNamedOptions("main", "widget", "colour")
+	require.Error(t, err)
+	assert.Equal(t, `invalid widget "main": must be in the form name=colour`, err.Error())
+}
+
+func Test_NamedEnvPrefix(t *testing.T) {
+	assert.Equal(t, "CLOUDFLARE_MAIN_", namedEnvPrefix("cloudflare-main"))
+	assert.Equal(t, "R53_", namedEnvPrefix("r53"))
+}
@@ -2,9 +2,13 @@ package config
 
 import (
 	"bufio"
+	"crypto/tls"
+	"crypto/x509"
 	"fmt"
 	"io"
 	"net/url"
+	"os"
+	"slices"
 	"strconv"
 	"strings"
 
@@ -26,8 +30,8 @@ func Parse(reader io.Reader) (routes []*proxy.Route, fallback *proxy.Route, err
 				return nil, nil, fmt.Errorf("no domains specified for route")
 			}
 			if route != nil {
-				if len(route.Upstreams) == 0 {
-					return nil, nil, fmt.Errorf("no upstreams specified for route %s", route.Domains)
+				if err := checkRoute(route); err != nil {
+					return nil, nil, err
 				}
 				routes = append(routes, route)
 			}
@@ -89,6 +93,66 @@ func Parse(reader io.Reader) (routes []*proxy.Route, fallback *proxy.Route, err
 				return nil, nil, fmt.Errorf("no domains specified for subject in route %s", route.Domains)
 			}
 			route.Subject = append(route.Subject, strings.Split(args, " ")...)
+		case "passthrough":
+			if route == nil {
+				return nil, nil, fmt.Errorf("passthrough without route: %s", line)
+			}
+			if route.Passthrough {
+				return nil, nil, fmt.Errorf("multiple passthrough options specified in route %s", route.Domains)
+			}
+			route.Passthrough = true
+		case "stream":
+			if route == nil {
+				return nil, nil, fmt.Errorf("stream without route: %s", line)
+			}
+			if route.Stream != nil {
+				return nil, nil, fmt.Errorf("multiple stream options specified in route %s", route.Domains)
+			}
+			if err := parseStream(args, route); err != nil {
+				return nil, nil, err
+			}
+		case "client-ca":
+			if route == nil {
+				return nil, nil, fmt.Errorf("client-ca without route: %s", line)
+			}
+			if route.ClientCAs != nil {
+				return nil, nil, fmt.Errorf("multiple client-ca options specified in route %s", route.Domains)
+			}
+			if err := parseClientCA(args, route); err != nil {
+				return nil, nil, err
+			}
+		case "certificate":
+			if route == nil {
+				return nil, nil, fmt.Errorf("certificate without route: %s", line)
+			}
+			if route.CertificateFile != "" {
+				return nil, nil, fmt.Errorf("multiple certificate options specified in route %s", route.Domains)
+			}
+			if err := parseCertificate(args, route); err != nil {
+				return nil, nil, err
+			}
+		case "key-type":
+			if route == nil {
+				return nil, nil, fmt.Errorf("key-type without route: %s", line)
+			}
+			if len(route.KeyTypes) > 0 {
+				return nil, nil, fmt.Errorf("multiple key-type options specified in route %s", route.Domains)
+			}
+			if err := parseKeyTypes(args, route); err != nil {
+				return nil, nil, err
+			}
+		case "tls-profile":
+			if route == nil {
+				return nil, nil, fmt.Errorf("tls-profile without route: %s", line)
+			}
+			if route.TLSProfile != "" {
+				return nil, nil, fmt.Errorf("multiple tls-profile options specified in route %s", route.Domains)
+			}
+			profile, err := proxy.ParseTLSProfile(args)
+			if err != nil {
+				return nil, nil, err
+			}
+			route.TLSProfile = profile
 		case "#":
 			// Ignore comments
 		default:
@@ -99,8 +163,8 @@ func Parse(reader io.Reader) (routes []*proxy.Route, fallback *proxy.Route, err
 	}
 
 	if route != nil {
-		if len(route.Upstreams) == 0 {
-			return nil, nil, fmt.Errorf("no upstreams specified for route %s", route.Domains)
+		if err := checkRoute(route); err != nil {
+			return nil, nil, err
 		}
 		routes = append(routes, route)
 	}
@@ -109,9 +173,201 @@ func Parse(reader io.Reader) (routes []*proxy.Route, fallback *proxy.Route, err
 		return nil, nil, err
 	}
 
+	if err := checkStreams(routes, fallback); err != nil {
+		return nil, nil, err
+	}
+
 	return
 }
 
+// checkRoute ensures that a fully-parsed route is complete and doesn't contain conflicting options.
+func checkRoute(route *proxy.Route) error {
+	if len(route.Upstreams) == 0 {
+		return fmt.Errorf("no upstreams specified for route %s", route.Domains)
+	}
+
+	if route.Passthrough {
+		// Passthrough routes never see the HTTP traffic or hold a certificate, so none of these can apply.
+		if len(route.Headers) > 0 || len(route.ErrorMappings) > 0 || route.RedirectToPrimary || route.Provider != "" || len(route.Subject) > 0 {
+			return fmt.Errorf("passthrough route %s cannot use header, on_error, redirect-to-primary, provider or subject", route.Domains)
+		}
+	}
+
+	if route.ClientCAs != nil {
+		if route.Passthrough {
+			return fmt.Errorf("passthrough route %s cannot use client-ca", route.Domains)
+		}
+		if route.Stream != nil && !route.Stream.TLS {
+			return fmt.Errorf("stream route %s must use tls to use client-ca", route.Domains)
+		}
+	}
+
+	if route.CertificateFile != "" {
+		if route.Passthrough {
+			return fmt.Errorf("passthrough route %s cannot use certificate", route.Domains)
+		}
+		if route.Stream != nil && !route.Stream.TLS {
+			return fmt.Errorf("stream route %s must use tls to use certificate", route.Domains)
+		}
+		if route.Provider != "" || len(route.Subject) > 0 {
+			return fmt.Errorf("route %s cannot use certificate with provider or subject", route.Domains)
+		}
+	}
+
+	if len(route.KeyTypes) > 0 {
+		if route.Passthrough {
+			return fmt.Errorf("passthrough route %s cannot use key-type", route.Domains)
+		}
+		if route.Stream != nil && !route.Stream.TLS {
+			return fmt.Errorf("stream route %s must use tls to use key-type", route.Domains)
+		}
+		if route.CertificateFile != "" {
+			return fmt.Errorf("route %s cannot use key-type with certificate", route.Domains)
+		}
+	}
+
+	if route.TLSProfile != "" {
+		if route.Passthrough {
+			return fmt.Errorf("passthrough route %s cannot use tls-profile", route.Domains)
+		}
+		if route.Stream != nil && !route.Stream.TLS {
+			return fmt.Errorf("stream route %s must use tls to use tls-profile", route.Domains)
+		}
+	}
+
+	if route.Stream != nil {
+		if route.Passthrough {
+			return fmt.Errorf("stream route %s cannot use passthrough", route.Domains)
+		}
+		if len(route.Headers) > 0 || len(route.ErrorMappings) > 0 || route.RedirectToPrimary {
+			return fmt.Errorf("stream route %s cannot use header, on_error or redirect-to-primary", route.Domains)
+		}
+	}
+
+	return nil
+}
+
+// checkStreams ensures that no two stream routes use the same port, and that stream routes aren't used as the
+// fallback.
+func checkStreams(routes []*proxy.Route, fallback *proxy.Route) error {
+	if fallback != nil && fallback.Stream != nil {
+		return fmt.Errorf("stream route %s cannot be the fallback", fallback.Domains)
+	}
+
+	ports := make(map[proxy.Stream][]string)
+	for i := range routes {
+		if stream := routes[i].Stream; stream != nil {
+			key := proxy.Stream{Port: stream.Port, Protocol: stream.Protocol}
+			if existing, ok := ports[key]; ok {
+				return fmt.Errorf("stream port %d/%s used by both %s and %s", stream.Port, stream.Protocol, existing, routes[i].Domains)
+			}
+			ports[key] = routes[i].Domains
+		}
+	}
+	return nil
+}
+
+func parseClientCA(args string, route *proxy.Route) error {
+	parts := strings.Fields(args)
+	if len(parts) == 0 || len(parts) > 2 {
+		return fmt.Errorf("invalid client-ca line: %s", args)
+	}
+
+	route.ClientAuth = proxy.ClientAuthRequired
+	if len(parts) == 2 {
+		switch strings.ToLower(parts[1]) {
+		case "required":
+			route.ClientAuth = proxy.ClientAuthRequired
+		case "optional":
+			route.ClientAuth = proxy.ClientAuthOptional
+		default:
+			return fmt.Errorf("invalid mode for client-ca: %s (must be optional or required)", parts[1])
+		}
+	}
+
+	data, err := os.ReadFile(parts[0])
+	if err != nil {
+		return fmt.Errorf("unable to read client-ca file: %w", err)
+	}
+
+	route.ClientCAs = x509.NewCertPool()
+	if !route.ClientCAs.AppendCertsFromPEM(data) {
+		return fmt.Errorf("no certificates found in client-ca file: %s", parts[0])
+	}
+	return nil
+}
+
+func parseCertificate(args string, route *proxy.Route) error {
+	parts := strings.Fields(args)
+	if len(parts) != 2 {
+		return fmt.Errorf("invalid certificate line: %s", args)
+	}
+
+	if _, err := tls.LoadX509KeyPair(parts[0], parts[1]); err != nil {
+		return fmt.Errorf("unable to load certificate files: %w", err)
+	}
+
+	route.CertificateFile = parts[0]
+	route.KeyFile = parts[1]
+	return nil
+}
+
+func parseKeyTypes(args string, route *proxy.Route) error {
+	parts := strings.Fields(args)
+	if len(parts) == 0 {
+		return fmt.Errorf("invalid key-type line: %s", args)
+	}
+
+	for i := range parts {
+		keyType, err := proxy.ParseKeyType(parts[i])
+		if err != nil {
+			return err
+		}
+		if slices.Contains(route.KeyTypes, keyType) {
+			return fmt.Errorf("duplicate key type %s in route %s", keyType, route.Domains)
+		}
+		route.KeyTypes = append(route.KeyTypes, keyType)
+	}
+	return nil
+}
+
+func parseStream(args string, route *proxy.Route) error {
+	parts := strings.Fields(args)
+	if len(parts) == 0 {
+		return fmt.Errorf("no port specified for stream in route %s", route.Domains)
+	}
+
+	port, err := strconv.Atoi(parts[0])
+	if err != nil || port < 1 || port > 65535 {
+		return fmt.Errorf("invalid port for stream: %s (must be 1-65535)", parts[0])
+	}
+
+	stream := &proxy.Stream{
+		Port:     port,
+		Protocol: proxy.StreamProtocolTCP,
+	}
+
+	for _, option := range parts[1:] {
+		switch strings.ToLower(option) {
+		case "tcp":
+			stream.Protocol = proxy.StreamProtocolTCP
+		case "udp":
+			stream.Protocol = proxy.StreamProtocolUDP
+		case "tls":
+			stream.TLS = true
+		default:
+			return fmt.Errorf("invalid option for stream: %s", option)
+		}
+	}
+
+	if stream.TLS && stream.Protocol != proxy.StreamProtocolTCP {
+		return fmt.Errorf("tls can only be used with tcp streams: %s", args)
+	}
+
+	route.Stream = stream
+	return nil
+}
+
 func parseOnError(args string, route *proxy.Route) error {
 	parts := strings.Fields(args)
 	if len(parts) != 2 {
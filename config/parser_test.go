@@ -2,10 +2,21 @@ package config
 
 import (
 	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/csmith/centauri/proxy"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func Test_Parse_ReturnsEmptySliceForEmptyFile(t *testing.T) {
@@ -493,3 +504,600 @@ route example.com
 	assert.ErrorContains(t, err, "path must begin with a /")
 }
 
+
+func Test_Parse_Passthrough_OutsideRoute(t *testing.T) {
+	_, _, err := Parse(bytes.NewBuffer([]byte(`passthrough`)))
+
+	assert.ErrorContains(t, err, "passthrough without route")
+}
+
+func Test_Parse_Passthrough_Single(t *testing.T) {
+	routes, _, err := Parse(bytes.NewBuffer([]byte(`
+route example.com
+	upstream localhost:8443
+	passthrough
+route example.net
+	upstream localhost:8080
+`)))
+
+	assert.NoError(t, err)
+	assert.True(t, routes[0].Passthrough)
+	assert.False(t, routes[1].Passthrough)
+}
+
+func Test_Parse_Passthrough_Repeated(t *testing.T) {
+	_, _, err := Parse(bytes.NewBuffer([]byte(`
+route example.com
+	passthrough
+	upstream localhost:8443
+	passthrough
+`)))
+
+	assert.ErrorContains(t, err, "multiple passthrough options specified")
+}
+
+func Test_Parse_Passthrough_WithIncompatibleOptions(t *testing.T) {
+	options := []string{
+		"header add X-Via Centauri",
+		"on_error 502 error-pages:8080",
+		"redirect-to-primary",
+		"provider selfsigned",
+		"subject example.com",
+	}
+
+	for _, option := range options {
+		t.Run(option, func(t *testing.T) {
+			_, _, err := Parse(bytes.NewBuffer([]byte(`
+route example.com www.example.com
+	upstream localhost:8443
+	passthrough
+	` + option + `
+`)))
+
+			assert.ErrorContains(t, err, "cannot use header, on_error, redirect-to-primary, provider or subject")
+		})
+	}
+}
+
+func Test_Parse_Stream_OutsideRoute(t *testing.T) {
+	_, _, err := Parse(bytes.NewBuffer([]byte(`stream 5432`)))
+
+	assert.ErrorContains(t, err, "stream without route")
+}
+
+func Test_Parse_Stream_Defaults(t *testing.T) {
+	routes, _, err := Parse(bytes.NewBuffer([]byte(`
+route db.example.com
+	upstream postgres:5432
+	stream 5432
+route example.com
+	upstream localhost:8080
+`)))
+
+	assert.NoError(t, err)
+	assert.Equal(t, &proxy.Stream{Port: 5432, Protocol: proxy.StreamProtocolTCP}, routes[0].Stream)
+	assert.Nil(t, routes[1].Stream)
+}
+
+func Test_Parse_Stream_WithOptions(t *testing.T) {
+	routes, _, err := Parse(bytes.NewBuffer([]byte(`
+route db.example.com
+	upstream postgres:5432
+	stream 5432 TLS
+route dns.example.com
+	upstream dns:53
+	stream 53 udp
+`)))
+
+	assert.NoError(t, err)
+	assert.Equal(t, &proxy.Stream{Port: 5432, Protocol: proxy.StreamProtocolTCP, TLS: true}, routes[0].Stream)
+	assert.Equal(t, &proxy.Stream{Port: 53, Protocol: proxy.StreamProtocolUDP}, routes[1].Stream)
+}
+
+func Test_Parse_Stream_InvalidPort(t *testing.T) {
+	for _, port := range []string{"", "0", "65536", "postgres"} {
+		t.Run(port, func(t *testing.T) {
+			_, _, err := Parse(bytes.NewBuffer([]byte(`
+route db.example.com
+	upstream postgres:5432
+	stream ` + port + `
+`)))
+
+			assert.Error(t, err)
+		})
+	}
+}
+
+func Test_Parse_Stream_InvalidOption(t *testing.T) {
+	_, _, err := Parse(bytes.NewBuffer([]byte(`
+route db.example.com
+	upstream postgres:5432
+	stream 5432 sctp
+`)))
+
+	assert.ErrorContains(t, err, "invalid option for stream: sctp")
+}
+
+func Test_Parse_Stream_TLSWithUDP(t *testing.T) {
+	_, _, err := Parse(bytes.NewBuffer([]byte(`
+route dns.example.com
+	upstream dns:53
+	stream 53 udp tls
+`)))
+
+	assert.ErrorContains(t, err, "tls can only be used with tcp streams")
+}
+
+func Test_Parse_Stream_Repeated(t *testing.T) {
+	_, _, err := Parse(bytes.NewBuffer([]byte(`
+route db.example.com
+	upstream postgres:5432
+	stream 5432
+	stream 5433
+`)))
+
+	assert.ErrorContains(t, err, "multiple stream options specified")
+}
+
+func Test_Parse_Stream_DuplicatePort(t *testing.T) {
+	_, _, err := Parse(bytes.NewBuffer([]byte(`
+route db.example.com
+	upstream postgres:5432
+	stream 5432
+route db2.example.com
+	upstream postgres2:5432
+	stream 5432
+`)))
+
+	assert.ErrorContains(t, err, "stream port 5432/tcp used by both")
+}
+
+func Test_Parse_Stream_SamePortDifferentProtocols(t *testing.T) {
+	routes, _, err := Parse(bytes.NewBuffer([]byte(`
+route dns.example.com
+	upstream dns:53
+	stream 53
+route dns2.example.com
+	upstream dns:53
+	stream 53 udp
+`)))
+
+	assert.NoError(t, err)
+	assert.Len(t, routes, 2)
+}
+
+func Test_Parse_Stream_Fallback(t *testing.T) {
+	_, _, err := Parse(bytes.NewBuffer([]byte(`
+route db.example.com
+	upstream postgres:5432
+	stream 5432
+	fallback
+`)))
+
+	assert.ErrorContains(t, err, "cannot be the fallback")
+}
+
+func Test_Parse_Stream_WithIncompatibleOptions(t *testing.T) {
+	options := []string{
+		"header add X-Via Centauri",
+		"on_error 502 error-pages:8080",
+		"redirect-to-primary",
+		"passthrough",
+	}
+
+	for _, option := range options {
+		t.Run(option, func(t *testing.T) {
+			_, _, err := Parse(bytes.NewBuffer([]byte(`
+route db.example.com www.example.com
+	upstream postgres:5432
+	stream 5432
+	` + option + `
+`)))
+
+			assert.ErrorContains(t, err, "cannot use")
+		})
+	}
+}
+
+// writeCA creates a self-signed CA certificate and writes it to a PEM file, returning the path.
+func writeCA(t *testing.T) string {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	path := filepath.Join(t.TempDir(), "ca.pem")
+	require.NoError(t, os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	return path
+}
+
+func Test_Parse_ClientCA_OutsideRoute(t *testing.T) {
+	_, _, err := Parse(bytes.NewBuffer([]byte(`client-ca ` + writeCA(t))))
+
+	assert.ErrorContains(t, err, "client-ca without route")
+}
+
+func Test_Parse_ClientCA_DefaultsToRequired(t *testing.T) {
+	routes, _, err := Parse(bytes.NewBuffer([]byte(`
+route example.com
+	upstream localhost:8080
+	client-ca ` + writeCA(t) + `
+`)))
+
+	assert.NoError(t, err)
+	assert.Equal(t, proxy.ClientAuthRequired, routes[0].ClientAuth)
+	assert.NotNil(t, routes[0].ClientCAs)
+}
+
+func Test_Parse_ClientCA_Modes(t *testing.T) {
+	tests := map[string]proxy.ClientAuth{
+		"required": proxy.ClientAuthRequired,
+		"OPTIONAL": proxy.ClientAuthOptional,
+	}
+
+	for mode, expected := range tests {
+		t.Run(mode, func(t *testing.T) {
+			routes, _, err := Parse(bytes.NewBuffer([]byte(`
+route example.com
+	upstream localhost:8080
+	client-ca ` + writeCA(t) + ` ` + mode + `
+`)))
+
+			assert.NoError(t, err)
+			assert.Equal(t, expected, routes[0].ClientAuth)
+		})
+	}
+}
+
+func Test_Parse_ClientCA_InvalidMode(t *testing.T) {
+	_, _, err := Parse(bytes.NewBuffer([]byte(`
+route example.com
+	upstream localhost:8080
+	client-ca ` + writeCA(t) + ` sometimes
+`)))
+
+	assert.ErrorContains(t, err, "invalid mode for client-ca")
+}
+
+func Test_Parse_ClientCA_MissingFile(t *testing.T) {
+	_, _, err := Parse(bytes.NewBuffer([]byte(`
+route example.com
+	upstream localhost:8080
+	client-ca ` + filepath.Join(t.TempDir(), "missing.pem") + `
+`)))
+
+	assert.ErrorContains(t, err, "unable to read client-ca file")
+}
+
+func Test_Parse_ClientCA_FileWithoutCertificates(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ca.pem")
+	require.NoError(t, os.WriteFile(path, []byte("not a certificate"), 0600))
+
+	_, _, err := Parse(bytes.NewBuffer([]byte(`
+route example.com
+	upstream localhost:8080
+	client-ca ` + path + `
+`)))
+
+	assert.ErrorContains(t, err, "no certificates found in client-ca file")
+}
+
+func Test_Parse_ClientCA_Repeated(t *testing.T) {
+	_, _, err := Parse(bytes.NewBuffer([]byte(`
+route example.com
+	upstream localhost:8080
+	client-ca ` + writeCA(t) + `
+	client-ca ` + writeCA(t) + `
+`)))
+
+	assert.ErrorContains(t, err, "multiple client-ca options specified")
+}
+
+func Test_Parse_ClientCA_WithPassthrough(t *testing.T) {
+	_, _, err := Parse(bytes.NewBuffer([]byte(`
+route example.com
+	upstream localhost:8443
+	passthrough
+	client-ca ` + writeCA(t) + `
+`)))
+
+	assert.ErrorContains(t, err, "passthrough route [example.com] cannot use client-ca")
+}
+
+func Test_Parse_ClientCA_WithStreams(t *testing.T) {
+	_, _, err := Parse(bytes.NewBuffer([]byte(`
+route example.com
+	upstream localhost:5432
+	stream 5432
+	client-ca ` + writeCA(t) + `
+`)))
+	assert.ErrorContains(t, err, "must use tls to use client-ca")
+
+	routes, _, err := Parse(bytes.NewBuffer([]byte(`
+route example.com
+	upstream localhost:5432
+	stream 5432 tls
+	client-ca ` + writeCA(t) + `
+`)))
+	assert.NoError(t, err)
+	assert.Equal(t, proxy.ClientAuthRequired, routes[0].ClientAuth)
+}
+
+func Test_Parse_TLSProfile_OutsideRoute(t *testing.T) {
+	_, _, err := Parse(bytes.NewBuffer([]byte(`tls-profile modern`)))
+
+	assert.ErrorContains(t, err, "tls-profile without route")
+}
+
+func Test_Parse_TLSProfile_Single(t *testing.T) {
+	routes, _, err := Parse(bytes.NewBuffer([]byte(`
+route example.com
+	upstream localhost:8080
+	tls-profile Modern
+route example.net
+	upstream localhost:8080
+`)))
+
+	assert.NoError(t, err)
+	assert.Equal(t, proxy.TLSProfileModern, routes[0].TLSProfile)
+	assert.Equal(t, proxy.TLSProfile(""), routes[1].TLSProfile)
+}
+
+func Test_Parse_TLSProfile_Invalid(t *testing.T) {
+	_, _, err := Parse(bytes.NewBuffer([]byte(`
+route example.com
+	upstream localhost:8080
+	tls-profile ancient
+`)))
+
+	assert.ErrorContains(t, err, "invalid TLS profile: ancient")
+}
+
+func Test_Parse_TLSProfile_Repeated(t *testing.T) {
+	_, _, err := Parse(bytes.NewBuffer([]byte(`
+route example.com
+	upstream localhost:8080
+	tls-profile modern
+	tls-profile old
+`)))
+
+	assert.ErrorContains(t, err, "multiple tls-profile options specified")
+}
+
+func Test_Parse_TLSProfile_WithPassthrough(t *testing.T) {
+	_, _, err := Parse(bytes.NewBuffer([]byte(`
+route example.com
+	upstream localhost:8443
+	passthrough
+	tls-profile modern
+`)))
+
+	assert.ErrorContains(t, err, "passthrough route [example.com] cannot use tls-profile")
+}
+
+func Test_Parse_TLSProfile_WithStreams(t *testing.T) {
+	_, _, err := Parse(bytes.NewBuffer([]byte(`
+route example.com
+	upstream localhost:5432
+	stream 5432
+	tls-profile modern
+`)))
+	assert.ErrorContains(t, err, "must use tls to use tls-profile")
+
+	routes, _, err := Parse(bytes.NewBuffer([]byte(`
+route example.com
+	upstream localhost:5432
+	stream 5432 tls
+	tls-profile modern
+`)))
+	assert.NoError(t, err)
+	assert.Equal(t, proxy.TLSProfileModern, routes[0].TLSProfile)
+}
+
+// writeKeyPair creates a self-signed certificate and writes it and its private key to PEM files, returning
+// their paths.
+func writeKeyPair(t *testing.T) (string, string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "example.com"},
+		DNSNames:     []string{"example.com"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	certPath := filepath.Join(dir, "cert.pem")
+	keyPath := filepath.Join(dir, "key.pem")
+	require.NoError(t, os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	require.NoError(t, os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600))
+	return certPath, keyPath
+}
+
+func Test_Parse_Certificate_OutsideRoute(t *testing.T) {
+	certPath, keyPath := writeKeyPair(t)
+	_, _, err := Parse(bytes.NewBuffer([]byte(`certificate ` + certPath + ` ` + keyPath)))
+
+	assert.ErrorContains(t, err, "certificate without route")
+}
+
+func Test_Parse_Certificate_SetsFiles(t *testing.T) {
+	certPath, keyPath := writeKeyPair(t)
+	routes, _, err := Parse(bytes.NewBuffer([]byte(`
+route example.com
+	upstream localhost:8080
+	certificate ` + certPath + ` ` + keyPath + `
+`)))
+
+	require.NoError(t, err)
+	assert.Equal(t, certPath, routes[0].CertificateFile)
+	assert.Equal(t, keyPath, routes[0].KeyFile)
+}
+
+func Test_Parse_Certificate_WrongNumberOfArguments(t *testing.T) {
+	certPath, _ := writeKeyPair(t)
+	_, _, err := Parse(bytes.NewBuffer([]byte(`
+route example.com
+	upstream localhost:8080
+	certificate ` + certPath + `
+`)))
+
+	assert.ErrorContains(t, err, "invalid certificate line")
+}
+
+func Test_Parse_Certificate_MissingFile(t *testing.T) {
+	certPath, _ := writeKeyPair(t)
+	_, _, err := Parse(bytes.NewBuffer([]byte(`
+route example.com
+	upstream localhost:8080
+	certificate ` + certPath + ` ` + filepath.Join(t.TempDir(), "missing.pem") + `
+`)))
+
+	assert.ErrorContains(t, err, "unable to load certificate files")
+}
+
+func Test_Parse_Certificate_Repeated(t *testing.T) {
+	certPath, keyPath := writeKeyPair(t)
+	_, _, err := Parse(bytes.NewBuffer([]byte(`
+route example.com
+	upstream localhost:8080
+	certificate ` + certPath + ` ` + keyPath + `
+	certificate ` + certPath + ` ` + keyPath + `
+`)))
+
+	assert.ErrorContains(t, err, "multiple certificate options specified")
+}
+
+func Test_Parse_Certificate_WithProvider(t *testing.T) {
+	certPath, keyPath := writeKeyPair(t)
+	_, _, err := Parse(bytes.NewBuffer([]byte(`
+route example.com
+	upstream localhost:8080
+	provider selfsigned
+	certificate ` + certPath + ` ` + keyPath + `
+`)))
+
+	assert.ErrorContains(t, err, "cannot use certificate with provider or subject")
+}
+
+func Test_Parse_Certificate_WithPassthrough(t *testing.T) {
+	certPath, keyPath := writeKeyPair(t)
+	_, _, err := Parse(bytes.NewBuffer([]byte(`
+route example.com
+	upstream localhost:8443
+	passthrough
+	certificate ` + certPath + ` ` + keyPath + `
+`)))
+
+	assert.ErrorContains(t, err, "passthrough route [example.com] cannot use certificate")
+}
+
+func Test_Parse_Certificate_WithStreams(t *testing.T) {
+	certPath, keyPath := writeKeyPair(t)
+	_, _, err := Parse(bytes.NewBuffer([]byte(`
+route example.com
+	upstream localhost:5432
+	stream 5432
+	certificate ` + certPath + ` ` + keyPath + `
+`)))
+	assert.ErrorContains(t, err, "must use tls to use certificate")
+}
+
+func Test_Parse_KeyType_OutsideRoute(t *testing.T) {
+	_, _, err := Parse(bytes.NewBuffer([]byte(`key-type rsa`)))
+
+	assert.ErrorContains(t, err, "key-type without route")
+}
+
+func Test_Parse_KeyType_SetsKeyTypesInOrder(t *testing.T) {
+	routes, _, err := Parse(bytes.NewBuffer([]byte(`
+route example.com
+	upstream localhost:8080
+	key-type RSA ecdsa
+
+route example.net
+	upstream localhost:8080
+`)))
+
+	require.NoError(t, err)
+	assert.Equal(t, []proxy.KeyType{proxy.KeyTypeRSA, proxy.KeyTypeECDSA}, routes[0].KeyTypes)
+	assert.Nil(t, routes[1].KeyTypes)
+}
+
+func Test_Parse_KeyType_Invalid(t *testing.T) {
+	_, _, err := Parse(bytes.NewBuffer([]byte(`
+route example.com
+	upstream localhost:8080
+	key-type dsa
+`)))
+	assert.ErrorContains(t, err, "invalid key type")
+
+	_, _, err = Parse(bytes.NewBuffer([]byte(`
+route example.com
+	upstream localhost:8080
+	key-type
+`)))
+	assert.ErrorContains(t, err, "invalid key-type line")
+
+	_, _, err = Parse(bytes.NewBuffer([]byte(`
+route example.com
+	upstream localhost:8080
+	key-type rsa rsa
+`)))
+	assert.ErrorContains(t, err, "duplicate key type")
+}
+
+func Test_Parse_KeyType_Repeated(t *testing.T) {
+	_, _, err := Parse(bytes.NewBuffer([]byte(`
+route example.com
+	upstream localhost:8080
+	key-type rsa
+	key-type ecdsa
+`)))
+
+	assert.ErrorContains(t, err, "multiple key-type options specified")
+}
+
+func Test_Parse_KeyType_WithPassthrough(t *testing.T) {
+	_, _, err := Parse(bytes.NewBuffer([]byte(`
+route example.com
+	upstream localhost:8443
+	passthrough
+	key-type rsa
+`)))
+
+	assert.ErrorContains(t, err, "passthrough route [example.com] cannot use key-type")
+}
+
+func Test_Parse_KeyType_WithCertificate(t *testing.T) {
+	certPath, keyPath := writeKeyPair(t)
+	_, _, err := Parse(bytes.NewBuffer([]byte(`
+route example.com
+	upstream localhost:8080
+	certificate ` + certPath + ` ` + keyPath + `
+	key-type rsa
+`)))
+
+	assert.ErrorContains(t, err, "cannot use key-type with certificate")
+}
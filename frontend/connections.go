@@ -0,0 +1,171 @@
+package frontend
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// drainPollInterval is how often we check whether upgraded connections have closed while draining.
+const drainPollInterval = 50 * time.Millisecond
+
+type trackedConnKey struct{}
+
+// connectionTracker keeps track of all connections accepted by a Server. http.Server forgets about connections
+// once they're hijacked (e.g. when a websocket is upgraded), so without this they would be abandoned during
+// shutdown rather than drained.
+type connectionTracker struct {
+	mu    sync.Mutex
+	conns map[*trackedConn]struct{}
+}
+
+func newConnectionTracker() *connectionTracker {
+	return &connectionTracker{conns: make(map[*trackedConn]struct{})}
+}
+
+// wrap returns a listener that tracks all connections it accepts. If connections are to be served over TLS,
+// the TLS listener should wrap the returned listener (not the other way around) so that http.Server still
+// sees a *tls.Conn.
+func (c *connectionTracker) wrap(listener net.Listener) net.Listener {
+	return &trackingListener{Listener: listener, tracker: c}
+}
+
+// connContext stores the tracked connection in the context of each request made over it. It satisfies the
+// signature of http.Server.ConnContext.
+func (c *connectionTracker) connContext(ctx context.Context, conn net.Conn) context.Context {
+	if tc := unwrapTrackedConn(conn); tc != nil {
+		return context.WithValue(ctx, trackedConnKey{}, tc)
+	}
+	return ctx
+}
+
+// connState records when a connection is hijacked. It satisfies the signature of http.Server.ConnState.
+func (c *connectionTracker) connState(conn net.Conn, state http.ConnState) {
+	if state != http.StateHijacked {
+		return
+	}
+
+	c.hijack(conn)
+}
+
+// hijack records that a connection is no longer being handled by http.Server, and should be treated like an
+// upgraded connection when draining.
+func (c *connectionTracker) hijack(conn net.Conn) {
+	if tc := unwrapTrackedConn(conn); tc != nil {
+		c.mu.Lock()
+		tc.hijacked = conn
+		c.mu.Unlock()
+	}
+}
+
+// waitForUpgraded blocks until all upgraded connections have been closed, or the context is done.
+func (c *connectionTracker) waitForUpgraded(ctx context.Context) {
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+
+	for len(c.upgraded()) > 0 {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// closeUpgraded forcibly closes all upgraded connections, returning the number that were closed.
+func (c *connectionTracker) closeUpgraded() int {
+	conns := c.upgraded()
+	for i := range conns {
+		_ = conns[i].Close()
+	}
+	return len(conns)
+}
+
+// countActive returns the number of open connections that haven't been upgraded.
+func (c *connectionTracker) countActive() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	count := 0
+	for conn := range c.conns {
+		if conn.hijacked == nil {
+			count++
+		}
+	}
+	return count
+}
+
+// upgraded returns the outermost connection (i.e. the *tls.Conn for TLS connections) of each open connection
+// that has been hijacked.
+func (c *connectionTracker) upgraded() []net.Conn {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var res []net.Conn
+	for conn := range c.conns {
+		if conn.hijacked != nil {
+			res = append(res, conn.hijacked)
+		}
+	}
+	return res
+}
+
+func (c *connectionTracker) add(conn *trackedConn) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.conns[conn] = struct{}{}
+}
+
+func (c *connectionTracker) remove(conn *trackedConn) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.conns, conn)
+}
+
+// trackingListener wraps a listener, registering each accepted connection with a connectionTracker.
+type trackingListener struct {
+	net.Listener
+	tracker *connectionTracker
+}
+
+func (t *trackingListener) Accept() (net.Conn, error) {
+	conn, err := t.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	tc := &trackedConn{Conn: conn, tracker: t.tracker}
+	t.tracker.add(tc)
+	return tc, nil
+}
+
+// trackedConn is a connection that removes itself from its connectionTracker when closed. The hijacked field is
+// guarded by the tracker's mutex.
+type trackedConn struct {
+	net.Conn
+	tracker   *connectionTracker
+	closeOnce sync.Once
+	hijacked  net.Conn
+}
+
+func (t *trackedConn) Close() error {
+	t.closeOnce.Do(func() {
+		t.tracker.remove(t)
+	})
+	return t.Conn.Close()
+}
+
+// unwrapTrackedConn finds the trackedConn underlying the given connection, if there is one.
+func unwrapTrackedConn(conn net.Conn) *trackedConn {
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		conn = tlsConn.NetConn()
+	}
+	if replayed, ok := conn.(*replayConn); ok {
+		conn = replayed.Conn
+	}
+	tc, _ := conn.(*trackedConn)
+	return tc
+}
@@ -6,9 +6,12 @@ import (
 	"context"
 	"crypto/tls"
 	"errors"
+	"fmt"
+	"log/slog"
 	"net"
 	"net/http"
 	"net/http/httputil"
+	"slices"
 	"sync"
 	"time"
 
@@ -18,11 +21,15 @@ import (
 )
 
 const (
+	// shutdownTimeout is how long servers wait for connections to drain when stopping, unless configured otherwise.
 	shutdownTimeout   = time.Second * 5
 	readHeaderTimeout = time.Second * 5
 	readTimeout       = time.Duration(0)
 	writeTimeout      = time.Duration(0)
 	idleTimeout       = time.Duration(0)
+
+	// acmeTLSProtocol is the ALPN protocol used by ACME servers validating TLS-ALPN-01 challenges.
+	acmeTLSProtocol = "acme-tls/1"
 )
 
 // Frontend represents something Centauri can listen for requests on.
@@ -42,64 +49,154 @@ type Context struct {
 	Rewriter *proxy.Rewriter
 	Recorder *metrics.Recorder
 	ErrChan  chan<- error
+	// DrainTimeout is how long to wait for open connections to finish when stopping. If zero, a default
+	// of five seconds is used.
+	DrainTimeout time.Duration
+	// TLSProfile is used for routes that don't specify their own. If empty, the intermediate profile is used.
+	TLSProfile proxy.TLSProfile
+	// HttpChallenges, if set, provides responses to ACME HTTP-01 challenges, which are served on the plain
+	// HTTP listener instead of redirecting to HTTPS.
+	HttpChallenges proxy.HttpChallengeProvider
+	// TlsAlpnChallenges, if set, provides certificates for ACME TLS-ALPN-01 challenges, which are served on
+	// the HTTPS listener to clients that only offer the acme-tls/1 protocol.
+	TlsAlpnChallenges TlsAlpnChallengeProvider
+	// PendingCertificatePage is served for routes using a temporary certificate while waiting for a valid one.
+	// If nil, a default page is used.
+	PendingCertificatePage []byte
+}
+
+// TlsAlpnChallengeProvider is the surface used to obtain certificates for ACME TLS-ALPN-01 challenges.
+type TlsAlpnChallengeProvider interface {
+	TlsAlpnChallengeCertificate(domain string) (*tls.Certificate, bool)
+}
+
+// newServer creates a server for the given handler, which drains according to the context's settings and
+// records any connections it has to kill when stopping.
+func (fc *Context) newServer(handler http.Handler) *Server {
+	s := NewServer(handler, fc.ErrChan)
+	if fc.DrainTimeout > 0 {
+		s.drainTimeout = fc.DrainTimeout
+	}
+	s.onKilled = fc.Recorder.TrackKilledConnections
+	return s
 }
 
 // createProxy creates a reverse proxy backed by the context's rewriter.
 func (fc *Context) createProxy() http.Handler {
-	return proxy.NewDomainRedirector(
+	return proxy.NewMisdirectedRequestHandler(fc.Manager, fc.TLSProfile, proxy.NewPendingCertificateHandler(
 		fc.Manager,
-		&httputil.ReverseProxy{
-			Rewrite:        fc.Rewriter.RewriteRequest,
-			ModifyResponse: fc.Recorder.TrackResponse(fc.Rewriter.RewriteResponse),
-			ErrorHandler:   fc.Recorder.TrackBadGateway(fc.Rewriter.RewriteError(handleError)),
-			BufferPool:     newBufferPool(),
-			Transport: &http.Transport{
-				ForceAttemptHTTP2:   false,
-				DisableCompression:  true,
-				MaxIdleConnsPerHost: 100,
-				IdleConnTimeout:     90 * time.Second,
+		fc.PendingCertificatePage,
+		proxy.NewDomainRedirector(
+			fc.Manager,
+			&httputil.ReverseProxy{
+				Rewrite:        fc.Rewriter.RewriteRequest,
+				ModifyResponse: fc.Recorder.TrackResponse(fc.Rewriter.RewriteResponse),
+				ErrorHandler:   fc.Recorder.TrackBadGateway(fc.Rewriter.RewriteError(handleError)),
+				BufferPool:     newBufferPool(),
+				Transport: &http.Transport{
+					ForceAttemptHTTP2:   false,
+					DisableCompression:  true,
+					MaxIdleConnsPerHost: 100,
+					IdleConnTimeout:     90 * time.Second,
+				},
 			},
-		})
+		),
+	))
 }
 
-// createRedirector creates a http.Handler that redirects all requests to HTTPS.
+// createRedirector creates a http.Handler that redirects all requests to HTTPS, other than those for ACME
+// HTTP-01 challenges.
 func (fc *Context) createRedirector() http.Handler {
-	return &proxy.HttpRedirector{}
+	return &proxy.HttpRedirector{Challenges: fc.HttpChallenges}
 }
 
-// createTLSConfig creates a new tls.Config following the Mozilla intermediate configuration, and using
-// the context's manager for obtaining certificates.
+// createTLSConfig creates a new tls.Config following the context's default TLS profile, and using the
+// context's manager for obtaining certificates. Routes that use a different profile or require client
+// certificates are given their own config when the client connects.
 func (fc *Context) createTLSConfig() *tls.Config {
+	config := fc.tlsConfigForProfile(fc.profileFor(nil))
+	config.GetConfigForClient = func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+		if fc.TlsAlpnChallenges != nil && slices.Contains(hello.SupportedProtos, acmeTLSProtocol) {
+			return fc.tlsAlpnChallengeConfig(hello.ServerName)
+		}
+
+		route := fc.Manager.RouteForDomain(proxy.ServerNameForClient(hello))
+		if route == nil || route.ClientAuth == proxy.ClientAuthNone && fc.profileFor(route) == fc.profileFor(nil) {
+			return nil, nil
+		}
+
+		return fc.tlsConfigForRoute(route), nil
+	}
+	return config
+}
+
+// tlsAlpnChallengeConfig creates a new tls.Config that serves the ACME TLS-ALPN-01 challenge certificate for
+// the given domain. If no challenge is in progress the handshake is aborted.
+func (fc *Context) tlsAlpnChallengeConfig(domain string) (*tls.Config, error) {
+	cert, ok := fc.TlsAlpnChallenges.TlsAlpnChallengeCertificate(domain)
+	if !ok {
+		return nil, fmt.Errorf("no TLS-ALPN-01 challenge in progress for %s", domain)
+	}
+
+	slog.Debug("Serving ACME TLS-ALPN-01 challenge", "domain", domain)
 	return &tls.Config{
-		MinVersion: tls.VersionTLS12,
-		// Generated 2026-06-18, TLSRef Guideline v6.0, Go 1.23.3, intermediate config, gitrev=9c09b2d
-		CurvePreferences: []tls.CurveID{
-			tls.X25519MLKEM768,
-			tls.X25519,
-			tls.CurveP256,
-			tls.CurveP384,
-		},
-		CipherSuites: []uint16{
-			tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
-			tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
-			tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
-			tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
-			tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256,
-			tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256,
-		},
+		Certificates: []tls.Certificate{*cert},
+		NextProtos:   []string{acmeTLSProtocol},
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
+
+// tlsConfigForRoute creates a new tls.Config following the route's TLS profile, and verifying client
+// certificates if the route requires them.
+func (fc *Context) tlsConfigForRoute(route *proxy.Route) *tls.Config {
+	config := fc.tlsConfigForProfile(fc.profileFor(route))
+	if route.ClientAuth == proxy.ClientAuthNone {
+		return config
+	}
+
+	config.ClientCAs = route.ClientCAs
+	switch route.ClientAuth {
+	case proxy.ClientAuthOptional:
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+	case proxy.ClientAuthRequired:
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	// Session tickets are shared between all routes, so a session established for a route that doesn't check
+	// client certificates (or trusts a different CA) could otherwise be resumed here.
+	config.SessionTicketsDisabled = true
+	return config
+}
+
+// tlsConfigForProfile creates a new tls.Config following the given profile, using the context's manager for
+// obtaining certificates.
+func (fc *Context) tlsConfigForProfile(profile proxy.TLSProfile) *tls.Config {
+	config := &tls.Config{
 		GetCertificate: fc.Recorder.TrackHello(fc.Manager.CertificateForClient),
 		NextProtos:     []string{"h2", "http/1.1"},
 	}
+	applyTLSProfile(config, profile)
+	return config
+}
+
+// profileFor returns the TLS profile that should be used for the route, or the default profile if the route
+// is nil.
+func (fc *Context) profileFor(route *proxy.Route) proxy.TLSProfile {
+	return proxy.TLSProfileFor(route, fc.TLSProfile)
 }
 
 // Server encapsulates an HTTP server with the ability to gracefully shutdown.
 type Server struct {
-	srv     *http.Server
-	errChan chan<- error
+	srv          *http.Server
+	errChan      chan<- error
+	connections  *connectionTracker
+	drainTimeout time.Duration
+	onKilled     func(kind string, count int)
 }
 
 // NewServer creates a new server with the provided handler and error channel.
 func NewServer(handler http.Handler, errChan chan<- error) *Server {
+	connections := newConnectionTracker()
 	return &Server{
 		srv: &http.Server{
 			Handler:           handler,
@@ -107,23 +204,61 @@ func NewServer(handler http.Handler, errChan chan<- error) *Server {
 			ReadTimeout:       readTimeout,
 			WriteTimeout:      writeTimeout,
 			IdleTimeout:       idleTimeout,
+			ConnContext:       connections.connContext,
+			ConnState:         connections.connState,
 		},
-		errChan: errChan,
+		errChan:      errChan,
+		connections:  connections,
+		drainTimeout: shutdownTimeout,
 	}
 }
 
 // Start starts the server listening on the given listener.
 func (s *Server) Start(listener net.Listener) {
+	s.serve(s.connections.wrap(listener))
+}
+
+// StartTLS starts the server listening on the given listener, performing TLS handshakes using the given config.
+func (s *Server) StartTLS(listener net.Listener, config *tls.Config) {
+	s.serve(tls.NewListener(s.connections.wrap(listener), config))
+}
+
+func (s *Server) serve(listener net.Listener) {
 	if err := s.srv.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
 		s.errChan <- err
 	}
 }
 
-// Stop gracefully stops the server with a timeout.
+// Stop gracefully stops the server. Listeners are closed immediately, and open connections (including upgraded
+// ones such as websockets) are given until the drain timeout to finish. Any connections remaining after the
+// timeout are forcibly closed.
+//
+// Upgraded connections aren't sent anything asking them to close: the reverse proxy may be part-way through
+// copying a frame from the upstream, so anything written to the connection could corrupt the stream.
 func (s *Server) Stop(ctx context.Context) {
-	timeoutContext, cancel := context.WithTimeout(ctx, shutdownTimeout)
+	timeoutContext, cancel := context.WithTimeout(ctx, s.drainTimeout)
 	defer cancel()
-	_ = s.srv.Shutdown(timeoutContext)
+
+	if err := s.srv.Shutdown(timeoutContext); err != nil {
+		// Whatever is left is still actively serving a request, e.g. a long-poll or an event stream.
+		s.killed("http", s.connections.countActive())
+		_ = s.srv.Close()
+	}
+
+	s.connections.waitForUpgraded(timeoutContext)
+	s.killed("upgraded", s.connections.closeUpgraded())
+}
+
+// killed reports connections that had to be forcibly closed during shutdown.
+func (s *Server) killed(kind string, count int) {
+	if count == 0 {
+		return
+	}
+
+	slog.Warn("Forcibly closed connections that did not finish draining", "type", kind, "count", count)
+	if s.onKilled != nil {
+		s.onKilled(kind, count)
+	}
 }
 
 const badGatewayError = `<!doctype html>
@@ -1,9 +1,15 @@
 package frontend
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
 	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
 	"fmt"
 	"io"
+	"math/big"
 	"net"
 	"net/http"
 	"net/http/httptest"
@@ -235,6 +241,34 @@ func Test_Context_CreateRedirector_redirectsToHttps(t *testing.T) {
 	assert.Equal(t, "https://example.com/foo?a=b", response.Header.Get("Location"))
 }
 
+type fakeHttpChallenges map[string]string
+
+func (f fakeHttpChallenges) HttpChallengeResponse(domain, token string) (string, bool) {
+	response, ok := f[domain+"/"+token]
+	return response, ok
+}
+
+func Test_Context_CreateRedirector_servesHttpChallenges(t *testing.T) {
+	ctx := newTestContext(t)
+	ctx.HttpChallenges = fakeHttpChallenges{"example.com/token": "token.keyauth"}
+
+	redirector := httptest.NewServer(ctx.createRedirector())
+	defer redirector.Close()
+
+	request, err := http.NewRequest(http.MethodGet, redirector.URL+"/.well-known/acme-challenge/token", nil)
+	require.NoError(t, err)
+	request.Host = "example.com"
+
+	response, err := http.DefaultClient.Do(request)
+	require.NoError(t, err)
+	defer response.Body.Close()
+
+	body, err := io.ReadAll(response.Body)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	assert.Equal(t, "token.keyauth", string(body))
+}
+
 func Test_Context_CreateTLSConfig(t *testing.T) {
 	cfg := newTestContext(t).createTLSConfig()
 
@@ -270,6 +304,123 @@ func Test_Server_servesRequestsAndStopsGracefully(t *testing.T) {
 	}
 }
 
+func Test_Server_waitsForUpgradedConnectionsWhenStopping(t *testing.T) {
+	server := NewServer(http.HandlerFunc(upgradeHandler), make(chan error, 1))
+	var killed []string
+	server.onKilled = func(kind string, count int) { killed = append(killed, kind) }
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go server.Start(listener)
+
+	conn := dialUpgraded(t, listener.Addr().String())
+	defer conn.Close()
+
+	stopped := make(chan struct{})
+	go func() {
+		server.Stop(t.Context())
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+		t.Fatal("Server stopped before the upgraded connection was closed")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// Closing our end lets the upgraded connection finish draining
+	require.NoError(t, conn.Close())
+
+	select {
+	case <-stopped:
+		assert.Empty(t, killed)
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timeout waiting for server to stop")
+	}
+}
+
+func Test_Server_killsUpgradedConnectionsThatDoNotDrain(t *testing.T) {
+	server := NewServer(http.HandlerFunc(upgradeHandler), make(chan error, 1))
+	server.drainTimeout = 100 * time.Millisecond
+	killed := make(map[string]int)
+	server.onKilled = func(kind string, count int) { killed[kind] += count }
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go server.Start(listener)
+
+	conn := dialUpgraded(t, listener.Addr().String())
+	defer conn.Close()
+
+	server.Stop(t.Context())
+	assert.Equal(t, map[string]int{"upgraded": 1}, killed)
+
+	// Nothing is written to the connection before it is closed
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(2*time.Second)))
+	b, err := io.ReadAll(conn)
+	assert.NoError(t, err)
+	assert.Empty(t, b)
+}
+
+func Test_Server_killsRequestsThatDoNotDrain(t *testing.T) {
+	started := make(chan struct{})
+	server := NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		close(started)
+		<-r.Context().Done()
+	}), make(chan error, 1))
+	server.drainTimeout = 100 * time.Millisecond
+	killed := make(map[string]int)
+	server.onKilled = func(kind string, count int) { killed[kind] += count }
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go server.Start(listener)
+
+	response, err := http.Get(fmt.Sprintf("http://%s", listener.Addr().String()))
+	require.NoError(t, err)
+	defer response.Body.Close()
+	<-started
+
+	server.Stop(t.Context())
+	assert.Equal(t, map[string]int{"http": 1}, killed)
+}
+
+// upgradeHandler hijacks the connection and pretends to be a websocket server, holding the connection open until
+// the client closes it.
+func upgradeHandler(w http.ResponseWriter, r *http.Request) {
+	conn, buf, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	_, _ = buf.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n\r\n")
+	_ = buf.Flush()
+	_, _ = io.Copy(io.Discard, conn)
+}
+
+// dialUpgraded connects to the given address and requests a websocket upgrade, returning the connection once
+// the server has agreed to it.
+func dialUpgraded(t *testing.T, address string) net.Conn {
+	t.Helper()
+
+	conn, err := net.Dial("tcp", address)
+	require.NoError(t, err)
+
+	_, err = conn.Write([]byte("GET / HTTP/1.1\r\nHost: example.com\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n\r\n"))
+	require.NoError(t, err)
+
+	expected := "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n\r\n"
+	response := make([]byte, len(expected))
+	_, err = io.ReadFull(conn, response)
+	require.NoError(t, err)
+	require.Equal(t, expected, string(response))
+	return conn
+}
+
 func Test_Server_reportsServeErrors(t *testing.T) {
 	errChan := make(chan error, 1)
 	server := NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), errChan)
@@ -311,3 +462,342 @@ func Test_bufferPool(t *testing.T) {
 		pool.Get()
 	})
 }
+
+// newTestCA creates a CA certificate and key for issuing client certificates in tests.
+func newTestCA(t *testing.T) (*x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return cert, key
+}
+
+// newTestClientCertificate issues a client certificate with the given common name from the CA.
+func newTestClientCertificate(t *testing.T, ca *x509.Certificate, caKey *ecdsa.PrivateKey, name string) tls.Certificate {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca, &key.PublicKey, caKey)
+	require.NoError(t, err)
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// startClientAuthServer starts a HTTPS server with an open route and one requiring client certificates, both
+// proxying to an upstream that reports the client certificate subject it was given.
+func startClientAuthServer(t *testing.T, mode proxy.ClientAuth, ca *x509.Certificate) string {
+	t.Helper()
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "Subject: %s", r.Header.Get("X-Client-Cert-Subject"))
+	}))
+	t.Cleanup(upstream.Close)
+
+	certificate := httptest.NewUnstartedServer(nil)
+	certificate.StartTLS()
+	certificate.Close()
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca)
+
+	manager := proxy.NewManager(&fakeCertificateProvider{certificate: &certificate.TLS.Certificates[0]})
+	require.NoError(t, manager.SetRoutes(t.Context(), []*proxy.Route{
+		{
+			Domains:   []string{"open.example.com"},
+			Upstreams: []proxy.Upstream{{Host: upstream.Listener.Addr().String()}},
+		},
+		{
+			Domains:    []string{"secure.example.com"},
+			Upstreams:  []proxy.Upstream{{Host: upstream.Listener.Addr().String()}},
+			ClientAuth: mode,
+			ClientCAs:  pool,
+		},
+	}, nil))
+
+	ctx := &Context{
+		Manager:  manager,
+		Rewriter: proxy.NewRewriter(manager, nil),
+		Recorder: metrics.NewRecorder(manager.RouteForDomain),
+		ErrChan:  make(chan error, 1),
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	server := ctx.newServer(ctx.createProxy())
+	go server.StartTLS(listener, ctx.createTLSConfig())
+	t.Cleanup(func() { server.Stop(t.Context()) })
+
+	return listener.Addr().String()
+}
+
+// getWithClientCertificate makes a request to the given host over a connection made to serverName, optionally
+// presenting a client certificate.
+func getWithClientCertificate(address, serverName, host string, certs ...tls.Certificate) (int, string, error) {
+	client := newFixedAddressClient(address)
+	transport := client.Transport.(*http.Transport)
+	transport.TLSClientConfig.ServerName = serverName
+	transport.TLSClientConfig.Certificates = certs
+	defer client.CloseIdleConnections()
+
+	request, err := http.NewRequest(http.MethodGet, "https://"+host+"/", nil)
+	if err != nil {
+		return 0, "", err
+	}
+	request.Header.Set("X-Client-Cert-Subject", "CN=forged")
+
+	response, err := client.Do(request)
+	if err != nil {
+		return 0, "", err
+	}
+	defer response.Body.Close()
+
+	body, err := io.ReadAll(response.Body)
+	return response.StatusCode, string(body), err
+}
+
+func Test_Context_CreateTLSConfig_requiresClientCertificates(t *testing.T) {
+	ca, caKey := newTestCA(t)
+	otherCA, otherCAKey := newTestCA(t)
+	address := startClientAuthServer(t, proxy.ClientAuthRequired, ca)
+
+	_, _, err := getWithClientCertificate(address, "secure.example.com", "secure.example.com")
+	assert.Error(t, err, "request without a certificate should fail")
+
+	_, _, err = getWithClientCertificate(address, "secure.example.com", "secure.example.com", newTestClientCertificate(t, otherCA, otherCAKey, "client"))
+	assert.Error(t, err, "request with a certificate from another CA should fail")
+
+	status, body, err := getWithClientCertificate(address, "secure.example.com", "secure.example.com", newTestClientCertificate(t, ca, caKey, "client"))
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "Subject: CN=client", body)
+}
+
+func Test_Context_CreateTLSConfig_optionalClientCertificates(t *testing.T) {
+	ca, caKey := newTestCA(t)
+	address := startClientAuthServer(t, proxy.ClientAuthOptional, ca)
+
+	status, body, err := getWithClientCertificate(address, "secure.example.com", "secure.example.com")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "Subject: ", body)
+
+	status, body, err = getWithClientCertificate(address, "secure.example.com", "secure.example.com", newTestClientCertificate(t, ca, caKey, "client"))
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "Subject: CN=client", body)
+}
+
+func Test_Context_CreateTLSConfig_doesNotRequestClientCertificatesForOtherRoutes(t *testing.T) {
+	ca, caKey := newTestCA(t)
+	address := startClientAuthServer(t, proxy.ClientAuthRequired, ca)
+
+	status, body, err := getWithClientCertificate(address, "open.example.com", "open.example.com", newTestClientCertificate(t, ca, caKey, "client"))
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "Subject: ", body)
+}
+
+func Test_Context_CreateProxy_rejectsRequestsForClientAuthRoutesOverOtherConnections(t *testing.T) {
+	ca, caKey := newTestCA(t)
+	address := startClientAuthServer(t, proxy.ClientAuthRequired, ca)
+
+	status, _, err := getWithClientCertificate(address, "open.example.com", "secure.example.com", newTestClientCertificate(t, ca, caKey, "client"))
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusMisdirectedRequest, status)
+}
+
+func Test_Context_CreateProxy_doesNotPassClientCertificatesToOtherRoutes(t *testing.T) {
+	ca, caKey := newTestCA(t)
+	address := startClientAuthServer(t, proxy.ClientAuthRequired, ca)
+
+	status, body, err := getWithClientCertificate(address, "secure.example.com", "open.example.com", newTestClientCertificate(t, ca, caKey, "client"))
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "Subject: ", body)
+}
+
+// startTLSProfileServer starts a HTTPS server with routes using each of the TLS profiles, and a route that
+// uses the default.
+func startTLSProfileServer(t *testing.T, defaultProfile proxy.TLSProfile) string {
+	t.Helper()
+
+	certificate := httptest.NewUnstartedServer(nil)
+	certificate.StartTLS()
+	certificate.Close()
+
+	manager := proxy.NewManager(&fakeCertificateProvider{certificate: &certificate.TLS.Certificates[0]})
+	require.NoError(t, manager.SetRoutes(t.Context(), []*proxy.Route{
+		{Domains: []string{"default.example.com"}},
+		{Domains: []string{"modern.example.com"}, TLSProfile: proxy.TLSProfileModern},
+		{Domains: []string{"intermediate.example.com"}, TLSProfile: proxy.TLSProfileIntermediate},
+		{Domains: []string{"old.example.com"}, TLSProfile: proxy.TLSProfileOld},
+	}, nil))
+
+	ctx := &Context{
+		Manager:    manager,
+		Rewriter:   proxy.NewRewriter(manager, nil),
+		Recorder:   metrics.NewRecorder(manager.RouteForDomain),
+		ErrChan:    make(chan error, 1),
+		TLSProfile: defaultProfile,
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	server := ctx.newServer(ctx.createProxy())
+	go server.StartTLS(listener, ctx.createTLSConfig())
+	t.Cleanup(func() { server.Stop(t.Context()) })
+
+	return listener.Addr().String()
+}
+
+// handshake attempts a TLS handshake with the given server name, restricted to the given maximum version and
+// cipher suite (if non-zero).
+func handshake(address, serverName string, maxVersion uint16, cipherSuite uint16) error {
+	config := &tls.Config{
+		ServerName:         serverName,
+		InsecureSkipVerify: true,
+		MinVersion:         tls.VersionTLS10,
+		MaxVersion:         maxVersion,
+	}
+	if cipherSuite != 0 {
+		config.CipherSuites = []uint16{cipherSuite}
+	}
+
+	conn, err := tls.Dial("tcp", address, config)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+func Test_Context_CreateTLSConfig_appliesRouteTLSProfiles(t *testing.T) {
+	address := startTLSProfileServer(t, "")
+
+	tests := []struct {
+		serverName  string
+		maxVersion  uint16
+		cipherSuite uint16
+		accepted    bool
+	}{
+		{"default.example.com", tls.VersionTLS13, 0, true},
+		{"default.example.com", tls.VersionTLS12, tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, true},
+		{"default.example.com", tls.VersionTLS12, tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA, false},
+		{"default.example.com", tls.VersionTLS11, 0, false},
+		{"intermediate.example.com", tls.VersionTLS12, tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, true},
+		{"intermediate.example.com", tls.VersionTLS11, 0, false},
+		{"modern.example.com", tls.VersionTLS13, 0, true},
+		{"modern.example.com", tls.VersionTLS12, tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, false},
+		{"old.example.com", tls.VersionTLS13, 0, true},
+		{"old.example.com", tls.VersionTLS12, tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA, true},
+		{"old.example.com", tls.VersionTLS10, tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s %s %s", tt.serverName, tls.VersionName(tt.maxVersion), tls.CipherSuiteName(tt.cipherSuite)), func(t *testing.T) {
+			err := handshake(address, tt.serverName, tt.maxVersion, tt.cipherSuite)
+			if tt.accepted {
+				assert.NoError(t, err)
+			} else {
+				assert.Error(t, err)
+			}
+		})
+	}
+}
+
+func Test_Context_CreateTLSConfig_usesDefaultTLSProfile(t *testing.T) {
+	address := startTLSProfileServer(t, proxy.TLSProfileModern)
+
+	assert.NoError(t, handshake(address, "default.example.com", tls.VersionTLS13, 0))
+	assert.Error(t, handshake(address, "default.example.com", tls.VersionTLS12, 0))
+	assert.NoError(t, handshake(address, "intermediate.example.com", tls.VersionTLS12, 0))
+}
+
+func Test_Context_CreateProxy_rejectsRequestsForRoutesWithOtherTLSProfilesOverOtherConnections(t *testing.T) {
+	address := startTLSProfileServer(t, "")
+
+	get := func(serverName, host string) int {
+		client := newFixedAddressClient(address)
+		transport := client.Transport.(*http.Transport)
+		transport.TLSClientConfig.ServerName = serverName
+		transport.TLSClientConfig.MinVersion = tls.VersionTLS10
+		transport.TLSClientConfig.MaxVersion = tls.VersionTLS12
+		defer client.CloseIdleConnections()
+
+		response, err := client.Get("https://" + host + "/")
+		require.NoError(t, err)
+		defer response.Body.Close()
+		return response.StatusCode
+	}
+
+	assert.Equal(t, http.StatusMisdirectedRequest, get("old.example.com", "modern.example.com"))
+	assert.Equal(t, http.StatusMisdirectedRequest, get("old.example.com", "default.example.com"))
+	assert.NotEqual(t, http.StatusMisdirectedRequest, get("old.example.com", "old.example.com"))
+	assert.NotEqual(t, http.StatusMisdirectedRequest, get("intermediate.example.com", "default.example.com"))
+}
+
+type fakeTlsAlpnChallenges map[string]*tls.Certificate
+
+func (f fakeTlsAlpnChallenges) TlsAlpnChallengeCertificate(domain string) (*tls.Certificate, bool) {
+	cert, ok := f[domain]
+	return cert, ok
+}
+
+func Test_Context_CreateTLSConfig_servesTlsAlpnChallenges(t *testing.T) {
+	ca, caKey := newTestCA(t)
+	challengeCert := newTestClientCertificate(t, ca, caKey, "challenge")
+
+	ctx := newTestContext(t, &proxy.Route{Domains: []string{"example.com"}})
+	ctx.TlsAlpnChallenges = fakeTlsAlpnChallenges{"example.com": &challengeCert}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	server := ctx.newServer(http.NotFoundHandler())
+	go server.StartTLS(listener, ctx.createTLSConfig())
+	defer server.Stop(t.Context())
+
+	conn, err := tls.Dial("tcp", listener.Addr().String(), &tls.Config{
+		ServerName:         "example.com",
+		NextProtos:         []string{acmeTLSProtocol},
+		InsecureSkipVerify: true,
+	})
+	require.NoError(t, err)
+	defer conn.Close()
+
+	assert.Equal(t, acmeTLSProtocol, conn.ConnectionState().NegotiatedProtocol)
+	assert.Equal(t, challengeCert.Certificate[0], conn.ConnectionState().PeerCertificates[0].Raw)
+
+	_, err = tls.Dial("tcp", listener.Addr().String(), &tls.Config{
+		ServerName:         "other.example.com",
+		NextProtos:         []string{acmeTLSProtocol},
+		InsecureSkipVerify: true,
+	})
+	assert.Error(t, err, "handshake should fail if there's no challenge for the domain")
+}
@@ -0,0 +1,224 @@
+package frontend
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/csmith/centauri/proxy"
+)
+
+const (
+	// clientHelloTimeout is how long we'll wait for a client to send its ClientHello before giving up on it.
+	clientHelloTimeout = time.Second * 5
+	// upstreamDialTimeout is how long we'll wait to connect to the upstream for passthrough and stream routes.
+	upstreamDialTimeout = time.Second * 10
+)
+
+// errClientHelloRead is used to abort the handshake once we've read the ClientHello.
+var errClientHelloRead = errors.New("client hello read")
+
+// passthroughRouter is the surface used by passthroughListener to find routes that require passthrough.
+type passthroughRouter interface {
+	PassthroughRoute(domain string) *proxy.Route
+}
+
+// startTLSWithPassthrough starts the server listening on the given listener, like StartTLS, but first reads the
+// ClientHello from each connection. Connections for passthrough routes are spliced directly to an upstream
+// without TLS being terminated; all others are handed to the server as normal.
+//
+// Spliced connections are tracked like hijacked connections, so they are drained when the server is stopped.
+func (s *Server) startTLSWithPassthrough(listener net.Listener, config *tls.Config, router passthroughRouter) {
+	s.serve(tls.NewListener(newPassthroughListener(s.connections.wrap(listener), router, s.connections), config))
+}
+
+// passthroughListener wraps a listener, peeking at the ClientHello sent on each connection before it is
+// returned by Accept. ClientHellos are read concurrently so that slow clients can't hold up other connections.
+type passthroughListener struct {
+	net.Listener
+	router  passthroughRouter
+	tracker *connectionTracker
+
+	conns     chan net.Conn
+	errs      chan error
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func newPassthroughListener(listener net.Listener, router passthroughRouter, tracker *connectionTracker) *passthroughListener {
+	p := &passthroughListener{
+		Listener: listener,
+		router:   router,
+		tracker:  tracker,
+		conns:    make(chan net.Conn),
+		errs:     make(chan error),
+		closed:   make(chan struct{}),
+	}
+	go p.acceptLoop()
+	return p
+}
+
+func (p *passthroughListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-p.conns:
+		return conn, nil
+	case err := <-p.errs:
+		return nil, err
+	case <-p.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+func (p *passthroughListener) Close() error {
+	p.closeOnce.Do(func() {
+		close(p.closed)
+	})
+	return p.Listener.Close()
+}
+
+// acceptLoop accepts connections from the underlying listener until it returns a permanent error.
+func (p *passthroughListener) acceptLoop() {
+	for {
+		conn, err := p.Listener.Accept()
+		if err != nil {
+			select {
+			case p.errs <- err:
+			case <-p.closed:
+				return
+			}
+
+			// http.Server will keep calling Accept after temporary errors, so we should too.
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				continue
+			}
+			return
+		}
+
+		go p.handle(conn)
+	}
+}
+
+// handle reads the ClientHello from the connection, and either splices it to an upstream or queues it to be
+// returned from Accept.
+func (p *passthroughListener) handle(conn net.Conn) {
+	serverName, hello, err := readClientHello(conn)
+	if err != nil {
+		// Let the TLS server deal with whatever the client actually sent.
+		slog.Debug("Unable to read client hello", "remote", conn.RemoteAddr(), "error", err)
+	} else if route := p.router.PassthroughRoute(serverName); route != nil {
+		p.splice(conn, hello, route)
+		return
+	}
+
+	replayed := &replayConn{Conn: conn, reader: io.MultiReader(bytes.NewReader(hello), conn)}
+	select {
+	case p.conns <- replayed:
+	case <-p.closed:
+		_ = conn.Close()
+	}
+}
+
+// splice connects to an upstream for the given route, replays the client hello, and then copies data in both
+// directions until one side closes the connection.
+func (p *passthroughListener) splice(conn net.Conn, hello []byte, route *proxy.Route) {
+	p.tracker.hijack(conn)
+
+	host := selectUpstream(route)
+	upstream, err := net.DialTimeout("tcp", host, upstreamDialTimeout)
+	if err != nil {
+		slog.Warn("Failed to connect to passthrough upstream", "route", route.Domains[0], "upstream", host, "error", err)
+		_ = conn.Close()
+		return
+	}
+
+	if _, err := upstream.Write(hello); err != nil {
+		slog.Warn("Failed to send client hello to passthrough upstream", "route", route.Domains[0], "upstream", host, "error", err)
+		_ = conn.Close()
+		_ = upstream.Close()
+		return
+	}
+
+	pipe(conn, upstream)
+}
+
+// pipe copies data in both directions between the two connections until either side is finished, and then
+// closes both of them.
+func pipe(a, b net.Conn) {
+	defer a.Close()
+	defer b.Close()
+
+	done := make(chan struct{}, 2)
+	go func() {
+		_, _ = io.Copy(a, b)
+		done <- struct{}{}
+	}()
+	go func() {
+		_, _ = io.Copy(b, a)
+		done <- struct{}{}
+	}()
+
+	// Once either side is finished, the deferred closes will cause the other copy to finish too.
+	<-done
+}
+
+// selectUpstream selects an upstream host from the given route at random.
+func selectUpstream(route *proxy.Route) string {
+	return route.Upstreams[rand.IntN(len(route.Upstreams))].Host
+}
+
+// readClientHello reads a TLS ClientHello from the given connection, returning the server name the client
+// requested along with all the bytes that were read from the connection.
+func readClientHello(conn net.Conn) (string, []byte, error) {
+	if err := conn.SetReadDeadline(time.Now().Add(clientHelloTimeout)); err != nil {
+		return "", nil, err
+	}
+	defer conn.SetReadDeadline(time.Time{})
+
+	recorder := &recordingConn{Conn: conn}
+
+	var serverName string
+	err := tls.Server(recorder, &tls.Config{
+		GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			serverName = hello.ServerName
+			return nil, errClientHelloRead
+		},
+	}).Handshake()
+
+	if !errors.Is(err, errClientHelloRead) {
+		return "", recorder.buffer.Bytes(), err
+	}
+	return serverName, recorder.buffer.Bytes(), nil
+}
+
+// recordingConn is a connection that records everything read from it, and discards anything written to it.
+type recordingConn struct {
+	net.Conn
+	buffer bytes.Buffer
+}
+
+func (r *recordingConn) Read(b []byte) (int, error) {
+	n, err := r.Conn.Read(b)
+	r.buffer.Write(b[:n])
+	return n, err
+}
+
+func (r *recordingConn) Write(b []byte) (int, error) {
+	return len(b), nil
+}
+
+// replayConn is a connection that replays previously-read data before reading from the underlying connection.
+type replayConn struct {
+	net.Conn
+	reader io.Reader
+}
+
+func (r *replayConn) Read(b []byte) (int, error) {
+	return r.reader.Read(b)
+}
@@ -0,0 +1,161 @@
+package frontend
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/csmith/centauri/proxy"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// startPassthroughServer starts a server that serves a static response over TLS, passing through any
+// connections for the given routes.
+func startPassthroughServer(t *testing.T, certificate tls.Certificate, routes ...*proxy.Route) (*Server, string) {
+	t.Helper()
+
+	manager := proxy.NewManager(nil)
+	require.NoError(t, manager.SetRoutes(t.Context(), routes, nil))
+
+	server := NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "Hello from centauri")
+	}), make(chan error, 1))
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go server.startTLSWithPassthrough(listener, &tls.Config{Certificates: []tls.Certificate{certificate}}, manager)
+
+	return server, listener.Addr().String()
+}
+
+// newFixedAddressClient creates a HTTP client that connects to the given address for all requests.
+func newFixedAddressClient(address string) *http.Client {
+	return &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, network, address)
+			},
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		},
+	}
+}
+
+func Test_Passthrough_splicesConnectionsForPassthroughRoutes(t *testing.T) {
+	upstream := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "Hello from upstream, you asked for %s", r.TLS.ServerName)
+	}))
+	defer upstream.Close()
+
+	server, address := startPassthroughServer(t, upstream.TLS.Certificates[0], &proxy.Route{
+		Domains:     []string{"passthrough.example.com"},
+		Upstreams:   []proxy.Upstream{{Host: upstream.Listener.Addr().String()}},
+		Passthrough: true,
+	})
+	defer server.Stop(t.Context())
+
+	client := newFixedAddressClient(address)
+	defer client.CloseIdleConnections()
+
+	response, err := client.Get("https://passthrough.example.com/")
+	require.NoError(t, err)
+	defer response.Body.Close()
+
+	body, err := io.ReadAll(response.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "Hello from upstream, you asked for passthrough.example.com", string(body))
+}
+
+func Test_Passthrough_servesOtherRoutesNormally(t *testing.T) {
+	upstream := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "Hello from upstream")
+	}))
+	defer upstream.Close()
+
+	server, address := startPassthroughServer(t, upstream.TLS.Certificates[0], &proxy.Route{
+		Domains:     []string{"passthrough.example.com"},
+		Upstreams:   []proxy.Upstream{{Host: upstream.Listener.Addr().String()}},
+		Passthrough: true,
+	})
+	defer server.Stop(t.Context())
+
+	client := newFixedAddressClient(address)
+	defer client.CloseIdleConnections()
+
+	response, err := client.Get("https://example.com/")
+	require.NoError(t, err)
+	defer response.Body.Close()
+
+	body, err := io.ReadAll(response.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "Hello from centauri", string(body))
+}
+
+func Test_Passthrough_closesConnectionIfUpstreamUnreachable(t *testing.T) {
+	upstream := httptest.NewTLSServer(http.NotFoundHandler())
+	upstream.Close()
+
+	server, address := startPassthroughServer(t, upstream.TLS.Certificates[0], &proxy.Route{
+		Domains:     []string{"passthrough.example.com"},
+		Upstreams:   []proxy.Upstream{{Host: upstream.Listener.Addr().String()}},
+		Passthrough: true,
+	})
+	defer server.Stop(t.Context())
+
+	client := newFixedAddressClient(address)
+	defer client.CloseIdleConnections()
+
+	_, err := client.Get("https://passthrough.example.com/")
+	assert.Error(t, err)
+}
+
+func Test_Passthrough_killsSplicedConnectionsThatDoNotDrain(t *testing.T) {
+	upstream, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer upstream.Close()
+
+	go func() {
+		conn, err := upstream.Accept()
+		if err == nil {
+			// Hold the connection open without ever completing the handshake
+			_, _ = io.Copy(io.Discard, conn)
+		}
+	}()
+
+	cert := httptest.NewUnstartedServer(nil)
+	cert.StartTLS()
+	cert.Close()
+
+	server, address := startPassthroughServer(t, cert.TLS.Certificates[0], &proxy.Route{
+		Domains:     []string{"passthrough.example.com"},
+		Upstreams:   []proxy.Upstream{{Host: upstream.Addr().String()}},
+		Passthrough: true,
+	})
+	server.drainTimeout = drainPollInterval * 2
+	killed := make(map[string]int)
+	server.onKilled = func(kind string, count int) { killed[kind] += count }
+
+	conn, err := net.Dial("tcp", address)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	handshakeErr := make(chan error, 1)
+	go func() {
+		handshakeErr <- tls.Client(conn, &tls.Config{ServerName: "passthrough.example.com"}).Handshake()
+	}()
+
+	// Wait for the connection to be spliced
+	require.Eventually(t, func() bool {
+		return len(server.connections.upgraded()) == 1
+	}, 2*time.Second, drainPollInterval)
+
+	server.Stop(t.Context())
+	assert.Equal(t, map[string]int{"upgraded": 1}, killed)
+	assert.Error(t, <-handshakeErr)
+}
@@ -0,0 +1,372 @@
+package frontend
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/csmith/centauri/proxy"
+)
+
+const (
+	// udpSessionTimeout is how long a UDP session can be idle before we forget about it.
+	udpSessionTimeout = time.Minute * 2
+	// maxDatagramSize is the largest UDP datagram we'll forward.
+	maxDatagramSize = 65535
+)
+
+// streamKey identifies the port a stream listens on.
+type streamKey struct {
+	port     int
+	protocol proxy.StreamProtocol
+}
+
+// streamForwarder listens on the ports required by stream routes, forwarding connections and packets to the
+// routes' upstreams. The listeners are updated whenever the manager's routes change.
+type streamForwarder struct {
+	manager      *proxy.Manager
+	tlsConfig    func(route *proxy.Route) *tls.Config
+	listen       func(port int) (net.Listener, error)
+	listenPacket func(port int) ([]net.PacketConn, error)
+	connections  *connectionTracker
+	drainTimeout time.Duration
+	onKilled     func(kind string, count int)
+
+	// updateMu serialises updates, so that listeners can be opened without holding mu.
+	updateMu sync.Mutex
+
+	mu      sync.Mutex
+	streams map[streamKey]*stream
+	stopped bool
+}
+
+// newStreamForwarder creates a forwarder that uses the given funcs to listen for TCP connections and UDP
+// packets. UDP packets may be received on several connections for the same port (e.g. one per address family).
+// It won't start listening until start is called.
+func (fc *Context) newStreamForwarder(listen func(port int) (net.Listener, error), listenPacket func(port int) ([]net.PacketConn, error)) *streamForwarder {
+	f := &streamForwarder{
+		manager:      fc.Manager,
+		tlsConfig:    fc.tlsConfigForRoute,
+		listen:       listen,
+		listenPacket: listenPacket,
+		connections:  newConnectionTracker(),
+		drainTimeout: shutdownTimeout,
+		onKilled:     fc.Recorder.TrackKilledConnections,
+		streams:      make(map[streamKey]*stream),
+	}
+	if fc.DrainTimeout > 0 {
+		f.drainTimeout = fc.DrainTimeout
+	}
+	return f
+}
+
+// start begins listening for the current stream routes, and subscribes to any future changes. Later updates
+// happen in the background, as opening a listener may block (e.g. while waiting to connect to a tailnet), and
+// routes shouldn't be held up by that.
+func (f *streamForwarder) start() {
+	f.manager.OnRoutesChanged(func() { go f.update() })
+	f.update()
+}
+
+// update starts and stops listeners so that they match the manager's current stream routes. Streams that
+// already exist keep their listener, but pick up any changes to their route.
+func (f *streamForwarder) update() {
+	f.updateMu.Lock()
+	defer f.updateMu.Unlock()
+
+	routes := f.manager.StreamRoutes()
+	wanted := make(map[streamKey]*proxy.Route)
+	for i := range routes {
+		wanted[streamKey{port: routes[i].Stream.Port, protocol: routes[i].Stream.Protocol}] = routes[i]
+	}
+
+	f.mu.Lock()
+	if f.stopped {
+		f.mu.Unlock()
+		return
+	}
+
+	for key, s := range f.streams {
+		if _, ok := wanted[key]; !ok {
+			slog.Info("Stopping stream", "port", key.port, "protocol", key.protocol)
+			s.close()
+			delete(f.streams, key)
+		}
+	}
+
+	missing := make(map[streamKey]*proxy.Route)
+	for key, route := range wanted {
+		if s, ok := f.streams[key]; ok {
+			s.route.Store(route)
+		} else {
+			missing[key] = route
+		}
+	}
+	f.mu.Unlock()
+
+	for key, route := range missing {
+		s, err := f.startStream(key, route)
+		if err != nil {
+			slog.Error("Failed to start stream", "route", route.Domains[0], "port", key.port, "protocol", key.protocol, "error", err)
+			continue
+		}
+
+		f.mu.Lock()
+		if f.stopped {
+			f.mu.Unlock()
+			s.close()
+			return
+		}
+		f.streams[key] = s
+		f.mu.Unlock()
+
+		slog.Info("Started stream", "route", route.Domains[0], "port", key.port, "protocol", key.protocol)
+	}
+}
+
+func (f *streamForwarder) startStream(key streamKey, route *proxy.Route) (*stream, error) {
+	s := &stream{forwarder: f}
+	s.route.Store(route)
+
+	switch key.protocol {
+	case proxy.StreamProtocolTCP:
+		listener, err := f.listen(key.port)
+		if err != nil {
+			return nil, err
+		}
+		s.listener = f.connections.wrap(listener)
+		go s.serveTCP()
+	case proxy.StreamProtocolUDP:
+		conns, err := f.listenPacket(key.port)
+		if err != nil {
+			return nil, err
+		}
+		s.packetConns = conns
+		s.sessions = make(map[string]*udpSession)
+		for i := range conns {
+			go s.serveUDP(conns[i])
+		}
+	default:
+		return nil, fmt.Errorf("unknown protocol: %s", key.protocol)
+	}
+
+	return s, nil
+}
+
+// stop closes all listeners, and gives open TCP connections until the drain timeout to finish.
+func (f *streamForwarder) stop(ctx context.Context) {
+	f.mu.Lock()
+	f.stopped = true
+	for key, s := range f.streams {
+		s.close()
+		delete(f.streams, key)
+	}
+	f.mu.Unlock()
+
+	timeoutContext, cancel := context.WithTimeout(ctx, f.drainTimeout)
+	defer cancel()
+
+	f.connections.waitForUpgraded(timeoutContext)
+	if count := f.connections.closeUpgraded(); count > 0 {
+		slog.Warn("Forcibly closed connections that did not finish draining", "type", "stream", "count", count)
+		f.onKilled("stream", count)
+	}
+}
+
+// stream is a single port being forwarded for a route.
+type stream struct {
+	forwarder *streamForwarder
+	route     atomic.Pointer[proxy.Route]
+
+	listener net.Listener
+
+	packetConns []net.PacketConn
+	sessionsMu  sync.Mutex
+	sessions    map[string]*udpSession
+}
+
+// close stops listening. Open TCP connections are left alone, but UDP sessions are forgotten.
+func (s *stream) close() {
+	if s.listener != nil {
+		_ = s.listener.Close()
+	}
+
+	if s.packetConns != nil {
+		for i := range s.packetConns {
+			_ = s.packetConns[i].Close()
+		}
+
+		s.sessionsMu.Lock()
+		defer s.sessionsMu.Unlock()
+		for addr, session := range s.sessions {
+			_ = session.upstream.Close()
+			delete(s.sessions, addr)
+		}
+	}
+}
+
+// serveTCP accepts connections until the listener is closed.
+func (s *stream) serveTCP() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				continue
+			}
+			if !errors.Is(err, net.ErrClosed) {
+				slog.Error("Failed to accept stream connection", "route", s.route.Load().Domains[0], "error", err)
+			}
+			return
+		}
+
+		go s.handleTCP(conn)
+	}
+}
+
+// handleTCP terminates TLS on the connection if required, and then pipes it to an upstream.
+func (s *stream) handleTCP(conn net.Conn) {
+	s.forwarder.connections.hijack(conn)
+	route := s.route.Load()
+
+	if route.Stream.TLS {
+		tlsConn := tls.Server(conn, s.tlsConfig(route))
+		if err := s.handshake(tlsConn); err != nil {
+			slog.Debug("TLS handshake failed for stream", "route", route.Domains[0], "remote", conn.RemoteAddr(), "error", err)
+			_ = conn.Close()
+			return
+		}
+		conn = tlsConn
+	}
+
+	host := selectUpstream(route)
+	upstream, err := net.DialTimeout("tcp", host, upstreamDialTimeout)
+	if err != nil {
+		slog.Warn("Failed to connect to stream upstream", "route", route.Domains[0], "upstream", host, "error", err)
+		_ = conn.Close()
+		return
+	}
+
+	pipe(conn, upstream)
+}
+
+// tlsConfig returns a TLS config that always uses the route's certificate, whatever name the client asks for,
+// and verifies client certificates if the route requires them.
+func (s *stream) tlsConfig(route *proxy.Route) *tls.Config {
+	config := s.forwarder.tlsConfig(route)
+	config.NextProtos = nil
+	config.GetCertificate = func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+		if cert := route.CertificateFor(hello); cert != nil {
+			return cert, nil
+		}
+		return nil, fmt.Errorf("no certificate available for route %s", route.Domains[0])
+	}
+	return config
+}
+
+func (s *stream) handshake(conn *tls.Conn) error {
+	ctx, cancel := context.WithTimeout(context.Background(), clientHelloTimeout)
+	defer cancel()
+	return conn.HandshakeContext(ctx)
+}
+
+// serveUDP reads packets until the connection is closed, forwarding each one to the upstream session for the
+// client that sent it.
+func (s *stream) serveUDP(conn net.PacketConn) {
+	buffer := make([]byte, maxDatagramSize)
+	for {
+		n, addr, err := conn.ReadFrom(buffer)
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				slog.Error("Failed to read stream packet", "route", s.route.Load().Domains[0], "error", err)
+			}
+			return
+		}
+
+		session, err := s.session(conn, addr)
+		if err != nil {
+			slog.Warn("Failed to connect to stream upstream", "route", s.route.Load().Domains[0], "error", err)
+			continue
+		}
+
+		session.lastActive.Store(time.Now().UnixNano())
+		if _, err := session.upstream.Write(buffer[:n]); err != nil {
+			slog.Debug("Failed to forward packet to stream upstream", "route", s.route.Load().Domains[0], "error", err)
+		}
+	}
+}
+
+// session returns the session for the given client address, creating it if it doesn't exist. Replies are sent
+// from the connection the client's first packet was received on.
+func (s *stream) session(conn net.PacketConn, addr net.Addr) (*udpSession, error) {
+	s.sessionsMu.Lock()
+	defer s.sessionsMu.Unlock()
+
+	if session, ok := s.sessions[addr.String()]; ok {
+		return session, nil
+	}
+
+	upstream, err := net.DialTimeout("udp", selectUpstream(s.route.Load()), upstreamDialTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	session := &udpSession{conn: conn, client: addr, upstream: upstream}
+	session.lastActive.Store(time.Now().UnixNano())
+	s.sessions[addr.String()] = session
+	go s.serveSession(session)
+	return session, nil
+}
+
+// serveSession relays packets from the upstream back to the client, until the session has been idle for too
+// long or the upstream connection is closed.
+func (s *stream) serveSession(session *udpSession) {
+	defer func() {
+		s.sessionsMu.Lock()
+		if s.sessions[session.client.String()] == session {
+			delete(s.sessions, session.client.String())
+		}
+		s.sessionsMu.Unlock()
+		_ = session.upstream.Close()
+	}()
+
+	buffer := make([]byte, maxDatagramSize)
+	for {
+		deadline := time.Unix(0, session.lastActive.Load()).Add(udpSessionTimeout)
+		if err := session.upstream.SetReadDeadline(deadline); err != nil {
+			return
+		}
+
+		n, err := session.upstream.Read(buffer)
+		if err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() && time.Now().Before(time.Unix(0, session.lastActive.Load()).Add(udpSessionTimeout)) {
+				// The client has sent something since we set the deadline, so keep going.
+				continue
+			}
+			return
+		}
+
+		session.lastActive.Store(time.Now().UnixNano())
+		if _, err := session.conn.WriteTo(buffer[:n], session.client); err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			slog.Debug("Failed to forward packet to stream client", "route", s.route.Load().Domains[0], "error", err)
+		}
+	}
+}
+
+// udpSession associates a client with the connection to the upstream handling its packets.
+type udpSession struct {
+	conn       net.PacketConn
+	client     net.Addr
+	upstream   net.Conn
+	lastActive atomic.Int64
+}
@@ -0,0 +1,312 @@
+package frontend
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/csmith/centauri/metrics"
+	"github.com/csmith/centauri/proxy"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeCertificateProvider struct {
+	certificate *tls.Certificate
+}
+
+func (f *fakeCertificateProvider) GetCertificate(context.Context, string, string, string, []string) (*tls.Certificate, error) {
+	return f.certificate, nil
+}
+
+func (f *fakeCertificateProvider) GetExistingCertificate(string, string, string, []string) (*tls.Certificate, bool, error) {
+	return f.certificate, false, nil
+}
+
+// startTestStreams creates a stream forwarder that listens on random local ports, and returns a func to look
+// up the address used for a stream.
+func startTestStreams(t *testing.T, manager *proxy.Manager) (*streamForwarder, func(port int, protocol proxy.StreamProtocol) string) {
+	t.Helper()
+
+	ctx := &Context{
+		Manager:  manager,
+		Rewriter: proxy.NewRewriter(manager, nil),
+		Recorder: metrics.NewRecorder(manager.RouteForDomain),
+		ErrChan:  make(chan error, 1),
+	}
+
+	forwarder := ctx.newStreamForwarder(
+		func(int) (net.Listener, error) {
+			return net.Listen("tcp", "127.0.0.1:0")
+		},
+		func(int) ([]net.PacketConn, error) {
+			conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+			if err != nil {
+				return nil, err
+			}
+			return []net.PacketConn{conn}, nil
+		},
+	)
+	forwarder.start()
+	t.Cleanup(func() { forwarder.stop(context.Background()) })
+
+	return forwarder, func(port int, protocol proxy.StreamProtocol) string {
+		forwarder.mu.Lock()
+		defer forwarder.mu.Unlock()
+
+		s, ok := forwarder.streams[streamKey{port: port, protocol: protocol}]
+		if !ok {
+			return ""
+		}
+		if s.listener != nil {
+			return s.listener.Addr().String()
+		}
+		return s.packetConns[0].LocalAddr().String()
+	}
+}
+
+// startEchoServer starts a TCP server that sends back every line it receives, prefixed with "echo: ".
+func startEchoServer(t *testing.T) string {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				scanner := bufio.NewScanner(conn)
+				for scanner.Scan() {
+					_, _ = fmt.Fprintf(conn, "echo: %s\n", scanner.Text())
+				}
+			}()
+		}
+	}()
+
+	return listener.Addr().String()
+}
+
+func Test_Streams_forwardsTCPConnections(t *testing.T) {
+	upstream := startEchoServer(t)
+
+	manager := proxy.NewManager(nil)
+	require.NoError(t, manager.SetRoutes(t.Context(), []*proxy.Route{{
+		Domains:   []string{"db.example.com"},
+		Upstreams: []proxy.Upstream{{Host: upstream}},
+		Stream:    &proxy.Stream{Port: 5432, Protocol: proxy.StreamProtocolTCP},
+	}}, nil))
+
+	_, address := startTestStreams(t, manager)
+
+	conn, err := net.Dial("tcp", address(5432, proxy.StreamProtocolTCP))
+	require.NoError(t, err)
+	defer conn.Close()
+
+	_, err = fmt.Fprintln(conn, "hello")
+	require.NoError(t, err)
+
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "echo: hello\n", line)
+}
+
+func Test_Streams_terminatesTLS(t *testing.T) {
+	upstream := startEchoServer(t)
+
+	server := httptest.NewUnstartedServer(nil)
+	server.StartTLS()
+	server.Close()
+
+	manager := proxy.NewManager(&fakeCertificateProvider{certificate: &server.TLS.Certificates[0]})
+	require.NoError(t, manager.SetRoutes(t.Context(), []*proxy.Route{{
+		Domains:   []string{"db.example.com"},
+		Upstreams: []proxy.Upstream{{Host: upstream}},
+		Stream:    &proxy.Stream{Port: 5432, Protocol: proxy.StreamProtocolTCP, TLS: true},
+	}}, nil))
+
+	_, address := startTestStreams(t, manager)
+
+	conn, err := tls.Dial("tcp", address(5432, proxy.StreamProtocolTCP), &tls.Config{InsecureSkipVerify: true})
+	require.NoError(t, err)
+	defer conn.Close()
+
+	assert.Equal(t, server.TLS.Certificates[0].Certificate[0], conn.ConnectionState().PeerCertificates[0].Raw)
+
+	_, err = fmt.Fprintln(conn, "hello")
+	require.NoError(t, err)
+
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "echo: hello\n", line)
+}
+
+func Test_Streams_forwardsUDPPackets(t *testing.T) {
+	upstream, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer upstream.Close()
+
+	go func() {
+		buffer := make([]byte, 1024)
+		for {
+			n, addr, err := upstream.ReadFrom(buffer)
+			if err != nil {
+				return
+			}
+			_, _ = upstream.WriteTo(append([]byte("echo: "), buffer[:n]...), addr)
+		}
+	}()
+
+	manager := proxy.NewManager(nil)
+	require.NoError(t, manager.SetRoutes(t.Context(), []*proxy.Route{{
+		Domains:   []string{"dns.example.com"},
+		Upstreams: []proxy.Upstream{{Host: upstream.LocalAddr().String()}},
+		Stream:    &proxy.Stream{Port: 53, Protocol: proxy.StreamProtocolUDP},
+	}}, nil))
+
+	_, address := startTestStreams(t, manager)
+
+	conn, err := net.Dial("udp", address(53, proxy.StreamProtocolUDP))
+	require.NoError(t, err)
+	defer conn.Close()
+
+	for _, message := range []string{"one", "two"} {
+		_, err = conn.Write([]byte(message))
+		require.NoError(t, err)
+
+		buffer := make([]byte, 1024)
+		require.NoError(t, conn.SetReadDeadline(time.Now().Add(2*time.Second)))
+		n, err := conn.Read(buffer)
+		require.NoError(t, err)
+		assert.Equal(t, "echo: "+message, string(buffer[:n]))
+	}
+}
+
+func Test_Streams_updatesListenersWhenRoutesChange(t *testing.T) {
+	upstream := startEchoServer(t)
+
+	route := &proxy.Route{
+		Domains:   []string{"db.example.com"},
+		Upstreams: []proxy.Upstream{{Host: upstream}},
+		Stream:    &proxy.Stream{Port: 5432, Protocol: proxy.StreamProtocolTCP},
+	}
+
+	manager := proxy.NewManager(nil)
+	forwarder, address := startTestStreams(t, manager)
+	assert.Equal(t, "", address(5432, proxy.StreamProtocolTCP))
+
+	require.NoError(t, manager.SetRoutes(t.Context(), []*proxy.Route{route}, nil))
+	require.Eventually(t, func() bool {
+		return address(5432, proxy.StreamProtocolTCP) != ""
+	}, 2*time.Second, 10*time.Millisecond)
+	first := address(5432, proxy.StreamProtocolTCP)
+
+	// An unchanged stream keeps its existing listener
+	require.NoError(t, manager.SetRoutes(t.Context(), []*proxy.Route{route}, nil))
+	forwarder.update()
+	assert.Equal(t, first, address(5432, proxy.StreamProtocolTCP))
+
+	require.NoError(t, manager.SetRoutes(t.Context(), nil, nil))
+	require.Eventually(t, func() bool {
+		return address(5432, proxy.StreamProtocolTCP) == ""
+	}, 2*time.Second, 10*time.Millisecond)
+
+	_, err := net.Dial("tcp", first)
+	assert.Error(t, err)
+}
+
+func Test_Streams_killsConnectionsThatDoNotDrain(t *testing.T) {
+	upstream := startEchoServer(t)
+
+	manager := proxy.NewManager(nil)
+	require.NoError(t, manager.SetRoutes(t.Context(), []*proxy.Route{{
+		Domains:   []string{"db.example.com"},
+		Upstreams: []proxy.Upstream{{Host: upstream}},
+		Stream:    &proxy.Stream{Port: 5432, Protocol: proxy.StreamProtocolTCP},
+	}}, nil))
+
+	forwarder, address := startTestStreams(t, manager)
+	forwarder.drainTimeout = drainPollInterval * 2
+	killed := make(map[string]int)
+	forwarder.onKilled = func(kind string, count int) { killed[kind] += count }
+
+	conn, err := net.Dial("tcp", address(5432, proxy.StreamProtocolTCP))
+	require.NoError(t, err)
+	defer conn.Close()
+
+	require.Eventually(t, func() bool {
+		return len(forwarder.connections.upgraded()) == 1
+	}, 2*time.Second, drainPollInterval)
+
+	forwarder.stop(t.Context())
+	assert.Equal(t, map[string]int{"stream": 1}, killed)
+
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(2*time.Second)))
+	_, err = io.ReadAll(conn)
+	assert.NoError(t, err)
+}
+
+func Test_Streams_doesNotBlockRouteChangesWhileListening(t *testing.T) {
+	manager := proxy.NewManager(nil)
+	ctx := &Context{
+		Manager:  manager,
+		Rewriter: proxy.NewRewriter(manager, nil),
+		Recorder: metrics.NewRecorder(manager.RouteForDomain),
+		ErrChan:  make(chan error, 1),
+	}
+
+	release := make(chan struct{})
+	forwarder := ctx.newStreamForwarder(
+		func(int) (net.Listener, error) {
+			return net.Listen("tcp", "127.0.0.1:0")
+		},
+		func(int) ([]net.PacketConn, error) {
+			<-release
+			conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+			if err != nil {
+				return nil, err
+			}
+			return []net.PacketConn{conn}, nil
+		},
+	)
+	forwarder.start()
+	t.Cleanup(func() { forwarder.stop(context.Background()) })
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		require.NoError(t, manager.SetRoutes(t.Context(), []*proxy.Route{{
+			Domains:   []string{"dns.example.com"},
+			Upstreams: []proxy.Upstream{{Host: "127.0.0.1:53"}},
+			Stream:    &proxy.Stream{Port: 53, Protocol: proxy.StreamProtocolUDP},
+		}}, nil))
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("SetRoutes blocked while a stream listener was being opened")
+	}
+
+	forwarder.mu.Lock()
+	assert.Empty(t, forwarder.streams)
+	forwarder.mu.Unlock()
+
+	close(release)
+	require.Eventually(t, func() bool {
+		forwarder.mu.Lock()
+		defer forwarder.mu.Unlock()
+		return len(forwarder.streams) == 1
+	}, 2*time.Second, 10*time.Millisecond)
+}
@@ -4,15 +4,20 @@ package frontend
 
 import (
 	"context"
-	"crypto/tls"
 	"fmt"
 	"log/slog"
+	"net"
 	"net/http"
+	"net/netip"
+	"time"
 
 	"tailscale.com/client/local"
 	"tailscale.com/tsnet"
 )
 
+// tailscaleUpTimeout is how long we'll wait to connect to the tailnet when we need to know our own address.
+const tailscaleUpTimeout = time.Minute
+
 // NewTailscale creates the tailscale frontend, which joins a tailnet and listens for requests from it.
 func NewTailscale(options TailscaleOptions) (Frontend, error) {
 	return &tailscaleFrontend{options: options}, nil
@@ -22,6 +27,7 @@ type tailscaleFrontend struct {
 	options     TailscaleOptions
 	tlsServer   *Server
 	plainServer *Server
+	streams     *streamForwarder
 	tailscale   *tsnet.Server
 }
 
@@ -64,16 +70,52 @@ func (t *tailscaleFrontend) Serve(ctx *Context) error {
 		}
 	}
 
+	t.streams = ctx.newStreamForwarder(t.listenStream, t.listenPacketStream)
+	t.streams.start()
 	return nil
 }
 
+func (t *tailscaleFrontend) listenStream(port int) (net.Listener, error) {
+	return t.tailscale.Listen("tcp", fmt.Sprintf(":%d", port))
+}
+
+// listenPacketStream listens for UDP packets on the given port, on each of our tailnet addresses. Unlike TCP,
+// tsnet requires UDP listeners to specify an address, so this waits until we're connected to the tailnet and
+// have been assigned one.
+func (t *tailscaleFrontend) listenPacketStream(port int) ([]net.PacketConn, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), tailscaleUpTimeout)
+	defer cancel()
+
+	status, err := t.tailscale.Up(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var conns []net.PacketConn
+	for _, ip := range status.TailscaleIPs {
+		conn, err := t.tailscale.ListenPacket("udp", netip.AddrPortFrom(ip, uint16(port)).String())
+		if err != nil {
+			for i := range conns {
+				_ = conns[i].Close()
+			}
+			return nil, err
+		}
+		conns = append(conns, conn)
+	}
+
+	if len(conns) == 0 {
+		return nil, fmt.Errorf("no tailscale addresses available")
+	}
+	return conns, nil
+}
+
 func (t *tailscaleFrontend) startHttpServer(ctx *Context, handler http.Handler) error {
 	listener, err := t.tailscale.Listen("tcp", ":80")
 	if err != nil {
 		return err
 	}
 
-	t.plainServer = NewServer(handler, ctx.ErrChan)
+	t.plainServer = ctx.newServer(handler)
 	go t.plainServer.Start(listener)
 	return nil
 }
@@ -84,8 +126,8 @@ func (t *tailscaleFrontend) startHttpsServer(ctx *Context) error {
 		return err
 	}
 
-	t.tlsServer = NewServer(ctx.createProxy(), ctx.ErrChan)
-	go t.tlsServer.Start(tls.NewListener(tlsListener, ctx.createTLSConfig()))
+	t.tlsServer = ctx.newServer(ctx.createProxy())
+	go t.tlsServer.startTLSWithPassthrough(tlsListener, ctx.createTLSConfig(), ctx.Manager)
 	return nil
 }
 
@@ -96,6 +138,9 @@ func (t *tailscaleFrontend) Stop(ctx context.Context) {
 	if t.tlsServer != nil {
 		t.tlsServer.Stop(ctx)
 	}
+	if t.streams != nil {
+		t.streams.stop(ctx)
+	}
 	_ = t.tailscale.Close()
 }
 
@@ -4,7 +4,6 @@ package frontend
 
 import (
 	"context"
-	"crypto/tls"
 	"fmt"
 	"log/slog"
 	"net"
@@ -20,31 +19,47 @@ type tcpFrontend struct {
 	httpsPort   int
 	tlsServer   *Server
 	plainServer *Server
+	streams     *streamForwarder
 }
 
 func (t *tcpFrontend) Serve(ctx *Context) error {
 	slog.Info("Starting TCP server", "httpsPort", t.httpsPort, "httpPort", t.httpPort, "frontend", "tcp")
 
-	tlsListener, err := tls.Listen("tcp", fmt.Sprintf(":%d", t.httpsPort), ctx.createTLSConfig())
+	tlsListener, err := net.Listen("tcp", fmt.Sprintf(":%d", t.httpsPort))
 	if err != nil {
 		return err
 	}
-	t.tlsServer = NewServer(ctx.createProxy(), ctx.ErrChan)
-	go t.tlsServer.Start(tlsListener)
+	t.tlsServer = ctx.newServer(ctx.createProxy())
+	go t.tlsServer.startTLSWithPassthrough(tlsListener, ctx.createTLSConfig(), ctx.Manager)
 
 	plainListener, err := net.Listen("tcp", fmt.Sprintf(":%d", t.httpPort))
 	if err != nil {
 		return err
 	}
 
-	t.plainServer = NewServer(ctx.createRedirector(), ctx.ErrChan)
+	t.plainServer = ctx.newServer(ctx.createRedirector())
 	go t.plainServer.Start(plainListener)
+
+	t.streams = ctx.newStreamForwarder(
+		func(port int) (net.Listener, error) {
+			return net.Listen("tcp", fmt.Sprintf(":%d", port))
+		},
+		func(port int) ([]net.PacketConn, error) {
+			conn, err := net.ListenPacket("udp", fmt.Sprintf(":%d", port))
+			if err != nil {
+				return nil, err
+			}
+			return []net.PacketConn{conn}, nil
+		},
+	)
+	t.streams.start()
 	return nil
 }
 
 func (t *tcpFrontend) Stop(ctx context.Context) {
 	t.tlsServer.Stop(ctx)
 	t.plainServer.Stop(ctx)
+	t.streams.stop(ctx)
 }
 
 func (t *tcpFrontend) UsesCertificates() bool {
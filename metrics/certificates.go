@@ -0,0 +1,54 @@
+package metrics
+
+import (
+	"crypto/x509"
+	"log/slog"
+
+	"github.com/csmith/centauri/proxy"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var certificateExpiryDesc = prometheus.NewDesc(
+	"centauri_certificate_expiry_timestamp_seconds",
+	"The time at which the certificate served for a route expires, in seconds since the epoch",
+	[]string{"route"},
+	nil,
+)
+
+// certificateCollector reports the expiry of the certificates currently held by each route.
+type certificateCollector struct {
+	routes func() []*proxy.Route
+}
+
+func (c *certificateCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- certificateExpiryDesc
+}
+
+func (c *certificateCollector) Collect(ch chan<- prometheus.Metric) {
+	routes := c.routes()
+	for i := range routes {
+		route := routes[i]
+		cert := route.Certificate()
+		if cert == nil || len(cert.Certificate) == 0 || route.CertificateStatus() == proxy.CertificatePending {
+			continue
+		}
+
+		leaf := cert.Leaf
+		if leaf == nil {
+			var err error
+			if leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+				continue
+			}
+		}
+
+		ch <- prometheus.MustNewConstMetric(certificateExpiryDesc, prometheus.GaugeValue, float64(leaf.NotAfter.Unix()), route.Domains[0])
+	}
+}
+
+// TrackCertificates reports the expiry time of the certificates held by the routes returned by the given
+// function, each time metrics are collected.
+func (r *Recorder) TrackCertificates(routes func() []*proxy.Route) {
+	if err := r.registry.Register(&certificateCollector{routes: routes}); err != nil {
+		slog.Error("Failed to register certificate collector", "error", err)
+	}
+}
@@ -0,0 +1,60 @@
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/csmith/centauri/proxy"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeCertificateProvider struct {
+	certificates map[string]*tls.Certificate
+}
+
+func (f *fakeCertificateProvider) GetCertificate(_ context.Context, _ string, _ string, subject string, _ []string) (*tls.Certificate, error) {
+	if cert, ok := f.certificates[subject]; ok {
+		return cert, nil
+	}
+	return nil, fmt.Errorf("no certificate")
+}
+
+func (f *fakeCertificateProvider) GetExistingCertificate(_ string, _ string, subject string, _ []string) (*tls.Certificate, bool, error) {
+	if cert, ok := f.certificates[subject]; ok {
+		return cert, false, nil
+	}
+	return nil, false, fmt.Errorf("no certificate")
+}
+
+func Test_Recorder_TracksCertificateExpiry(t *testing.T) {
+	manager := proxy.NewManager(&fakeCertificateProvider{
+		certificates: map[string]*tls.Certificate{
+			"example.com": {
+				Certificate: [][]byte{{0}},
+				Leaf:        &x509.Certificate{NotAfter: time.Unix(1700000000, 0)},
+			},
+		},
+	})
+
+	require.NoError(t, manager.SetRoutes(context.Background(), []*proxy.Route{
+		{Domains: []string{"example.com"}},
+		{Domains: []string{"example.net"}},
+	}, nil))
+
+	rec := NewRecorder(manager.RouteForDomain)
+	rec.TrackCertificates(manager.Routes)
+
+	expected := `# HELP centauri_certificate_expiry_timestamp_seconds The time at which the certificate served for a route expires, in seconds since the epoch
+# TYPE centauri_certificate_expiry_timestamp_seconds gauge
+centauri_certificate_expiry_timestamp_seconds{route="example.com"} 1.7e+09
+`
+
+	assert.NoError(t, testutil.CollectAndCompare(rec.registry, bytes.NewBufferString(expected), "centauri_certificate_expiry_timestamp_seconds"))
+}
@@ -9,6 +9,7 @@ import (
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"log/slog"
 	"net/http"
+	"sync/atomic"
 )
 
 // Recorder provides methods to track metrics for requests
@@ -17,6 +18,9 @@ type Recorder struct {
 	registry        *prometheus.Registry
 	helloCounter    *prometheus.CounterVec
 	responseCounter *prometheus.CounterVec
+	killedCounter   *prometheus.CounterVec
+	drainingGauge   prometheus.Gauge
+	draining        atomic.Bool
 }
 
 // NewRecorder creates a new Recorder that will use the given function to map
@@ -35,6 +39,16 @@ func NewRecorder(routeForDomain func(domain string) *proxy.Route) *Recorder {
 			Name: "centauri_response_total",
 			Help: "The total number of HTTP responses sent to clients",
 		}, []string{"route", "status"}),
+
+		killedCounter: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "centauri_connections_killed_total",
+			Help: "The total number of connections forcibly closed because they did not finish draining at shutdown",
+		}, []string{"type"}),
+
+		drainingGauge: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "centauri_draining",
+			Help: "Whether Centauri is draining connections prior to shutting down",
+		}),
 	}
 	r.registerMetrics()
 	return r
@@ -51,6 +65,14 @@ func (r *Recorder) registerMetrics() {
 		slog.Error("Failed to register response counter", "error", err)
 	}
 
+	if err := r.registry.Register(r.killedCounter); err != nil {
+		slog.Error("Failed to register killed connections counter", "error", err)
+	}
+
+	if err := r.registry.Register(r.drainingGauge); err != nil {
+		slog.Error("Failed to register draining gauge", "error", err)
+	}
+
 	// Prometheus-supplied general process metrics
 	if err := r.registry.Register(collectors.NewProcessCollector(collectors.ProcessCollectorOpts{})); err != nil {
 		slog.Error("Failed to register process collector", "error", err)
@@ -69,6 +91,31 @@ func (r *Recorder) Handler() http.Handler {
 	)
 }
 
+// ReadinessHandler returns a HTTP handler that reports whether Centauri is ready to receive traffic. It
+// responds with a 200 status normally, and a 503 once Centauri has started draining.
+func (r *Recorder) ReadinessHandler() http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if r.draining.Load() {
+			writer.WriteHeader(http.StatusServiceUnavailable)
+			_, _ = writer.Write([]byte("draining\n"))
+		} else {
+			_, _ = writer.Write([]byte("ready\n"))
+		}
+	})
+}
+
+// SetDraining records that Centauri is about to shut down, causing the readiness handler to report that it is
+// no longer ready to receive traffic.
+func (r *Recorder) SetDraining() {
+	r.draining.Store(true)
+	r.drainingGauge.Set(1)
+}
+
+// TrackKilledConnections records connections of the given type that were forcibly closed during shutdown.
+func (r *Recorder) TrackKilledConnections(kind string, count int) {
+	r.killedCounter.With(prometheus.Labels{"type": kind}).Add(float64(count))
+}
+
 // TrackBadGateway wraps the ErrorHandler field of httputil.ReverseProxy,
 // recording a response with an implied 502 status code.
 func (r *Recorder) TrackBadGateway(fn func(http.ResponseWriter, *http.Request, error)) func(http.ResponseWriter, *http.Request, error) {
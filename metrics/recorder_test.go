@@ -129,3 +129,36 @@ centauri_tls_hello_total{known="true"} 2
 
 	assert.NoError(t, testutil.CollectAndCompare(rec.registry, bytes.NewBufferString(expected), "centauri_tls_hello_total"))
 }
+
+func Test_Recorder_TracksKilledConnections(t *testing.T) {
+	rec := NewRecorder(func(domain string) *proxy.Route { return nil })
+
+	rec.TrackKilledConnections("http", 2)
+	rec.TrackKilledConnections("upgraded", 1)
+	rec.TrackKilledConnections("http", 1)
+
+	expected := `# HELP centauri_connections_killed_total The total number of connections forcibly closed because they did not finish draining at shutdown
+# TYPE centauri_connections_killed_total counter
+centauri_connections_killed_total{type="http"} 3
+centauri_connections_killed_total{type="upgraded"} 1
+`
+
+	assert.NoError(t, testutil.CollectAndCompare(rec.registry, bytes.NewBufferString(expected), "centauri_connections_killed_total"))
+}
+
+func Test_Recorder_ReadinessReflectsDraining(t *testing.T) {
+	rec := NewRecorder(func(domain string) *proxy.Route { return nil })
+	handler := rec.ReadinessHandler()
+
+	writer := &fakeResponseWriter{header: make(http.Header)}
+	handler.ServeHTTP(writer, &http.Request{})
+	assert.Equal(t, 0, writer.statusCode)
+	assert.Equal(t, float64(0), testutil.ToFloat64(rec.drainingGauge))
+
+	rec.SetDraining()
+
+	writer = &fakeResponseWriter{header: make(http.Header)}
+	handler.ServeHTTP(writer, &http.Request{})
+	assert.Equal(t, http.StatusServiceUnavailable, writer.statusCode)
+	assert.Equal(t, float64(1), testutil.ToFloat64(rec.drainingGauge))
+}
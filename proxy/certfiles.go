@@ -0,0 +1,87 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"log/slog"
+	"time"
+)
+
+// certificateFileMinValidity is the remaining validity below which a certificate loaded from files is reported
+// as expiring soon. Centauri can't renew these itself, so this is just a warning for whoever supplies them.
+const certificateFileMinValidity = time.Hour * 24 * 14
+
+// UsesCertificateFiles indicates whether the route's certificate is loaded from files on disk, rather than
+// being obtained from a certificate provider.
+func (r *Route) UsesCertificateFiles() bool {
+	return r.CertificateFile != "" && r.KeyFile != ""
+}
+
+// loadCertificateFiles loads the route's certificate and key from disk. If the files can't be loaded, any
+// certificate previously loaded for the route continues to be used, as the files may be part-way through being
+// replaced.
+func (m *Manager) loadCertificateFiles(route *Route) {
+	cert, err := tls.LoadX509KeyPair(route.CertificateFile, route.KeyFile)
+	if err != nil {
+		slog.Error("Failed to load certificate files", "route", route.Domains[0], "certificate", route.CertificateFile, "key", route.KeyFile, "error", err)
+		if route.Certificate() == nil {
+			route.setCertificateStatus(CertificateMissing)
+		}
+		return
+	}
+
+	if cert.Leaf == nil {
+		if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+			slog.Error("Failed to parse certificate file", "route", route.Domains[0], "certificate", route.CertificateFile, "error", err)
+			return
+		}
+	}
+
+	if time.Now().After(cert.Leaf.NotAfter) {
+		slog.Error("Certificate loaded from file has expired, route will not be served", "route", route.Domains[0], "certificate", route.CertificateFile, "expired", cert.Leaf.NotAfter)
+		route.setCertificate(nil)
+		route.setCertificateStatus(CertificateMissing)
+		return
+	}
+
+	if existing := route.Certificate(); existing == nil || !bytes.Equal(existing.Certificate[0], cert.Certificate[0]) {
+		slog.Info("Loaded certificate from files", "route", route.Domains[0], "certificate", route.CertificateFile, "expires", cert.Leaf.NotAfter)
+		route.setCertificate(&cert)
+	}
+
+	if time.Now().Add(certificateFileMinValidity).After(cert.Leaf.NotAfter) {
+		slog.Warn("Certificate loaded from file expires soon", "route", route.Domains[0], "certificate", route.CertificateFile, "expires", cert.Leaf.NotAfter)
+		route.setCertificateStatus(CertificateExpiringSoon)
+	} else {
+		route.setCertificateStatus(CertificateGood)
+	}
+}
+
+// CheckCertificateFiles reloads the certificates for all routes that load them from files, picking up any
+// changes made to the files.
+func (m *Manager) CheckCertificateFiles() {
+	routes := m.routes.Routes()
+	for i := range routes {
+		if routes[i].RequiresCertificate() && routes[i].UsesCertificateFiles() {
+			m.loadCertificateFiles(routes[i])
+		}
+	}
+}
+
+// MonitorCertificateFiles periodically calls CheckCertificateFiles until the context is cancelled.
+// It blocks and is intended to be run in its own goroutine.
+func (m *Manager) MonitorCertificateFiles(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			m.CheckCertificateFiles()
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package proxy
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeCertificateFiles writes a self-signed certificate expiring at the given time, and its key, to files in
+// the given directory.
+func writeCertificateFiles(t *testing.T, dir string, serial int64, notAfter time.Time) (string, string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "example.com"},
+		DNSNames:     []string{"example.com"},
+		NotBefore:    time.Now().Add(-time.Hour * 24 * 365),
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	certPath := filepath.Join(dir, "cert.pem")
+	keyPath := filepath.Join(dir, "key.pem")
+	require.NoError(t, os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	require.NoError(t, os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600))
+	return certPath, keyPath
+}
+
+func Test_Manager_SetRoutes_loadsCertificateFiles(t *testing.T) {
+	certPath, keyPath := writeCertificateFiles(t, t.TempDir(), 1, time.Now().Add(time.Hour*24*90))
+	provider := &fakeCertManager{existingErr: os.ErrNotExist}
+	manager := NewManager(provider)
+
+	route := &Route{Domains: []string{"example.com"}, CertificateFile: certPath, KeyFile: keyPath}
+	require.NoError(t, manager.SetRoutes(context.Background(), []*Route{route}, nil))
+
+	cert, err := manager.CertificateForClient(&tls.ClientHelloInfo{ServerName: "example.com"})
+	require.NoError(t, err)
+	require.NotNil(t, cert)
+	assert.Equal(t, int64(1), cert.Leaf.SerialNumber.Int64())
+	assert.Equal(t, CertificateGood, route.CertificateStatus())
+}
+
+func Test_Manager_CheckCertificates_doesNotUseProviderForCertificateFiles(t *testing.T) {
+	certPath, keyPath := writeCertificateFiles(t, t.TempDir(), 1, time.Now().Add(time.Hour*24*90))
+	provider := &fakeCertManager{existingErr: os.ErrNotExist, err: os.ErrNotExist}
+	manager := NewManager(provider)
+
+	route := &Route{Domains: []string{"example.com"}, CertificateFile: certPath, KeyFile: keyPath}
+	require.NoError(t, manager.routes.Update([]*Route{route}))
+	manager.CheckCertificates(context.Background())
+
+	assert.Equal(t, "", provider.subject)
+	assert.NotNil(t, route.Certificate())
+	assert.Equal(t, CertificateGood, route.CertificateStatus())
+}
+
+func Test_Manager_SetRoutes_setsStatusIfCertificateFileExpiresSoon(t *testing.T) {
+	certPath, keyPath := writeCertificateFiles(t, t.TempDir(), 1, time.Now().Add(time.Hour*24))
+	manager := NewManager(&fakeCertManager{})
+
+	route := &Route{Domains: []string{"example.com"}, CertificateFile: certPath, KeyFile: keyPath}
+	require.NoError(t, manager.SetRoutes(context.Background(), []*Route{route}, nil))
+
+	assert.NotNil(t, route.Certificate())
+	assert.Equal(t, CertificateExpiringSoon, route.CertificateStatus())
+}
+
+func Test_Manager_SetRoutes_doesNotServeExpiredCertificateFiles(t *testing.T) {
+	certPath, keyPath := writeCertificateFiles(t, t.TempDir(), 1, time.Now().Add(-time.Hour))
+	manager := NewManager(&fakeCertManager{})
+
+	route := &Route{Domains: []string{"example.com"}, CertificateFile: certPath, KeyFile: keyPath}
+	require.NoError(t, manager.SetRoutes(context.Background(), []*Route{route}, nil))
+
+	assert.Nil(t, route.Certificate())
+	assert.Equal(t, CertificateMissing, route.CertificateStatus())
+}
+
+func Test_Manager_SetRoutes_setsStatusIfCertificateFilesMissing(t *testing.T) {
+	dir := t.TempDir()
+	manager := NewManager(&fakeCertManager{})
+
+	route := &Route{Domains: []string{"example.com"}, CertificateFile: filepath.Join(dir, "cert.pem"), KeyFile: filepath.Join(dir, "key.pem")}
+	require.NoError(t, manager.SetRoutes(context.Background(), []*Route{route}, nil))
+
+	assert.Nil(t, route.Certificate())
+	assert.Equal(t, CertificateMissing, route.CertificateStatus())
+}
+
+func Test_Manager_CheckCertificateFiles_reloadsChangedFiles(t *testing.T) {
+	dir := t.TempDir()
+	certPath, keyPath := writeCertificateFiles(t, dir, 1, time.Now().Add(time.Hour*24*90))
+	manager := NewManager(&fakeCertManager{})
+
+	route := &Route{Domains: []string{"example.com"}, CertificateFile: certPath, KeyFile: keyPath}
+	require.NoError(t, manager.SetRoutes(context.Background(), []*Route{route}, nil))
+
+	writeCertificateFiles(t, dir, 2, time.Now().Add(time.Hour*24*90))
+	manager.CheckCertificateFiles()
+
+	assert.Equal(t, int64(2), route.Certificate().Leaf.SerialNumber.Int64())
+}
+
+func Test_Manager_CheckCertificateFiles_keepsPreviousCertificateIfFilesCantBeLoaded(t *testing.T) {
+	dir := t.TempDir()
+	certPath, keyPath := writeCertificateFiles(t, dir, 1, time.Now().Add(time.Hour*24*90))
+	manager := NewManager(&fakeCertManager{})
+
+	route := &Route{Domains: []string{"example.com"}, CertificateFile: certPath, KeyFile: keyPath}
+	require.NoError(t, manager.SetRoutes(context.Background(), []*Route{route}, nil))
+
+	require.NoError(t, os.WriteFile(keyPath, []byte("garbage"), 0600))
+	manager.CheckCertificateFiles()
+
+	assert.Equal(t, int64(1), route.Certificate().Leaf.SerialNumber.Int64())
+	assert.Equal(t, CertificateGood, route.CertificateStatus())
+}
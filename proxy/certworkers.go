@@ -0,0 +1,130 @@
+package proxy
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// defaultCertificateWorkers is the number of certificates updated concurrently if SetCertificateWorkers isn't
+// called.
+const defaultCertificateWorkers = 4
+
+// SetCertificateWorkers sets the maximum number of certificates that will be updated concurrently when checking
+// certificates. Obtaining a certificate can take minutes (e.g. while waiting for DNS propagation), so checking
+// routes one at a time can leave new routes waiting a long time. Any rate limits imposed by the certificate
+// provider still apply. It must be called before routes are set.
+func (m *Manager) SetCertificateWorkers(workers int) {
+	m.certificateWorkers = max(workers, 1)
+}
+
+// groupByCertificate groups together routes that use the same certificate, so it's only updated once. Groups
+// containing a route that doesn't have a valid certificate are ordered before those that just need renewing;
+// otherwise the order of the routes is preserved.
+func (m *Manager) groupByCertificate(routes []*Route) [][]*Route {
+	var groups [][]*Route
+	indices := make(map[string]int)
+	for i := range routes {
+		key := m.certificateKey(routes[i])
+		if index, ok := indices[key]; ok {
+			groups[index] = append(groups[index], routes[i])
+		} else {
+			indices[key] = len(groups)
+			groups = append(groups, []*Route{routes[i]})
+		}
+	}
+
+	slices.SortStableFunc(groups, func(a, b []*Route) int {
+		return priority(a) - priority(b)
+	})
+	return groups
+}
+
+// priority returns the order in which a group of routes should have its certificate updated. Lower values
+// should be updated first.
+func priority(routes []*Route) int {
+	for i := range routes {
+		if routes[i].CertificateStatus() <= CertificatePending {
+			return 0
+		}
+	}
+	return 1
+}
+
+// certificateKey returns a key identifying the certificate used by the route. Routes with the same key can
+// share a certificate.
+func (m *Manager) certificateKey(route *Route) string {
+	primary, alts := route.CertificateNames()
+	names := make([]string, len(alts))
+	for i := range alts {
+		names[i] = strings.ToLower(alts[i])
+	}
+	slices.Sort(names)
+
+	keyTypes := make([]string, len(m.keyTypesFor(route)))
+	for i, keyType := range m.keyTypesFor(route) {
+		keyTypes[i] = string(keyType)
+	}
+
+	return strings.Join([]string{
+		route.Provider,
+		strings.Join(keyTypes, ","),
+		strings.ToLower(primary),
+		strings.Join(names, ","),
+	}, " ")
+}
+
+// updateCerts updates the certificates for each group of routes, using up to the configured number of workers.
+// It blocks until all groups have been updated. Groups that are already being updated (for example by SetRoutes
+// while the scheduler wakes for the same routes) aren't updated again; instead the worker waits for the existing
+// update to finish.
+func (m *Manager) updateCerts(ctx context.Context, groups [][]*Route) {
+	if len(groups) == 0 {
+		return
+	}
+
+	work := make(chan []*Route)
+	wg := &sync.WaitGroup{}
+	for range min(max(m.certificateWorkers, 1), len(groups)) {
+		wg.Go(func() {
+			for routes := range work {
+				m.updateCertOnce(ctx, routes)
+			}
+		})
+	}
+
+	for i := range groups {
+		work <- groups[i]
+	}
+	close(work)
+	wg.Wait()
+}
+
+// updateCertOnce updates the certificate for the given group of routes, unless an update for the same certificate
+// is already in progress, in which case it waits for that update to finish instead.
+func (m *Manager) updateCertOnce(ctx context.Context, routes []*Route) {
+	key := m.certificateKey(routes[0])
+
+	m.updatingLock.Lock()
+	if done, ok := m.updating[key]; ok {
+		m.updatingLock.Unlock()
+		select {
+		case <-done:
+		case <-ctx.Done():
+		}
+		return
+	}
+	done := make(chan struct{})
+	m.updating[key] = done
+	m.updatingLock.Unlock()
+
+	defer func() {
+		m.updatingLock.Lock()
+		delete(m.updating, key)
+		m.updatingLock.Unlock()
+		close(done)
+	}()
+
+	m.updateCert(ctx, routes)
+}
@@ -0,0 +1,181 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+	"testing/synctest"
+	"time"
+
+	"github.com/csmith/centauri/certificate"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// blockingCertManager is a CertificateProvider that blocks obtaining certificates until released, recording
+// how many requests are in progress.
+type blockingCertManager struct {
+	mu        sync.Mutex
+	release   chan struct{}
+	active    int
+	requested []string
+}
+
+func (b *blockingCertManager) GetCertificate(ctx context.Context, _ string, _ string, subject string, _ []string) (*tls.Certificate, error) {
+	b.mu.Lock()
+	b.active++
+	b.requested = append(b.requested, subject)
+	b.mu.Unlock()
+
+	select {
+	case <-b.release:
+	case <-ctx.Done():
+	}
+
+	b.mu.Lock()
+	b.active--
+	b.mu.Unlock()
+	return dummyCert, nil
+}
+
+func (b *blockingCertManager) GetExistingCertificate(_ string, _ string, _ string, _ []string) (*tls.Certificate, bool, error) {
+	return dummyCert, false, nil
+}
+
+func Test_Manager_CheckCertificates_limitsConcurrentUpdates(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		provider := &blockingCertManager{release: make(chan struct{})}
+		manager := NewManager(provider)
+		manager.SetCertificateWorkers(2)
+
+		routes := []*Route{
+			{Domains: []string{"one.example.com"}},
+			{Domains: []string{"two.example.com"}},
+			{Domains: []string{"three.example.com"}},
+			{Domains: []string{"four.example.com"}},
+		}
+		assert.NoError(t, manager.routes.Update(routes))
+
+		done := make(chan struct{})
+		go func() {
+			manager.CheckCertificates(t.Context())
+			close(done)
+		}()
+
+		synctest.Wait()
+		provider.mu.Lock()
+		assert.Equal(t, 2, provider.active)
+		provider.mu.Unlock()
+
+		close(provider.release)
+		<-done
+
+		assert.Len(t, provider.requested, 4)
+		for i := range routes {
+			assert.Equal(t, CertificateGood, routes[i].CertificateStatus())
+			assert.Equal(t, dummyCert, routes[i].Certificate())
+		}
+	})
+}
+
+func Test_Manager_CheckCertificates_updatesSharedCertificatesOnce(t *testing.T) {
+	provider := &blockingCertManager{release: make(chan struct{})}
+	close(provider.release)
+	manager := NewManager(provider)
+
+	first := &Route{Domains: []string{"example.com", "www.example.com", "example.net"}}
+	second := &Route{Domains: []string{"EXAMPLE.com", "example.net", "www.example.com"}}
+	third := &Route{Domains: []string{"other.example.com"}, Subject: []string{"example.com", "example.net", "www.example.com"}}
+	assert.NoError(t, manager.routes.Update([]*Route{first, second, third}))
+
+	manager.CheckCertificates(t.Context())
+
+	assert.Equal(t, []string{"example.com"}, provider.requested)
+	assert.Equal(t, dummyCert, first.Certificate())
+	assert.Equal(t, dummyCert, second.Certificate())
+	assert.Equal(t, dummyCert, third.Certificate())
+	assert.Equal(t, CertificateGood, third.CertificateStatus())
+}
+
+func Test_Manager_CheckCertificates_doesNotRepeatUpdatesInProgress(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		provider := &blockingCertManager{release: make(chan struct{})}
+		manager := NewManager(provider)
+
+		route := &Route{Domains: []string{"example.com"}}
+		assert.NoError(t, manager.SetRoutes(t.Context(), []*Route{route}, nil))
+		synctest.Wait()
+
+		done := make(chan struct{})
+		go func() {
+			manager.CheckCertificates(t.Context())
+			close(done)
+		}()
+
+		synctest.Wait()
+		close(provider.release)
+		<-done
+		synctest.Wait()
+
+		assert.Equal(t, []string{"example.com"}, provider.requested)
+		assert.Equal(t, CertificateGood, route.CertificateStatus())
+	})
+}
+
+func Test_Manager_groupByCertificate(t *testing.T) {
+	manager := NewManager(&fakeCertManager{})
+	manager.SetDefaultKeyTypes([]KeyType{KeyTypeECDSA})
+
+	renewing := &Route{Domains: []string{"example.com"}}
+	renewing.setCertificateStatus(CertificateExpiringSoon)
+	shared := &Route{Domains: []string{"example.com"}}
+	shared.setCertificateStatus(CertificateGood)
+	otherProvider := &Route{Domains: []string{"example.com"}, Provider: "selfsigned"}
+	otherProvider.setCertificateStatus(CertificateGood)
+	otherKeyType := &Route{Domains: []string{"example.com"}, KeyTypes: []KeyType{KeyTypeRSA}}
+	otherKeyType.setCertificateStatus(CertificateGood)
+	missing := &Route{Domains: []string{"new.example.com"}}
+	missing.setCertificateStatus(CertificateMissing)
+
+	groups := manager.groupByCertificate([]*Route{renewing, shared, otherProvider, otherKeyType, missing})
+	assert.Equal(t, [][]*Route{
+		{missing},
+		{renewing, shared},
+		{otherProvider},
+		{otherKeyType},
+	}, groups)
+}
+
+func Test_Manager_SetRoutes_obtainsCertificatesConcurrentlyFromJsonStore(t *testing.T) {
+	store, err := certificate.NewStore(filepath.Join(t.TempDir(), "certs.json"))
+	require.NoError(t, err)
+
+	provider := certificate.NewProvider(t.Context(), certificate.ProviderConfig{
+		Store:              store,
+		PreferredSuppliers: []string{"selfsigned"},
+	})
+	manager := NewManager(provider)
+	manager.SetCertificateWorkers(4)
+
+	var routes []*Route
+	for i := range 40 {
+		routes = append(routes, &Route{Domains: []string{fmt.Sprintf("%d.example.com", i)}})
+	}
+	require.NoError(t, manager.SetRoutes(t.Context(), routes, nil))
+
+	require.Eventually(t, func() bool {
+		for i := range routes {
+			if routes[i].CertificateStatus() != CertificateGood {
+				return false
+			}
+		}
+		return true
+	}, 30*time.Second, 10*time.Millisecond)
+
+	certs, err := store.Certificates()
+	require.NoError(t, err)
+	assert.Len(t, certs, 40)
+}
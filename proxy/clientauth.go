@@ -0,0 +1,142 @@
+package proxy
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// MisdirectedRequestHandler is a http.Handler that rejects requests for routes requiring client certificates, or
+// using a different TLS profile, if the TLS handshake was made for a different route. Clients may reuse a
+// connection for multiple hosts if the certificate covers them all, but client certificates are only verified
+// during the handshake, against the CAs of the route the client originally connected to, and the TLS version and
+// cipher suite are negotiated using that route's profile.
+type MisdirectedRequestHandler struct {
+	routeProvider  RouteProvider
+	defaultProfile TLSProfile
+	next           http.Handler
+}
+
+// NewMisdirectedRequestHandler creates a new MisdirectedRequestHandler which will obtain routes from the given
+// provider. Routes that don't specify a TLS profile are assumed to use the default profile. If the request was
+// made over an appropriate connection, it is passed to the `next` handler.
+func NewMisdirectedRequestHandler(provider RouteProvider, defaultProfile TLSProfile, next http.Handler) *MisdirectedRequestHandler {
+	return &MisdirectedRequestHandler{
+		routeProvider:  provider,
+		defaultProfile: defaultProfile,
+		next:           next,
+	}
+}
+
+func (m *MisdirectedRequestHandler) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	if request.TLS != nil {
+		host, _, err := net.SplitHostPort(request.Host)
+		if err != nil {
+			host = request.Host
+		}
+
+		route := m.routeProvider.RouteForDomain(host)
+		if handshake := handshakeRoute(m.routeProvider, request); route != nil && route != handshake {
+			if route.ClientAuth != ClientAuthNone {
+				slog.Debug("Rejecting request for route requiring client certificates made over another route's connection", "host", host, "serverName", request.TLS.ServerName)
+				writer.WriteHeader(http.StatusMisdirectedRequest)
+				return
+			}
+
+			if TLSProfileFor(route, m.defaultProfile) != TLSProfileFor(handshake, m.defaultProfile) {
+				slog.Debug("Rejecting request for route made over a connection using a different TLS profile", "host", host, "serverName", request.TLS.ServerName)
+				writer.WriteHeader(http.StatusMisdirectedRequest)
+				return
+			}
+		}
+	}
+
+	m.next.ServeHTTP(writer, request)
+}
+
+// handshakeRoute returns the route that the request's TLS connection was established for, if any.
+func handshakeRoute(provider RouteProvider, request *http.Request) *Route {
+	serverName := request.TLS.ServerName
+	if serverName == "" {
+		// Clients connecting to an IP address don't send a server name; their connection was made for the
+		// route matching the local address instead.
+		if addr, ok := request.Context().Value(http.LocalAddrContextKey).(net.Addr); ok {
+			serverName = localAddressName(addr)
+		}
+	}
+	return provider.RouteForDomain(serverName)
+}
+
+// clientCertificateHeaders are the headers used to pass details of verified client certificates to upstreams.
+var clientCertificateHeaders = []string{
+	"X-Client-Cert-Subject",
+	"X-Client-Cert-Issuer",
+	"X-Client-Cert-Fingerprint",
+	"X-Client-Cert-SANs",
+}
+
+type clientCertificateDecorator struct {
+	routeProvider RouteProvider
+}
+
+// NewClientCertificateDecorator creates a decorator that passes details of verified client certificates to
+// the upstream, in the X-Client-Cert-* headers. Routes are obtained from the given provider, and details are
+// only passed on for routes that require client certificates and were the route the connection was made for.
+func NewClientCertificateDecorator(provider RouteProvider) Decorator {
+	return &clientCertificateDecorator{routeProvider: provider}
+}
+
+func (c *clientCertificateDecorator) Decorate(in, out *http.Request) {
+	for i := range clientCertificateHeaders {
+		out.Header.Del(clientCertificateHeaders[i])
+	}
+
+	// Chains are only verified if the route requested client certificates, so unverified certificates
+	// presented to other routes are never passed on.
+	if in.TLS == nil || len(in.TLS.VerifiedChains) == 0 || len(in.TLS.VerifiedChains[0]) == 0 {
+		return
+	}
+
+	// A connection made to one route may be reused for another. The certificate was only verified against the
+	// CAs of the route the connection was made for, so it means nothing to any other route.
+	host, _, err := net.SplitHostPort(in.Host)
+	if err != nil {
+		host = in.Host
+	}
+	route := c.routeProvider.RouteForDomain(host)
+	if route == nil || route.ClientAuth == ClientAuthNone || route != handshakeRoute(c.routeProvider, in) {
+		return
+	}
+
+	cert := in.TLS.VerifiedChains[0][0]
+	fingerprint := sha256.Sum256(cert.Raw)
+
+	out.Header.Set("X-Client-Cert-Subject", cert.Subject.String())
+	out.Header.Set("X-Client-Cert-Issuer", cert.Issuer.String())
+	out.Header.Set("X-Client-Cert-Fingerprint", hex.EncodeToString(fingerprint[:]))
+	if sans := subjectAltNames(cert); len(sans) > 0 {
+		out.Header.Set("X-Client-Cert-SANs", strings.Join(sans, ", "))
+	}
+}
+
+// subjectAltNames returns all the alternate names in the certificate, prefixed with their type.
+func subjectAltNames(cert *x509.Certificate) []string {
+	var res []string
+	for i := range cert.DNSNames {
+		res = append(res, "DNS:"+cert.DNSNames[i])
+	}
+	for i := range cert.EmailAddresses {
+		res = append(res, "email:"+cert.EmailAddresses[i])
+	}
+	for i := range cert.IPAddresses {
+		res = append(res, "IP:"+cert.IPAddresses[i].String())
+	}
+	for i := range cert.URIs {
+		res = append(res, "URI:"+cert.URIs[i].String())
+	}
+	return res
+}
@@ -0,0 +1,222 @@
+package proxy
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"net"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_MisdirectedRequestHandler(t *testing.T) {
+	open := &Route{Domains: []string{"example.com"}}
+	secure := &Route{Domains: []string{"secure.example.com", "alt.example.com"}, ClientAuth: ClientAuthRequired}
+	optional := &Route{Domains: []string{"optional.example.com"}, ClientAuth: ClientAuthOptional}
+	address := &Route{Domains: []string{"203.0.113.10"}, ClientAuth: ClientAuthRequired}
+	modern := &Route{Domains: []string{"modern.example.com"}, TLSProfile: TLSProfileModern}
+	intermediate := &Route{Domains: []string{"intermediate.example.com"}, TLSProfile: TLSProfileIntermediate}
+	old := &Route{Domains: []string{"old.example.com"}, TLSProfile: TLSProfileOld}
+
+	provider := &mockRouteProvider{
+		routes: map[string]*Route{
+			"example.com":              open,
+			"secure.example.com":       secure,
+			"alt.example.com":          secure,
+			"optional.example.com":     optional,
+			"203.0.113.10":             address,
+			"modern.example.com":       modern,
+			"intermediate.example.com": intermediate,
+			"old.example.com":          old,
+		},
+	}
+
+	tests := []struct {
+		name       string
+		host       string
+		serverName string
+		localAddr  string
+		plain      bool
+		rejected   bool
+	}{
+		{"Open route over its own connection", "example.com", "example.com", "", false, false},
+		{"Open route over another connection", "example.com", "secure.example.com", "", false, false},
+		{"Secure route over its own connection", "secure.example.com", "secure.example.com", "", false, false},
+		{"Secure route with port over its own connection", "secure.example.com:443", "secure.example.com", "", false, false},
+		{"Secure route over a connection for another of its domains", "secure.example.com", "alt.example.com", "", false, false},
+		{"Secure route over another route's connection", "secure.example.com", "example.com", "", false, true},
+		{"Secure route over a connection without SNI", "secure.example.com", "", "", false, true},
+		{"Optional route over another route's connection", "optional.example.com", "example.com", "", false, true},
+		{"Unknown route", "unknown.example.com", "example.com", "", false, false},
+		{"Plain HTTP", "secure.example.com", "", "", true, false},
+		{"IP route over a connection to its address", "203.0.113.10", "", "203.0.113.10:443", false, false},
+		{"IP route over a connection to another address", "203.0.113.10", "", "203.0.113.11:443", false, true},
+		{"Modern route over an old route's connection", "modern.example.com", "old.example.com", "", false, true},
+		{"Old route over a default route's connection", "old.example.com", "example.com", "", false, true},
+		{"Modern route over a connection without SNI", "modern.example.com", "", "", false, true},
+		{"Default route over a connection for a route with the same profile", "example.com", "intermediate.example.com", "", false, false},
+		{"Open route over an old route's connection", "example.com", "old.example.com", "", false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u, _ := url.Parse("/foo/bar")
+			request := &http.Request{
+				URL:    u,
+				Header: make(http.Header),
+				Host:   tt.host,
+			}
+			if !tt.plain {
+				request.TLS = &tls.ConnectionState{ServerName: tt.serverName}
+			}
+			if tt.localAddr != "" {
+				addr, _ := net.ResolveTCPAddr("tcp", tt.localAddr)
+				request = request.WithContext(context.WithValue(request.Context(), http.LocalAddrContextKey, addr))
+			}
+
+			writer := &fakeResponseWriter{header: make(http.Header)}
+			nextHandler := &mockNextHandler{}
+
+			NewMisdirectedRequestHandler(provider, "", nextHandler).ServeHTTP(writer, request)
+
+			if tt.rejected {
+				assert.False(t, nextHandler.called)
+				assert.Equal(t, http.StatusMisdirectedRequest, writer.statusCode)
+			} else {
+				assert.True(t, nextHandler.called)
+				assert.Equal(t, 0, writer.statusCode)
+			}
+		})
+	}
+}
+
+func Test_MisdirectedRequestHandler_usesDefaultTLSProfile(t *testing.T) {
+	provider := &mockRouteProvider{
+		routes: map[string]*Route{
+			"example.com":        {Domains: []string{"example.com"}},
+			"modern.example.com": {Domains: []string{"modern.example.com"}, TLSProfile: TLSProfileModern},
+		},
+	}
+
+	request := &http.Request{
+		Header: make(http.Header),
+		Host:   "example.com",
+		TLS:    &tls.ConnectionState{ServerName: "modern.example.com"},
+	}
+
+	writer := &fakeResponseWriter{header: make(http.Header)}
+	nextHandler := &mockNextHandler{}
+	NewMisdirectedRequestHandler(provider, TLSProfileModern, nextHandler).ServeHTTP(writer, request)
+	assert.True(t, nextHandler.called)
+
+	writer = &fakeResponseWriter{header: make(http.Header)}
+	nextHandler = &mockNextHandler{}
+	NewMisdirectedRequestHandler(provider, TLSProfileOld, nextHandler).ServeHTTP(writer, request)
+	assert.False(t, nextHandler.called)
+	assert.Equal(t, http.StatusMisdirectedRequest, writer.statusCode)
+}
+
+// clientCertificateRoutes returns a route provider with a route requiring client certificates and one that
+// doesn't.
+func clientCertificateRoutes() *mockRouteProvider {
+	secure := &Route{Domains: []string{"secure.example.com", "alt.example.com"}, ClientAuth: ClientAuthRequired}
+	return &mockRouteProvider{
+		routes: map[string]*Route{
+			"secure.example.com": secure,
+			"alt.example.com":    secure,
+			"open.example.com":   {Domains: []string{"open.example.com"}},
+		},
+	}
+}
+
+func testClientCertificate() *x509.Certificate {
+	spiffe, _ := url.Parse("spiffe://example.com/client")
+	return &x509.Certificate{
+		Raw:            []byte("cert"),
+		Subject:        pkix.Name{CommonName: "client", Organization: []string{"Example"}},
+		Issuer:         pkix.Name{CommonName: "Example CA"},
+		DNSNames:       []string{"client.example.com"},
+		EmailAddresses: []string{"client@example.com"},
+		IPAddresses:    []net.IP{net.ParseIP("192.0.2.1")},
+		URIs:           []*url.URL{spiffe},
+	}
+}
+
+func Test_ClientCertificateDecorator_doesNothingWithoutVerifiedChains(t *testing.T) {
+	tests := []struct {
+		name  string
+		state *tls.ConnectionState
+	}{
+		{"Plain HTTP", nil},
+		{"No certificate", &tls.ConnectionState{ServerName: "secure.example.com"}},
+		{"Unverified certificate", &tls.ConnectionState{ServerName: "secure.example.com", PeerCertificates: []*x509.Certificate{{Raw: []byte("cert")}}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			in := &http.Request{Header: make(http.Header), Host: "secure.example.com", TLS: tt.state}
+			out := &http.Request{Header: make(http.Header)}
+
+			NewClientCertificateDecorator(clientCertificateRoutes()).Decorate(in, out)
+
+			assert.Empty(t, out.Header)
+		})
+	}
+}
+
+func Test_ClientCertificateDecorator_setsHeadersFromVerifiedCertificate(t *testing.T) {
+	in := &http.Request{
+		Header: make(http.Header),
+		Host:   "secure.example.com:443",
+		TLS: &tls.ConnectionState{
+			ServerName:     "alt.example.com",
+			VerifiedChains: [][]*x509.Certificate{{testClientCertificate()}},
+		},
+	}
+	out := &http.Request{Header: make(http.Header)}
+
+	NewClientCertificateDecorator(clientCertificateRoutes()).Decorate(in, out)
+
+	fingerprint := sha256.Sum256([]byte("cert"))
+	assert.Equal(t, "CN=client,O=Example", out.Header.Get("X-Client-Cert-Subject"))
+	assert.Equal(t, "CN=Example CA", out.Header.Get("X-Client-Cert-Issuer"))
+	assert.Equal(t, hex.EncodeToString(fingerprint[:]), out.Header.Get("X-Client-Cert-Fingerprint"))
+	assert.Equal(t, "DNS:client.example.com, email:client@example.com, IP:192.0.2.1, URI:spiffe://example.com/client", out.Header.Get("X-Client-Cert-SANs"))
+}
+
+func Test_ClientCertificateDecorator_stripsHeadersForOtherRoutes(t *testing.T) {
+	tests := []struct {
+		name       string
+		host       string
+		serverName string
+	}{
+		{"Open route over a client auth route's connection", "open.example.com", "secure.example.com"},
+		{"Client auth route over another route's connection", "secure.example.com", "open.example.com"},
+		{"Unknown route", "unknown.example.com", "secure.example.com"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			in := &http.Request{
+				Header: make(http.Header),
+				Host:   tt.host,
+				TLS: &tls.ConnectionState{
+					ServerName:     tt.serverName,
+					VerifiedChains: [][]*x509.Certificate{{testClientCertificate()}},
+				},
+			}
+			out := &http.Request{Header: make(http.Header)}
+			out.Header.Set("X-Client-Cert-Subject", "CN=forged")
+
+			NewClientCertificateDecorator(clientCertificateRoutes()).Decorate(in, out)
+
+			assert.Empty(t, out.Header)
+		})
+	}
+}
@@ -29,6 +29,11 @@ func NewBannedHeaderDecorator() Decorator {
 			"Tailscale-User-Login",
 			"Tailscale-User-Name",
 			"Tailscale-User-Profile-Pic",
+			// Details of the client certificate, which are only set by us if one has been verified.
+			"X-Client-Cert-Subject",
+			"X-Client-Cert-Issuer",
+			"X-Client-Cert-Fingerprint",
+			"X-Client-Cert-SANs",
 		},
 	}
 }
@@ -0,0 +1,60 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"net"
+	"net/netip"
+	"strings"
+)
+
+// isIPAddress checks if a string is an IPv4 or IPv6 address literal. Addresses with zones are rejected, as
+// they can't appear in certificates.
+func isIPAddress(s string) bool {
+	addr, err := netip.ParseAddr(s)
+	return err == nil && addr.Zone() == ""
+}
+
+// isHostName checks if a string is either a domain name or an IP address literal, and so can be used to
+// identify a route.
+func isHostName(s string) bool {
+	return isDomainName(s) || isIPAddress(s)
+}
+
+// normaliseHostName returns the canonical form of the given host name, for use when looking up routes.
+// Domain names are lower-cased, and IP addresses are converted to their shortest form without brackets.
+func normaliseHostName(s string) string {
+	if addr, err := netip.ParseAddr(strings.TrimSuffix(strings.TrimPrefix(s, "["), "]")); err == nil {
+		return addr.Unmap().String()
+	}
+	return strings.ToLower(s)
+}
+
+// urlHostName returns the given host name in the form it should take in a URL, i.e. with IPv6 addresses
+// enclosed in square brackets.
+func urlHostName(s string) string {
+	if addr, err := netip.ParseAddr(s); err == nil && addr.Is6() && !addr.Is4In6() {
+		return "[" + s + "]"
+	}
+	return s
+}
+
+// ServerNameForClient returns the name that the client is trying to connect to. This is normally the server
+// name given in the TLS handshake, but clients connecting to an IP address can't send that, so the local
+// address the client connected to is used instead.
+func ServerNameForClient(hello *tls.ClientHelloInfo) string {
+	if hello.ServerName != "" || hello.Conn == nil {
+		return hello.ServerName
+	}
+	return localAddressName(hello.Conn.LocalAddr())
+}
+
+// localAddressName returns the IP address of the given local address, or an empty string if it doesn't
+// have one.
+func localAddressName(addr net.Addr) string {
+	if tcpAddr, ok := addr.(*net.TCPAddr); ok {
+		if ip, ok := netip.AddrFromSlice(tcpAddr.IP); ok {
+			return ip.Unmap().String()
+		}
+	}
+	return ""
+}
@@ -0,0 +1,63 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeLocalAddrConn is a net.Conn that only reports its local address.
+type fakeLocalAddrConn struct {
+	net.Conn
+	addr string
+}
+
+func (f *fakeLocalAddrConn) LocalAddr() net.Addr {
+	addr, _ := net.ResolveTCPAddr("tcp", f.addr)
+	return addr
+}
+
+func Test_isHostName(t *testing.T) {
+	tests := []struct {
+		host string
+		want bool
+	}{
+		{"example.com", true},
+		{"203.0.113.10", true},
+		{"2001:db8::1", true},
+		{"::ffff:203.0.113.10", true},
+		{"[2001:db8::1]", false},
+		{"fe80::1%eth0", false},
+		{"203.0.113.10:443", false},
+		{"example..com", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.host, func(t *testing.T) {
+			assert.Equal(t, tt.want, isHostName(tt.host))
+		})
+	}
+}
+
+func Test_normaliseHostName(t *testing.T) {
+	assert.Equal(t, "example.com", normaliseHostName("ExAmPlE.CoM"))
+	assert.Equal(t, "203.0.113.10", normaliseHostName("203.0.113.10"))
+	assert.Equal(t, "203.0.113.10", normaliseHostName("::ffff:203.0.113.10"))
+	assert.Equal(t, "2001:db8::1", normaliseHostName("2001:DB8:0:0::1"))
+	assert.Equal(t, "2001:db8::1", normaliseHostName("[2001:db8::1]"))
+}
+
+func Test_urlHostName(t *testing.T) {
+	assert.Equal(t, "example.com", urlHostName("example.com"))
+	assert.Equal(t, "203.0.113.10", urlHostName("203.0.113.10"))
+	assert.Equal(t, "[2001:db8::1]", urlHostName("2001:db8::1"))
+}
+
+func Test_ServerNameForClient(t *testing.T) {
+	assert.Equal(t, "example.com", ServerNameForClient(&tls.ClientHelloInfo{ServerName: "example.com", Conn: &fakeLocalAddrConn{addr: "203.0.113.10:443"}}))
+	assert.Equal(t, "203.0.113.10", ServerNameForClient(&tls.ClientHelloInfo{Conn: &fakeLocalAddrConn{addr: "203.0.113.10:443"}}))
+	assert.Equal(t, "2001:db8::1", ServerNameForClient(&tls.ClientHelloInfo{Conn: &fakeLocalAddrConn{addr: "[2001:db8::1]:443"}}))
+	assert.Equal(t, "", ServerNameForClient(&tls.ClientHelloInfo{}))
+}
@@ -5,7 +5,6 @@ import (
 	"crypto/tls"
 	"fmt"
 	"log/slog"
-	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -13,8 +12,8 @@ import (
 
 // CertificateProvider defines the interface for providing certificates to a Manager.
 type CertificateProvider interface {
-	GetCertificate(ctx context.Context, preferredSupplier string, subject string, altNames []string) (*tls.Certificate, error)
-	GetExistingCertificate(preferredSupplier string, subject string, altNames []string) (*tls.Certificate, bool, error)
+	GetCertificate(ctx context.Context, preferredSupplier string, keyType string, subject string, altNames []string) (*tls.Certificate, error)
+	GetExistingCertificate(preferredSupplier string, keyType string, subject string, altNames []string) (*tls.Certificate, bool, error)
 }
 
 // Manager is responsible for maintaining a set of routes, mapping domains to those routes, and refreshing the
@@ -23,7 +22,17 @@ type Manager struct {
 	provider CertificateProvider
 	routes   routeMap
 	fallback *Route
+	onDemand *onDemand
 	lock     *sync.RWMutex
+
+	temporarySupplier  string
+	keyTypes           []KeyType
+	certificateWorkers int
+
+	updating     map[string]chan struct{}
+	updatingLock sync.Mutex
+
+	listeners []func()
 }
 
 // NewManager creates a new route provider. Routes should be set using the SetRoutes method after creation.
@@ -31,13 +40,22 @@ type Manager struct {
 // an error.
 func NewManager(provider CertificateProvider) *Manager {
 	return &Manager{
-		provider: provider,
-		lock:     &sync.RWMutex{},
+		provider:           provider,
+		lock:               &sync.RWMutex{},
+		certificateWorkers: defaultCertificateWorkers,
+		updating:           make(map[string]chan struct{}),
 	}
 }
 
-// SetRoutes replaces all previously registered routes with the given new routes. This func may block while new
-// certificates are obtained; during this time the old routes will continue to be served to avoid too much disruption.
+// SetRoutes replaces all previously registered routes with the given new routes. The new routes are served as soon
+// as it returns: any certificate already in the store is loaded straight away, and routes without one have the
+// CertificateMissing status (or CertificatePending, if temporary certificates are enabled) until their certificate
+// is obtained in the background by updateCerts.
+//
+// Routes that use the same certificate as one of the previous routes take over its certificate and status, rather
+// than loading it again. Certificates are only updated in the background for routes that are new or changed;
+// retrying carried-over certificates that need renewing or previously failed is left to MonitorCertificates or
+// ScheduleCertificates, so reloading the config doesn't bypass their backoff.
 //
 // If a fallback is specified, then that route will be used for any requests that don't otherwise a route.
 func (m *Manager) SetRoutes(ctx context.Context, newRoutes []*Route, fallback *Route) error {
@@ -47,38 +65,136 @@ func (m *Manager) SetRoutes(ctx context.Context, newRoutes []*Route, fallback *R
 		slog.Debug("Configuring proxy manager", "routes", len(newRoutes), "fallback", fallback != nil)
 	}
 
+	previous := m.previousCertificates()
+	var pending []*Route
+	reused := 0
 	for i := range newRoutes {
-		m.loadCertificate(newRoutes[i])
+		route := newRoutes[i]
+		if old, ok := previous[m.certificateKey(route)]; ok && m.obtainsCertificate(route) {
+			route.copyCertificates(old)
+			reused++
+			continue
+		}
+
+		m.loadCertificate(route)
+		if m.obtainsCertificate(route) {
+			pending = append(pending, route)
+		}
 	}
 
 	if err := m.routes.Update(newRoutes); err != nil {
 		return err
 	}
 
+	slog.Debug("Routes updated", "reusedCertificates", reused, "pendingCertificates", len(pending))
 	m.fallback = fallback
-	go m.CheckCertificates(ctx)
+	m.notifyListeners()
+	if len(pending) > 0 {
+		go m.updateCerts(ctx, m.groupByCertificate(pending))
+	}
 	return nil
 }
 
+// previousCertificates returns the currently registered routes that hold certificates from the provider, keyed
+// by their certificateKey.
+func (m *Manager) previousCertificates() map[string]*Route {
+	res := make(map[string]*Route)
+	routes := m.routes.Routes()
+	for i := range routes {
+		if m.obtainsCertificate(routes[i]) && routes[i].CertificateStatus() != CertificateNotChecked {
+			res[m.certificateKey(routes[i])] = routes[i]
+		}
+	}
+	return res
+}
+
+// obtainsCertificate indicates whether the route's certificate comes from the manager's provider.
+func (m *Manager) obtainsCertificate(route *Route) bool {
+	return m.provider != nil && route.RequiresCertificate() && !route.UsesCertificateFiles()
+}
+
+// EnableTemporaryCertificates causes routes without a valid certificate to be served using a certificate from
+// the named supplier (such as "selfsigned") until a valid one is obtained. The routes have the
+// CertificatePending status in the meantime. It must be called before routes are set.
+func (m *Manager) EnableTemporaryCertificates(supplier string) {
+	m.temporarySupplier = supplier
+}
+
+// SetDefaultKeyTypes sets the types of certificate obtained for routes that don't specify their own. The first
+// type is preferred when a client supports more than one. If not called, only ECDSA certificates are obtained.
+// It must be called before routes are set.
+func (m *Manager) SetDefaultKeyTypes(keyTypes []KeyType) {
+	m.keyTypes = keyTypes
+}
+
+// keyTypesFor returns the types of certificate that should be obtained for the route, in order of preference.
+func (m *Manager) keyTypesFor(route *Route) []KeyType {
+	if len(route.KeyTypes) > 0 {
+		return route.KeyTypes
+	}
+	if len(m.keyTypes) > 0 {
+		return m.keyTypes
+	}
+	return []KeyType{KeyTypeECDSA}
+}
+
+// OnRoutesChanged registers a func that will be called each time the routes are replaced by SetRoutes.
+func (m *Manager) OnRoutesChanged(fn func()) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.listeners = append(m.listeners, fn)
+}
+
+// notifyListeners calls each func registered with OnRoutesChanged.
+func (m *Manager) notifyListeners() {
+	m.lock.RLock()
+	listeners := m.listeners
+	m.lock.RUnlock()
+
+	for i := range listeners {
+		listeners[i]()
+	}
+}
+
 // loadCertificate attempts to load an existing certificate for use with the given route, to enable it to be served
 // immediately without waiting for certificate renewals.
 func (m *Manager) loadCertificate(route *Route) {
-	if m.provider == nil {
+	if m.provider == nil || !route.RequiresCertificate() {
 		route.setCertificateStatus(CertificateNotRequired)
 		return
 	}
 
+	if route.UsesCertificateFiles() {
+		m.loadCertificateFiles(route)
+		return
+	}
+
 	primary, alts := route.CertificateNames()
-	cert, needsRenewal, err := m.provider.GetExistingCertificate(route.Provider, primary, alts)
-	if err == nil {
-		route.setCertificate(cert)
+	var certs []*tls.Certificate
+	needsRenewal := false
+	for _, keyType := range m.keyTypesFor(route) {
+		cert, renew, err := m.provider.GetExistingCertificate(route.Provider, string(keyType), primary, alts)
+		if err != nil {
+			needsRenewal = true
+			continue
+		}
+		certs = append(certs, cert)
+		needsRenewal = needsRenewal || renew
+	}
+
+	if len(certs) > 0 {
+		route.setCertificates(certs)
 		if needsRenewal {
-			slog.Debug("Existing certificate found but it expires soon", "route", route.Domains[0])
+			slog.Debug("Existing certificate found but it needs renewing", "route", route.Domains[0])
 			route.setCertificateStatus(CertificateExpiringSoon)
 		} else {
 			slog.Debug("Existing certificate found", "route", route.Domains[0])
 			route.setCertificateStatus(CertificateGood)
 		}
+	} else if cert := m.temporaryCertificate(route); cert != nil {
+		slog.Info("No existing certificate found, serving a temporary certificate until one is obtained", "route", route.Domains[0])
+		route.setCertificate(cert)
+		route.setCertificateStatus(CertificatePending)
 	} else {
 		slog.Info("No existing certificate found, route will not be served until cert is obtained", "route", route.Domains[0])
 		route.setCertificate(nil)
@@ -86,12 +202,44 @@ func (m *Manager) loadCertificate(route *Route) {
 	}
 }
 
+// temporaryCertificate returns a certificate to serve for the route while waiting for a valid one, or nil if
+// temporary certificates aren't enabled or one couldn't be created.
+func (m *Manager) temporaryCertificate(route *Route) *tls.Certificate {
+	if m.temporarySupplier == "" || route.Provider == m.temporarySupplier {
+		return nil
+	}
+
+	primary, alts := route.CertificateNames()
+	cert, err := m.provider.GetCertificate(context.Background(), m.temporarySupplier, string(m.keyTypesFor(route)[0]), primary, alts)
+	if err != nil {
+		slog.Warn("Unable to create temporary certificate", "route", route.Domains[0], "error", err)
+		return nil
+	}
+	return cert
+}
+
 // RouteForDomain returns the previously-registered route for the given domain. If no routes match the domain,
-// nil is returned.
+// nil is returned. Passthrough routes are never returned, as they can't be used to serve HTTP requests.
 func (m *Manager) RouteForDomain(domain string) *Route {
 	route := m.routeFor(domain)
 
-	if route == nil || route.CertificateStatus() <= CertificateMissing {
+	if route == nil || route.Passthrough {
+		return nil
+	}
+
+	if route.CertificateStatus() <= CertificateMissing && !m.hasOnDemandCertificate(domain) {
+		return nil
+	}
+
+	return route
+}
+
+// PassthroughRoute returns the previously-registered route for the given domain if it is a passthrough route.
+// If no routes match the domain, or the matching route isn't a passthrough route, nil is returned.
+func (m *Manager) PassthroughRoute(domain string) *Route {
+	route := m.routeFor(domain)
+
+	if route == nil || !route.Passthrough {
 		return nil
 	}
 
@@ -99,18 +247,53 @@ func (m *Manager) RouteForDomain(domain string) *Route {
 }
 
 // CertificateForClient returns a certificate (if one exists) for the domain specified in the provided
-// client hello. If no certificate is available, nil is returned. The error return value is unused, but
+// client hello, or for the address the client connected to if it didn't specify a domain. If no certificate
+// is available, nil is returned. The error return value is unused, but
 // is kept to maintain compatibility with the tls.Config.GetCertificate func signature.
+//
+// If on-demand certificates are enabled and the domain would be served by the fallback route, this may block
+// while a certificate is obtained for it.
 func (m *Manager) CertificateForClient(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
 	if m.provider == nil {
 		return nil, fmt.Errorf("this manager does not support obtaining certificates")
 	}
 
-	route := m.routeFor(hello.ServerName)
+	route := m.routes.Get(ServerNameForClient(hello))
 	if route == nil {
+		route = m.fallback
+		if route != nil && !route.Passthrough && !route.UsesCertificateFiles() && m.onDemand != nil && hello.ServerName != "" {
+			ctx := hello.Context()
+			if ctx == nil {
+				ctx = context.Background()
+			}
+
+			if cert := m.onDemandCertificate(ctx, hello.ServerName, route); cert != nil {
+				return cert, nil
+			}
+		}
+	}
+
+	if route == nil || route.Passthrough {
 		return nil, nil
 	}
-	return route.Certificate(), nil
+	return route.CertificateFor(hello), nil
+}
+
+// Routes returns all of the previously-registered routes.
+func (m *Manager) Routes() []*Route {
+	return m.routes.Routes()
+}
+
+// StreamRoutes returns all of the previously-registered routes that define a stream.
+func (m *Manager) StreamRoutes() []*Route {
+	var res []*Route
+	routes := m.routes.Routes()
+	for i := range routes {
+		if routes[i].Stream != nil {
+			res = append(res, routes[i])
+		}
+	}
+	return res
 }
 
 // routeFor looks up a route to be used for the given domain. If there is no direct match and a fallback
@@ -124,17 +307,29 @@ func (m *Manager) routeFor(domain string) *Route {
 
 // CheckCertificates checks and updates the certificates required for registered routes.
 // It should be called periodically to renew certificates and obtain new OCSP staples.
+//
+// Certificates are updated concurrently, up to the number of workers configured with SetCertificateWorkers.
+// Routes without a valid certificate are updated before those that just need renewing.
 func (m *Manager) CheckCertificates(ctx context.Context) {
+	var pending []*Route
 	routes := m.routes.Routes()
 	for i := range routes {
 		route := routes[i]
 
-		if m.provider == nil {
+		if m.provider == nil || !route.RequiresCertificate() {
 			route.setCertificateStatus(CertificateNotRequired)
+		} else if route.UsesCertificateFiles() {
+			m.loadCertificateFiles(route)
 		} else {
-			m.updateCert(ctx, route)
+			pending = append(pending, route)
 		}
 	}
+
+	m.updateCerts(ctx, m.groupByCertificate(pending))
+
+	if m.provider != nil {
+		m.checkOnDemandCertificates(ctx)
+	}
 }
 
 // MonitorCertificates periodically calls CheckCertificates until the context is cancelled.
@@ -154,18 +349,27 @@ func (m *Manager) MonitorCertificates(ctx context.Context, interval time.Duratio
 	}
 }
 
-// updateCert updates the certificate for the given route.
-func (m *Manager) updateCert(ctx context.Context, route *Route) {
+// updateCert updates the certificates for the given routes, which must all use the same certificate.
+func (m *Manager) updateCert(ctx context.Context, routes []*Route) {
+	route := routes[0]
 	primary, alts := route.CertificateNames()
-	cert, err := m.provider.GetCertificate(ctx, route.Provider, primary, alts)
-	if err != nil {
-		slog.Error("Failed to update certificate", "route", route.Domains[0], "error", err)
-		m.loadCertificate(route)
-		return
+	var certs []*tls.Certificate
+	for _, keyType := range m.keyTypesFor(route) {
+		cert, err := m.provider.GetCertificate(ctx, route.Provider, string(keyType), primary, alts)
+		if err != nil {
+			slog.Error("Failed to update certificate", "route", route.Domains[0], "keyType", keyType, "error", err)
+			for i := range routes {
+				m.loadCertificate(routes[i])
+			}
+			return
+		}
+		certs = append(certs, cert)
 	}
 
-	route.setCertificate(cert)
-	route.setCertificateStatus(CertificateGood)
+	for i := range routes {
+		routes[i].setCertificates(certs)
+		routes[i].setCertificateStatus(CertificateGood)
+	}
 }
 
 // routeMap maintains a map of domain names to routes, using copy-on-write
@@ -175,7 +379,8 @@ type routeMap struct {
 	routes  atomic.Pointer[[]*Route]
 }
 
-// Update replaces all known routes with the ones provided.
+// Update replaces all known routes with the ones provided. Stream routes aren't mapped to their domains, as
+// they're never looked up by name.
 func (r *routeMap) Update(routes []*Route) error {
 	newDomains := make(map[string]*Route)
 	newRoutes := make([]*Route, len(routes))
@@ -184,11 +389,15 @@ func (r *routeMap) Update(routes []*Route) error {
 	for i := range routes {
 		route := routes[i]
 		for j := range route.Domains {
-			if !isDomainName(route.Domains[j]) {
+			if !isHostName(route.Domains[j]) {
 				return fmt.Errorf("invalid domain name: %s", route.Domains[j])
 			}
 
-			newDomains[strings.ToLower(route.Domains[j])] = route
+			if route.Stream != nil {
+				continue
+			}
+
+			newDomains[normaliseHostName(route.Domains[j])] = route
 		}
 	}
 
@@ -200,7 +409,7 @@ func (r *routeMap) Update(routes []*Route) error {
 // Get retrieves the route for the given domain, or nil if no such route exists.
 func (r *routeMap) Get(domain string) *Route {
 	if m := r.domains.Load(); m != nil {
-		return (*m)[strings.ToLower(domain)]
+		return (*m)[normaliseHostName(domain)]
 	}
 	return nil
 }
@@ -4,6 +4,7 @@ import (
 	"context"
 	"crypto/tls"
 	"fmt"
+	"sync"
 	"testing"
 	"testing/synctest"
 	"time"
@@ -22,14 +23,14 @@ type fakeCertManager struct {
 	altNames          []string
 }
 
-func (f *fakeCertManager) GetCertificate(_ context.Context, preferredSupplier string, subject string, altNames []string) (*tls.Certificate, error) {
+func (f *fakeCertManager) GetCertificate(_ context.Context, preferredSupplier string, _ string, subject string, altNames []string) (*tls.Certificate, error) {
 	f.preferredSupplier = preferredSupplier
 	f.subject = subject
 	f.altNames = altNames
 	return f.certificate, f.err
 }
 
-func (f *fakeCertManager) GetExistingCertificate(preferredSupplier string, subject string, altNames []string) (*tls.Certificate, bool, error) {
+func (f *fakeCertManager) GetExistingCertificate(preferredSupplier string, _ string, subject string, altNames []string) (*tls.Certificate, bool, error) {
 	f.preferredSupplier = preferredSupplier
 	f.subject = subject
 	f.altNames = altNames
@@ -115,6 +116,18 @@ func Test_Manager_SetRoutes_returnsErrorIfDomainIsInvalid(t *testing.T) {
 	assert.Error(t, err)
 }
 
+func Test_Manager_SetRoutes_returnsErrorIfIPAddressHasZone(t *testing.T) {
+	manager := NewManager(nil)
+	err := manager.SetRoutes(
+		t.Context(),
+		[]*Route{{
+			Domains: []string{"fe80::1%eth0"},
+		}},
+		nil,
+	)
+	assert.Error(t, err)
+}
+
 func Test_Manager_RouteForDomain_returnsNullIfNoRouteFound(t *testing.T) {
 	certManager := &fakeCertManager{
 		err: fmt.Errorf("ruh roh"),
@@ -219,6 +232,54 @@ func Test_Manager_CertificateForClient_returnsCertificateForDomain(t *testing.T)
 	})
 }
 
+func Test_Manager_RouteForDomain_matchesIPAddresses(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		certManager := &fakeCertManager{
+			certificate: dummyCert,
+		}
+
+		manager := NewManager(certManager)
+		route := &Route{
+			Domains: []string{"203.0.113.10", "2001:DB8:0::1"},
+		}
+		assert.NoError(t, manager.SetRoutes(t.Context(), []*Route{route}, nil))
+		synctest.Wait()
+
+		assert.Equal(t, route, manager.RouteForDomain("203.0.113.10"))
+		assert.Equal(t, route, manager.RouteForDomain("2001:db8::1"))
+		assert.Equal(t, route, manager.RouteForDomain("[2001:db8::1]"))
+		assert.Nil(t, manager.RouteForDomain("203.0.113.11"))
+	})
+}
+
+func Test_Manager_CertificateForClient_usesLocalAddressIfNoServerName(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		certManager := &fakeCertManager{
+			certificate: dummyCert,
+		}
+
+		manager := NewManager(certManager)
+		route := &Route{
+			Domains: []string{"203.0.113.10"},
+		}
+		_ = manager.SetRoutes(t.Context(), []*Route{route}, nil)
+		synctest.Wait()
+		manager.CheckCertificates(t.Context())
+
+		res, err := manager.CertificateForClient(&tls.ClientHelloInfo{Conn: &fakeLocalAddrConn{addr: "203.0.113.10:443"}})
+		assert.Equal(t, dummyCert, res)
+		assert.NoError(t, err)
+
+		res, err = manager.CertificateForClient(&tls.ClientHelloInfo{Conn: &fakeLocalAddrConn{addr: "[::ffff:203.0.113.10]:443"}})
+		assert.Equal(t, dummyCert, res)
+		assert.NoError(t, err)
+
+		res, err = manager.CertificateForClient(&tls.ClientHelloInfo{Conn: &fakeLocalAddrConn{addr: "203.0.113.11:443"}})
+		assert.Nil(t, res)
+		assert.NoError(t, err)
+	})
+}
+
 func Test_Manager_CertificateForClient_returnsCertificateForFallbackDomainIfSpecified(t *testing.T) {
 	synctest.Test(t, func(t *testing.T) {
 		certManager := &fakeCertManager{
@@ -315,6 +376,82 @@ func Test_Manager_SetRoutes_removesPreviousRoutes(t *testing.T) {
 	})
 }
 
+// countingCertManager is a CertificateProvider that records which subjects it was asked about.
+type countingCertManager struct {
+	mu           sync.Mutex
+	needsRenewal bool
+	existing     []string
+	obtained     []string
+}
+
+func (c *countingCertManager) GetCertificate(_ context.Context, _ string, _ string, subject string, _ []string) (*tls.Certificate, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.obtained = append(c.obtained, subject)
+	return dummyCert, nil
+}
+
+func (c *countingCertManager) GetExistingCertificate(_ string, _ string, subject string, _ []string) (*tls.Certificate, bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.existing = append(c.existing, subject)
+	return dummyCert, c.needsRenewal, nil
+}
+
+func (c *countingCertManager) reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.existing = nil
+	c.obtained = nil
+}
+
+func Test_Manager_SetRoutes_reusesCertificatesForUnchangedRoutes(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		certManager := &countingCertManager{}
+		manager := NewManager(certManager)
+		assert.NoError(t, manager.SetRoutes(t.Context(), []*Route{
+			{Domains: []string{"example.com"}},
+			{Domains: []string{"example.net"}},
+		}, nil))
+		synctest.Wait()
+		assert.ElementsMatch(t, []string{"example.com", "example.net"}, certManager.existing)
+		assert.ElementsMatch(t, []string{"example.com", "example.net"}, certManager.obtained)
+
+		certManager.reset()
+		assert.NoError(t, manager.SetRoutes(t.Context(), []*Route{
+			{Domains: []string{"example.com"}, Upstreams: []Upstream{{Host: "server2:8080"}}},
+			{Domains: []string{"example.net", "www.example.net"}},
+			{Domains: []string{"example.org"}},
+		}, nil))
+		synctest.Wait()
+
+		assert.ElementsMatch(t, []string{"example.net", "example.org"}, certManager.existing)
+		assert.ElementsMatch(t, []string{"example.net", "example.org"}, certManager.obtained)
+		assert.Equal(t, CertificateGood, manager.RouteForDomain("example.com").CertificateStatus())
+		assert.Equal(t, dummyCert, manager.RouteForDomain("example.com").Certificate())
+	})
+}
+
+func Test_Manager_SetRoutes_leavesReusedCertificatesThatNeedRenewingToScheduler(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		certManager := &countingCertManager{needsRenewal: true}
+		manager := NewManager(certManager)
+
+		route := &Route{Domains: []string{"example.com"}}
+		manager.loadCertificate(route)
+		assert.NoError(t, manager.routes.Update([]*Route{route}))
+		assert.Equal(t, CertificateExpiringSoon, route.CertificateStatus())
+
+		certManager.reset()
+		assert.NoError(t, manager.SetRoutes(t.Context(), []*Route{{Domains: []string{"example.com"}}}, nil))
+		synctest.Wait()
+
+		assert.Empty(t, certManager.existing)
+		assert.Empty(t, certManager.obtained)
+		assert.Equal(t, CertificateExpiringSoon, manager.RouteForDomain("example.com").CertificateStatus())
+	})
+}
+
 func Test_Manager_CheckCertificates_setsStatusIfGetCertificateFails_andNoPreviousCertificateExists(t *testing.T) {
 	synctest.Test(t, func(t *testing.T) {
 		certManager := &fakeCertManager{
@@ -495,3 +632,335 @@ func Test_Manager_MonitorCertificates_checksPeriodicallyUntilCancelled(t *testin
 		}
 	})
 }
+
+func Test_Manager_SetRoutes_doesNotObtainCertificatesForPassthroughRoutes(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		certManager := &fakeCertManager{
+			certificate: dummyCert,
+		}
+
+		route := &Route{
+			Domains:     []string{"example.com"},
+			Passthrough: true,
+		}
+
+		manager := NewManager(certManager)
+		err := manager.SetRoutes(t.Context(), []*Route{route}, nil)
+		synctest.Wait()
+		assert.NoError(t, err)
+		assert.Equal(t, "", certManager.subject)
+		assert.Nil(t, route.Certificate())
+		assert.Equal(t, CertificateNotRequired, route.CertificateStatus())
+	})
+}
+
+func Test_Manager_PassthroughRoute_returnsOnlyPassthroughRoutes(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		certManager := &fakeCertManager{
+			certificate: dummyCert,
+		}
+
+		manager := NewManager(certManager)
+		passthrough := &Route{
+			Domains:     []string{"passthrough.example.com"},
+			Passthrough: true,
+		}
+		normal := &Route{
+			Domains: []string{"example.com"},
+		}
+		_ = manager.SetRoutes(t.Context(), []*Route{passthrough, normal}, nil)
+		synctest.Wait()
+
+		assert.Equal(t, passthrough, manager.PassthroughRoute("passthrough.example.com"))
+		assert.Equal(t, passthrough, manager.PassthroughRoute("PASSTHROUGH.example.com"))
+		assert.Nil(t, manager.PassthroughRoute("example.com"))
+		assert.Nil(t, manager.PassthroughRoute("example.net"))
+	})
+}
+
+func Test_Manager_PassthroughRoute_returnsFallbackIfPassthrough(t *testing.T) {
+	manager := NewManager(nil)
+	route := &Route{
+		Domains:     []string{"example.com"},
+		Passthrough: true,
+	}
+	_ = manager.SetRoutes(t.Context(), []*Route{route}, route)
+
+	assert.Equal(t, route, manager.PassthroughRoute("example.net"))
+}
+
+func Test_Manager_RouteForDomain_doesNotReturnPassthroughRoutes(t *testing.T) {
+	manager := NewManager(nil)
+	route := &Route{
+		Domains:     []string{"example.com"},
+		Passthrough: true,
+	}
+	_ = manager.SetRoutes(t.Context(), []*Route{route}, nil)
+
+	assert.Nil(t, manager.RouteForDomain("example.com"))
+}
+
+func Test_Manager_CertificateForClient_returnsNullForPassthroughRoutes(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		certManager := &fakeCertManager{
+			certificate: dummyCert,
+		}
+
+		manager := NewManager(certManager)
+		route := &Route{
+			Domains:     []string{"example.com"},
+			Passthrough: true,
+		}
+		_ = manager.SetRoutes(t.Context(), []*Route{route}, nil)
+		synctest.Wait()
+
+		res, err := manager.CertificateForClient(&tls.ClientHelloInfo{ServerName: "example.com"})
+		assert.Nil(t, res)
+		assert.NoError(t, err)
+	})
+}
+
+func Test_Manager_SetRoutes_onlyObtainsCertificatesForTLSStreams(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		certManager := &fakeCertManager{
+			certificate: dummyCert,
+		}
+
+		plain := &Route{
+			Domains: []string{"plain.example.com"},
+			Stream:  &Stream{Port: 2222, Protocol: StreamProtocolTCP},
+		}
+		secure := &Route{
+			Domains: []string{"secure.example.com"},
+			Stream:  &Stream{Port: 5432, Protocol: StreamProtocolTCP, TLS: true},
+		}
+
+		manager := NewManager(certManager)
+		err := manager.SetRoutes(t.Context(), []*Route{plain, secure}, nil)
+		synctest.Wait()
+		assert.NoError(t, err)
+		assert.Equal(t, CertificateNotRequired, plain.CertificateStatus())
+		assert.Equal(t, CertificateGood, secure.CertificateStatus())
+		assert.Equal(t, dummyCert, secure.Certificate())
+	})
+}
+
+func Test_Manager_RouteForDomain_doesNotReturnStreamRoutes(t *testing.T) {
+	manager := NewManager(nil)
+	stream := &Route{
+		Domains: []string{"db.example.com"},
+		Stream:  &Stream{Port: 5432, Protocol: StreamProtocolTCP},
+	}
+	_ = manager.SetRoutes(t.Context(), []*Route{stream}, nil)
+
+	assert.Nil(t, manager.RouteForDomain("db.example.com"))
+}
+
+func Test_Manager_StreamRoutes_returnsOnlyStreamRoutes(t *testing.T) {
+	manager := NewManager(nil)
+	stream := &Route{
+		Domains: []string{"db.example.com"},
+		Stream:  &Stream{Port: 5432, Protocol: StreamProtocolTCP},
+	}
+	normal := &Route{
+		Domains: []string{"example.com"},
+	}
+	_ = manager.SetRoutes(t.Context(), []*Route{stream, normal}, nil)
+
+	assert.Equal(t, []*Route{stream}, manager.StreamRoutes())
+}
+
+func Test_Manager_OnRoutesChanged_calledAfterRoutesAreSet(t *testing.T) {
+	manager := NewManager(nil)
+	route := &Route{
+		Domains: []string{"db.example.com"},
+		Stream:  &Stream{Port: 5432, Protocol: StreamProtocolTCP},
+	}
+
+	var seen [][]*Route
+	manager.OnRoutesChanged(func() {
+		seen = append(seen, manager.StreamRoutes())
+	})
+
+	_ = manager.SetRoutes(t.Context(), []*Route{route}, nil)
+	_ = manager.SetRoutes(t.Context(), nil, nil)
+
+	assert.Equal(t, [][]*Route{{route}, nil}, seen)
+}
+
+type fakeSupplierCertManager struct {
+	mu    sync.Mutex
+	certs map[string]*tls.Certificate
+}
+
+func (f *fakeSupplierCertManager) GetCertificate(_ context.Context, preferredSupplier string, _ string, _ string, _ []string) (*tls.Certificate, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if cert, ok := f.certs[preferredSupplier]; ok {
+		return cert, nil
+	}
+	return nil, fmt.Errorf("ruh roh")
+}
+
+func (f *fakeSupplierCertManager) GetExistingCertificate(_ string, _ string, _ string, _ []string) (*tls.Certificate, bool, error) {
+	return nil, false, fmt.Errorf("ruh roh")
+}
+
+func (f *fakeSupplierCertManager) setCertificate(supplier string, cert *tls.Certificate) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.certs[supplier] = cert
+}
+
+func Test_Manager_SetRoutes_servesTemporaryCertificateIfEnabled(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		temporaryCert := &tls.Certificate{}
+		certManager := &fakeSupplierCertManager{certs: map[string]*tls.Certificate{"selfsigned": temporaryCert}}
+
+		route := &Route{Domains: []string{"example.com"}, Provider: "lego"}
+
+		manager := NewManager(certManager)
+		manager.EnableTemporaryCertificates("selfsigned")
+		err := manager.SetRoutes(t.Context(), []*Route{route}, nil)
+		synctest.Wait()
+		assert.NoError(t, err)
+		assert.Equal(t, CertificatePending, route.CertificateStatus())
+		assert.Same(t, temporaryCert, route.Certificate())
+		assert.Same(t, route, manager.RouteForDomain("example.com"))
+	})
+}
+
+func Test_Manager_SetRoutes_setsStatusIfTemporaryCertificateFails(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		certManager := &fakeSupplierCertManager{certs: map[string]*tls.Certificate{}}
+		route := &Route{Domains: []string{"example.com"}, Provider: "lego"}
+
+		manager := NewManager(certManager)
+		manager.EnableTemporaryCertificates("selfsigned")
+		_ = manager.SetRoutes(t.Context(), []*Route{route}, nil)
+		synctest.Wait()
+		assert.Equal(t, CertificateMissing, route.CertificateStatus())
+		assert.Nil(t, route.Certificate())
+		assert.Nil(t, manager.RouteForDomain("example.com"))
+	})
+}
+
+func Test_Manager_CheckCertificates_replacesTemporaryCertificate(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		certManager := &fakeSupplierCertManager{certs: map[string]*tls.Certificate{"selfsigned": {}}}
+		route := &Route{Domains: []string{"example.com"}, Provider: "lego"}
+
+		manager := NewManager(certManager)
+		manager.EnableTemporaryCertificates("selfsigned")
+		_ = manager.SetRoutes(t.Context(), []*Route{route}, nil)
+		synctest.Wait()
+		assert.Equal(t, CertificatePending, route.CertificateStatus())
+
+		certManager.setCertificate("lego", dummyCert)
+		manager.CheckCertificates(t.Context())
+		assert.Equal(t, CertificateGood, route.CertificateStatus())
+		assert.Same(t, dummyCert, route.Certificate())
+	})
+}
+
+func Test_Manager_SetRoutes_doesNotServeTemporaryCertificateFromRoutesOwnProvider(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		certManager := &fakeSupplierCertManager{certs: map[string]*tls.Certificate{}}
+		route := &Route{Domains: []string{"example.com"}, Provider: "selfsigned"}
+
+		manager := NewManager(certManager)
+		manager.EnableTemporaryCertificates("selfsigned")
+		manager.loadCertificate(route)
+		assert.Equal(t, CertificateMissing, route.CertificateStatus())
+	})
+}
+
+type fakeKeyTypeCertManager struct {
+	mu           sync.Mutex
+	certificates map[string]*tls.Certificate
+	requested    []string
+}
+
+func (f *fakeKeyTypeCertManager) GetCertificate(_ context.Context, _ string, keyType string, _ string, _ []string) (*tls.Certificate, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.requested = append(f.requested, keyType)
+	if cert, ok := f.certificates[keyType]; ok {
+		return cert, nil
+	}
+	return nil, fmt.Errorf("no certificate")
+}
+
+func (f *fakeKeyTypeCertManager) GetExistingCertificate(_ string, keyType string, _ string, _ []string) (*tls.Certificate, bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if cert, ok := f.certificates[keyType]; ok {
+		return cert, false, nil
+	}
+	return nil, true, fmt.Errorf("no certificate")
+}
+
+func Test_Manager_CertificateForClient_selectsCertificateByKeyType(t *testing.T) {
+	ecdsaCert := newKeyTypeCertificate(t, KeyTypeECDSA)
+	rsaCert := newKeyTypeCertificate(t, KeyTypeRSA)
+	provider := &fakeKeyTypeCertManager{certificates: map[string]*tls.Certificate{"ecdsa": ecdsaCert, "rsa": rsaCert}}
+
+	manager := NewManager(provider)
+	manager.SetDefaultKeyTypes([]KeyType{KeyTypeECDSA, KeyTypeRSA})
+	route := &Route{Domains: []string{"example.com"}}
+	manager.loadCertificate(route)
+	_ = manager.routes.Update([]*Route{route})
+
+	cert, err := manager.CertificateForClient(modernHello)
+	assert.NoError(t, err)
+	assert.Same(t, ecdsaCert, cert)
+
+	cert, err = manager.CertificateForClient(legacyRSAHello)
+	assert.NoError(t, err)
+	assert.Same(t, rsaCert, cert)
+	assert.Equal(t, CertificateGood, route.CertificateStatus())
+}
+
+func Test_Manager_CheckCertificates_obtainsCertificateForEachKeyType(t *testing.T) {
+	provider := &fakeKeyTypeCertManager{certificates: map[string]*tls.Certificate{
+		"ecdsa": newKeyTypeCertificate(t, KeyTypeECDSA),
+		"rsa":   newKeyTypeCertificate(t, KeyTypeRSA),
+	}}
+
+	manager := NewManager(provider)
+	manager.SetDefaultKeyTypes([]KeyType{KeyTypeECDSA, KeyTypeRSA})
+	_ = manager.routes.Update([]*Route{
+		{Domains: []string{"example.com"}},
+		{Domains: []string{"example.net"}, KeyTypes: []KeyType{KeyTypeRSA}},
+	})
+	manager.CheckCertificates(context.Background())
+
+	assert.Equal(t, []string{"ecdsa", "rsa", "rsa"}, provider.requested)
+}
+
+func Test_Manager_SetRoutes_setsStatusIfCertificateMissingForAKeyType(t *testing.T) {
+	ecdsaCert := newKeyTypeCertificate(t, KeyTypeECDSA)
+	provider := &fakeKeyTypeCertManager{certificates: map[string]*tls.Certificate{"ecdsa": ecdsaCert}}
+
+	manager := NewManager(provider)
+	manager.SetDefaultKeyTypes([]KeyType{KeyTypeECDSA, KeyTypeRSA})
+	route := &Route{Domains: []string{"example.com"}}
+	manager.loadCertificate(route)
+
+	assert.Equal(t, CertificateExpiringSoon, route.CertificateStatus())
+	assert.Same(t, ecdsaCert, route.CertificateFor(legacyRSAHello))
+}
+
+func Test_Manager_CheckCertificates_keepsExistingCertificatesIfAKeyTypeFails(t *testing.T) {
+	ecdsaCert := newKeyTypeCertificate(t, KeyTypeECDSA)
+	provider := &fakeKeyTypeCertManager{certificates: map[string]*tls.Certificate{"ecdsa": ecdsaCert}}
+
+	manager := NewManager(provider)
+	manager.SetDefaultKeyTypes([]KeyType{KeyTypeECDSA, KeyTypeRSA})
+	route := &Route{Domains: []string{"example.com"}}
+	_ = manager.routes.Update([]*Route{route})
+	manager.CheckCertificates(context.Background())
+
+	assert.Same(t, ecdsaCert, route.Certificate())
+	assert.Equal(t, CertificateExpiringSoon, route.CertificateStatus())
+}
@@ -0,0 +1,354 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// errOnDemandRateLimited is returned when a certificate can't be obtained on demand because too many have
+// been requested recently.
+var errOnDemandRateLimited = errors.New("on-demand certificate rate limit exceeded")
+
+// maxOnDemandRefusals is the most domains we'll remember refusing at once. Handshakes for random names (e.g.
+// from scanners) would otherwise grow the cache without limit.
+const maxOnDemandRefusals = 10000
+
+// OnDemandPolicy decides whether a certificate may be obtained on demand for a domain.
+type OnDemandPolicy interface {
+	AllowOnDemand(ctx context.Context, domain string) (bool, error)
+}
+
+// OnDemandConfig configures how certificates are obtained for domains that don't have a route of their own,
+// and are instead served by the fallback route.
+type OnDemandConfig struct {
+	// Policies decide which domains certificates may be obtained for. All of them must allow a domain.
+	Policies []OnDemandPolicy
+	// Timeout is how long a TLS handshake will wait for a certificate to be obtained. If it takes longer, the
+	// handshake continues with the fallback route's certificate, and the certificate is still obtained in the
+	// background.
+	Timeout time.Duration
+	// Interval and Burst limit how frequently new certificates are requested.
+	Interval time.Duration
+	Burst    int
+	// NegativeCacheDuration is how long to wait before trying again for a domain that wasn't allowed, or that
+	// we failed to obtain a certificate for.
+	NegativeCacheDuration time.Duration
+}
+
+// onDemand holds the state of certificates obtained on demand.
+type onDemand struct {
+	config  OnDemandConfig
+	limiter *rate.Limiter
+
+	mu           sync.Mutex
+	certificates map[string]*tls.Certificate
+	pending      map[string]chan struct{}
+	refused      map[string]time.Time
+}
+
+// EnableOnDemand allows certificates to be obtained during the TLS handshake for domains that are served by the
+// fallback route. It must be called before the manager is used.
+func (m *Manager) EnableOnDemand(config OnDemandConfig) {
+	limit := rate.Inf
+	if config.Interval > 0 {
+		limit = rate.Every(config.Interval)
+	}
+
+	m.onDemand = &onDemand{
+		config:       config,
+		limiter:      rate.NewLimiter(limit, max(config.Burst, 1)),
+		certificates: make(map[string]*tls.Certificate),
+		pending:      make(map[string]chan struct{}),
+		refused:      make(map[string]time.Time),
+	}
+}
+
+// onDemandCertificate returns a certificate for the given domain, obtaining one using the route's provider
+// if necessary. If the domain isn't allowed, or the certificate isn't available before the timeout, nil is
+// returned.
+func (m *Manager) onDemandCertificate(ctx context.Context, domain string, route *Route) *tls.Certificate {
+	o := m.onDemand
+	domain = strings.ToLower(strings.TrimSuffix(domain, "."))
+	if !isDomainName(domain) {
+		return nil
+	}
+
+	o.mu.Lock()
+	if cert, ok := o.certificates[domain]; ok {
+		o.mu.Unlock()
+		return cert
+	}
+
+	if until, ok := o.refused[domain]; ok && time.Now().Before(until) {
+		o.mu.Unlock()
+		return nil
+	}
+
+	done, ok := o.pending[domain]
+	if !ok {
+		done = make(chan struct{})
+		o.pending[domain] = done
+		go m.obtainOnDemand(domain, route, done)
+	}
+	o.mu.Unlock()
+
+	timer := time.NewTimer(o.config.Timeout)
+	defer timer.Stop()
+
+	select {
+	case <-done:
+		o.mu.Lock()
+		defer o.mu.Unlock()
+		return o.certificates[domain]
+	case <-timer.C:
+		slog.Info("Timed out waiting for on-demand certificate, continuing in the background", "domain", domain)
+	case <-ctx.Done():
+	}
+	return nil
+}
+
+// obtainOnDemand obtains a certificate for the domain and stores it for future handshakes, then closes the
+// done channel.
+func (m *Manager) obtainOnDemand(domain string, route *Route, done chan struct{}) {
+	o := m.onDemand
+	cert, err := m.fetchOnDemand(context.Background(), domain, route)
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	defer close(done)
+
+	delete(o.pending, domain)
+	if err != nil {
+		slog.Warn("Unable to obtain on-demand certificate", "domain", domain, "error", err)
+		if errors.Is(err, errOnDemandRateLimited) {
+			// Only wait until the limit would allow another certificate, so the domain isn't held up for long.
+			o.refuse(domain, min(o.config.Interval, o.config.NegativeCacheDuration))
+		} else {
+			o.refuse(domain, o.config.NegativeCacheDuration)
+		}
+		return
+	}
+
+	slog.Info("Obtained on-demand certificate", "domain", domain)
+	delete(o.refused, domain)
+	o.certificates[domain] = cert
+}
+
+// fetchOnDemand checks that the domain is allowed, and then gets a certificate for it from the provider. Only
+// new certificates count towards the rate limit.
+func (m *Manager) fetchOnDemand(ctx context.Context, domain string, route *Route) (*tls.Certificate, error) {
+	if err := m.onDemand.allow(ctx, domain); err != nil {
+		return nil, err
+	}
+
+	keyType := string(m.keyTypesFor(route)[0])
+	if cert, _, err := m.provider.GetExistingCertificate(route.Provider, keyType, domain, nil); err == nil {
+		return cert, nil
+	}
+
+	if !m.onDemand.limiter.Allow() {
+		return nil, errOnDemandRateLimited
+	}
+
+	return m.provider.GetCertificate(ctx, route.Provider, keyType, domain, nil)
+}
+
+// hasOnDemandCertificate determines whether a certificate has been obtained on demand for the domain, and it
+// is still being served by the fallback route.
+func (m *Manager) hasOnDemandCertificate(domain string) bool {
+	o := m.onDemand
+	if o == nil || m.routes.Get(domain) != nil {
+		return false
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	_, ok := o.certificates[strings.ToLower(strings.TrimSuffix(domain, "."))]
+	return ok
+}
+
+// checkOnDemandCertificates renews the certificates obtained on demand. Domains that are no longer allowed, or
+// that now have a route of their own, are forgotten.
+func (m *Manager) checkOnDemandCertificates(ctx context.Context) {
+	o := m.onDemand
+	if o == nil {
+		return
+	}
+
+	o.mu.Lock()
+	var domains []string
+	for domain := range o.certificates {
+		domains = append(domains, domain)
+	}
+	o.pruneRefused()
+	o.mu.Unlock()
+
+	fallback := m.fallback
+	for i := range domains {
+		domain := domains[i]
+
+		if fallback == nil || m.routes.Get(domain) != nil {
+			o.forget(domain)
+			continue
+		}
+
+		if err := o.allow(ctx, domain); err != nil {
+			slog.Info("Forgetting on-demand certificate", "domain", domain, "reason", err)
+			o.forget(domain)
+			continue
+		}
+
+		cert, err := m.provider.GetCertificate(ctx, fallback.Provider, string(m.keyTypesFor(fallback)[0]), domain, nil)
+		if err != nil {
+			slog.Error("Failed to update on-demand certificate", "domain", domain, "error", err)
+			continue
+		}
+
+		o.mu.Lock()
+		o.certificates[domain] = cert
+		o.mu.Unlock()
+	}
+}
+
+// allow checks that every policy allows the domain.
+func (o *onDemand) allow(ctx context.Context, domain string) error {
+	for i := range o.config.Policies {
+		allowed, err := o.config.Policies[i].AllowOnDemand(ctx, domain)
+		if err != nil {
+			return fmt.Errorf("unable to check on-demand policy: %w", err)
+		}
+		if !allowed {
+			return fmt.Errorf("domain not allowed by on-demand policy")
+		}
+	}
+	return nil
+}
+
+// refuse records that the domain shouldn't be tried again for the given duration. If too many domains have been
+// refused, expired entries are pruned, and then the entry that expires soonest is dropped to make room. The
+// caller must hold the mutex.
+func (o *onDemand) refuse(domain string, duration time.Duration) {
+	if duration <= 0 {
+		return
+	}
+
+	if _, ok := o.refused[domain]; !ok && len(o.refused) >= maxOnDemandRefusals {
+		o.pruneRefused()
+
+		if len(o.refused) >= maxOnDemandRefusals {
+			var soonest string
+			for d, until := range o.refused {
+				if soonest == "" || until.Before(o.refused[soonest]) {
+					soonest = d
+				}
+			}
+			delete(o.refused, soonest)
+		}
+	}
+
+	o.refused[domain] = time.Now().Add(duration)
+}
+
+// pruneRefused removes refusals that have expired. The caller must hold the mutex.
+func (o *onDemand) pruneRefused() {
+	for domain, until := range o.refused {
+		if !time.Now().Before(until) {
+			delete(o.refused, domain)
+		}
+	}
+}
+
+// forget removes the certificate for the domain.
+func (o *onDemand) forget(domain string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	delete(o.certificates, domain)
+}
+
+// SuffixPolicy allows domains that are equal to, or are subdomains of, any of its suffixes.
+type SuffixPolicy struct {
+	suffixes []string
+}
+
+// NewSuffixPolicy creates a policy allowing the given domains and their subdomains.
+func NewSuffixPolicy(suffixes []string) *SuffixPolicy {
+	p := &SuffixPolicy{}
+	for i := range suffixes {
+		if suffix := strings.ToLower(strings.Trim(suffixes[i], ".")); suffix != "" {
+			p.suffixes = append(p.suffixes, suffix)
+		}
+	}
+	return p
+}
+
+func (p *SuffixPolicy) AllowOnDemand(_ context.Context, domain string) (bool, error) {
+	domain = strings.ToLower(domain)
+	for i := range p.suffixes {
+		if domain == p.suffixes[i] || strings.HasSuffix(domain, "."+p.suffixes[i]) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// AskPolicy allows domains by asking an HTTP endpoint. The domain is passed in the `domain` query parameter,
+// and the endpoint should respond with a 200 status if a certificate may be obtained for it, or 403 if not.
+type AskPolicy struct {
+	endpoint string
+	client   *http.Client
+}
+
+// NewAskPolicy creates a policy that asks the given endpoint about each domain.
+func NewAskPolicy(endpoint string) (*AskPolicy, error) {
+	if u, err := url.Parse(endpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, fmt.Errorf("invalid ask endpoint: %s", endpoint)
+	}
+
+	return &AskPolicy{
+		endpoint: endpoint,
+		client:   &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+func (p *AskPolicy) AllowOnDemand(ctx context.Context, domain string) (bool, error) {
+	u, err := url.Parse(p.endpoint)
+	if err != nil {
+		return false, err
+	}
+
+	query := u.Query()
+	query.Set("domain", domain)
+	u.RawQuery = query.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return false, err
+	}
+
+	res, err := p.client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer res.Body.Close()
+	_, _ = io.Copy(io.Discard, res.Body)
+
+	switch res.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusForbidden:
+		return false, nil
+	default:
+		return false, fmt.Errorf("unexpected response from ask endpoint: %s", res.Status)
+	}
+}
@@ -0,0 +1,368 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"testing/synctest"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeOnDemandProvider struct {
+	mu        sync.Mutex
+	certs     map[string]*tls.Certificate
+	existing  map[string]*tls.Certificate
+	err       error
+	delay     time.Duration
+	obtained  []string
+	suppliers []string
+}
+
+func (f *fakeOnDemandProvider) GetCertificate(_ context.Context, preferredSupplier string, _ string, subject string, _ []string) (*tls.Certificate, error) {
+	time.Sleep(f.delay)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.obtained = append(f.obtained, subject)
+	f.suppliers = append(f.suppliers, preferredSupplier)
+	if f.err != nil {
+		return nil, f.err
+	}
+	return f.certs[subject], nil
+}
+
+func (f *fakeOnDemandProvider) GetExistingCertificate(_ string, _ string, subject string, _ []string) (*tls.Certificate, bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if cert, ok := f.existing[subject]; ok {
+		return cert, false, nil
+	}
+	return nil, false, fmt.Errorf("no existing certificate")
+}
+
+func (f *fakeOnDemandProvider) obtainCount() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.obtained)
+}
+
+type fakeOnDemandPolicy struct {
+	mu      sync.Mutex
+	allowed map[string]bool
+	err     error
+	checks  int
+}
+
+func (f *fakeOnDemandPolicy) AllowOnDemand(_ context.Context, domain string) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.checks++
+	return f.allowed[domain], f.err
+}
+
+func (f *fakeOnDemandPolicy) setAllowed(domain string, allowed bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.allowed[domain] = allowed
+}
+
+var (
+	fallbackCert = &tls.Certificate{}
+	vanityCert   = &tls.Certificate{}
+)
+
+func newOnDemandManager(t *testing.T, provider *fakeOnDemandProvider, policy OnDemandPolicy, config OnDemandConfig) *Manager {
+	manager := NewManager(provider)
+	config.Policies = []OnDemandPolicy{policy}
+	manager.EnableOnDemand(config)
+
+	fallback := &Route{Domains: []string{"example.com"}, Provider: "lego"}
+	fallback.setCertificate(fallbackCert)
+	fallback.setCertificateStatus(CertificateGood)
+	require.NoError(t, manager.routes.Update([]*Route{fallback}))
+	manager.fallback = fallback
+	return manager
+}
+
+func Test_Manager_OnDemand_obtainsCertificateForAllowedDomain(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		provider := &fakeOnDemandProvider{certs: map[string]*tls.Certificate{"vanity.example.net": vanityCert}}
+		policy := &fakeOnDemandPolicy{allowed: map[string]bool{"vanity.example.net": true}}
+		manager := newOnDemandManager(t, provider, policy, OnDemandConfig{Timeout: time.Minute})
+
+		res, err := manager.CertificateForClient(&tls.ClientHelloInfo{ServerName: "Vanity.Example.Net"})
+		require.NoError(t, err)
+		assert.Same(t, vanityCert, res)
+		assert.Equal(t, []string{"vanity.example.net"}, provider.obtained)
+		assert.Equal(t, []string{"lego"}, provider.suppliers)
+
+		res, err = manager.CertificateForClient(&tls.ClientHelloInfo{ServerName: "vanity.example.net"})
+		require.NoError(t, err)
+		assert.Same(t, vanityCert, res)
+		assert.Equal(t, 1, provider.obtainCount())
+	})
+}
+
+func Test_Manager_OnDemand_routeForDomainReturnsFallbackOnceCertificateObtained(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		provider := &fakeOnDemandProvider{certs: map[string]*tls.Certificate{"vanity.example.net": vanityCert}}
+		policy := &fakeOnDemandPolicy{allowed: map[string]bool{"vanity.example.net": true}}
+		manager := newOnDemandManager(t, provider, policy, OnDemandConfig{Timeout: time.Minute})
+		manager.fallback.setCertificate(nil)
+		manager.fallback.setCertificateStatus(CertificateMissing)
+
+		assert.Nil(t, manager.RouteForDomain("vanity.example.net"))
+
+		_, _ = manager.CertificateForClient(&tls.ClientHelloInfo{ServerName: "vanity.example.net"})
+		assert.Same(t, manager.fallback, manager.RouteForDomain("vanity.example.net"))
+		assert.Nil(t, manager.RouteForDomain("other.example.net"))
+		assert.Nil(t, manager.RouteForDomain("example.com"))
+	})
+}
+
+func Test_Manager_OnDemand_usesExistingCertificateWithoutRateLimit(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		provider := &fakeOnDemandProvider{existing: map[string]*tls.Certificate{"vanity.example.net": vanityCert}}
+		policy := &fakeOnDemandPolicy{allowed: map[string]bool{"vanity.example.net": true}}
+		manager := newOnDemandManager(t, provider, policy, OnDemandConfig{Timeout: time.Minute, Interval: time.Hour})
+		manager.onDemand.limiter.Allow()
+
+		res, err := manager.CertificateForClient(&tls.ClientHelloInfo{ServerName: "vanity.example.net"})
+		require.NoError(t, err)
+		assert.Same(t, vanityCert, res)
+		assert.Equal(t, 0, provider.obtainCount())
+	})
+}
+
+func Test_Manager_OnDemand_doesNotObtainCertificateForExplicitRoutes(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		provider := &fakeOnDemandProvider{certs: map[string]*tls.Certificate{"example.com": vanityCert}}
+		policy := &fakeOnDemandPolicy{allowed: map[string]bool{"example.com": true}}
+		manager := newOnDemandManager(t, provider, policy, OnDemandConfig{Timeout: time.Minute})
+
+		res, err := manager.CertificateForClient(&tls.ClientHelloInfo{ServerName: "example.com"})
+		require.NoError(t, err)
+		assert.Same(t, fallbackCert, res)
+		assert.Equal(t, 0, provider.obtainCount())
+		assert.Equal(t, 0, policy.checks)
+	})
+}
+
+func Test_Manager_OnDemand_servesFallbackCertificateAndCachesRefusal(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		provider := &fakeOnDemandProvider{certs: map[string]*tls.Certificate{"vanity.example.net": vanityCert}}
+		policy := &fakeOnDemandPolicy{allowed: map[string]bool{}}
+		manager := newOnDemandManager(t, provider, policy, OnDemandConfig{Timeout: time.Minute, NegativeCacheDuration: time.Hour})
+
+		res, err := manager.CertificateForClient(&tls.ClientHelloInfo{ServerName: "vanity.example.net"})
+		require.NoError(t, err)
+		assert.Same(t, fallbackCert, res)
+		assert.Equal(t, 0, provider.obtainCount())
+
+		policy.setAllowed("vanity.example.net", true)
+		res, _ = manager.CertificateForClient(&tls.ClientHelloInfo{ServerName: "vanity.example.net"})
+		assert.Same(t, fallbackCert, res)
+		assert.Equal(t, 1, policy.checks)
+
+		time.Sleep(time.Hour + time.Second)
+		res, _ = manager.CertificateForClient(&tls.ClientHelloInfo{ServerName: "vanity.example.net"})
+		assert.Same(t, vanityCert, res)
+		assert.Equal(t, 2, policy.checks)
+	})
+}
+
+func Test_Manager_OnDemand_cachesFailuresToObtainCertificate(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		provider := &fakeOnDemandProvider{err: fmt.Errorf("ruh roh")}
+		policy := &fakeOnDemandPolicy{allowed: map[string]bool{"vanity.example.net": true}}
+		manager := newOnDemandManager(t, provider, policy, OnDemandConfig{Timeout: time.Minute, NegativeCacheDuration: time.Hour})
+
+		res, _ := manager.CertificateForClient(&tls.ClientHelloInfo{ServerName: "vanity.example.net"})
+		assert.Same(t, fallbackCert, res)
+		res, _ = manager.CertificateForClient(&tls.ClientHelloInfo{ServerName: "vanity.example.net"})
+		assert.Same(t, fallbackCert, res)
+		assert.Equal(t, 1, provider.obtainCount())
+	})
+}
+
+func Test_Manager_OnDemand_rateLimitsNewCertificates(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		provider := &fakeOnDemandProvider{certs: map[string]*tls.Certificate{
+			"one.example.net": vanityCert,
+			"two.example.net": vanityCert,
+		}}
+		policy := &fakeOnDemandPolicy{allowed: map[string]bool{"one.example.net": true, "two.example.net": true}}
+		manager := newOnDemandManager(t, provider, policy, OnDemandConfig{Timeout: time.Minute, Interval: time.Minute, NegativeCacheDuration: time.Hour})
+
+		res, _ := manager.CertificateForClient(&tls.ClientHelloInfo{ServerName: "one.example.net"})
+		assert.Same(t, vanityCert, res)
+
+		res, _ = manager.CertificateForClient(&tls.ClientHelloInfo{ServerName: "two.example.net"})
+		assert.Same(t, fallbackCert, res)
+		assert.Equal(t, 1, provider.obtainCount())
+
+		// Rate limited domains are only cached until the limit would allow them, so the policy isn't checked
+		// on every handshake in the meantime.
+		checks := policy.checks
+		res, _ = manager.CertificateForClient(&tls.ClientHelloInfo{ServerName: "two.example.net"})
+		assert.Same(t, fallbackCert, res)
+		assert.Equal(t, checks, policy.checks)
+
+		time.Sleep(time.Minute)
+		res, _ = manager.CertificateForClient(&tls.ClientHelloInfo{ServerName: "two.example.net"})
+		assert.Same(t, vanityCert, res)
+		assert.Equal(t, 2, provider.obtainCount())
+	})
+}
+
+func Test_Manager_OnDemand_limitsRefusalCache(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		provider := &fakeOnDemandProvider{}
+		policy := &fakeOnDemandPolicy{allowed: map[string]bool{}}
+		manager := newOnDemandManager(t, provider, policy, OnDemandConfig{Timeout: time.Minute, NegativeCacheDuration: time.Hour})
+
+		for i := range maxOnDemandRefusals + 10 {
+			_, _ = manager.CertificateForClient(&tls.ClientHelloInfo{ServerName: fmt.Sprintf("scan%d.example.net", i)})
+		}
+
+		manager.onDemand.mu.Lock()
+		defer manager.onDemand.mu.Unlock()
+		assert.Len(t, manager.onDemand.refused, maxOnDemandRefusals)
+		assert.Contains(t, manager.onDemand.refused, fmt.Sprintf("scan%d.example.net", maxOnDemandRefusals+9))
+	})
+}
+
+func Test_Manager_OnDemand_continuesInBackgroundAfterTimeout(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		provider := &fakeOnDemandProvider{
+			certs: map[string]*tls.Certificate{"vanity.example.net": vanityCert},
+			delay: time.Minute,
+		}
+		policy := &fakeOnDemandPolicy{allowed: map[string]bool{"vanity.example.net": true}}
+		manager := newOnDemandManager(t, provider, policy, OnDemandConfig{Timeout: time.Second})
+
+		var wg sync.WaitGroup
+		for range 3 {
+			wg.Go(func() {
+				res, _ := manager.CertificateForClient(&tls.ClientHelloInfo{ServerName: "vanity.example.net"})
+				assert.Same(t, fallbackCert, res)
+			})
+		}
+		wg.Wait()
+
+		time.Sleep(time.Minute)
+		synctest.Wait()
+
+		res, _ := manager.CertificateForClient(&tls.ClientHelloInfo{ServerName: "vanity.example.net"})
+		assert.Same(t, vanityCert, res)
+		assert.Equal(t, 1, provider.obtainCount())
+	})
+}
+
+func Test_Manager_OnDemand_ignoresInvalidServerNames(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		provider := &fakeOnDemandProvider{}
+		policy := &fakeOnDemandPolicy{allowed: map[string]bool{}}
+		manager := newOnDemandManager(t, provider, policy, OnDemandConfig{Timeout: time.Minute})
+
+		res, _ := manager.CertificateForClient(&tls.ClientHelloInfo{ServerName: "bad_domain!"})
+		assert.Same(t, fallbackCert, res)
+		assert.Equal(t, 0, policy.checks)
+	})
+}
+
+func Test_Manager_CheckCertificates_renewsOnDemandCertificates(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		renewedCert := &tls.Certificate{}
+		provider := &fakeOnDemandProvider{certs: map[string]*tls.Certificate{
+			"one.example.net": vanityCert,
+			"two.example.net": vanityCert,
+		}}
+		policy := &fakeOnDemandPolicy{allowed: map[string]bool{"one.example.net": true, "two.example.net": true}}
+		manager := newOnDemandManager(t, provider, policy, OnDemandConfig{Timeout: time.Minute})
+
+		_, _ = manager.CertificateForClient(&tls.ClientHelloInfo{ServerName: "one.example.net"})
+		_, _ = manager.CertificateForClient(&tls.ClientHelloInfo{ServerName: "two.example.net"})
+
+		provider.mu.Lock()
+		provider.certs["one.example.net"] = renewedCert
+		provider.mu.Unlock()
+		policy.setAllowed("two.example.net", false)
+
+		manager.checkOnDemandCertificates(t.Context())
+
+		res, _ := manager.CertificateForClient(&tls.ClientHelloInfo{ServerName: "one.example.net"})
+		assert.Same(t, renewedCert, res)
+		assert.NotContains(t, manager.onDemand.certificates, "two.example.net")
+	})
+}
+
+func Test_SuffixPolicy_AllowOnDemand(t *testing.T) {
+	policy := NewSuffixPolicy([]string{"customers.example.com", ".Example.NET.", ""})
+
+	tests := []struct {
+		domain  string
+		allowed bool
+	}{
+		{"customers.example.com", true},
+		{"acme.customers.example.com", true},
+		{"ACME.Customers.Example.com", true},
+		{"example.com", false},
+		{"evilcustomers.example.com", false},
+		{"example.net", true},
+		{"www.example.net", true},
+		{"example.org", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.domain, func(t *testing.T) {
+			allowed, err := policy.AllowOnDemand(t.Context(), tt.domain)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.allowed, allowed)
+		})
+	}
+}
+
+func Test_AskPolicy_AllowOnDemand(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Query().Get("domain") {
+		case "allowed.example.com":
+			w.WriteHeader(http.StatusOK)
+		case "denied.example.com":
+			w.WriteHeader(http.StatusForbidden)
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+
+	policy, err := NewAskPolicy(server.URL + "/check?token=abc")
+	require.NoError(t, err)
+
+	allowed, err := policy.AllowOnDemand(t.Context(), "allowed.example.com")
+	assert.NoError(t, err)
+	assert.True(t, allowed)
+
+	allowed, err = policy.AllowOnDemand(t.Context(), "denied.example.com")
+	assert.NoError(t, err)
+	assert.False(t, allowed)
+
+	allowed, err = policy.AllowOnDemand(t.Context(), "broken.example.com")
+	assert.Error(t, err)
+	assert.False(t, allowed)
+}
+
+func Test_NewAskPolicy_rejectsInvalidEndpoints(t *testing.T) {
+	_, err := NewAskPolicy("ftp://example.com/")
+	assert.Error(t, err)
+
+	_, err = NewAskPolicy("://")
+	assert.Error(t, err)
+}
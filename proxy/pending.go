@@ -0,0 +1,65 @@
+package proxy
+
+import (
+	"net"
+	"net/http"
+)
+
+// defaultPendingPage is served for routes waiting for a certificate if no other page is configured.
+var defaultPendingPage = []byte(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>Certificate pending</title>
+</head>
+<body>
+<h1>Certificate pending</h1>
+<p>This site is waiting for its TLS certificate to be issued. Please try again in a few minutes.</p>
+</body>
+</html>
+`)
+
+// pendingRetryAfter is the value of the Retry-After header sent with the pending page, in seconds.
+const pendingRetryAfter = "60"
+
+// PendingCertificateHandler is a http.Handler that serves a placeholder page for routes that are using a
+// temporary certificate, instead of proxying requests to their upstreams.
+type PendingCertificateHandler struct {
+	routeProvider RouteProvider
+	page          []byte
+	next          http.Handler
+}
+
+// NewPendingCertificateHandler creates a new PendingCertificateHandler which will obtain routes from the given
+// provider. If page is nil, a default page is used. Requests for routes that aren't pending are passed to the
+// `next` handler.
+func NewPendingCertificateHandler(provider RouteProvider, page []byte, next http.Handler) *PendingCertificateHandler {
+	if page == nil {
+		page = defaultPendingPage
+	}
+
+	return &PendingCertificateHandler{
+		routeProvider: provider,
+		page:          page,
+		next:          next,
+	}
+}
+
+func (p *PendingCertificateHandler) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	host, _, err := net.SplitHostPort(request.Host)
+	if err != nil {
+		host = request.Host
+	}
+
+	route := p.routeProvider.RouteForDomain(host)
+	if route == nil || route.CertificateStatus() != CertificatePending {
+		p.next.ServeHTTP(writer, request)
+		return
+	}
+
+	writer.Header().Set("Content-Type", "text/html; charset=utf-8")
+	writer.Header().Set("Cache-Control", "no-store")
+	writer.Header().Set("Retry-After", pendingRetryAfter)
+	writer.WriteHeader(http.StatusServiceUnavailable)
+	_, _ = writer.Write(p.page)
+}
@@ -0,0 +1,66 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_PendingCertificateHandler(t *testing.T) {
+	pending := &Route{Domains: []string{"pending.example.com"}}
+	pending.setCertificateStatus(CertificatePending)
+	good := &Route{Domains: []string{"example.com"}}
+	good.setCertificateStatus(CertificateGood)
+
+	provider := &mockRouteProvider{
+		routes: map[string]*Route{
+			"pending.example.com": pending,
+			"example.com":         good,
+		},
+	}
+
+	tests := []struct {
+		name    string
+		host    string
+		page    []byte
+		served  bool
+		content string
+	}{
+		{"Route with a certificate", "example.com", nil, false, ""},
+		{"Unknown route", "unknown.example.com", nil, false, ""},
+		{"Pending route", "pending.example.com", nil, true, string(defaultPendingPage)},
+		{"Pending route with port", "pending.example.com:443", nil, true, string(defaultPendingPage)},
+		{"Pending route with custom page", "pending.example.com", []byte("Hang on"), true, "Hang on"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u, _ := url.Parse("/foo/bar")
+			request := &http.Request{
+				URL:    u,
+				Header: make(http.Header),
+				Host:   tt.host,
+			}
+
+			writer := httptest.NewRecorder()
+			nextHandler := &mockNextHandler{}
+
+			NewPendingCertificateHandler(provider, tt.page, nextHandler).ServeHTTP(writer, request)
+
+			if tt.served {
+				assert.False(t, nextHandler.called)
+				assert.Equal(t, http.StatusServiceUnavailable, writer.Code)
+				assert.Equal(t, tt.content, writer.Body.String())
+				assert.Equal(t, "text/html; charset=utf-8", writer.Header().Get("Content-Type"))
+				assert.Equal(t, "60", writer.Header().Get("Retry-After"))
+			} else {
+				assert.True(t, nextHandler.called)
+				assert.False(t, writer.Flushed)
+				assert.Empty(t, writer.Body.String())
+			}
+		})
+	}
+}
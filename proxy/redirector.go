@@ -1,14 +1,26 @@
 package proxy
 
 import (
+	"io"
 	"log/slog"
 	"net"
 	"net/http"
 	"net/url"
+	"strings"
 )
 
-// HttpRedirector is a http.Handler that redirects all requests to HTTPS.
+// acmeChallengePath is the path prefix under which ACME servers request HTTP-01 challenge tokens.
+const acmeChallengePath = "/.well-known/acme-challenge/"
+
+// HttpChallengeProvider is the surface used by HttpRedirector to obtain responses to ACME HTTP-01 challenges.
+type HttpChallengeProvider interface {
+	HttpChallengeResponse(domain, token string) (string, bool)
+}
+
+// HttpRedirector is a http.Handler that redirects all requests to HTTPS. If Challenges is set, requests for
+// ACME HTTP-01 challenge tokens it knows about are answered instead of being redirected.
 type HttpRedirector struct {
+	Challenges HttpChallengeProvider
 }
 
 func (h *HttpRedirector) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
@@ -19,13 +31,25 @@ func (h *HttpRedirector) ServeHTTP(writer http.ResponseWriter, request *http.Req
 	}
 
 	// Make sure the host isn't garbage
-	if !isDomainName(host) {
+	if addr := strings.TrimSuffix(strings.TrimPrefix(host, "["), "]"); isIPAddress(addr) {
+		host = normaliseHostName(addr)
+	} else if !isDomainName(host) {
 		slog.Debug("Invalid host header from HTTP client, not redirecting", "host", request.Host)
 		writer.WriteHeader(http.StatusBadRequest)
 		return
 	}
 
-	targetUrl := url.URL{Scheme: "https", Host: host, Path: request.URL.Path, RawQuery: request.URL.RawQuery}
+	if h.Challenges != nil && strings.HasPrefix(request.URL.Path, acmeChallengePath) {
+		token := strings.TrimPrefix(request.URL.Path, acmeChallengePath)
+		if response, ok := h.Challenges.HttpChallengeResponse(host, token); ok {
+			slog.Debug("Serving ACME HTTP-01 challenge", "host", host, "token", token)
+			writer.Header().Set("Content-Type", "text/plain")
+			_, _ = io.WriteString(writer, response)
+			return
+		}
+	}
+
+	targetUrl := url.URL{Scheme: "https", Host: urlHostName(host), Path: request.URL.Path, RawQuery: request.URL.RawQuery}
 	http.Redirect(writer, request, targetUrl.String(), http.StatusPermanentRedirect)
 }
 
@@ -55,9 +79,9 @@ func NewDomainRedirector(provider RouteProvider, next http.Handler) *DomainRedir
 func (d *DomainRedirector) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
 	host := d.hostForRequest(request)
 	route := d.routeProvider.RouteForDomain(host)
-	if route != nil && route.RedirectToPrimary && route.Domains[0] != host {
+	if route != nil && route.RedirectToPrimary && normaliseHostName(route.Domains[0]) != normaliseHostName(host) {
 		newAddress := request.URL
-		newAddress.Host = route.Domains[0]
+		newAddress.Host = urlHostName(route.Domains[0])
 		if request.TLS != nil {
 			newAddress.Scheme = "https"
 		} else {
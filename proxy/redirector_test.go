@@ -3,6 +3,7 @@ package proxy
 import (
 	"crypto/tls"
 	"net/http"
+	"net/http/httptest"
 	"net/url"
 	"strings"
 	"testing"
@@ -51,10 +52,9 @@ func Test_HttpRedirector_ErrorsIfHostIsInvalid(t *testing.T) {
 	tests := []string{
 		"/invalid/",
 		"invalid with spaces",
-		"127.0.0.1",
-		"127.0.0.1:80",
-		"[::1]",
-		"[::1]:80",
+		"127.0.0.1.1",
+		"[::1%eth0]",
+		"[example.com]",
 		"invalid-.example.com",
 		"invalid.-example.com",
 		"invalid..example.com",
@@ -105,6 +105,38 @@ func Test_HttpRedirector_RedirectsToHttpsUrl(t *testing.T) {
 	assert.Equal(t, "https://example.com/foo/bar", writer.header.Get("Location"))
 }
 
+func Test_HttpRedirector_RedirectsIPAddresses(t *testing.T) {
+	tests := map[string]string{
+		"203.0.113.10":          "https://203.0.113.10/foo/bar",
+		"203.0.113.10:80":       "https://203.0.113.10/foo/bar",
+		"[2001:db8::1]":         "https://[2001:db8::1]/foo/bar",
+		"[2001:DB8:0:0::1]:80":  "https://[2001:db8::1]/foo/bar",
+		"[::ffff:203.0.113.10]": "https://203.0.113.10/foo/bar",
+	}
+
+	for host, expected := range tests {
+		t.Run(host, func(t *testing.T) {
+			u, _ := url.Parse("/foo/bar")
+			request := &http.Request{
+				URL:        u,
+				Header:     make(http.Header),
+				RemoteAddr: "127.0.0.1:11003",
+				Host:       host,
+			}
+
+			writer := &fakeResponseWriter{
+				header: make(http.Header),
+			}
+
+			redirector := &HttpRedirector{}
+			redirector.ServeHTTP(writer, request)
+
+			assert.Equal(t, http.StatusPermanentRedirect, writer.statusCode)
+			assert.Equal(t, expected, writer.header.Get("Location"))
+		})
+	}
+}
+
 func Test_HttpRedirector_PreservesQueryString(t *testing.T) {
 	u, _ := url.Parse("/foo/bar?baz=quux")
 	request := &http.Request{
@@ -145,6 +177,35 @@ func Test_HttpRedirector_StripsPort(t *testing.T) {
 	assert.Equal(t, "https://example.com/foo/bar", writer.header.Get("Location"))
 }
 
+type fakeHttpChallengeProvider map[string]string
+
+func (f fakeHttpChallengeProvider) HttpChallengeResponse(domain, token string) (string, bool) {
+	response, ok := f[domain+"/"+token]
+	return response, ok
+}
+
+func Test_HttpRedirector_ServesKnownChallenges(t *testing.T) {
+	request := httptest.NewRequest(http.MethodGet, "http://example.com:80/.well-known/acme-challenge/token1", nil)
+	recorder := httptest.NewRecorder()
+
+	redirector := &HttpRedirector{Challenges: fakeHttpChallengeProvider{"example.com/token1": "token1.keyauth"}}
+	redirector.ServeHTTP(recorder, request)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, "token1.keyauth", recorder.Body.String())
+}
+
+func Test_HttpRedirector_RedirectsUnknownChallenges(t *testing.T) {
+	request := httptest.NewRequest(http.MethodGet, "http://example.com/.well-known/acme-challenge/token2", nil)
+	recorder := httptest.NewRecorder()
+
+	redirector := &HttpRedirector{Challenges: fakeHttpChallengeProvider{"other.example.com/token2": "token2.keyauth"}}
+	redirector.ServeHTTP(recorder, request)
+
+	assert.Equal(t, http.StatusPermanentRedirect, recorder.Code)
+	assert.Equal(t, "https://example.com/.well-known/acme-challenge/token2", recorder.Header().Get("Location"))
+}
+
 type mockRouteProvider struct {
 	routes map[string]*Route
 }
@@ -47,6 +47,7 @@ func NewRewriter(manager *Manager, trustedDownstreams []net.IPNet) *Rewriter {
 		decorators: []Decorator{
 			NewXForwardedForDecorator(trustedDownstreams),
 			NewBannedHeaderDecorator(),
+			NewClientCertificateDecorator(manager),
 			NewUserAgentDecorator(),
 		},
 		errorClient: newErrorClient(),
@@ -2,6 +2,9 @@ package proxy
 
 import (
 	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"strings"
 	"sync/atomic"
 )
 
@@ -14,17 +17,69 @@ type Route struct {
 	ErrorMappings     []ErrorMapping
 	Provider          string
 	RedirectToPrimary bool
-
-	certificate       atomic.Pointer[tls.Certificate]
+	// Passthrough indicates that TLS connections for the route should be passed to an upstream as-is, rather
+	// than being terminated by Centauri and proxied over HTTP.
+	Passthrough bool
+	// Stream, if set, means the route forwards a port to its upstreams instead of being served over HTTP.
+	Stream *Stream
+	// ClientAuth determines whether clients must present a certificate signed by one of the ClientCAs.
+	ClientAuth ClientAuth
+	ClientCAs  *x509.CertPool
+	// TLSProfile determines which TLS versions and ciphers are accepted. If empty, the default is used.
+	TLSProfile TLSProfile
+	// CertificateFile and KeyFile, if set, are paths to a PEM-encoded certificate and private key that will be
+	// served for the route instead of obtaining a certificate from a provider.
+	CertificateFile string
+	KeyFile         string
+	// KeyTypes lists the types of certificate to obtain for the route. If empty, the manager's default is used.
+	KeyTypes []KeyType
+
+	certificates      atomic.Pointer[[]*tls.Certificate]
 	certificateStatus atomic.Int32
 }
 
+// Certificate returns the route's primary certificate, or nil if it doesn't have one.
 func (r *Route) Certificate() *tls.Certificate {
-	return r.certificate.Load()
+	if certs := r.certificates.Load(); certs != nil && len(*certs) > 0 {
+		return (*certs)[0]
+	}
+	return nil
+}
+
+// CertificateFor returns the first of the route's certificates that is supported by the client. If the client
+// doesn't support any of them, the primary certificate is returned.
+func (r *Route) CertificateFor(hello *tls.ClientHelloInfo) *tls.Certificate {
+	certs := r.certificates.Load()
+	if certs == nil || len(*certs) == 0 {
+		return nil
+	}
+
+	if len(*certs) > 1 && hello != nil {
+		for _, cert := range *certs {
+			if hello.SupportsCertificate(cert) == nil {
+				return cert
+			}
+		}
+	}
+	return (*certs)[0]
 }
 
 func (r *Route) setCertificate(cert *tls.Certificate) {
-	r.certificate.Store(cert)
+	if cert == nil {
+		r.certificates.Store(nil)
+	} else {
+		r.setCertificates([]*tls.Certificate{cert})
+	}
+}
+
+func (r *Route) setCertificates(certs []*tls.Certificate) {
+	r.certificates.Store(&certs)
+}
+
+// copyCertificates replaces the route's certificates and status with those of the other route.
+func (r *Route) copyCertificates(other *Route) {
+	r.certificates.Store(other.certificates.Load())
+	r.setCertificateStatus(other.CertificateStatus())
 }
 
 func (r *Route) CertificateStatus() CertificateStatus {
@@ -35,6 +90,11 @@ func (r *Route) setCertificateStatus(status CertificateStatus) {
 	r.certificateStatus.Store(int32(status))
 }
 
+// RequiresCertificate indicates whether Centauri needs to hold a certificate in order to serve the route.
+func (r *Route) RequiresCertificate() bool {
+	return !r.Passthrough && (r.Stream == nil || r.Stream.TLS)
+}
+
 func (r *Route) CertificateNames() (string, []string) {
 	if len(r.Subject) > 0 {
 		return r.Subject[0], r.Subject[1:]
@@ -58,12 +118,90 @@ type Upstream struct {
 	Host string
 }
 
+// StreamProtocol is the transport protocol used for a Stream.
+type StreamProtocol string
+
+const (
+	StreamProtocolTCP StreamProtocol = "tcp"
+	StreamProtocolUDP StreamProtocol = "udp"
+)
+
+// Stream describes a port that is forwarded to a route's upstreams without any knowledge of the protocol
+// being spoken over it.
+type Stream struct {
+	Port     int
+	Protocol StreamProtocol
+	TLS      bool // Whether TLS should be terminated using the route's certificate. Only supported for TCP.
+}
+
+// ClientAuth describes whether a route requires clients to authenticate using a certificate.
+type ClientAuth int
+
+const (
+	ClientAuthNone     ClientAuth = iota // Client certificates are not requested
+	ClientAuthOptional                   // Client certificates are requested, and verified if supplied
+	ClientAuthRequired                   // Client certificates must be supplied and valid
+)
+
+// TLSProfile names a set of TLS settings, following Mozilla's server side TLS guidelines.
+type TLSProfile string
+
+const (
+	TLSProfileModern       TLSProfile = "modern"       // TLS 1.3 only
+	TLSProfileIntermediate TLSProfile = "intermediate" // TLS 1.2 and 1.3 with strong ciphers
+	TLSProfileOld          TLSProfile = "old"          // TLS 1.0 onwards, with ciphers for legacy clients
+)
+
+// ParseTLSProfile converts the name of a TLS profile into a TLSProfile, returning an error if the name isn't
+// recognised.
+func ParseTLSProfile(name string) (TLSProfile, error) {
+	switch profile := TLSProfile(strings.ToLower(name)); profile {
+	case TLSProfileModern, TLSProfileIntermediate, TLSProfileOld:
+		return profile, nil
+	default:
+		return "", fmt.Errorf("invalid TLS profile: %s (must be modern, intermediate or old)", name)
+	}
+}
+
+// TLSProfileFor returns the TLS profile used for connections to the route: its own profile if it has one,
+// otherwise the given default, or the intermediate profile if no default is given. A nil route (such as when
+// the client connects to an unknown domain) uses the default.
+func TLSProfileFor(route *Route, defaultProfile TLSProfile) TLSProfile {
+	switch {
+	case route != nil && route.TLSProfile != "":
+		return route.TLSProfile
+	case defaultProfile != "":
+		return defaultProfile
+	default:
+		return TLSProfileIntermediate
+	}
+}
+
+// KeyType identifies the type of key used by a certificate.
+type KeyType string
+
+const (
+	KeyTypeECDSA KeyType = "ecdsa" // Smaller and faster, supported by all modern clients
+	KeyTypeRSA   KeyType = "rsa"   // For legacy clients that don't support ECDSA
+)
+
+// ParseKeyType converts the name of a key type into a KeyType, returning an error if the name isn't recognised.
+func ParseKeyType(name string) (KeyType, error) {
+	switch keyType := KeyType(strings.ToLower(name)); keyType {
+	case KeyTypeECDSA, KeyTypeRSA:
+		return keyType, nil
+	default:
+		return "", fmt.Errorf("invalid key type: %s (must be ecdsa or rsa)", name)
+	}
+}
+
 // CertificateStatus describes the current status of the route's certificate
 type CertificateStatus int
 
 const (
 	CertificateNotChecked   CertificateStatus = iota // The route has just been initialised, so we don't yet know
 	CertificateMissing                               // The certificate is required and no valid one is held
+	CertificatePending                               // We're serving a temporary certificate until a valid one is obtained
 	CertificateExpiringSoon                          // We have a certificate but it needs to be renewed
 	CertificateGood                                  // We have a certificate and it is in good order
 	CertificateNotRequired                           // We don't have a certificate and are happy about it
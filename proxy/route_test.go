@@ -1,9 +1,20 @@
 package proxy
 
 import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func Test_Route_CertificateNames_returnsDomainsWhenSubjectNotSet(t *testing.T) {
@@ -78,3 +89,122 @@ func Test_Route_ErrorMappingForStatus(t *testing.T) {
 	assert.Nil(t, route.ErrorMappingForStatus(500))
 	assert.Nil(t, (&Route{}).ErrorMappingForStatus(404))
 }
+
+func Test_ParseTLSProfile(t *testing.T) {
+	tests := map[string]TLSProfile{
+		"modern":       TLSProfileModern,
+		"Intermediate": TLSProfileIntermediate,
+		"OLD":          TLSProfileOld,
+	}
+
+	for name, expected := range tests {
+		t.Run(name, func(t *testing.T) {
+			profile, err := ParseTLSProfile(name)
+			assert.NoError(t, err)
+			assert.Equal(t, expected, profile)
+		})
+	}
+}
+
+func Test_ParseTLSProfile_errorsOnUnknownProfile(t *testing.T) {
+	for _, name := range []string{"", "ancient", "modern "} {
+		t.Run(name, func(t *testing.T) {
+			_, err := ParseTLSProfile(name)
+			assert.ErrorContains(t, err, "invalid TLS profile")
+		})
+	}
+}
+
+func Test_ParseKeyType(t *testing.T) {
+	tests := map[string]KeyType{
+		"ecdsa": KeyTypeECDSA,
+		"RSA":   KeyTypeRSA,
+	}
+
+	for name, expected := range tests {
+		t.Run(name, func(t *testing.T) {
+			keyType, err := ParseKeyType(name)
+			assert.NoError(t, err)
+			assert.Equal(t, expected, keyType)
+		})
+	}
+}
+
+func Test_ParseKeyType_errorsOnUnknownType(t *testing.T) {
+	for _, name := range []string{"", "dsa", "ec384"} {
+		t.Run(name, func(t *testing.T) {
+			_, err := ParseKeyType(name)
+			assert.ErrorContains(t, err, "invalid key type")
+		})
+	}
+}
+
+// newKeyTypeCertificate creates a self-signed certificate for example.com with a key of the given type.
+func newKeyTypeCertificate(t *testing.T, keyType KeyType) *tls.Certificate {
+	t.Helper()
+
+	var key crypto.Signer
+	var err error
+	if keyType == KeyTypeRSA {
+		key, err = rsa.GenerateKey(rand.Reader, 2048)
+	} else {
+		key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	}
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "example.com"},
+		DNSNames:     []string{"example.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	require.NoError(t, err)
+
+	leaf, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return &tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+// legacyRSAHello is a ClientHelloInfo from a client that only supports TLS 1.2 with RSA certificates.
+var legacyRSAHello = &tls.ClientHelloInfo{
+	ServerName:        "example.com",
+	CipherSuites:      []uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256},
+	SignatureSchemes:  []tls.SignatureScheme{tls.PKCS1WithSHA256},
+	SupportedCurves:   []tls.CurveID{tls.CurveP256},
+	SupportedPoints:   []uint8{0},
+	SupportedVersions: []uint16{tls.VersionTLS12},
+}
+
+// modernHello is a ClientHelloInfo from a client that supports TLS 1.3 with ECDSA and RSA certificates.
+var modernHello = &tls.ClientHelloInfo{
+	ServerName:        "example.com",
+	CipherSuites:      []uint16{tls.TLS_AES_128_GCM_SHA256},
+	SignatureSchemes:  []tls.SignatureScheme{tls.ECDSAWithP256AndSHA256, tls.PSSWithSHA256},
+	SupportedCurves:   []tls.CurveID{tls.X25519, tls.CurveP256},
+	SupportedVersions: []uint16{tls.VersionTLS13},
+}
+
+func Test_Route_CertificateFor_returnsFirstSupportedCertificate(t *testing.T) {
+	ecdsaCert := newKeyTypeCertificate(t, KeyTypeECDSA)
+	rsaCert := newKeyTypeCertificate(t, KeyTypeRSA)
+
+	route := &Route{}
+	route.setCertificates([]*tls.Certificate{ecdsaCert, rsaCert})
+
+	assert.Same(t, ecdsaCert, route.CertificateFor(modernHello))
+	assert.Same(t, rsaCert, route.CertificateFor(legacyRSAHello))
+	assert.Same(t, ecdsaCert, route.Certificate())
+}
+
+func Test_Route_CertificateFor_returnsPrimaryCertificateIfNoneSupported(t *testing.T) {
+	ecdsaCert := newKeyTypeCertificate(t, KeyTypeECDSA)
+
+	route := &Route{}
+	assert.Nil(t, route.CertificateFor(legacyRSAHello))
+
+	route.setCertificates([]*tls.Certificate{ecdsaCert})
+	assert.Same(t, ecdsaCert, route.CertificateFor(legacyRSAHello))
+}
@@ -0,0 +1,170 @@
+package proxy
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// CertificateScheduler is implemented by CertificateProviders that can report when a certificate will next need
+// to be renewed, or have its OCSP staple or renewal information updated. This allows certificates to be updated
+// at the right time, rather than waiting for the next periodic check.
+type CertificateScheduler interface {
+	NextCertificateAction(preferredSupplier string, keyType string, subject string, altNames []string) (time.Time, error)
+}
+
+const (
+	// scheduleMinInterval is the minimum time between successful updates of a certificate, in case the provider
+	// still reports that it needs attention straight after being updated.
+	scheduleMinInterval = time.Hour
+	// scheduleRetryInterval is the time to wait before retrying a certificate that failed to update. It doubles
+	// with each consecutive failure.
+	scheduleRetryInterval = time.Minute
+)
+
+// certificateSchedule tracks when each certificate may next be updated, keyed by certificateKey.
+type certificateSchedule struct {
+	notBefore map[string]time.Time
+	failures  map[string]int
+}
+
+// ScheduleCertificates updates certificates as they need it, waking each time the provider says a certificate
+// needs to be renewed or have its OCSP staple or renewal information updated. Certificates that fail to update
+// are retried with an exponential backoff. All certificates are also checked with CheckCertificates at least
+// every maxInterval. It blocks until the context is cancelled, and is intended to be run in its own goroutine.
+//
+// If the manager's provider doesn't implement CertificateScheduler, this behaves like MonitorCertificates.
+func (m *Manager) ScheduleCertificates(ctx context.Context, maxInterval time.Duration) {
+	scheduler, ok := m.provider.(CertificateScheduler)
+	if !ok {
+		m.MonitorCertificates(ctx, maxInterval)
+		return
+	}
+
+	wake := make(chan struct{}, 1)
+	m.OnRoutesChanged(func() {
+		select {
+		case wake <- struct{}{}:
+		default:
+		}
+	})
+
+	schedule := &certificateSchedule{
+		notBefore: make(map[string]time.Time),
+		failures:  make(map[string]int),
+	}
+	nextCheck := time.Now().Add(maxInterval)
+
+	for {
+		if !time.Now().Before(nextCheck) {
+			slog.Info("Checking for certificate validity...")
+			m.CheckCertificates(ctx)
+			nextCheck = time.Now().Add(maxInterval)
+		}
+
+		due, next := m.dueCertificates(scheduler, schedule)
+		if len(due) > 0 {
+			m.updateCerts(ctx, due)
+			m.recordUpdates(schedule, due, maxInterval)
+			continue
+		}
+
+		if next.IsZero() || nextCheck.Before(next) {
+			next = nextCheck
+		}
+
+		slog.Debug("Waiting for next certificate action", "next", next)
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-wake:
+			timer.Stop()
+		case <-timer.C:
+		}
+	}
+}
+
+// dueCertificates returns the groups of routes whose certificate needs updating now, and the time at which the
+// next of the remaining certificates will need updating.
+func (m *Manager) dueCertificates(scheduler CertificateScheduler, schedule *certificateSchedule) ([][]*Route, time.Time) {
+	var routes []*Route
+	for _, route := range m.routes.Routes() {
+		if m.obtainsCertificate(route) {
+			routes = append(routes, route)
+		}
+	}
+
+	var due [][]*Route
+	var next time.Time
+	now := time.Now()
+	keys := make(map[string]bool)
+	for _, group := range m.groupByCertificate(routes) {
+		key := m.certificateKey(group[0])
+		keys[key] = true
+
+		at := m.nextCertificateAction(scheduler, group[0])
+		if notBefore := schedule.notBefore[key]; notBefore.After(at) {
+			at = notBefore
+		}
+
+		if !at.After(now) {
+			due = append(due, group)
+		} else if next.IsZero() || at.Before(next) {
+			next = at
+		}
+	}
+
+	// Forget about certificates that are no longer used by any routes
+	for key := range schedule.notBefore {
+		if !keys[key] {
+			delete(schedule.notBefore, key)
+			delete(schedule.failures, key)
+		}
+	}
+
+	return due, next
+}
+
+// nextCertificateAction returns the earliest time at which any of the route's certificates will need updating.
+// If the provider doesn't have a certificate for the route, it needs updating immediately.
+func (m *Manager) nextCertificateAction(scheduler CertificateScheduler, route *Route) time.Time {
+	primary, alts := route.CertificateNames()
+	var next time.Time
+	for i, keyType := range m.keyTypesFor(route) {
+		at, err := scheduler.NextCertificateAction(route.Provider, string(keyType), primary, alts)
+		if err != nil {
+			return time.Time{}
+		}
+		if i == 0 || at.Before(next) {
+			next = at
+		}
+	}
+	return next
+}
+
+// recordUpdates records the outcome of updating the given groups of routes, so that failures are retried with
+// an exponential backoff, up to maxInterval.
+func (m *Manager) recordUpdates(schedule *certificateSchedule, groups [][]*Route, maxInterval time.Duration) {
+	now := time.Now()
+	for _, group := range groups {
+		key := m.certificateKey(group[0])
+
+		if group[0].CertificateStatus() == CertificateGood {
+			delete(schedule.failures, key)
+			schedule.notBefore[key] = now.Add(scheduleMinInterval)
+			continue
+		}
+
+		schedule.failures[key]++
+		delay := scheduleRetryInterval
+		for i := 1; i < schedule.failures[key] && delay < maxInterval; i++ {
+			delay *= 2
+		}
+		delay = min(delay, maxInterval)
+
+		slog.Warn("Certificate update failed, will retry", "route", group[0].Domains[0], "failures", schedule.failures[key], "retry", now.Add(delay))
+		schedule.notBefore[key] = now.Add(delay)
+	}
+}
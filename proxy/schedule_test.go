@@ -0,0 +1,170 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"sync"
+	"testing"
+	"testing/synctest"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeSchedulingCertManager is a CertificateProvider that reports when its certificates next need attention.
+type fakeSchedulingCertManager struct {
+	mu         sync.Mutex
+	nextAction time.Time
+	nextErr    error
+	err        error
+	obtained   []time.Time
+}
+
+func (f *fakeSchedulingCertManager) GetCertificate(_ context.Context, _ string, _ string, _ string, _ []string) (*tls.Certificate, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.obtained = append(f.obtained, time.Now())
+	if f.err != nil {
+		return nil, f.err
+	}
+	f.nextAction = time.Now().Add(24 * time.Hour)
+	f.nextErr = nil
+	return dummyCert, nil
+}
+
+func (f *fakeSchedulingCertManager) GetExistingCertificate(_ string, _ string, _ string, _ []string) (*tls.Certificate, bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.nextErr != nil {
+		return nil, false, f.nextErr
+	}
+	return dummyCert, false, nil
+}
+
+func (f *fakeSchedulingCertManager) NextCertificateAction(_ string, _ string, _ string, _ []string) (time.Time, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.nextAction, f.nextErr
+}
+
+func (f *fakeSchedulingCertManager) obtainedCount() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.obtained)
+}
+
+func Test_Manager_ScheduleCertificates_updatesCertificateWhenDue(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		provider := &fakeSchedulingCertManager{nextAction: time.Now().Add(2 * time.Hour)}
+		manager := NewManager(provider)
+		assert.NoError(t, manager.routes.Update([]*Route{{Domains: []string{"example.com"}}}))
+
+		ctx, cancel := context.WithCancel(t.Context())
+		defer cancel()
+		go manager.ScheduleCertificates(ctx, 12*time.Hour)
+
+		time.Sleep(2*time.Hour - time.Second)
+		synctest.Wait()
+		assert.Equal(t, 0, provider.obtainedCount())
+
+		time.Sleep(2 * time.Second)
+		synctest.Wait()
+		assert.Equal(t, 1, provider.obtainedCount())
+
+		// The next action is now 24 hours away, so nothing should happen until the periodic check
+		time.Sleep(10*time.Hour - 2*time.Second)
+		synctest.Wait()
+		assert.Equal(t, 1, provider.obtainedCount())
+	})
+}
+
+func Test_Manager_ScheduleCertificates_retriesFailuresWithBackoff(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		start := time.Now()
+		provider := &fakeSchedulingCertManager{nextErr: fmt.Errorf("no certificate"), err: fmt.Errorf("rate limited")}
+		manager := NewManager(provider)
+		assert.NoError(t, manager.routes.Update([]*Route{{Domains: []string{"example.com"}}}))
+
+		ctx, cancel := context.WithCancel(t.Context())
+		defer cancel()
+		go manager.ScheduleCertificates(ctx, 12*time.Hour)
+
+		time.Sleep(16 * time.Minute)
+		synctest.Wait()
+
+		provider.mu.Lock()
+		defer provider.mu.Unlock()
+		var offsets []time.Duration
+		for _, at := range provider.obtained {
+			offsets = append(offsets, at.Sub(start))
+		}
+		assert.Equal(t, []time.Duration{0, time.Minute, 3 * time.Minute, 7 * time.Minute, 15 * time.Minute}, offsets)
+	})
+}
+
+func Test_Manager_ScheduleCertificates_keepsBackoffWhenRoutesAreReloaded(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		provider := &fakeSchedulingCertManager{nextErr: fmt.Errorf("no certificate"), err: fmt.Errorf("rate limited")}
+		manager := NewManager(provider)
+		assert.NoError(t, manager.routes.Update([]*Route{{Domains: []string{"example.com"}}}))
+
+		ctx, cancel := context.WithCancel(t.Context())
+		defer cancel()
+		go manager.ScheduleCertificates(ctx, 12*time.Hour)
+		synctest.Wait()
+		assert.Equal(t, 1, provider.obtainedCount())
+
+		// Reloading the same routes shouldn't retry the failed certificate before the backoff expires
+		time.Sleep(30 * time.Second)
+		assert.NoError(t, manager.SetRoutes(t.Context(), []*Route{{Domains: []string{"example.com"}}}, nil))
+		synctest.Wait()
+		assert.Equal(t, 1, provider.obtainedCount())
+
+		time.Sleep(30 * time.Second)
+		synctest.Wait()
+		assert.Equal(t, 2, provider.obtainedCount())
+	})
+}
+
+func Test_Manager_ScheduleCertificates_wakesWhenRoutesChange(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		provider := &fakeSchedulingCertManager{nextAction: time.Now().Add(time.Hour)}
+		manager := NewManager(provider)
+
+		ctx, cancel := context.WithCancel(t.Context())
+		defer cancel()
+		go manager.ScheduleCertificates(ctx, 12*time.Hour)
+		synctest.Wait()
+
+		provider.mu.Lock()
+		provider.nextErr = fmt.Errorf("no certificate")
+		provider.mu.Unlock()
+
+		// Add the route directly and notify, so only the scheduler obtains the certificate
+		assert.NoError(t, manager.routes.Update([]*Route{{Domains: []string{"example.com"}}}))
+		manager.notifyListeners()
+		synctest.Wait()
+
+		assert.Equal(t, 1, provider.obtainedCount())
+	})
+}
+
+func Test_Manager_ScheduleCertificates_ignoresRoutesWithoutProviderCertificates(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		provider := &fakeSchedulingCertManager{nextErr: fmt.Errorf("no certificate")}
+		manager := NewManager(provider)
+		assert.NoError(t, manager.routes.Update([]*Route{
+			{Domains: []string{"example.com"}, Passthrough: true},
+			{Domains: []string{"example.net"}, CertificateFile: "cert.pem", KeyFile: "key.pem"},
+		}))
+
+		ctx, cancel := context.WithCancel(t.Context())
+		defer cancel()
+		go manager.ScheduleCertificates(ctx, 12*time.Hour)
+
+		time.Sleep(time.Hour)
+		synctest.Wait()
+		assert.Equal(t, 0, provider.obtainedCount())
+	})
+}